@@ -0,0 +1,582 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/table"
+	"simple-db-golang/src/transaction"
+	"simple-db-golang/src/types"
+)
+
+const (
+	// The header page of the catalog heap, which is the first page of the
+	// database file.
+	CatalogPageId = common.PageId(1)
+)
+
+var (
+	ErrTableExists   = errors.New("Table already exists.")
+	ErrTableNotFound = errors.New("Table does not exist.")
+	ErrIndexExists   = errors.New("Index already exists.")
+	ErrIndexNotFound = errors.New("Index does not exist.")
+)
+
+type IndexKind uint8
+
+const (
+	InvalidIndex IndexKind = iota
+	BPlusTreeIndex
+	HashIndex
+)
+
+type TableInfo struct {
+	Name         string
+	Schema       *types.Schema
+	HeaderPageId common.PageId
+	Heap         *table.TableHeap
+	Indexes      []*IndexInfo // Ordered by name.
+
+	rid common.RID // Of the entry in the catalog heap.
+}
+
+type IndexInfo struct {
+	Name      string
+	TableName string
+	Columns   []string // Key columns, in order.
+	Kind      IndexKind
+	Unique    bool
+	// The page the index is reached from, e.g. the root of a B+ tree.
+	RootPageId common.PageId
+
+	rid common.RID // Of the entry in the catalog heap.
+}
+
+// Catalog keeps track of the tables of a database and of their indexes. The
+// entries live in a table heap of their own, the catalog heap, so they are
+// read and written by transactions like any other record: a transaction sees
+// the tables as of its snapshot, and its changes are rolled back if it aborts.
+//
+// Changes of the catalog are serialized by an exclusive lock held until the
+// changing transaction ends, and a transaction may only change the catalog if
+// its snapshot includes every committed change. Otherwise it gets
+// `table.ErrWriteConflict`, and should abort.
+//
+// The table heap of a created table is freed if the creation is rolled back,
+// and the one of a dropped table by `CollectGarbage` once no snapshot older
+// than the drop is left, since readers take no locks and may still be reading
+// it. Older snapshots do not see the dropped table any more either, and must
+// not use it. The structure of an index is freed once its drop commits, after
+// its users finish. Pages of tables and indexes created or dropped by a
+// transaction cut short by a crash are not freed.
+//
+// Index structures are not logged: the indexes of a table are emptied and
+// rebuilt when the table is first opened after the database is opened, see
+// `OpenTable`, and their old nodes are not freed.
+type Catalog struct {
+	bufferPoolManager *disk.BufferPoolManager
+	heap              *table.TableHeap
+
+	// Table heaps opened so far by header page, and the commit timestamp of
+	// the last drop of a table by the header page of its heap. A snapshot
+	// older than the drop may still see the entry of the dropped table, but
+	// must not open its heap again.
+	heaps        map[common.PageId]*table.TableHeap
+	droppedHeaps map[common.PageId]transaction.Timestamp
+	// Table heaps of committed drops not freed yet, in commit order.
+	heapsToFree []heapToFree
+	// Index structures in use by root page id, and the ones of each table by
+	// header page, once the table has been opened.
+	indexes      map[common.PageId]*Index
+	tableIndexes map[common.PageId][]*Index
+	// Commit timestamps of the last change of the indexes of each table, and
+	// of the last write to each table through `Table`, by header page.
+	indexChangeTs map[common.PageId]transaction.Timestamp
+	writeTs       map[common.PageId]transaction.Timestamp
+	// Commit timestamp of the last change.
+	lastChangeTs transaction.Timestamp
+	mu           sync.Mutex
+	// Held while the indexes of a table are opened.
+	openMu sync.Mutex
+}
+
+type heapToFree struct {
+	heap      *table.TableHeap
+	droppedTs transaction.Timestamp
+}
+
+// Create the catalog of a new database. It must be the first thing allocated
+// in the database file.
+func NewCatalog(bufferPoolManager *disk.BufferPoolManager) *Catalog {
+	heap := table.NewTableHeap(bufferPoolManager)
+	if heap.HeaderPageId() != CatalogPageId {
+		log.Fatalf("Unexpected: catalog header page id is not %d.", CatalogPageId)
+	}
+	return newCatalog(bufferPoolManager, heap)
+}
+
+// Open the catalog of an existing database, after recovering the database.
+// Table heaps are opened when their tables are first looked up.
+func OpenCatalog(bufferPoolManager *disk.BufferPoolManager) (*Catalog, error) {
+	if err := table.Recover(bufferPoolManager); err != nil {
+		return nil, err
+	}
+	return newCatalog(bufferPoolManager, table.OpenTableHeap(bufferPoolManager, CatalogPageId)), nil
+}
+
+func newCatalog(bufferPoolManager *disk.BufferPoolManager, heap *table.TableHeap) *Catalog {
+	return &Catalog{
+		bufferPoolManager: bufferPoolManager,
+		heap:              heap,
+		heaps:             make(map[common.PageId]*table.TableHeap),
+		droppedHeaps:      make(map[common.PageId]transaction.Timestamp),
+		indexes:           make(map[common.PageId]*Index),
+		tableIndexes:      make(map[common.PageId][]*Index),
+		indexChangeTs:     make(map[common.PageId]transaction.Timestamp),
+		writeTs:           make(map[common.PageId]transaction.Timestamp),
+	}
+}
+
+// Create a table with an empty table heap.
+func (c *Catalog) CreateTable(txn *transaction.Transaction, name string, schema *types.Schema) (*TableInfo, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	if err := schema.Validate(); err != nil {
+		return nil, err
+	}
+	tables, _, err := c.beginChange(txn)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tables[name]; ok {
+		return nil, ErrTableExists
+	}
+
+	heap := table.NewTableHeap(c.bufferPoolManager)
+	c.mu.Lock()
+	c.heaps[heap.HeaderPageId()] = heap
+	c.tableIndexes[heap.HeaderPageId()] = make([]*Index, 0)
+	c.mu.Unlock()
+	c.appendChange(txn, &catalogChange{catalog: c, createdHeap: heap})
+	info := &TableInfo{
+		Name:         name,
+		Schema:       schema,
+		HeaderPageId: heap.HeaderPageId(),
+		Heap:         heap,
+		Indexes:      make([]*IndexInfo, 0),
+	}
+	if info.rid, err = c.heap.Insert(txn, encodeTableEntry(info)); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Look a table up by name, as of the snapshot of `txn`.
+func (c *Catalog) GetTable(txn *transaction.Transaction, name string) (*TableInfo, error) {
+	tables, _, err := c.readEntries(txn)
+	if err != nil {
+		return nil, err
+	}
+	info, ok := tables[name]
+	if !ok {
+		return nil, ErrTableNotFound
+	}
+	return info, nil
+}
+
+// All tables, ordered by name, as of the snapshot of `txn`.
+func (c *Catalog) ListTables(txn *transaction.Transaction) ([]*TableInfo, error) {
+	tables, _, err := c.readEntries(txn)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*TableInfo, 0, len(tables))
+	for _, info := range tables {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Drop a table along with its indexes. Writers of the table are waited for,
+// and its table heap is freed by `CollectGarbage` once the snapshots older
+// than the commit of `txn` are gone.
+func (c *Catalog) DropTable(txn *transaction.Transaction, name string) error {
+	tables, _, err := c.beginChange(txn)
+	if err != nil {
+		return err
+	}
+	info, ok := tables[name]
+	if !ok {
+		return ErrTableNotFound
+	}
+	if err := info.Heap.LockTable(txn, transaction.Exclusive); err != nil {
+		return err
+	}
+	change := &catalogChange{catalog: c, droppedHeap: info.Heap}
+	for _, index := range info.Indexes {
+		if err := c.deleteEntry(txn, index.rid); err != nil {
+			return err
+		}
+		change.droppedIndexes = append(change.droppedIndexes, c.indexToDrop(info, index))
+	}
+	if err := c.deleteEntry(txn, info.rid); err != nil {
+		return err
+	}
+	c.appendChange(txn, change)
+	return nil
+}
+
+// Add an index to the catalog, and build its structure from the rows of the
+// table visible to `txn`, setting `index.RootPageId`. Writers of the table are
+// waited for with an X lock, and the creation fails with
+// `table.ErrWriteConflict` if rows were written after the snapshot of `txn`,
+// or with `ErrDuplicateKey` if a unique index would have the same key twice.
+func (c *Catalog) CreateIndex(txn *transaction.Transaction, index *IndexInfo) error {
+	if err := validateName(index.Name); err != nil {
+		return err
+	}
+	tables, indexes, err := c.beginChange(txn)
+	if err != nil {
+		return err
+	}
+	if _, ok := indexes[index.Name]; ok {
+		return ErrIndexExists
+	}
+	info, ok := tables[index.TableName]
+	if !ok {
+		return ErrTableNotFound
+	}
+	if len(index.Columns) == 0 {
+		return fmt.Errorf("Index %s has no columns.", index.Name)
+	}
+	for _, column := range index.Columns {
+		if _, ok := info.Schema.ColumnIndex(column); !ok {
+			return fmt.Errorf("Column %s does not exist in table %s.", column, info.Name)
+		}
+	}
+	if index.Kind != BPlusTreeIndex && index.Kind != HashIndex {
+		return fmt.Errorf("Index %s has an invalid kind.", index.Name)
+	}
+	ix := newIndex(c.bufferPoolManager, info, index)
+	if err := ix.checkKeySize(); err != nil {
+		return err
+	}
+	if err := info.Heap.LockTable(txn, transaction.Exclusive); err != nil {
+		return err
+	}
+	tableIndexes, err := c.openIndexes(txn, info)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	writeTs := c.writeTs[info.HeaderPageId]
+	c.mu.Unlock()
+	if writeTs > txn.ReadTimestamp() {
+		return table.ErrWriteConflict
+	}
+
+	if err := ix.create(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.indexes[index.RootPageId] = ix
+	c.tableIndexes[info.HeaderPageId] = append(append([]*Index(nil), tableIndexes...), ix)
+	c.mu.Unlock()
+	c.appendChange(txn, &catalogChange{catalog: c, createdIndex: ix})
+	if index.rid, err = c.heap.Insert(txn, encodeIndexEntry(index)); err != nil {
+		return err
+	}
+	return ix.build(txn, info.Heap)
+}
+
+// Remove an index from the catalog. Writers of the table are waited for with
+// an X lock, and the index structure is freed once `txn` commits.
+func (c *Catalog) DropIndex(txn *transaction.Transaction, name string) error {
+	tables, indexes, err := c.beginChange(txn)
+	if err != nil {
+		return err
+	}
+	index, ok := indexes[name]
+	if !ok {
+		return ErrIndexNotFound
+	}
+	info := tables[index.TableName]
+	if err := info.Heap.LockTable(txn, transaction.Exclusive); err != nil {
+		return err
+	}
+	if err := c.deleteEntry(txn, index.rid); err != nil {
+		return err
+	}
+	c.appendChange(txn, &catalogChange{catalog: c, droppedIndexes: []*Index{c.indexToDrop(info, index)}})
+	return nil
+}
+
+// Open the index structures of a table, rebuilding them from the rows visible
+// to `txn` the first time.
+func (c *Catalog) openIndexes(txn *transaction.Transaction, info *TableInfo) ([]*Index, error) {
+	c.openMu.Lock()
+	defer c.openMu.Unlock()
+	c.mu.Lock()
+	indexes, ok := c.tableIndexes[info.HeaderPageId]
+	c.mu.Unlock()
+	if ok {
+		return indexes, nil
+	}
+	// No transaction wrote to the table through `Table` since the database was
+	// opened, so `txn` sees all of its rows.
+	indexes = make([]*Index, 0, len(info.Indexes))
+	for _, index := range info.Indexes {
+		ix := newIndex(c.bufferPoolManager, info, index)
+		ix.recover()
+		if err := ix.build(txn, info.Heap); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, ix)
+	}
+	c.mu.Lock()
+	for _, ix := range indexes {
+		c.indexes[ix.Info.RootPageId] = ix
+	}
+	c.tableIndexes[info.HeaderPageId] = indexes
+	c.mu.Unlock()
+	return indexes, nil
+}
+
+// The index structure to free when `index` is dropped, which may not have been
+// opened yet.
+func (c *Catalog) indexToDrop(info *TableInfo, index *IndexInfo) *Index {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ix, ok := c.indexes[index.RootPageId]; ok {
+		return ix
+	}
+	return newIndex(c.bufferPoolManager, info, index)
+}
+
+// Remove the entries of the indexes that no snapshot at or after
+// `oldestSnapshot` needs any more, see `table.GarbageCollector`, and free the
+// table heaps of the tables dropped at or before it. Returns the number of
+// removed entries.
+func (c *Catalog) CollectGarbage(oldestSnapshot transaction.Timestamp) int {
+	c.mu.Lock()
+	indexes := make([]*Index, 0, len(c.indexes))
+	for _, ix := range c.indexes {
+		indexes = append(indexes, ix)
+	}
+	numFreed := 0
+	for _, dropped := range c.heapsToFree {
+		if dropped.droppedTs > oldestSnapshot {
+			break
+		}
+		// Every snapshot sees the drop, so none reads the heap any more, and
+		// none can see an entry with its header page from before the drop.
+		if c.droppedHeaps[dropped.heap.HeaderPageId()] == dropped.droppedTs {
+			delete(c.droppedHeaps, dropped.heap.HeaderPageId())
+		}
+		numFreed++
+	}
+	freed := c.heapsToFree[:numFreed]
+	c.heapsToFree = c.heapsToFree[numFreed:]
+	c.mu.Unlock()
+	for _, dropped := range freed {
+		dropped.heap.Drop()
+	}
+	numRemoved := 0
+	for _, ix := range indexes {
+		numRemoved += ix.collectGarbage(oldestSnapshot)
+	}
+	return numRemoved
+}
+
+func validateName(name string) error {
+	if name == "" || len(name) > 1<<16-1 {
+		return fmt.Errorf("Invalid name %q.", name)
+	}
+	return nil
+}
+
+// Take the lock for changing the catalog, and read the entries. Fails if the
+// catalog changed after the snapshot of `txn`.
+func (c *Catalog) beginChange(txn *transaction.Transaction) (map[string]*TableInfo, map[string]*IndexInfo, error) {
+	if lockManager := txn.LockManager(); lockManager != nil {
+		if err := lockManager.Lock(txn, c.changeLockId(), transaction.Exclusive); err != nil {
+			return nil, nil, err
+		}
+	}
+	c.mu.Lock()
+	lastChangeTs := c.lastChangeTs
+	c.mu.Unlock()
+	if lastChangeTs > txn.ReadTimestamp() {
+		return nil, nil, table.ErrWriteConflict
+	}
+	return c.readEntries(txn)
+}
+
+// The lock taken to change the catalog. It is not a record of the catalog
+// heap, since slot numbers are never negative.
+func (c *Catalog) changeLockId() common.RID {
+	return common.RID{PageId: CatalogPageId, SlotNum: -2}
+}
+
+// Read all entries visible to `txn`, and return the tables and the indexes
+// by name.
+func (c *Catalog) readEntries(txn *transaction.Transaction) (map[string]*TableInfo, map[string]*IndexInfo, error) {
+	tables := make(map[string]*TableInfo)
+	indexes := make(map[string]*IndexInfo)
+	it := c.heap.Iterator(txn)
+	defer it.Close()
+	for it.Next() {
+		data := it.Record()
+		switch data[0] {
+		case tableEntry:
+			info, err := decodeTableEntry(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			info.rid = it.RID()
+			info.Indexes = make([]*IndexInfo, 0)
+			tables[info.Name] = info
+		case indexEntry:
+			info, err := decodeIndexEntry(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			info.rid = it.RID()
+			indexes[info.Name] = info
+		default:
+			return nil, nil, fmt.Errorf("Unknown catalog entry kind %d.", data[0])
+		}
+	}
+	for _, index := range indexes {
+		if info, ok := tables[index.TableName]; ok {
+			info.Indexes = append(info.Indexes, index)
+		}
+	}
+	for name, info := range tables {
+		sort.Slice(info.Indexes, func(i, j int) bool { return info.Indexes[i].Name < info.Indexes[j].Name })
+		var ok bool
+		if info.Heap, ok = c.openHeap(txn, info.HeaderPageId); !ok {
+			// Dropped after the snapshot was taken.
+			delete(tables, name)
+			for _, index := range info.Indexes {
+				delete(indexes, index.Name)
+			}
+		}
+	}
+	return tables, indexes, nil
+}
+
+// Open the table heap of an entry visible to `txn`, or return false if the
+// table has been dropped. An entry is of a dropped table exactly when the
+// snapshot of `txn` is older than the last drop of a table with the same
+// header page: a table created after the drop is not visible to it, and the
+// snapshots that see the drop do not see the entries of the tables dropped.
+func (c *Catalog) openHeap(txn *transaction.Transaction, headerPageId common.PageId) (*table.TableHeap, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if droppedTs, ok := c.droppedHeaps[headerPageId]; ok && txn.ReadTimestamp() < droppedTs {
+		return nil, false
+	}
+	heap, ok := c.heaps[headerPageId]
+	if !ok {
+		heap = table.OpenTableHeap(c.bufferPoolManager, headerPageId)
+		c.heaps[headerPageId] = heap
+	}
+	return heap, true
+}
+
+func (c *Catalog) deleteEntry(txn *transaction.Transaction, rid common.RID) error {
+	deleted, err := c.heap.Delete(txn, rid)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return table.ErrWriteConflict
+	}
+	return nil
+}
+
+func (c *Catalog) appendChange(txn *transaction.Transaction, change *catalogChange) {
+	txn.AppendWriteRecord(&transaction.WriteRecord{
+		Table:       change,
+		UndoNextLSN: txn.PrevLSN(),
+	})
+}
+
+// catalogChange is put into the write set of a transaction changing the
+// catalog, to finish the change once the transaction ends.
+type catalogChange struct {
+	catalog        *Catalog
+	createdHeap    *table.TableHeap // Freed if the transaction aborts.
+	droppedHeap    *table.TableHeap // Freed by `CollectGarbage` after the commit.
+	createdIndex   *Index           // Freed if the transaction aborts.
+	droppedIndexes []*Index         // Freed once the transaction commits.
+}
+
+func (cc *catalogChange) CommitWrite(txn *transaction.Transaction, record *transaction.WriteRecord) {
+	c := cc.catalog
+	c.mu.Lock()
+	c.lastChangeTs = txn.CommitTimestamp()
+	if cc.createdIndex != nil {
+		c.indexChangeTs[cc.createdIndex.tableHeaderPageId] = txn.CommitTimestamp()
+	}
+	for _, ix := range cc.droppedIndexes {
+		c.removeIndex(ix)
+		c.indexChangeTs[ix.tableHeaderPageId] = txn.CommitTimestamp()
+	}
+	if cc.droppedHeap != nil {
+		headerPageId := cc.droppedHeap.HeaderPageId()
+		delete(c.heaps, headerPageId)
+		c.droppedHeaps[headerPageId] = txn.CommitTimestamp()
+		c.heapsToFree = append(c.heapsToFree, heapToFree{heap: cc.droppedHeap, droppedTs: txn.CommitTimestamp()})
+		delete(c.tableIndexes, headerPageId)
+		delete(c.indexChangeTs, headerPageId)
+		delete(c.writeTs, headerPageId)
+	}
+	c.mu.Unlock()
+	for _, ix := range cc.droppedIndexes {
+		ix.drop()
+	}
+}
+
+func (cc *catalogChange) RollbackWrite(txn *transaction.Transaction, record *transaction.WriteRecord) {
+	c := cc.catalog
+	if cc.createdIndex != nil {
+		c.mu.Lock()
+		c.removeIndex(cc.createdIndex)
+		c.mu.Unlock()
+		cc.createdIndex.drop()
+	}
+	if cc.createdHeap != nil {
+		c.mu.Lock()
+		// The entry of the table was never visible to other transactions.
+		delete(c.heaps, cc.createdHeap.HeaderPageId())
+		delete(c.tableIndexes, cc.createdHeap.HeaderPageId())
+		c.mu.Unlock()
+		cc.createdHeap.Drop()
+	}
+}
+
+// Forget an index structure which is about to be freed. `c.mu` must be held.
+func (c *Catalog) removeIndex(ix *Index) {
+	delete(c.indexes, ix.Info.RootPageId)
+	indexes, ok := c.tableIndexes[ix.tableHeaderPageId]
+	if !ok {
+		return
+	}
+	kept := make([]*Index, 0, len(indexes))
+	for _, other := range indexes {
+		if other != ix {
+			kept = append(kept, other)
+		}
+	}
+	c.tableIndexes[ix.tableHeaderPageId] = kept
+}
@@ -0,0 +1,332 @@
+package catalog
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/table"
+	"simple-db-golang/src/transaction"
+	"simple-db-golang/src/types"
+	"simple-db-golang/src/wal"
+)
+
+func testSchema() *types.Schema {
+	return types.NewSchema(
+		types.Column{Name: "id", Type: types.Integer},
+		types.Column{Name: "name", Type: types.Varchar, Length: 32, Nullable: true},
+		types.Column{Name: "score", Type: types.Double},
+	)
+}
+
+type testDatabase struct {
+	diskManager       *disk.DiskManager
+	logManager        *wal.LogManager
+	bufferPoolManager *disk.BufferPoolManager
+	lockManager       *transaction.LockManager
+	txnManager        *transaction.TransactionManager
+	catalog           *Catalog
+}
+
+func openTestDatabase(t *testing.T, isNew bool) *testDatabase {
+	if isNew {
+		// Remove the files of a test which failed before cleaning up, since
+		// the catalog must be the first thing allocated in the database file.
+		os.Remove("test.db")
+		wal.RemoveLogFiles("test.log")
+	}
+	db := &testDatabase{
+		diskManager: disk.NewDiskManager("test.db"),
+		logManager:  wal.NewLogManager("test.log"),
+		lockManager: transaction.NewLockManager(transaction.DeadlockDetection),
+	}
+	db.bufferPoolManager = disk.NewBufferPoolManager(16, db.diskManager, disk.NewLRUReplacer())
+	db.bufferPoolManager.SetLogManager(db.logManager)
+	if isNew {
+		db.catalog = NewCatalog(db.bufferPoolManager)
+	} else {
+		var err error
+		db.catalog, err = OpenCatalog(db.bufferPoolManager)
+		require.Nil(t, err)
+	}
+	db.txnManager = transaction.NewTransactionManager(db.logManager, db.lockManager)
+	return db
+}
+
+// Close the files without flushing the buffer pool, like a crash.
+func (db *testDatabase) close() {
+	db.lockManager.Close()
+	db.diskManager.Close()
+	db.logManager.Close()
+}
+
+func TestCatalog(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	catalog := NewCatalog(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+
+	txn := txnManager.Begin()
+	tables, err := catalog.ListTables(txn)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(tables))
+	users, err := catalog.CreateTable(txn, "users", testSchema())
+	require.Nil(t, err)
+	require.NotEqual(t, CatalogPageId, users.HeaderPageId)
+	_, err = catalog.CreateTable(txn, "orders", types.NewSchema(types.Column{Name: "id", Type: types.BigInt}))
+	require.Nil(t, err)
+	_, err = catalog.CreateTable(txn, "users", testSchema())
+	require.Equal(t, ErrTableExists, err)
+	_, err = catalog.CreateTable(txn, "bad", types.NewSchema(types.Column{Name: "a", Type: types.Integer}, types.Column{Name: "a", Type: types.Boolean}))
+	require.NotNil(t, err)
+	_, err = catalog.CreateTable(txn, "bad", types.NewSchema(types.Column{Name: "a", Type: types.Varchar}))
+	require.NotNil(t, err)
+
+	usersId := &IndexInfo{Name: "users_id", TableName: "users", Columns: []string{"id"}, Kind: BPlusTreeIndex, Unique: true}
+	require.Nil(t, catalog.CreateIndex(txn, usersId))
+	require.NotEqual(t, common.InvalidPageId, usersId.RootPageId)
+	require.Equal(t, ErrIndexExists, catalog.CreateIndex(txn, &IndexInfo{
+		Name: "users_id", TableName: "users", Columns: []string{"id"}, Kind: HashIndex,
+	}))
+	require.NotNil(t, catalog.CreateIndex(txn, &IndexInfo{
+		Name: "users_missing", TableName: "users", Columns: []string{"missing"}, Kind: HashIndex,
+	}))
+	require.Equal(t, ErrTableNotFound, catalog.CreateIndex(txn, &IndexInfo{
+		Name: "missing_id", TableName: "missing", Columns: []string{"id"}, Kind: HashIndex,
+	}))
+	require.Nil(t, catalog.CreateIndex(txn, &IndexInfo{
+		Name: "users_name", TableName: "users", Columns: []string{"name", "score"}, Kind: HashIndex,
+	}))
+
+	// The table heap is the one created, and is usable right away.
+	rid, err := users.Heap.Insert(txn, []byte("alice"))
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Commit(txn))
+
+	txn = txnManager.Begin()
+	info, err := catalog.GetTable(txn, "users")
+	require.Nil(t, err)
+	require.Equal(t, "users", info.Name)
+	require.Equal(t, testSchema(), info.Schema)
+	require.Equal(t, users.HeaderPageId, info.HeaderPageId)
+	require.True(t, users.Heap == info.Heap)
+	require.Equal(t, 2, len(info.Indexes))
+	require.Equal(t, &IndexInfo{
+		Name: "users_id", TableName: "users", Columns: []string{"id"}, Kind: BPlusTreeIndex, Unique: true, RootPageId: usersId.RootPageId,
+		rid: info.Indexes[0].rid,
+	}, info.Indexes[0])
+	require.Equal(t, "users_name", info.Indexes[1].Name)
+	require.Equal(t, []string{"name", "score"}, info.Indexes[1].Columns)
+	data, found, err := info.Heap.Get(txn, rid)
+	require.Nil(t, err)
+	require.True(t, found)
+	require.Equal(t, []byte("alice"), data)
+
+	tables, err = catalog.ListTables(txn)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(tables))
+	require.Equal(t, "orders", tables[0].Name)
+	require.Equal(t, "users", tables[1].Name)
+
+	require.Nil(t, catalog.DropIndex(txn, "users_name"))
+	require.Equal(t, ErrIndexNotFound, catalog.DropIndex(txn, "users_name"))
+	require.Nil(t, catalog.DropTable(txn, "users"))
+	require.Equal(t, ErrTableNotFound, catalog.DropTable(txn, "users"))
+	_, err = catalog.GetTable(txn, "users")
+	require.Equal(t, ErrTableNotFound, err)
+	require.Nil(t, txnManager.Commit(txn))
+
+	// The table heap is freed along with the garbage of the table.
+	catalog.CollectGarbage(txnManager.OldestSnapshot())
+	_, err = bufferPoolManager.FetchPage(users.HeaderPageId)
+	require.NotNil(t, err)
+	txn = txnManager.Begin()
+	tables, err = catalog.ListTables(txn)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tables))
+	require.Equal(t, "orders", tables[0].Name)
+	require.Nil(t, txnManager.Commit(txn))
+}
+
+func TestCatalog_Abort(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	catalog := NewCatalog(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+
+	txn := txnManager.Begin()
+	kept, err := catalog.CreateTable(txn, "kept", testSchema())
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Commit(txn))
+
+	// A rolled back creation frees the table heap, and a rolled back drop
+	// keeps it.
+	txn = txnManager.Begin()
+	created, err := catalog.CreateTable(txn, "created", testSchema())
+	require.Nil(t, err)
+	require.Nil(t, catalog.DropTable(txn, "kept"))
+	require.Nil(t, txnManager.Abort(txn))
+	_, err = bufferPoolManager.FetchPage(created.HeaderPageId)
+	require.NotNil(t, err)
+
+	txn = txnManager.Begin()
+	_, err = catalog.GetTable(txn, "created")
+	require.Equal(t, ErrTableNotFound, err)
+	info, err := catalog.GetTable(txn, "kept")
+	require.Nil(t, err)
+	require.Equal(t, kept.HeaderPageId, info.HeaderPageId)
+	_, err = info.Heap.Insert(txn, []byte("row"))
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Commit(txn))
+}
+
+func TestCatalog_Snapshot(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	catalog := NewCatalog(bufferPoolManager)
+	lockManager := transaction.NewLockManager(transaction.DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := transaction.NewTransactionManager(nil, lockManager)
+
+	txn := txnManager.Begin()
+	_, err := catalog.CreateTable(txn, "dropped", testSchema())
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Commit(txn))
+
+	reader := txnManager.Begin()
+	txn = txnManager.Begin()
+	_, err = catalog.CreateTable(txn, "created", testSchema())
+	require.Nil(t, err)
+	require.Nil(t, catalog.DropTable(txn, "dropped"))
+	// Uncommitted changes are not visible to others.
+	tables, err := catalog.ListTables(reader)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tables))
+	require.Equal(t, "dropped", tables[0].Name)
+	require.Nil(t, txnManager.Commit(txn))
+
+	// Neither are changes committed after the snapshot, except that the heap
+	// of the dropped table is gone.
+	_, err = catalog.GetTable(reader, "created")
+	require.Equal(t, ErrTableNotFound, err)
+	_, err = catalog.GetTable(reader, "dropped")
+	require.Equal(t, ErrTableNotFound, err)
+	// Changing the catalog from an old snapshot is a conflict.
+	_, err = catalog.CreateTable(reader, "created", testSchema())
+	require.Equal(t, table.ErrWriteConflict, err)
+	require.Nil(t, txnManager.Abort(reader))
+
+	txn = txnManager.Begin()
+	_, err = catalog.GetTable(txn, "created")
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Commit(txn))
+}
+
+func TestCatalog_DropWhileReading(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	catalog := NewCatalog(bufferPoolManager)
+	lockManager := transaction.NewLockManager(transaction.DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := transaction.NewTransactionManager(nil, lockManager)
+
+	txn := txnManager.Begin()
+	dropped, err := catalog.CreateTable(txn, "dropped", testSchema())
+	require.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		_, err = dropped.Heap.Insert(txn, make([]byte, 200))
+		require.Nil(t, err)
+	}
+	require.Nil(t, txnManager.Commit(txn))
+
+	// Readers take no locks, so the drop commits while a scan is open.
+	reader := txnManager.Begin()
+	it := dropped.Heap.Iterator(reader)
+	require.True(t, it.Next())
+	txn = txnManager.Begin()
+	require.Nil(t, catalog.DropTable(txn, "dropped"))
+	require.Nil(t, txnManager.Commit(txn))
+	_, err = catalog.GetTable(reader, "dropped")
+	require.Equal(t, ErrTableNotFound, err)
+
+	// The heap is kept as long as the reader may use it, so its pages are not
+	// reused by a new table.
+	catalog.CollectGarbage(txnManager.OldestSnapshot())
+	txn = txnManager.Begin()
+	created, err := catalog.CreateTable(txn, "created", testSchema())
+	require.Nil(t, err)
+	require.NotEqual(t, dropped.HeaderPageId, created.HeaderPageId)
+	require.Nil(t, txnManager.Commit(txn))
+	numRecords := 1
+	for it.Next() {
+		numRecords++
+	}
+	require.Equal(t, 100, numRecords)
+	it.Close()
+	require.Nil(t, txnManager.Commit(reader))
+
+	// Once the reader is gone, the heap is freed and its pages reused.
+	catalog.CollectGarbage(txnManager.OldestSnapshot())
+	_, err = bufferPoolManager.FetchPage(dropped.HeaderPageId)
+	require.NotNil(t, err)
+	txn = txnManager.Begin()
+	reused, err := catalog.CreateTable(txn, "reused", testSchema())
+	require.Nil(t, err)
+	require.Equal(t, dropped.HeaderPageId, reused.HeaderPageId)
+	require.Nil(t, txnManager.Commit(txn))
+	txn = txnManager.Begin()
+	info, err := catalog.GetTable(txn, "reused")
+	require.Nil(t, err)
+	require.Equal(t, reused.Heap, info.Heap)
+	_, err = catalog.GetTable(txn, "dropped")
+	require.Equal(t, ErrTableNotFound, err)
+	require.Nil(t, txnManager.Commit(txn))
+}
+
+func TestCatalog_Reopen(t *testing.T) {
+	defer os.Remove("test.db")
+	defer wal.RemoveLogFiles("test.log")
+
+	db := openTestDatabase(t, true)
+	txn := db.txnManager.Begin()
+	rids := make(map[string]common.RID)
+	aId := &IndexInfo{Name: "a_id", TableName: "a", Columns: []string{"id"}, Kind: BPlusTreeIndex}
+	for _, name := range []string{"a", "b", "c"} {
+		info, err := db.catalog.CreateTable(txn, name, testSchema())
+		require.Nil(t, err)
+		if name == "a" {
+			require.Nil(t, db.catalog.CreateIndex(txn, aId))
+		}
+		rids[name], err = info.Heap.Insert(txn, []byte("row of "+name))
+		require.Nil(t, err)
+	}
+	require.Nil(t, db.txnManager.Commit(txn))
+	txn = db.txnManager.Begin()
+	require.Nil(t, db.catalog.DropTable(txn, "b"))
+	require.Nil(t, db.txnManager.Commit(txn))
+	// Unfinished, rolled back by recovery.
+	txn = db.txnManager.Begin()
+	_, err := db.catalog.CreateTable(txn, "d", testSchema())
+	require.Nil(t, err)
+	require.Nil(t, db.catalog.DropTable(txn, "c"))
+	db.logManager.Flush(db.logManager.LastLSN())
+	db.close()
+
+	db = openTestDatabase(t, false)
+	txn = db.txnManager.Begin()
+	tables, err := db.catalog.ListTables(txn)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(tables))
+	require.Equal(t, "a", tables[0].Name)
+	require.Equal(t, "c", tables[1].Name)
+	require.Equal(t, testSchema(), tables[0].Schema)
+	require.Equal(t, 1, len(tables[0].Indexes))
+	require.Equal(t, aId.RootPageId, tables[0].Indexes[0].RootPageId)
+	for _, info := range tables {
+		data, found, err := info.Heap.Get(txn, rids[info.Name])
+		require.Nil(t, err)
+		require.True(t, found)
+		require.Equal(t, []byte("row of "+info.Name), data)
+	}
+	require.Nil(t, db.txnManager.Commit(txn))
+	db.close()
+}
@@ -0,0 +1,162 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/types"
+)
+
+// Every table and every index has an entry in the catalog heap. All integers
+// are little endian, and strings are prefixed with their length (2):
+//   - tableEntry: `| kind | name | header page id (4) | number of columns (2) |
+//     columns ... |`, where each column is `| name | type (1) | length (4) |
+//     nullable (1) |`.
+//   - indexEntry: `| kind | name | table name | index kind (1) | unique (1) |
+//     root page id (4) | number of key columns (2) | key column names ... |`.
+const (
+	tableEntry = byte(1)
+	indexEntry = byte(2)
+)
+
+type entryWriter struct {
+	buf []byte
+}
+
+func (w *entryWriter) putByte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *entryWriter) putBool(b bool) {
+	if b {
+		w.putByte(1)
+	} else {
+		w.putByte(0)
+	}
+}
+
+func (w *entryWriter) putUint16(v int) {
+	w.buf = append(w.buf, 0, 0)
+	binary.LittleEndian.PutUint16(w.buf[len(w.buf)-2:], uint16(v))
+}
+
+func (w *entryWriter) putUint32(v uint32) {
+	w.buf = append(w.buf, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(w.buf[len(w.buf)-4:], v)
+}
+
+func (w *entryWriter) putString(s string) {
+	w.putUint16(len(s))
+	w.buf = append(w.buf, s...)
+}
+
+// entryReader decodes an entry. Reading past the end yields zeros, and makes
+// `err` return an error.
+type entryReader struct {
+	data      []byte
+	truncated bool
+}
+
+func (r *entryReader) next(n int) []byte {
+	if len(r.data) < n {
+		r.truncated = true
+		r.data = nil
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *entryReader) byte() byte {
+	return r.next(1)[0]
+}
+
+func (r *entryReader) bool() bool {
+	return r.byte() != 0
+}
+
+func (r *entryReader) uint16() int {
+	return int(binary.LittleEndian.Uint16(r.next(2)))
+}
+
+func (r *entryReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.next(4))
+}
+
+func (r *entryReader) string() string {
+	return string(r.next(r.uint16()))
+}
+
+func (r *entryReader) err() error {
+	if r.truncated || len(r.data) != 0 {
+		return fmt.Errorf("Catalog entry is malformed.")
+	}
+	return nil
+}
+
+func encodeTableEntry(info *TableInfo) []byte {
+	w := &entryWriter{}
+	w.putByte(tableEntry)
+	w.putString(info.Name)
+	w.putUint32(uint32(info.HeaderPageId))
+	w.putUint16(len(info.Schema.Columns))
+	for _, column := range info.Schema.Columns {
+		w.putString(column.Name)
+		w.putByte(byte(column.Type))
+		w.putUint32(uint32(column.Length))
+		w.putBool(column.Nullable)
+	}
+	return w.buf
+}
+
+func decodeTableEntry(data []byte) (*TableInfo, error) {
+	r := &entryReader{data: data[1:]}
+	info := &TableInfo{
+		Name:         r.string(),
+		HeaderPageId: common.PageId(r.uint32()),
+		Schema:       &types.Schema{},
+	}
+	info.Schema.Columns = make([]types.Column, r.uint16())
+	for i := range info.Schema.Columns {
+		info.Schema.Columns[i] = types.Column{
+			Name:     r.string(),
+			Type:     types.TypeId(r.byte()),
+			Length:   int(r.uint32()),
+			Nullable: r.bool(),
+		}
+	}
+	return info, r.err()
+}
+
+func encodeIndexEntry(info *IndexInfo) []byte {
+	w := &entryWriter{}
+	w.putByte(indexEntry)
+	w.putString(info.Name)
+	w.putString(info.TableName)
+	w.putByte(byte(info.Kind))
+	w.putBool(info.Unique)
+	w.putUint32(uint32(info.RootPageId))
+	w.putUint16(len(info.Columns))
+	for _, column := range info.Columns {
+		w.putString(column)
+	}
+	return w.buf
+}
+
+func decodeIndexEntry(data []byte) (*IndexInfo, error) {
+	r := &entryReader{data: data[1:]}
+	info := &IndexInfo{
+		Name:       r.string(),
+		TableName:  r.string(),
+		Kind:       IndexKind(r.byte()),
+		Unique:     r.bool(),
+		RootPageId: common.PageId(r.uint32()),
+	}
+	info.Columns = make([]string, r.uint16())
+	for i := range info.Columns {
+		info.Columns[i] = r.string()
+	}
+	return info, r.err()
+}
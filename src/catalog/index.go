@@ -0,0 +1,291 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/index"
+	"simple-db-golang/src/table"
+	"simple-db-golang/src/transaction"
+	"simple-db-golang/src/types"
+)
+
+// Index is the structure of an index, a B+ tree or an extendible hash table,
+// mapping the key of each row of its table to the RID of the row. Keys are
+// the values of the key columns encoded with `types.EncodeKey`.
+//
+// A B+ tree only holds unique keys, so the RID of the row is appended to its
+// keys, while a hash table holds the key of every row as is.
+//
+// A row whose key changes keeps its old entry as long as older snapshots may
+// find the row through it, so lookups check the key of the row they find.
+type Index struct {
+	Info              *IndexInfo
+	tableHeaderPageId common.PageId
+	schema            *types.Schema // Of the table.
+	columns           []int         // Positions of the key columns in the table.
+	bufferPoolManager *disk.BufferPoolManager
+	// Nil until the structure is opened.
+	tree *index.BPlusTree
+	hash *index.ExtendibleHashTable
+
+	// Entries left behind by committed transactions, with the commit
+	// timestamp, removed once no snapshot can see the rows they point to.
+	garbage map[keyEntry]transaction.Timestamp
+	mu      sync.Mutex // Guards garbage.
+	// Held exclusively while the index is rebuilt or dropped, and shared
+	// while it is used.
+	rw      sync.RWMutex
+	dropped bool
+}
+
+// keyEntry is an entry of an index: a key and the RID of a row.
+type keyEntry struct {
+	index *Index
+	key   string
+	rid   common.RID
+}
+
+// Size of the RID appended to the keys of a B+ tree.
+const ridSize = 8
+
+func newIndex(bufferPoolManager *disk.BufferPoolManager, tableInfo *TableInfo, info *IndexInfo) *Index {
+	ix := &Index{
+		Info:              info,
+		tableHeaderPageId: tableInfo.HeaderPageId,
+		schema:            tableInfo.Schema,
+		columns:           make([]int, len(info.Columns)),
+		bufferPoolManager: bufferPoolManager,
+		garbage:           make(map[keyEntry]transaction.Timestamp),
+	}
+	for i, column := range info.Columns {
+		ix.columns[i], _ = tableInfo.Schema.ColumnIndex(column)
+	}
+	return ix
+}
+
+// Size of the keys of the structure of an index on `columns`.
+func indexKeySize(kind IndexKind, columns []types.Column) int {
+	size := types.MaxKeySize(columns)
+	if kind == BPlusTreeIndex {
+		size += ridSize
+	}
+	return size
+}
+
+func (ix *Index) checkKeySize() error {
+	if keySize := indexKeySize(ix.Info.Kind, ix.keyColumns()); keySize > index.MaxKeySize {
+		return fmt.Errorf("Keys of index %s may take %d bytes, more than %d.", ix.Info.Name, keySize, index.MaxKeySize)
+	}
+	return nil
+}
+
+// Create the structure of the index, and set the root page id of its info.
+func (ix *Index) create() error {
+	var err error
+	keySize := indexKeySize(ix.Info.Kind, ix.keyColumns())
+	if ix.Info.Kind == BPlusTreeIndex {
+		if ix.tree, err = index.NewBPlusTree(ix.bufferPoolManager, keySize); err != nil {
+			return err
+		}
+		ix.Info.RootPageId = ix.tree.HeaderPageId()
+	} else {
+		if ix.hash, err = index.NewExtendibleHashTable(ix.bufferPoolManager, keySize); err != nil {
+			return err
+		}
+		ix.Info.RootPageId = ix.hash.DirectoryPageId()
+	}
+	return nil
+}
+
+// Open the structure of the index emptied, see `index.RecoverBPlusTree`.
+func (ix *Index) recover() {
+	if ix.Info.Kind == BPlusTreeIndex {
+		ix.tree = index.RecoverBPlusTree(ix.bufferPoolManager, ix.Info.RootPageId)
+	} else {
+		ix.hash = index.RecoverExtendibleHashTable(ix.bufferPoolManager, ix.Info.RootPageId)
+	}
+}
+
+// The key columns, as declared in the schema of the table.
+func (ix *Index) keyColumns() []types.Column {
+	columns := make([]types.Column, len(ix.columns))
+	for i, column := range ix.columns {
+		columns[i] = ix.schema.Columns[column]
+	}
+	return columns
+}
+
+// The key of a row with `values`, and whether the key has a NULL value, which
+// is never equal to another key.
+func (ix *Index) key(values []types.Value) ([]byte, bool) {
+	keyValues := make([]types.Value, len(ix.columns))
+	hasNull := false
+	for i, column := range ix.columns {
+		keyValues[i] = values[column]
+		hasNull = hasNull || keyValues[i].IsNull()
+	}
+	return types.EncodeKey(keyValues), hasNull
+}
+
+// Check that `values` can be looked up in the index, and return their key.
+func (ix *Index) lookupKey(values []types.Value) ([]byte, bool, error) {
+	if len(values) != len(ix.columns) {
+		return nil, false, fmt.Errorf("Index %s has %d key columns, not %d.", ix.Info.Name, len(ix.columns), len(values))
+	}
+	hasNull := false
+	for i, column := range ix.keyColumns() {
+		if values[i].Type() != column.Type {
+			return nil, false, fmt.Errorf("Column %s is %s, not %s.", column.Name, column.Type, values[i].Type())
+		}
+		hasNull = hasNull || values[i].IsNull()
+	}
+	return types.EncodeKey(values), hasNull, nil
+}
+
+func treeKey(key []byte, rid common.RID) []byte {
+	treeKey := make([]byte, len(key)+ridSize)
+	copy(treeKey, key)
+	binary.BigEndian.PutUint32(treeKey[len(key):], uint32(rid.PageId))
+	binary.BigEndian.PutUint32(treeKey[len(key)+4:], uint32(int32(rid.SlotNum)))
+	return treeKey
+}
+
+// Add an entry, returning false if it is already in the index.
+func (ix *Index) insert(key []byte, rid common.RID) (bool, error) {
+	if ix.tree != nil {
+		return ix.tree.Insert(treeKey(key, rid), rid)
+	}
+	return ix.hash.Insert(key, rid)
+}
+
+// Remove an entry, returning false if it is not in the index.
+func (ix *Index) remove(key []byte, rid common.RID) bool {
+	if ix.tree != nil {
+		return ix.tree.Delete(treeKey(key, rid))
+	}
+	return ix.hash.Delete(key, rid)
+}
+
+// The RIDs of the entries with `key`.
+func (ix *Index) rids(key []byte) []common.RID {
+	if ix.hash != nil {
+		return ix.hash.Get(key)
+	}
+	rids := make([]common.RID, 0)
+	// No key is a prefix of another, so the keys starting with `key` are
+	// `key` followed by a RID.
+	it := ix.tree.Iterator(key, nil)
+	for it.Next() && bytes.HasPrefix(it.Key(), key) {
+		rids = append(rids, it.RID())
+	}
+	return rids
+}
+
+// Fill the index with the rows of its table visible to `txn`. Fails with
+// `ErrDuplicateKey` if a unique index has the same key twice.
+func (ix *Index) build(txn *transaction.Transaction, heap *table.TableHeap) error {
+	it := heap.Iterator(txn)
+	defer it.Close()
+	for it.Next() {
+		tuple, err := types.DecodeTuple(ix.schema, it.Record())
+		if err != nil {
+			return err
+		}
+		key, hasNull := ix.key(tuple.Values(ix.schema))
+		if _, err := ix.insert(key, it.RID()); err != nil {
+			return err
+		}
+		if ix.Info.Unique && !hasNull && len(ix.rids(key)) > 1 {
+			return ErrDuplicateKey
+		}
+	}
+	return nil
+}
+
+// Remove every entry, along with the garbage.
+func (ix *Index) clear() {
+	if ix.tree != nil {
+		ix.tree.Clear()
+	} else {
+		ix.hash.Clear()
+	}
+	ix.mu.Lock()
+	ix.garbage = make(map[keyEntry]transaction.Timestamp)
+	ix.mu.Unlock()
+}
+
+// Free the structure of the index, waiting for its users to finish.
+func (ix *Index) drop() {
+	ix.rw.Lock()
+	defer ix.rw.Unlock()
+	if ix.tree == nil && ix.hash == nil {
+		// Not opened since the database was opened, so its nodes cannot be
+		// trusted.
+		ix.recover()
+	}
+	if ix.tree != nil {
+		ix.tree.Drop()
+	} else {
+		ix.hash.Drop()
+	}
+	ix.dropped = true
+}
+
+// Mark an entry as garbage as of `ts`.
+func (ix *Index) addGarbage(entry keyEntry, ts transaction.Timestamp) {
+	ix.mu.Lock()
+	ix.garbage[entry] = ts
+	ix.mu.Unlock()
+}
+
+// Keep an entry found in the index: take it out of the garbage, returning its
+// timestamp if it was there, and add it again if the garbage collector
+// removed it meanwhile.
+func (ix *Index) keep(entry keyEntry) (transaction.Timestamp, bool, bool, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ts, isGarbage := ix.garbage[entry]
+	delete(ix.garbage, entry)
+	inserted, err := ix.insert([]byte(entry.key), entry.rid)
+	return ts, isGarbage, inserted, err
+}
+
+func (ix *Index) removeGarbage(entry keyEntry) {
+	ix.mu.Lock()
+	delete(ix.garbage, entry)
+	ix.mu.Unlock()
+}
+
+func (ix *Index) isGarbage(entry keyEntry) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	_, ok := ix.garbage[entry]
+	return ok
+}
+
+// Remove the entries that became garbage at or before `oldestSnapshot`.
+// Returns the number of removed entries.
+func (ix *Index) collectGarbage(oldestSnapshot transaction.Timestamp) int {
+	ix.rw.RLock()
+	defer ix.rw.RUnlock()
+	if ix.dropped {
+		return 0
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	numRemoved := 0
+	for entry, ts := range ix.garbage {
+		if ts > oldestSnapshot {
+			continue
+		}
+		ix.remove([]byte(entry.key), entry.rid)
+		delete(ix.garbage, entry)
+		numRemoved++
+	}
+	return numRemoved
+}
@@ -0,0 +1,417 @@
+package catalog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/table"
+	"simple-db-golang/src/transaction"
+	"simple-db-golang/src/types"
+)
+
+var (
+	ErrDuplicateKey = errors.New("Duplicate key in unique index.")
+)
+
+// Table is a table opened for a transaction, whose rows are tuples of its
+// schema. Its writes keep the indexes of the table up to date, including the
+// indexes created by the transaction after the table was opened.
+//
+// Index entries are added along with the rows, and removed if the write
+// fails, or if the transaction aborts. Entries of deleted rows and old keys
+// of updated rows are kept until no snapshot can see the rows as they were,
+// see `Catalog.CollectGarbage`.
+//
+// Writes take an IX lock on the table, and fail with `table.ErrWriteConflict`
+// if the indexes of the table changed after the snapshot of the transaction.
+// Rows must only be written through a Table once the table has indexes.
+type Table struct {
+	Info    *TableInfo
+	catalog *Catalog
+}
+
+// Open a table as of the snapshot of `txn`. The indexes of the table are
+// rebuilt the first time the table is opened since the database was opened.
+func (c *Catalog) OpenTable(txn *transaction.Transaction, name string) (*Table, error) {
+	info, err := c.GetTable(txn, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.openIndexes(txn, info); err != nil {
+		return nil, err
+	}
+	return &Table{Info: info, catalog: c}, nil
+}
+
+// Get the row at `rid` in the snapshot of `txn`.
+func (t *Table) Get(txn *transaction.Transaction, rid common.RID) (*types.Tuple, bool, error) {
+	data, found, err := t.Info.Heap.Get(txn, rid)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	tuple, err := types.DecodeTuple(t.Info.Schema, data)
+	if err != nil {
+		return nil, false, err
+	}
+	return tuple, true, nil
+}
+
+// Insert a row, and add it to the indexes. Fails with `ErrDuplicateKey` if a
+// unique index already has its key, in which case the row is removed again.
+func (t *Table) Insert(txn *transaction.Transaction, values []types.Value) (common.RID, error) {
+	tuple, err := types.NewTuple(t.Info.Schema, values)
+	if err != nil {
+		return common.RID{}, err
+	}
+	indexes, err := t.beginWrite(txn)
+	if err != nil {
+		return common.RID{}, err
+	}
+	defer t.endWrite(indexes)
+
+	rid, err := t.Info.Heap.Insert(txn, tuple.Data())
+	if err != nil {
+		return common.RID{}, err
+	}
+	change := t.newChange()
+	for _, ix := range indexes {
+		key, hasNull := ix.key(values)
+		if err := t.addEntry(txn, change, ix, key, hasNull, rid); err != nil {
+			change.rollback()
+			if _, deleteErr := t.Info.Heap.Delete(txn, rid); deleteErr != nil {
+				return common.RID{}, deleteErr
+			}
+			return common.RID{}, err
+		}
+	}
+	t.appendChange(txn, change)
+	return rid, nil
+}
+
+// Replace the row at `rid`, and move it to its new keys in the indexes.
+// Returns false if `txn` cannot see the row, and fails like
+// `table.TableHeap.Update`, or with `ErrDuplicateKey`. Index entries are only
+// added for the keys which change, and are removed again if the row cannot be
+// updated.
+func (t *Table) Update(txn *transaction.Transaction, rid common.RID, values []types.Value) (bool, error) {
+	tuple, err := types.NewTuple(t.Info.Schema, values)
+	if err != nil {
+		return false, err
+	}
+	indexes, err := t.beginWrite(txn)
+	if err != nil {
+		return false, err
+	}
+	defer t.endWrite(indexes)
+
+	old, found, err := t.Get(txn, rid)
+	if err != nil || !found {
+		return false, err
+	}
+	oldValues := old.Values(t.Info.Schema)
+	change := t.newChange()
+	for _, ix := range indexes {
+		oldKey, _ := ix.key(oldValues)
+		key, hasNull := ix.key(values)
+		if bytes.Equal(oldKey, key) {
+			continue
+		}
+		if err := t.addEntry(txn, change, ix, key, hasNull, rid); err != nil {
+			change.rollback()
+			return false, err
+		}
+		change.removed = append(change.removed, keyEntry{ix, string(oldKey), rid})
+	}
+	updated, err := t.Info.Heap.Update(txn, rid, tuple.Data())
+	if err != nil || !updated {
+		change.rollback()
+		return false, err
+	}
+	t.appendChange(txn, change)
+	return true, nil
+}
+
+// Delete the row at `rid`. Returns false if `txn` cannot see the row, and
+// fails like `table.TableHeap.Delete`. The index entries of the row are
+// removed once no snapshot can see it any more.
+func (t *Table) Delete(txn *transaction.Transaction, rid common.RID) (bool, error) {
+	indexes, err := t.beginWrite(txn)
+	if err != nil {
+		return false, err
+	}
+	defer t.endWrite(indexes)
+
+	old, found, err := t.Get(txn, rid)
+	if err != nil || !found {
+		return false, err
+	}
+	deleted, err := t.Info.Heap.Delete(txn, rid)
+	if err != nil || !deleted {
+		return false, err
+	}
+	oldValues := old.Values(t.Info.Schema)
+	change := t.newChange()
+	for _, ix := range indexes {
+		key, _ := ix.key(oldValues)
+		change.removed = append(change.removed, keyEntry{ix, string(key), rid})
+	}
+	t.appendChange(txn, change)
+	return true, nil
+}
+
+// The RIDs of the rows visible to `txn` whose key in the index `name` is
+// `key`, which has a value of the type of each key column. A key with a NULL
+// value matches no row.
+func (t *Table) Lookup(txn *transaction.Transaction, name string, key []types.Value) ([]common.RID, error) {
+	ix, err := t.index(name)
+	if err != nil {
+		return nil, err
+	}
+	encodedKey, hasNull, err := ix.lookupKey(key)
+	if err != nil {
+		return nil, err
+	}
+	rids := make([]common.RID, 0)
+	if hasNull {
+		return rids, nil
+	}
+	ix.rw.RLock()
+	if ix.dropped {
+		ix.rw.RUnlock()
+		return nil, ErrIndexNotFound
+	}
+	candidates := ix.rids(encodedKey)
+	ix.rw.RUnlock()
+	for _, rid := range candidates {
+		tuple, found, err := t.Get(txn, rid)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			if rowKey, _ := ix.key(tuple.Values(t.Info.Schema)); bytes.Equal(rowKey, encodedKey) {
+				rids = append(rids, rid)
+			}
+		}
+	}
+	return rids, nil
+}
+
+// Empty the index `name` and fill it again from the rows of the table. Waits
+// for the writers of the table with an X lock, and fails with
+// `table.ErrWriteConflict` if rows were written after the snapshot of `txn`.
+// Rows written by `txn` itself could not be rolled back in the index, so
+// neither can the table have been written by `txn`.
+func (t *Table) RebuildIndex(txn *transaction.Transaction, name string) error {
+	ix, err := t.index(name)
+	if err != nil {
+		return err
+	}
+	if err := t.Info.Heap.LockTable(txn, transaction.Exclusive); err != nil {
+		return err
+	}
+	c := t.catalog
+	c.mu.Lock()
+	writeTs := c.writeTs[t.Info.HeaderPageId]
+	c.mu.Unlock()
+	if writeTs > txn.ReadTimestamp() {
+		return table.ErrWriteConflict
+	}
+	for _, record := range txn.WriteSet() {
+		if change, ok := record.Table.(*indexChange); ok && change.table == t.Info.HeaderPageId {
+			return fmt.Errorf("Table %s was written by the transaction.", t.Info.Name)
+		}
+	}
+
+	ix.rw.Lock()
+	defer ix.rw.Unlock()
+	if ix.dropped {
+		return ErrIndexNotFound
+	}
+	ix.clear()
+	return ix.build(txn, t.Info.Heap)
+}
+
+// The index `name` of the table as of the snapshot the table was opened with.
+func (t *Table) index(name string) (*Index, error) {
+	for _, info := range t.Info.Indexes {
+		if info.Name != name {
+			continue
+		}
+		t.catalog.mu.Lock()
+		ix, ok := t.catalog.indexes[info.RootPageId]
+		t.catalog.mu.Unlock()
+		if !ok {
+			// Dropped after the snapshot was taken.
+			break
+		}
+		return ix, nil
+	}
+	return nil, ErrIndexNotFound
+}
+
+// Lock the table for writing, and return the indexes to keep up to date,
+// which are shared locked until `endWrite`.
+func (t *Table) beginWrite(txn *transaction.Transaction) ([]*Index, error) {
+	if err := t.Info.Heap.LockTable(txn, transaction.IntentionExclusive); err != nil {
+		return nil, err
+	}
+	c := t.catalog
+	c.mu.Lock()
+	indexes, ok := c.tableIndexes[t.Info.HeaderPageId]
+	indexChangeTs := c.indexChangeTs[t.Info.HeaderPageId]
+	c.mu.Unlock()
+	if !ok {
+		return nil, ErrTableNotFound
+	}
+	if indexChangeTs > txn.ReadTimestamp() {
+		return nil, table.ErrWriteConflict
+	}
+	for _, ix := range indexes {
+		ix.rw.RLock()
+	}
+	return indexes, nil
+}
+
+func (t *Table) endWrite(indexes []*Index) {
+	for _, ix := range indexes {
+		ix.rw.RUnlock()
+	}
+}
+
+// Add the entry of a row to an index, or keep it if it is already there, and
+// check that a unique index has no other visible row with the same key.
+func (t *Table) addEntry(txn *transaction.Transaction, change *indexChange, ix *Index, key []byte, hasNull bool, rid common.RID) error {
+	entry := keyEntry{ix, string(key), rid}
+	inserted, err := ix.insert(key, rid)
+	if err != nil {
+		return err
+	}
+	if !inserted {
+		// The entry may be the garbage of an earlier key of the row, which
+		// must stay now.
+		ts, isGarbage, reinserted, err := ix.keep(entry)
+		if isGarbage {
+			change.revived[entry] = ts
+		}
+		if err != nil {
+			return err
+		}
+		inserted = reinserted
+	}
+	if inserted {
+		change.added = append(change.added, entry)
+	}
+	change.kept = append(change.kept, entry)
+	if !ix.Info.Unique || hasNull {
+		return nil
+	}
+
+	for _, other := range ix.rids(key) {
+		entry := keyEntry{ix, string(key), other}
+		if other == rid || ix.isGarbage(entry) || removedBy(txn, entry) {
+			continue
+		}
+		tuple, found, err := t.Get(txn, other)
+		if err != nil {
+			return err
+		}
+		if found {
+			if otherKey, _ := ix.key(tuple.Values(t.Info.Schema)); bytes.Equal(otherKey, key) {
+				return ErrDuplicateKey
+			}
+		}
+		// Written by a transaction `txn` cannot see.
+		return table.ErrWriteConflict
+	}
+	return nil
+}
+
+// Whether the latest write of `txn` to the row of an entry removed the entry.
+func removedBy(txn *transaction.Transaction, entry keyEntry) bool {
+	removed := false
+	for _, record := range txn.WriteSet() {
+		change, ok := record.Table.(*indexChange)
+		if !ok {
+			continue
+		}
+		for _, kept := range change.kept {
+			if kept == entry {
+				removed = false
+			}
+		}
+		for _, old := range change.removed {
+			if old == entry {
+				removed = true
+			}
+		}
+	}
+	return removed
+}
+
+func (t *Table) newChange() *indexChange {
+	return &indexChange{
+		catalog: t.catalog,
+		table:   t.Info.HeaderPageId,
+		revived: make(map[keyEntry]transaction.Timestamp),
+	}
+}
+
+func (t *Table) appendChange(txn *transaction.Transaction, change *indexChange) {
+	txn.AppendWriteRecord(&transaction.WriteRecord{
+		Table:       change,
+		UndoNextLSN: txn.PrevLSN(),
+	})
+}
+
+// indexChange is put into the write set of a transaction for each row it
+// writes through a `Table`, to finish the changes of the indexes once the
+// transaction ends.
+type indexChange struct {
+	catalog *Catalog
+	table   common.PageId // Header page of the table heap.
+	// Entries added by the write, removed if the transaction aborts.
+	added []keyEntry
+	// Entries taken out of the garbage by the write, put back if the
+	// transaction aborts.
+	revived map[keyEntry]transaction.Timestamp
+	// Entries of the row after the write, and entries of the row before the
+	// write which it does not have any more, garbage once the transaction
+	// commits.
+	kept    []keyEntry
+	removed []keyEntry
+}
+
+func (change *indexChange) CommitWrite(txn *transaction.Transaction, record *transaction.WriteRecord) {
+	c := change.catalog
+	c.mu.Lock()
+	if _, ok := c.tableIndexes[change.table]; ok {
+		c.writeTs[change.table] = txn.CommitTimestamp()
+	}
+	c.mu.Unlock()
+	// A later write of the row may have brought back an entry that an earlier
+	// one removed.
+	for _, entry := range change.kept {
+		entry.index.removeGarbage(entry)
+	}
+	for _, entry := range change.removed {
+		entry.index.addGarbage(entry, txn.CommitTimestamp())
+	}
+}
+
+func (change *indexChange) RollbackWrite(txn *transaction.Transaction, record *transaction.WriteRecord) {
+	change.rollback()
+}
+
+// Undo the changes of the indexes.
+func (change *indexChange) rollback() {
+	for _, entry := range change.added {
+		entry.index.remove([]byte(entry.key), entry.rid)
+	}
+	for entry, ts := range change.revived {
+		entry.index.addGarbage(entry, ts)
+	}
+	change.added = nil
+	change.revived = make(map[keyEntry]transaction.Timestamp)
+}
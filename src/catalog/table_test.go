@@ -0,0 +1,329 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/table"
+	"simple-db-golang/src/transaction"
+	"simple-db-golang/src/types"
+	"simple-db-golang/src/wal"
+)
+
+func testRow(id int32, name string, score float64) []types.Value {
+	return []types.Value{types.NewInteger(id), types.NewVarchar(name), types.NewDouble(score)}
+}
+
+// Create the table "users" with a unique B+ tree index on "id" and a hash
+// index on "name".
+func createTestTable(t *testing.T, catalog *Catalog, txnManager *transaction.TransactionManager) {
+	txn := txnManager.Begin()
+	_, err := catalog.CreateTable(txn, "users", testSchema())
+	require.Nil(t, err)
+	require.Nil(t, catalog.CreateIndex(txn, &IndexInfo{
+		Name: "users_id", TableName: "users", Columns: []string{"id"}, Kind: BPlusTreeIndex, Unique: true,
+	}))
+	require.Nil(t, catalog.CreateIndex(txn, &IndexInfo{
+		Name: "users_name", TableName: "users", Columns: []string{"name"}, Kind: HashIndex,
+	}))
+	require.Nil(t, txnManager.Commit(txn))
+}
+
+func lookupId(t *testing.T, users *Table, txn *transaction.Transaction, id int32) []common.RID {
+	rids, err := users.Lookup(txn, "users_id", []types.Value{types.NewInteger(id)})
+	require.Nil(t, err)
+	return rids
+}
+
+func lookupName(t *testing.T, users *Table, txn *transaction.Transaction, name string) []common.RID {
+	rids, err := users.Lookup(txn, "users_name", []types.Value{types.NewVarchar(name)})
+	require.Nil(t, err)
+	return rids
+}
+
+// Number of entries of an index with the key of `values`, whether they are
+// garbage or not.
+func countEntries(t *testing.T, users *Table, name string, values ...types.Value) int {
+	ix, err := users.index(name)
+	require.Nil(t, err)
+	key, _, err := ix.lookupKey(values)
+	require.Nil(t, err)
+	return len(ix.rids(key))
+}
+
+func TestTable(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(16, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	catalog := NewCatalog(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+	createTestTable(t, catalog, txnManager)
+
+	txn := txnManager.Begin()
+	users, err := catalog.OpenTable(txn, "users")
+	require.Nil(t, err)
+	alice, err := users.Insert(txn, testRow(1, "alice", 1.5))
+	require.Nil(t, err)
+	bob, err := users.Insert(txn, testRow(2, "bob", 2.5))
+	require.Nil(t, err)
+	carol, err := users.Insert(txn, testRow(3, "bob", 3.5))
+	require.Nil(t, err)
+	require.Equal(t, []common.RID{alice}, lookupId(t, users, txn, 1))
+	require.ElementsMatch(t, []common.RID{bob, carol}, lookupName(t, users, txn, "bob"))
+	require.Equal(t, []common.RID{}, lookupId(t, users, txn, 4))
+
+	// A duplicate key is rejected along with its row.
+	_, err = users.Insert(txn, testRow(1, "dave", 0))
+	require.Equal(t, ErrDuplicateKey, err)
+	require.Equal(t, []common.RID{}, lookupName(t, users, txn, "dave"))
+	require.Equal(t, 0, countEntries(t, users, "users_name", types.NewVarchar("dave")))
+	_, err = users.Insert(txn, testRow(1, "alice", 1.5)[:2])
+	require.NotNil(t, err)
+	_, err = users.Lookup(txn, "users_id", []types.Value{types.NewBigInt(1)})
+	require.NotNil(t, err)
+	_, err = users.Lookup(txn, "missing", []types.Value{types.NewInteger(1)})
+	require.Equal(t, ErrIndexNotFound, err)
+	require.Nil(t, txnManager.Commit(txn))
+
+	reader := txnManager.Begin()
+	txn = txnManager.Begin()
+	users, err = catalog.OpenTable(txn, "users")
+	require.Nil(t, err)
+	// Updates move rows to their new keys, and fail on duplicate keys.
+	updated, err := users.Update(txn, carol, testRow(3, "carol", 3.5))
+	require.Nil(t, err)
+	require.True(t, updated)
+	_, err = users.Update(txn, carol, testRow(2, "carol", 3.5))
+	require.Equal(t, ErrDuplicateKey, err)
+	require.Equal(t, []common.RID{carol}, lookupId(t, users, txn, 3))
+	require.Equal(t, []common.RID{carol}, lookupName(t, users, txn, "carol"))
+	require.Equal(t, []common.RID{bob}, lookupName(t, users, txn, "bob"))
+	// A deleted key can be used again by another row.
+	deleted, err := users.Delete(txn, alice)
+	require.Nil(t, err)
+	require.True(t, deleted)
+	require.Equal(t, []common.RID{}, lookupId(t, users, txn, 1))
+	erin, err := users.Insert(txn, testRow(1, "erin", 4.5))
+	require.Nil(t, err)
+	require.Equal(t, []common.RID{erin}, lookupId(t, users, txn, 1))
+	// A row can go back to a key it had before.
+	_, err = users.Update(txn, erin, testRow(5, "erin", 4.5))
+	require.Nil(t, err)
+	_, err = users.Update(txn, erin, testRow(1, "erin", 4.5))
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Commit(txn))
+
+	// Older snapshots still find the rows as they were.
+	readerUsers, err := catalog.OpenTable(reader, "users")
+	require.Nil(t, err)
+	require.Equal(t, []common.RID{alice}, lookupId(t, readerUsers, reader, 1))
+	require.ElementsMatch(t, []common.RID{bob, carol}, lookupName(t, readerUsers, reader, "bob"))
+	require.Equal(t, []common.RID{}, lookupName(t, readerUsers, reader, "carol"))
+
+	// The old entries are removed once no snapshot can see them.
+	require.Equal(t, 0, catalog.CollectGarbage(txnManager.OldestSnapshot()))
+	require.Nil(t, txnManager.Commit(reader))
+	require.Equal(t, 2, countEntries(t, users, "users_id", types.NewInteger(1)))
+	require.Equal(t, 4, catalog.CollectGarbage(txnManager.OldestSnapshot()))
+	require.Equal(t, 1, countEntries(t, users, "users_id", types.NewInteger(1)))
+	require.Equal(t, 1, countEntries(t, users, "users_name", types.NewVarchar("bob")))
+	require.Equal(t, 0, countEntries(t, users, "users_id", types.NewInteger(5)))
+
+	txn = txnManager.Begin()
+	require.Equal(t, []common.RID{erin}, lookupId(t, users, txn, 1))
+	require.Equal(t, []common.RID{carol}, lookupId(t, users, txn, 3))
+	require.Equal(t, []common.RID{erin}, lookupName(t, users, txn, "erin"))
+	require.Nil(t, txnManager.Commit(txn))
+}
+
+func TestTable_Abort(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(16, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	catalog := NewCatalog(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+	createTestTable(t, catalog, txnManager)
+
+	txn := txnManager.Begin()
+	users, err := catalog.OpenTable(txn, "users")
+	require.Nil(t, err)
+	alice, err := users.Insert(txn, testRow(1, "alice", 1.5))
+	require.Nil(t, err)
+	bob, err := users.Insert(txn, testRow(2, "bob", 2.5))
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Commit(txn))
+	txn = txnManager.Begin()
+	_, err = users.Update(txn, bob, testRow(3, "bob", 2.5))
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Commit(txn))
+
+	txn = txnManager.Begin()
+	_, err = users.Insert(txn, testRow(4, "carol", 0))
+	require.Nil(t, err)
+	_, err = users.Update(txn, alice, testRow(5, "alice", 1.5))
+	require.Nil(t, err)
+	// Brings back the garbage entry of the first key of the row.
+	_, err = users.Update(txn, bob, testRow(2, "bob", 2.5))
+	require.Nil(t, err)
+	_, err = users.Delete(txn, bob)
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Abort(txn))
+
+	// The indexes are as before, garbage included.
+	txn = txnManager.Begin()
+	require.Equal(t, []common.RID{alice}, lookupId(t, users, txn, 1))
+	require.Equal(t, []common.RID{bob}, lookupId(t, users, txn, 3))
+	require.Equal(t, []common.RID{}, lookupId(t, users, txn, 2))
+	require.Equal(t, 0, countEntries(t, users, "users_id", types.NewInteger(4)))
+	require.Equal(t, 0, countEntries(t, users, "users_id", types.NewInteger(5)))
+	require.Equal(t, 0, countEntries(t, users, "users_name", types.NewVarchar("carol")))
+	require.Nil(t, txnManager.Commit(txn))
+	require.Equal(t, 1, catalog.CollectGarbage(txnManager.OldestSnapshot()))
+	require.Equal(t, 0, countEntries(t, users, "users_id", types.NewInteger(2)))
+
+	// An index created by an aborted transaction is freed.
+	txn = txnManager.Begin()
+	score := &IndexInfo{Name: "users_score", TableName: "users", Columns: []string{"score"}, Kind: BPlusTreeIndex}
+	require.Nil(t, catalog.CreateIndex(txn, score))
+	require.Nil(t, txnManager.Abort(txn))
+	_, err = bufferPoolManager.FetchPage(score.RootPageId)
+	require.NotNil(t, err)
+}
+
+func TestTable_Conflicts(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(16, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	catalog := NewCatalog(bufferPoolManager)
+	lockManager := transaction.NewLockManager(transaction.DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := transaction.NewTransactionManager(nil, lockManager)
+	createTestTable(t, catalog, txnManager)
+
+	// A key inserted by an uncommitted transaction cannot be inserted.
+	txn1 := txnManager.Begin()
+	users, err := catalog.OpenTable(txn1, "users")
+	require.Nil(t, err)
+	_, err = users.Insert(txn1, testRow(1, "alice", 1.5))
+	require.Nil(t, err)
+	txn2 := txnManager.Begin()
+	_, err = users.Insert(txn2, testRow(1, "alice", 1.5))
+	require.Equal(t, table.ErrWriteConflict, err)
+	require.Nil(t, txnManager.Abort(txn2))
+	require.Nil(t, txnManager.Commit(txn1))
+
+	// Nor can an index be created from a snapshot missing some rows, and
+	// writers from older snapshots conflict with new indexes.
+	reader := txnManager.Begin()
+	txn := txnManager.Begin()
+	_, err = users.Insert(txn, testRow(2, "bob", 2.5))
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Commit(txn))
+	writer := txnManager.Begin()
+	require.Equal(t, table.ErrWriteConflict, catalog.CreateIndex(reader, &IndexInfo{
+		Name: "users_score", TableName: "users", Columns: []string{"score"}, Kind: HashIndex,
+	}))
+	require.Nil(t, txnManager.Abort(reader))
+	txn = txnManager.Begin()
+	require.Nil(t, catalog.CreateIndex(txn, &IndexInfo{
+		Name: "users_score", TableName: "users", Columns: []string{"score"}, Kind: HashIndex,
+	}))
+	require.Nil(t, txnManager.Commit(txn))
+	_, err = users.Insert(writer, testRow(3, "carol", 3.5))
+	require.Equal(t, table.ErrWriteConflict, err)
+	require.Nil(t, txnManager.Abort(writer))
+
+	// A unique index cannot be created over duplicate keys.
+	txn = txnManager.Begin()
+	users, err = catalog.OpenTable(txn, "users")
+	require.Nil(t, err)
+	_, err = users.Insert(txn, testRow(3, "bob", 2.5))
+	require.Nil(t, err)
+	require.Equal(t, ErrDuplicateKey, catalog.CreateIndex(txn, &IndexInfo{
+		Name: "users_name_unique", TableName: "users", Columns: []string{"name"}, Kind: BPlusTreeIndex, Unique: true,
+	}))
+	require.Nil(t, txnManager.Abort(txn))
+}
+
+func TestTable_RebuildIndex(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(16, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	catalog := NewCatalog(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+	createTestTable(t, catalog, txnManager)
+
+	txn := txnManager.Begin()
+	users, err := catalog.OpenTable(txn, "users")
+	require.Nil(t, err)
+	rids := make([]common.RID, 100)
+	for i := range rids {
+		rids[i], err = users.Insert(txn, testRow(int32(i), fmt.Sprintf("user%d", i%10), float64(i)))
+		require.Nil(t, err)
+	}
+	for i := 0; i < len(rids); i += 2 {
+		_, err = users.Delete(txn, rids[i])
+		require.Nil(t, err)
+	}
+	// Not after writing to the table.
+	require.NotNil(t, users.RebuildIndex(txn, "users_id"))
+	require.Nil(t, txnManager.Commit(txn))
+
+	txn = txnManager.Begin()
+	users, err = catalog.OpenTable(txn, "users")
+	require.Nil(t, err)
+	require.Nil(t, users.RebuildIndex(txn, "users_id"))
+	require.Nil(t, users.RebuildIndex(txn, "users_name"))
+	require.Equal(t, ErrIndexNotFound, users.RebuildIndex(txn, "missing"))
+	for i := range rids {
+		require.Equal(t, i%2, countEntries(t, users, "users_id", types.NewInteger(int32(i))))
+	}
+	require.Equal(t, 10, len(lookupName(t, users, txn, "user1")))
+	require.Equal(t, 10, countEntries(t, users, "users_name", types.NewVarchar("user1")))
+	require.Equal(t, 0, countEntries(t, users, "users_name", types.NewVarchar("user2")))
+	// The garbage went away with the old entries.
+	require.Equal(t, 0, catalog.CollectGarbage(txnManager.OldestSnapshot()))
+	require.Nil(t, txnManager.Commit(txn))
+}
+
+func TestTable_Reopen(t *testing.T) {
+	defer os.Remove("test.db")
+	defer wal.RemoveLogFiles("test.log")
+
+	db := openTestDatabase(t, true)
+	createTestTable(t, db.catalog, db.txnManager)
+	txn := db.txnManager.Begin()
+	users, err := db.catalog.OpenTable(txn, "users")
+	require.Nil(t, err)
+	rids := make([]common.RID, 20)
+	for i := range rids {
+		rids[i], err = users.Insert(txn, testRow(int32(i), "user", float64(i)))
+		require.Nil(t, err)
+	}
+	require.Nil(t, db.txnManager.Commit(txn))
+	// Unfinished, rolled back by recovery.
+	txn = db.txnManager.Begin()
+	_, err = users.Insert(txn, testRow(100, "lost", 0))
+	require.Nil(t, err)
+	_, err = users.Delete(txn, rids[0])
+	require.Nil(t, err)
+	db.logManager.Flush(db.logManager.LastLSN())
+	db.close()
+
+	// The indexes are rebuilt from the table.
+	db = openTestDatabase(t, false)
+	txn = db.txnManager.Begin()
+	users, err = db.catalog.OpenTable(txn, "users")
+	require.Nil(t, err)
+	for i, rid := range rids {
+		require.Equal(t, []common.RID{rid}, lookupId(t, users, txn, int32(i)))
+	}
+	require.Equal(t, []common.RID{}, lookupId(t, users, txn, 100))
+	require.Equal(t, 0, countEntries(t, users, "users_name", types.NewVarchar("lost")))
+	require.ElementsMatch(t, rids, lookupName(t, users, txn, "user"))
+	_, err = users.Insert(txn, testRow(0, "user", 0))
+	require.Equal(t, ErrDuplicateKey, err)
+	require.Nil(t, db.txnManager.Commit(txn))
+
+	txn = db.txnManager.Begin()
+	require.Nil(t, db.catalog.DropTable(txn, "users"))
+	require.Nil(t, db.txnManager.Commit(txn))
+	db.close()
+}
@@ -2,4 +2,6 @@ package common
 
 const (
 	InvalidPageId PageId = PageId(-1)
+	InvalidLSN    LSN    = LSN(-1)
+	InvalidTxnId  TxnId  = TxnId(-1)
 )
@@ -2,3 +2,7 @@ package common
 
 type PageId int32
 
+// Log sequence number, i.e. the position of a log record in the write-ahead log.
+type LSN int64
+
+type TxnId int32
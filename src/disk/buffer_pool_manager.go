@@ -9,25 +9,31 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"simple-db-golang/src/common"
+	"simple-db-golang/src/wal"
 )
 
 type BufferPoolManager struct {
-	size        int
-	pages       []Page
-	replacer    Replacer
-	freeList    list.List
-	pageTable   map[common.PageId]int
-	diskManager *DiskManager
-	mu          sync.Mutex
+	size       int
+	pages      []Page
+	replacer   Replacer
+	freeList   list.List
+	pageTable  map[common.PageId]int
+	pageStore  PageStore
+	logManager *wal.LogManager
+	mu         sync.Mutex
+	// The copy of a page being written back, guarded by `mu`.
+	writeBuffer []byte
 }
 
-func NewBufferPoolManager(size int, diskManager *DiskManager, replacer Replacer) *BufferPoolManager {
+func NewBufferPoolManager(size int, pageStore PageStore, replacer Replacer) *BufferPoolManager {
 	bpm := &BufferPoolManager{
-		size:        size,
-		pages:       make([]Page, size),
-		replacer:    replacer,
-		pageTable:   make(map[common.PageId]int),
-		diskManager: diskManager,
+		size:      size,
+		pages:     make([]Page, size),
+		replacer:  replacer,
+		pageTable: make(map[common.PageId]int),
+		pageStore: pageStore,
+
+		writeBuffer: directio.AlignedBlock(pageSize),
 	}
 	for i := 0; i < size; i++ {
 		bpm.pages[i] = Page{
@@ -35,12 +41,28 @@ func NewBufferPoolManager(size int, diskManager *DiskManager, replacer Replacer)
 			pageId:   common.InvalidPageId,
 			pinCount: 0,
 			isDirty:  false,
+			recLSN:   int64(common.InvalidLSN),
 		}
 		bpm.freeList.PushBack(i)
 	}
 	return bpm
 }
 
+// Enable write-ahead logging. Once set, a dirty page is only written back after
+// the log is durable up to the page's LSN.
+func (bpm *BufferPoolManager) SetLogManager(logManager *wal.LogManager) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	bpm.logManager = logManager
+}
+
+// The log manager set by `SetLogManager`, or nil if logging is disabled.
+func (bpm *BufferPoolManager) LogManager() *wal.LogManager {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	return bpm.logManager
+}
+
 func (bpm *BufferPoolManager) FetchPage(pageId common.PageId) (*Page, error) {
 	bpm.mu.Lock()
 	defer bpm.mu.Unlock()
@@ -48,6 +70,9 @@ func (bpm *BufferPoolManager) FetchPage(pageId common.PageId) (*Page, error) {
 	if frameId, ok := bpm.pageTable[pageId]; ok {
 		bpm.replacer.Remove(frameId)
 		page := &bpm.pages[frameId]
+		if page.pinCount == 0 {
+			page.pinLSN = bpm.nextLSN()
+		}
 		page.pinCount += 1
 		return page, nil
 	}
@@ -59,18 +84,21 @@ func (bpm *BufferPoolManager) FetchPage(pageId common.PageId) (*Page, error) {
 	page := &bpm.pages[frameId]
 	oldPageId := page.PageId()
 	if page.IsDirty() {
-		if err := bpm.diskManager.WritePage(oldPageId, page.Data()); err != nil {
+		if err := bpm.writeBack(page); err != nil {
 			log.WithError(err).Fatalf("Cannot write page %d back.", oldPageId)
 		}
 		page.isDirty = false
 	}
-	if err := bpm.diskManager.ReadPage(pageId, page.Data()); err != nil {
+	if err := bpm.pageStore.ReadPage(pageId, page.data); err != nil {
 		log.WithError(err).Warnf("Cannot read page %d from disk.", pageId)
+		bpm.releaseFrame(frameId)
 		return nil, err
 	}
 
 	page.pageId = pageId
 	page.pinCount = 1
+	page.pinLSN = bpm.nextLSN()
+	page.resetRecLSN()
 	delete(bpm.pageTable, oldPageId)
 	bpm.pageTable[pageId] = frameId
 	return page, nil
@@ -96,24 +124,17 @@ func (bpm *BufferPoolManager) UnpinPage(pageId common.PageId, isDirty bool) {
 	}
 }
 
+// Write a page back if it is dirty. A pinned page is written under its read
+// latch, so it must not be flushed by a goroutine holding its write latch.
 func (bpm *BufferPoolManager) FlushPage(pageId common.PageId) error {
 	bpm.mu.Lock()
-	defer bpm.mu.Unlock()
-
-	if frameId, ok := bpm.pageTable[pageId]; !ok {
+	frameId, ok := bpm.pageTable[pageId]
+	if !ok {
+		bpm.mu.Unlock()
 		log.Warnf("Page %d is not in buffer. Cannot flush page.", pageId)
 		return nil
-	} else {
-		page := &bpm.pages[frameId]
-		if page.isDirty {
-			if err := bpm.diskManager.WritePage(page.PageId(), page.Data()); err != nil {
-				log.WithError(err).Errorf("Cannot flush page %d.", page.PageId())
-				return err
-			}
-			page.isDirty = false
-		}
-		return nil
 	}
+	return bpm.flushFrame(frameId)
 }
 
 func (bpm *BufferPoolManager) NewPage() (*Page, error) {
@@ -128,21 +149,25 @@ func (bpm *BufferPoolManager) NewPage() (*Page, error) {
 	page := &bpm.pages[frameId]
 	oldPageId := page.PageId()
 	if page.IsDirty() {
-		if err := bpm.diskManager.WritePage(oldPageId, page.Data()); err != nil {
+		if err := bpm.writeBack(page); err != nil {
 			log.WithError(err).Fatalf("Cannot write page %d back.", oldPageId)
 		}
 		page.isDirty = false
 	}
-	newPageId, err := bpm.diskManager.AllocatePage()
+	newPageId, err := bpm.pageStore.AllocatePage()
 	if err != nil {
 		log.WithError(err).Errorf("Allocate page failed.")
+		bpm.releaseFrame(frameId)
 		return nil, err
 	}
-	if err := bpm.diskManager.ReadPage(newPageId, page.Data()); err != nil {
+	if err := bpm.pageStore.ReadPage(newPageId, page.data); err != nil {
 		log.WithError(err).Errorf("Cannot read page %d from disk.", newPageId)
+		bpm.releaseFrame(frameId)
 		return nil, err
 	}
 	page.pinCount = 1
+	page.pinLSN = bpm.nextLSN()
+	page.resetRecLSN()
 	page.pageId = newPageId
 	delete(bpm.pageTable, oldPageId)
 	bpm.pageTable[newPageId] = frameId
@@ -154,18 +179,19 @@ func (bpm *BufferPoolManager) DeletePage(pageId common.PageId) error {
 	defer bpm.mu.Unlock()
 
 	if frameId, ok := bpm.pageTable[pageId]; !ok {
-		return bpm.diskManager.DeallocatePage(pageId)
+		return bpm.pageStore.DeallocatePage(pageId)
 	} else {
 		page := &bpm.pages[frameId]
 		if page.PinCount() > 0 {
 			return fmt.Errorf("Page %d is still pinned.", pageId)
 		}
-		if err := bpm.diskManager.DeallocatePage(pageId); err != nil {
+		if err := bpm.pageStore.DeallocatePage(pageId); err != nil {
 			return err
 		}
 		page.pageId = common.InvalidPageId
 		page.isDirty = false
 		page.pinCount = 0
+		page.resetRecLSN()
 		delete(bpm.pageTable, pageId)
 		bpm.replacer.Remove(frameId)
 		bpm.freeList.PushBack(frameId)
@@ -173,14 +199,81 @@ func (bpm *BufferPoolManager) DeletePage(pageId common.PageId) error {
 	}
 }
 
+// Write back all the dirty pages, pinned ones under their read latch like
+// `FlushPage`.
 func (bpm *BufferPoolManager) FlushAllPages() error {
+	bpm.mu.Lock()
+	pageIds := make([]common.PageId, 0, len(bpm.pageTable))
+	for pageId := range bpm.pageTable {
+		pageIds = append(pageIds, pageId)
+	}
+	bpm.mu.Unlock()
+
+	for _, pageId := range pageIds {
+		bpm.mu.Lock()
+		frameId, ok := bpm.pageTable[pageId]
+		if !ok {
+			// Evicted in the meantime, so already written back.
+			bpm.mu.Unlock()
+			continue
+		}
+		if err := bpm.flushFrame(frameId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write back the page of a frame if it is dirty. Called with `bpm.mu` held,
+// which it releases.
+//
+// A pinned page may be changed at the same time under its write latch, so it
+// is written under its read latch, lest the page on disk mix two states of
+// it. The latch is taken before `bpm.mu`, in the same order as the users of
+// the page, with the page pinned so that it stays in the frame.
+func (bpm *BufferPoolManager) flushFrame(frameId int) error {
+	page := &bpm.pages[frameId]
+	if page.pinCount == 0 {
+		defer bpm.mu.Unlock()
+		return bpm.flushDirty(page)
+	}
+	page.pinCount++
+	bpm.mu.Unlock()
+
+	page.RLock()
+	defer page.RUnlock()
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	err := bpm.flushDirty(page)
+	page.pinCount--
+	if page.pinCount == 0 {
+		bpm.replacer.Add(frameId)
+	}
+	return err
+}
+
+func (bpm *BufferPoolManager) flushDirty(page *Page) error {
+	if !page.isDirty {
+		return nil
+	}
+	if err := bpm.writeBack(page); err != nil {
+		log.WithError(err).Errorf("Cannot flush page %d.", page.PageId())
+		return err
+	}
+	page.isDirty = false
+	return nil
+}
+
+// Write back the dirty pages that are not pinned. Unlike `FlushAllPages`, it
+// does not race with modifications, so it can run in the background.
+func (bpm *BufferPoolManager) FlushUnpinnedPages() error {
 	bpm.mu.Lock()
 	defer bpm.mu.Unlock()
 
 	for _, frameId := range bpm.pageTable {
 		page := &bpm.pages[frameId]
-		if page.isDirty {
-			if err := bpm.diskManager.WritePage(page.PageId(), page.Data()); err != nil {
+		if page.isDirty && page.pinCount == 0 {
+			if err := bpm.writeBack(page); err != nil {
 				log.WithError(err).Errorf("Cannot flush page %d.", page.PageId())
 				return err
 			}
@@ -190,6 +283,30 @@ func (bpm *BufferPoolManager) FlushAllPages() error {
 	return nil
 }
 
+// The dirty page table for a checkpoint: every page in the buffer that may
+// hold logged changes which are not on disk yet, mapped to the LSN of the
+// first log record that may not be reflected on disk. Pinned pages are
+// included, since they may be in the middle of a change that is logged but
+// not yet stamped on the page.
+func (bpm *BufferPoolManager) DirtyPageTable() map[common.PageId]common.LSN {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+
+	dirtyPages := make(map[common.PageId]common.LSN)
+	for pageId, frameId := range bpm.pageTable {
+		page := &bpm.pages[frameId]
+		recLSN := page.loadRecLSN()
+		if page.pinCount > 0 && page.pinLSN != common.InvalidLSN &&
+			(recLSN == common.InvalidLSN || page.pinLSN < recLSN) {
+			recLSN = page.pinLSN
+		}
+		if recLSN != common.InvalidLSN {
+			dirtyPages[pageId] = recLSN
+		}
+	}
+	return dirtyPages
+}
+
 func (bpm *BufferPoolManager) findAvailablePage() (int, bool) {
 	if bpm.freeList.Len() == 0 {
 		return bpm.replacer.Victim()
@@ -199,3 +316,39 @@ func (bpm *BufferPoolManager) findAvailablePage() (int, bool) {
 	bpm.freeList.Remove(elem)
 	return frameId, true
 }
+
+// Write a page back to the page store, following the write-ahead logging rule.
+func (bpm *BufferPoolManager) writeBack(page *Page) error {
+	if bpm.logManager != nil {
+		if err := bpm.logManager.Flush(page.LSN()); err != nil {
+			return err
+		}
+	}
+	page.resetRecLSN()
+	// The page store stamps the trailer of the data it writes, which must not
+	// be the frame: its users may read it at the same time.
+	copy(bpm.writeBuffer, page.data)
+	return bpm.pageStore.WritePage(page.pageId, bpm.writeBuffer)
+}
+
+func (bpm *BufferPoolManager) nextLSN() common.LSN {
+	if bpm.logManager == nil {
+		return common.InvalidLSN
+	}
+	return bpm.logManager.NextLSN()
+}
+
+// Drop whatever page is held by the frame and put the frame back to the free
+// list. Used when a frame was taken for a page that failed to load, so that
+// neither the frame nor possibly corrupted data in it leaks.
+func (bpm *BufferPoolManager) releaseFrame(frameId int) {
+	page := &bpm.pages[frameId]
+	if page.pageId != common.InvalidPageId {
+		delete(bpm.pageTable, page.pageId)
+	}
+	page.pageId = common.InvalidPageId
+	page.isDirty = false
+	page.pinCount = 0
+	page.resetRecLSN()
+	bpm.freeList.PushBack(frameId)
+}
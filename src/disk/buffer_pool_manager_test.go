@@ -1,26 +1,26 @@
 package disk
 
 import (
+	"errors"
 	"math/rand"
 	"os"
 	"testing"
 
-	"github.com/ncw/directio"
 	"github.com/stretchr/testify/require"
 
 	"simple-db-golang/src/common"
+	"simple-db-golang/src/wal"
 )
 
 var (
-	tmpFileName = "tmp-file"
+	tmpFileName    = "tmp-file"
+	tmpLogFileName = "tmp-log"
 )
 
 func TestNewBufferPoolManager(t *testing.T) {
-	defer os.Remove(tmpFileName)
-	dm := NewDiskManager(tmpFileName)
-	defer dm.Close()
+	store := NewMemoryPageStore()
 	lru := NewLRUReplacer()
-	bfm := NewBufferPoolManager(4, dm, lru)
+	bfm := NewBufferPoolManager(4, store, lru)
 
 	require.Equal(t, 0, len(bfm.pageTable))
 	require.Equal(t, 4, len(bfm.pages))
@@ -29,11 +29,9 @@ func TestNewBufferPoolManager(t *testing.T) {
 }
 
 func TestBufferPoolManager_NewPage(t *testing.T) {
-	defer os.Remove(tmpFileName)
-	dm := NewDiskManager(tmpFileName)
-	defer dm.Close()
+	store := NewMemoryPageStore()
 	lru := NewLRUReplacer()
-	bfm := NewBufferPoolManager(4, dm, lru)
+	bfm := NewBufferPoolManager(4, store, lru)
 
 	for i := 0; i < 4; i++ {
 		page, _ := bfm.NewPage()
@@ -51,11 +49,9 @@ func TestBufferPoolManager_NewPage(t *testing.T) {
 }
 
 func TestBufferPoolManager_UnpinPage(t *testing.T) {
-	defer os.Remove(tmpFileName)
-	dm := NewDiskManager(tmpFileName)
-	defer dm.Close()
+	store := NewMemoryPageStore()
 	lru := NewLRUReplacer()
-	bfm := NewBufferPoolManager(4, dm, lru)
+	bfm := NewBufferPoolManager(4, store, lru)
 
 	bfm.NewPage() // allocate page 1
 	bfm.NewPage() // allocate page 2
@@ -76,11 +72,9 @@ func TestBufferPoolManager_UnpinPage(t *testing.T) {
 }
 
 func TestBufferPoolManager_FetchPage(t *testing.T) {
-	defer os.Remove(tmpFileName)
-	dm := NewDiskManager(tmpFileName)
-	defer dm.Close()
+	store := NewMemoryPageStore()
 	lru := NewLRUReplacer()
-	bfm := NewBufferPoolManager(4, dm, lru)
+	bfm := NewBufferPoolManager(4, store, lru)
 
 	bfm.NewPage() // allocate page 1
 	bfm.NewPage() // allocate page 2
@@ -97,11 +91,9 @@ func TestBufferPoolManager_FetchPage(t *testing.T) {
 }
 
 func TestBufferPoolManager_DeletePage(t *testing.T) {
-	defer os.Remove(tmpFileName)
-	dm := NewDiskManager(tmpFileName)
-	defer dm.Close()
+	store := NewMemoryPageStore()
 	lru := NewLRUReplacer()
-	bfm := NewBufferPoolManager(4, dm, lru)
+	bfm := NewBufferPoolManager(4, store, lru)
 
 	bfm.NewPage() // allocate page 1
 	bfm.NewPage() // allocate page 2
@@ -115,11 +107,9 @@ func TestBufferPoolManager_DeletePage(t *testing.T) {
 }
 
 func TestBufferPoolManager_Full(t *testing.T) {
-	defer os.Remove(tmpFileName)
-	dm := NewDiskManager(tmpFileName)
-	defer dm.Close()
+	store := NewMemoryPageStore()
 	lru := NewLRUReplacer()
-	bfm := NewBufferPoolManager(4, dm, lru)
+	bfm := NewBufferPoolManager(4, store, lru)
 
 	for i := 0; i < 4; i++ {
 		bfm.NewPage()
@@ -141,11 +131,9 @@ func TestBufferPoolManager_Full(t *testing.T) {
 }
 
 func TestBufferPoolManager_FetchPageVictim(t *testing.T) {
-	defer os.Remove(tmpFileName)
-	dm := NewDiskManager(tmpFileName)
-	defer dm.Close()
+	store := NewMemoryPageStore()
 	lru := NewLRUReplacer()
-	bfm := NewBufferPoolManager(4, dm, lru)
+	bfm := NewBufferPoolManager(4, store, lru)
 
 	bfm.NewPage() // allocate page 1
 	bfm.NewPage() // allocate page 2
@@ -178,7 +166,7 @@ func TestBufferPoolManager_BinaryData(t *testing.T) {
 		for i := 0; i < 10; i++ {
 			page, _ := bfm.NewPage()
 			rand.Read(page.Data())
-			copyData := directio.AlignedBlock(pageSize)
+			copyData := make([]byte, len(page.Data()))
 			copy(copyData, page.Data())
 			allDatas = append(allDatas, copyData)
 			bfm.UnpinPage(page.PageId(), true)
@@ -204,3 +192,115 @@ func TestBufferPoolManager_BinaryData(t *testing.T) {
 		}
 	}
 }
+
+func TestBufferPoolManager_FetchCorruptedPage(t *testing.T) {
+	defer os.Remove(tmpFileName)
+	dm := NewDiskManager(tmpFileName)
+	defer dm.Close()
+	lru := NewLRUReplacer()
+	bfm := NewBufferPoolManager(4, dm, lru)
+
+	for i := 0; i < 4; i++ {
+		page, _ := bfm.NewPage()
+		rand.Read(page.Data())
+		bfm.UnpinPage(page.PageId(), true)
+	}
+	bfm.FlushAllPages()
+	bfm.FetchPage(common.PageId(1))
+	bfm.FetchPage(common.PageId(3))
+
+	// Flip one byte of page 2 behind the buffer pool's back.
+	fi, _ := os.OpenFile(tmpFileName, os.O_RDWR, 0644)
+	buf := make([]byte, 1)
+	fi.ReadAt(buf, 2*pageSize+100)
+	buf[0] ^= 0xff
+	fi.WriteAt(buf, 2*pageSize+100)
+	fi.Close()
+
+	// Evict page 2 so that it has to be read from disk again.
+	bfm.NewPage()
+	require.NotContains(t, bfm.pageTable, common.PageId(2))
+	bfm.UnpinPage(common.PageId(5), false)
+
+	page, err := bfm.FetchPage(common.PageId(2))
+	require.Nil(t, page)
+	var corruptErr *CorruptPageError
+	require.True(t, errors.As(err, &corruptErr))
+	require.Equal(t, common.PageId(2), corruptErr.PageId)
+
+	// The frame used for the failed read is not leaked.
+	require.NotContains(t, bfm.pageTable, common.PageId(2))
+	require.Equal(t, 4, len(bfm.pageTable)+bfm.freeList.Len())
+}
+
+func TestBufferPoolManager_WriteAheadLogging(t *testing.T) {
+	defer os.Remove(tmpFileName)
+	defer wal.RemoveLogFiles(tmpLogFileName)
+	dm := NewDiskManager(tmpFileName)
+	defer dm.Close()
+	lm := wal.NewLogManager(tmpLogFileName)
+	defer lm.Close()
+	lru := NewLRUReplacer()
+	bfm := NewBufferPoolManager(1, dm, lru)
+	bfm.SetLogManager(lm)
+
+	page, _ := bfm.NewPage()
+	rand.Read(page.Data())
+	lsn := lm.AppendLogRecord(&wal.LogRecord{Type: wal.NewPageRecord, PageId: page.PageId()})
+	page.SetLSN(lsn)
+	bfm.UnpinPage(page.PageId(), true)
+	require.Equal(t, common.InvalidLSN, lm.PersistentLSN())
+
+	// Evicting the dirty page forces the log to be flushed first.
+	page, _ = bfm.NewPage()
+	require.NotNil(t, page)
+	require.Equal(t, lsn, lm.PersistentLSN())
+	bfm.UnpinPage(page.PageId(), false)
+
+	page, _ = bfm.FetchPage(common.PageId(1))
+	require.Equal(t, lsn, page.LSN()) // The page LSN is persisted.
+}
+
+func TestBufferPoolManager_FlushWhileWriting(t *testing.T) {
+	defer os.Remove(tmpFileName)
+	dm := NewDiskManager(tmpFileName)
+	defer dm.Close()
+	bfm := NewBufferPoolManager(4, dm, NewLRUReplacer())
+
+	page, err := bfm.NewPage()
+	require.Nil(t, err)
+	pageId := page.PageId()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			page.Lock()
+			rand.Read(page.Data())
+			page.Unlock()
+			// Mark the page as dirty, though not after each change.
+			if i%8 == 0 {
+				bfm.FetchPage(pageId)
+				bfm.UnpinPage(pageId, true)
+			}
+		}
+	}()
+
+	// Each flush writes a consistent state of the page, whose checksum holds.
+	data := make([]byte, pageSize)
+	for i := 0; i < 1000; i++ {
+		require.Nil(t, bfm.FlushPage(pageId))
+		require.Nil(t, dm.ReadPage(pageId, data))
+	}
+	close(stop)
+	<-done
+	bfm.UnpinPage(pageId, true)
+	require.Nil(t, bfm.FlushAllPages())
+	require.Nil(t, dm.ReadPage(pageId, data))
+	require.Equal(t, page.Data(), data[:PageDataSize])
+}
@@ -0,0 +1,116 @@
+package disk
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/wal"
+)
+
+const DefaultCheckpointInterval = 30 * time.Second
+
+// CheckpointManager takes fuzzy checkpoints, which bound how much of the log
+// recovery has to read. A checkpoint does not stop the world: it logs the
+// dirty page table of the buffer pool and the active transactions between a
+// begin and an end checkpoint record, while other transactions keep running.
+// Once a checkpoint is durable, the log segments that neither recovery nor a
+// rollback can need any more are deleted.
+//
+// When started, it also writes back dirty pages in the background before each
+// checkpoint, so that the dirty page table, and with it the part of the log
+// to redo, stays small.
+type CheckpointManager struct {
+	bufferPoolManager *BufferPoolManager
+	interval          time.Duration
+	stop              chan struct{}
+	done              chan struct{}
+	mu                sync.Mutex // Serializes checkpoints.
+}
+
+func NewCheckpointManager(bufferPoolManager *BufferPoolManager, interval time.Duration) *CheckpointManager {
+	return &CheckpointManager{
+		bufferPoolManager: bufferPoolManager,
+		interval:          interval,
+	}
+}
+
+// Start taking checkpoints periodically.
+func (cm *CheckpointManager) Start() {
+	if cm.stop != nil {
+		return
+	}
+	cm.stop = make(chan struct{})
+	cm.done = make(chan struct{})
+	go cm.run(cm.stop, cm.done)
+}
+
+// Stop taking checkpoints periodically, and wait for the current one to finish.
+func (cm *CheckpointManager) Stop() {
+	if cm.stop == nil {
+		return
+	}
+	close(cm.stop)
+	<-cm.done
+	cm.stop = nil
+	cm.done = nil
+}
+
+func (cm *CheckpointManager) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(cm.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := cm.bufferPoolManager.FlushUnpinnedPages(); err != nil {
+				log.WithError(err).Errorf("Background flush failed.")
+			}
+			if err := cm.Checkpoint(); err != nil {
+				log.WithError(err).Errorf("Checkpoint failed.")
+			}
+		}
+	}
+}
+
+// Take a checkpoint now, and truncate the log behind it.
+func (cm *CheckpointManager) Checkpoint() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	logManager := cm.bufferPoolManager.LogManager()
+	if logManager == nil {
+		return fmt.Errorf("Logging is disabled.")
+	}
+	beginLSN := logManager.AppendLogRecord(&wal.LogRecord{
+		PrevLSN: common.InvalidLSN,
+		TxnId:   common.InvalidTxnId,
+		Type:    wal.BeginCheckpointRecord,
+	})
+	dirtyPages := cm.bufferPoolManager.DirtyPageTable()
+	endLSN := logManager.AppendCheckpointRecord(dirtyPages)
+	if err := logManager.Flush(endLSN); err != nil {
+		return err
+	}
+	if err := logManager.SetCheckpointLSN(beginLSN); err != nil {
+		return err
+	}
+
+	// Redo starts at the oldest dirty page, and undo may go back to the first
+	// record of the oldest active transaction.
+	truncateLSN := beginLSN
+	for _, recLSN := range dirtyPages {
+		if recLSN < truncateLSN {
+			truncateLSN = recLSN
+		}
+	}
+	if lsn := logManager.MinActiveTxnLSN(); lsn != common.InvalidLSN && lsn < truncateLSN {
+		truncateLSN = lsn
+	}
+	return logManager.Truncate(truncateLSN)
+}
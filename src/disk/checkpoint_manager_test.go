@@ -0,0 +1,100 @@
+package disk
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/wal"
+)
+
+func TestBufferPoolManager_DirtyPageTable(t *testing.T) {
+	defer wal.RemoveLogFiles(tmpLogFileName)
+	lm := wal.NewLogManager(tmpLogFileName)
+	defer lm.Close()
+	bfm := NewBufferPoolManager(4, NewMemoryPageStore(), NewLRUReplacer())
+	bfm.SetLogManager(lm)
+
+	// A pinned page may be about to get a change logged after it was pinned.
+	page, _ := bfm.NewPage()
+	pinLSN := lm.NextLSN()
+	require.Equal(t, map[common.PageId]common.LSN{page.PageId(): pinLSN}, bfm.DirtyPageTable())
+
+	lsn := lm.AppendLogRecord(&wal.LogRecord{Type: wal.NewPageRecord, PageId: page.PageId()})
+	page.SetLSN(lsn)
+	page.SetLSN(lm.AppendLogRecord(&wal.LogRecord{Type: wal.NewPageRecord, PageId: page.PageId()}))
+	bfm.UnpinPage(page.PageId(), true)
+	require.Equal(t, map[common.PageId]common.LSN{page.PageId(): lsn}, bfm.DirtyPageTable())
+
+	// Clean pages which are not pinned are left out.
+	require.Nil(t, bfm.FlushUnpinnedPages())
+	require.Equal(t, 0, len(bfm.DirtyPageTable()))
+	require.False(t, page.IsDirty())
+}
+
+func TestCheckpointManager_Checkpoint(t *testing.T) {
+	defer wal.RemoveLogFiles(tmpLogFileName)
+	lm := wal.NewLogManager(tmpLogFileName)
+	defer lm.Close()
+	lm.SetSegmentSize(4096)
+	bfm := NewBufferPoolManager(4, NewMemoryPageStore(), NewLRUReplacer())
+	bfm.SetLogManager(lm)
+	cm := NewCheckpointManager(bfm, time.Hour)
+
+	page, _ := bfm.NewPage()
+	for i := 0; i < 50; i++ {
+		data := make([]byte, 256)
+		rand.Read(data)
+		page.SetLSN(lm.AppendLogRecord(&wal.LogRecord{
+			TxnId: common.InvalidTxnId,
+			Type:  wal.InsertRecord,
+			RID:   common.RID{PageId: page.PageId()},
+			Data:  data,
+		}))
+	}
+	bfm.UnpinPage(page.PageId(), true)
+	recLSN := bfm.DirtyPageTable()[page.PageId()]
+
+	// The dirty page still needs the log from its first change on.
+	require.Nil(t, cm.Checkpoint())
+	require.True(t, lm.CheckpointLSN() > recLSN)
+	_, err := lm.ReadLogRecord(recLSN)
+	require.Nil(t, err)
+	numSegments := lm.NumSegments()
+	require.True(t, numSegments > 1)
+
+	record, err := lm.ReadLogRecord(lm.LastLSN())
+	require.Nil(t, err)
+	require.Equal(t, wal.EndCheckpointRecord, record.Type)
+	require.Equal(t, map[common.PageId]common.LSN{page.PageId(): recLSN}, record.DirtyPages)
+
+	// Once the page is written back, the old segments can go.
+	require.Nil(t, bfm.FlushAllPages())
+	require.Nil(t, cm.Checkpoint())
+	require.True(t, lm.NumSegments() < numSegments)
+	_, err = lm.ReadLogRecord(recLSN)
+	require.NotNil(t, err)
+}
+
+func TestCheckpointManager_Background(t *testing.T) {
+	defer wal.RemoveLogFiles(tmpLogFileName)
+	lm := wal.NewLogManager(tmpLogFileName)
+	defer lm.Close()
+	bfm := NewBufferPoolManager(4, NewMemoryPageStore(), NewLRUReplacer())
+	bfm.SetLogManager(lm)
+
+	page, _ := bfm.NewPage()
+	page.SetLSN(lm.AppendLogRecord(&wal.LogRecord{Type: wal.NewPageRecord, PageId: page.PageId()}))
+	bfm.UnpinPage(page.PageId(), true)
+
+	cm := NewCheckpointManager(bfm, 10*time.Millisecond)
+	cm.Start()
+	require.Eventually(t, func() bool {
+		return lm.CheckpointLSN() != common.InvalidLSN
+	}, time.Second, 10*time.Millisecond)
+	cm.Stop()
+	require.False(t, page.IsDirty()) // Flushed in the background.
+}
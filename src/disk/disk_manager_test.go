@@ -97,10 +97,10 @@ func TestAllocateAndDeallocate(t *testing.T) {
 	for i := 1; i <= 3; i++ {
 		pageId, err := dm.AllocatePage()
 		require.Nil(t, err)
-		require.Equal(t, common.PageId(i), pageId)
+		require.Equal(t, common.PageId(4-i), pageId) // Free pages are reused in LIFO order.
 		require.Equal(t, common.PageId(6), dm.header.nextPageId)
 		require.Equal(t, int32(3-i), dm.header.numFreePages)
-		require.NotContains(t, dm.freePageSet, common.PageId(i))
+		require.NotContains(t, dm.freePageSet, common.PageId(4-i))
 	}
 	for i := 1; i <= 5; i++ {
 		pageId, err := dm.AllocatePage()
@@ -167,3 +167,86 @@ func TestHeaderPage(t *testing.T) {
 	require.Contains(t, new_dm.freePageSet, common.PageId(2))
 	require.Contains(t, new_dm.freePageSet, common.PageId(4))
 }
+
+func TestDiskManager_FreeListOverflow(t *testing.T) {
+	defer os.Remove(testFileName)
+	dm := NewDiskManager(testFileName)
+
+	total := int(headerFreeListCapacity + overflowFreeListCapacity + 10)
+	for i := 0; i < total; i++ {
+		dm.AllocatePage()
+	}
+	for i := 1; i <= total; i++ {
+		err := dm.DeallocatePage(common.PageId(i))
+		require.Nil(t, err)
+	}
+	require.Equal(t, int32(total), dm.header.numFreePages)
+	require.Equal(t, total, len(dm.freePageSet))
+	require.NotEqual(t, common.InvalidPageId, dm.header.freeList.next)
+	dm.Close()
+
+	// The overflow chain must be persisted.
+	dm = NewDiskManager(testFileName)
+	defer dm.Close()
+	require.Equal(t, int32(total), dm.header.numFreePages)
+	require.Equal(t, total, len(dm.freePageSet))
+	err := dm.DeallocatePage(common.PageId(total))
+	require.NotNil(t, err) // Already deallocated.
+
+	allocated := make(map[common.PageId]struct{})
+	for i := 0; i < total; i++ {
+		pageId, err := dm.AllocatePage()
+		require.Nil(t, err)
+		require.True(t, pageId >= 1 && int(pageId) <= total)
+		require.NotContains(t, allocated, pageId)
+		allocated[pageId] = struct{}{}
+	}
+	require.Equal(t, int32(0), dm.header.numFreePages)
+	require.Equal(t, 0, len(dm.freePageSet))
+	require.Equal(t, common.InvalidPageId, dm.header.freeList.next)
+
+	// Pages popped from the overflow chain are handed out zeroed.
+	data := directio.AlignedBlock(pageSize)
+	for pageId := range allocated {
+		require.Nil(t, dm.ReadPage(pageId, data))
+		require.Equal(t, make([]byte, PageDataSize), data[:PageDataSize])
+	}
+
+	pageId, err := dm.AllocatePage()
+	require.Nil(t, err)
+	require.Equal(t, common.PageId(total+1), pageId)
+}
+
+func TestDiskManager_Checksum(t *testing.T) {
+	defer os.Remove(testFileName)
+	dm := NewDiskManager(testFileName)
+	defer dm.Close()
+
+	pageId, _ := dm.AllocatePage()
+	data := directio.AlignedBlock(pageSize)
+	rand.Read(data)
+	require.Nil(t, dm.WritePage(pageId, data))
+	trailer := getPageTrailer(data)
+	require.Equal(t, pageFormatVersion, trailer.version)
+	require.Equal(t, computePageChecksum(data), trailer.checksum)
+
+	// Simulate a torn write: only the first half of a new version of the page
+	// reaches the disk.
+	newData := directio.AlignedBlock(pageSize)
+	rand.Read(newData)
+	fi, _ := os.OpenFile(testFileName, os.O_RDWR, 0644)
+	fi.WriteAt(newData[:pageSize/2], int64(pageId)*pageSize)
+	fi.Close()
+
+	err := dm.ReadPage(pageId, data)
+	require.NotNil(t, err)
+	corruptErr, ok := err.(*CorruptPageError)
+	require.True(t, ok)
+	require.Equal(t, pageId, corruptErr.PageId)
+	require.NotEqual(t, corruptErr.ExpectedChecksum, corruptErr.ActualChecksum)
+
+	// Rewriting the page repairs it.
+	require.Nil(t, dm.WritePage(pageId, newData))
+	require.Nil(t, dm.ReadPage(pageId, data))
+	require.Equal(t, newData, data)
+}
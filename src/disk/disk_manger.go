@@ -52,13 +52,34 @@ func NewDiskManager(fileName string) *DiskManager {
 			log.WithError(err).Fatalf("Read header page failed.")
 		}
 		dm.header = createHeaderPageInfo(dm.headerRawData)
+		if err := dm.loadFreePageSet(); err != nil {
+			log.WithError(err).Fatalf("Read free list failed.")
+		}
+	}
+	return dm
+}
 
-		for i := int32(0); i < dm.header.numFreePages; i++ {
-			freePageId := dm.header.get(i)
+func (dm *DiskManager) loadFreePageSet() error {
+	for _, freePageId := range dm.header.freeList.getEntries() {
+		dm.freePageSet[freePageId] = struct{}{}
+	}
+	data := directio.AlignedBlock(pageSize)
+	for pageId := dm.header.freeList.next; pageId != common.InvalidPageId; {
+		if err := dm.readPageData(pageId, data); err != nil {
+			return err
+		}
+		chunk := createFreeListChunk(data)
+		dm.freePageSet[pageId] = struct{}{}
+		for _, freePageId := range chunk.getEntries() {
 			dm.freePageSet[freePageId] = struct{}{}
 		}
+		pageId = chunk.next
 	}
-	return dm
+	return nil
+}
+
+func (dm *DiskManager) Sync() error {
+	return dm.fi.Sync()
 }
 
 func (dm *DiskManager) Close() error {
@@ -70,7 +91,10 @@ func (dm *DiskManager) AllocatePage() (common.PageId, error) {
 	var data []byte
 	var err error
 	if dm.header.hasFreePage() {
-		pageId = dm.header.popFreePage()
+		if pageId, err = dm.popFreePage(); err != nil {
+			log.WithError(err).Errorf("Pop free page failed.")
+			return 0, err
+		}
 		delete(dm.freePageSet, pageId)
 	} else {
 		pageId = dm.header.nextPageId
@@ -94,14 +118,57 @@ func (dm *DiskManager) DeallocatePage(id common.PageId) error {
 	if _, ok := dm.freePageSet[id]; ok {
 		return fmt.Errorf("Page %d is already deallocated.", id)
 	}
+	if err := dm.pushFreePage(id); err != nil {
+		return err
+	}
 	dm.freePageSet[id] = struct{}{}
-	dm.header.pushFreePage(id)
 	if err := dm.writeHeaderPage(); err != nil {
 		log.WithError(err).Fatalf("Write header page failed.")
 	}
 	return nil
 }
 
+// Pop a page from the free list. If the inline part of the free list is
+// empty, the head of the overflow chain is loaded into the header page and
+// the overflow page itself is returned.
+func (dm *DiskManager) popFreePage() (common.PageId, error) {
+	if dm.header.freeList.numEntries > 0 {
+		return dm.header.popFreePage(), nil
+	}
+	pageId := dm.header.freeList.next
+	data := directio.AlignedBlock(pageSize)
+	if err := dm.readPageData(pageId, data); err != nil {
+		return 0, err
+	}
+	dm.header.freeList.copyFrom(createFreeListChunk(data))
+	dm.header.numFreePages -= 1
+
+	// Hand out a clean page rather than the stale free list content.
+	if err := dm.writePageData(pageId, directio.AlignedBlock(pageSize)); err != nil {
+		return 0, err
+	}
+	return pageId, nil
+}
+
+// Push a page onto the free list. If the inline part of the free list is
+// full, its entries are moved into the freed page, which becomes the new head
+// of the overflow chain.
+func (dm *DiskManager) pushFreePage(pageId common.PageId) error {
+	if !dm.header.isInlineFull() {
+		dm.header.pushFreePage(pageId)
+		return nil
+	}
+	data := directio.AlignedBlock(pageSize)
+	createFreeListChunk(data).copyFrom(&dm.header.freeList)
+	if err := dm.writePageData(pageId, data); err != nil {
+		return err
+	}
+	dm.header.freeList.init()
+	dm.header.freeList.next = pageId
+	dm.header.numFreePages += 1
+	return nil
+}
+
 func (dm *DiskManager) ReadPage(pageId common.PageId, data []byte) error {
 	if pageId >= dm.header.nextPageId {
 		return fmt.Errorf("Page %d is not in the file.", pageId)
@@ -151,7 +218,7 @@ func (dm *DiskManager) readPageData(pageId common.PageId, data []byte) error {
 		if n < pageSize {
 			return fmt.Errorf("Read less than a page.")
 		}
-		return nil
+		return verifyPage(pageId, data)
 	}
 }
 
@@ -163,6 +230,7 @@ func (dm *DiskManager) writePageData(pageId common.PageId, data []byte) error {
 	if _, err := dm.fi.Seek(int64(offset), io.SeekStart); err != nil {
 		return err
 	}
+	stampPage(data)
 	if _, err := dm.fi.Write(data); err != nil {
 		return err
 	}
@@ -7,13 +7,32 @@ import (
 	"simple-db-golang/src/common"
 )
 
-// todo: use bitmask instead of list of int32
+// The free list is a stack of page ids. The top of the stack lives inline in
+// the header page; once it is full, the inline entries are spilled into the
+// page being freed, which becomes the new head of a chain of overflow
+// free-list pages. Popping reverses this: when the inline chunk is empty, the
+// head overflow page is read back into the header and handed out itself.
+type freeListChunk struct {
+	numEntries int32
+	next       common.PageId
+
+	// Pointer of page ids.
+	ptr struct{}
+}
+
 type headerPageInfo struct {
 	nextPageId   common.PageId
-	numFreePages int32
-	freeListPtr  uintptr
+	numFreePages int32 // Including the pages in the overflow chain.
+	freeList     freeListChunk
 }
 
+var (
+	headerFreeListCapacity = int32((PageDataSize - int(unsafe.Offsetof(headerPageInfo{}.freeList)) -
+		int(unsafe.Offsetof(freeListChunk{}.ptr))) / int(unsafe.Sizeof(common.PageId(0))))
+	overflowFreeListCapacity = int32((PageDataSize - int(unsafe.Offsetof(freeListChunk{}.ptr))) /
+		int(unsafe.Sizeof(common.PageId(0))))
+)
+
 func createHeaderPageInfo(data []byte) *headerPageInfo {
 	return (*headerPageInfo)(unsafe.Pointer(&data[0]))
 }
@@ -21,29 +40,62 @@ func createHeaderPageInfo(data []byte) *headerPageInfo {
 func (hdr *headerPageInfo) init() {
 	hdr.nextPageId = 1
 	hdr.numFreePages = 0
+	hdr.freeList.init()
 }
 
 func (hdr *headerPageInfo) get(i int32) common.PageId {
-	buf := (*[math.MaxInt32]common.PageId)(unsafe.Pointer(&hdr.freeListPtr))
-	return buf[i]
+	return hdr.freeList.get(i)
 }
 
 func (hdr *headerPageInfo) hasFreePage() bool {
 	return hdr.numFreePages > 0
 }
 
+func (hdr *headerPageInfo) isInlineFull() bool {
+	return hdr.freeList.numEntries >= headerFreeListCapacity
+}
+
 func (hdr *headerPageInfo) popFreePage() common.PageId {
-	buf := (*[math.MaxInt32]common.PageId)(unsafe.Pointer(&hdr.freeListPtr))
-	ret := buf[0]
-	for i := int32(1); i < hdr.numFreePages; i++ {
-		buf[i-1] = buf[i]
-	}
 	hdr.numFreePages -= 1
-	return ret
+	return hdr.freeList.pop()
 }
 
 func (hdr *headerPageInfo) pushFreePage(pageId common.PageId) {
-	buf := (*[math.MaxInt32]common.PageId)(unsafe.Pointer(&hdr.freeListPtr))
-	buf[hdr.numFreePages] = pageId
+	hdr.freeList.push(pageId)
 	hdr.numFreePages += 1
 }
+
+func createFreeListChunk(data []byte) *freeListChunk {
+	return (*freeListChunk)(unsafe.Pointer(&data[0]))
+}
+
+func (chunk *freeListChunk) init() {
+	chunk.numEntries = 0
+	chunk.next = common.InvalidPageId
+}
+
+func (chunk *freeListChunk) getEntries() []common.PageId {
+	return (*(*[math.MaxInt32]common.PageId)(unsafe.Pointer(&chunk.ptr)))[:int(chunk.numEntries)]
+}
+
+func (chunk *freeListChunk) get(i int32) common.PageId {
+	return chunk.getEntries()[i]
+}
+
+func (chunk *freeListChunk) pop() common.PageId {
+	ret := chunk.get(chunk.numEntries - 1)
+	chunk.numEntries -= 1
+	return ret
+}
+
+func (chunk *freeListChunk) push(pageId common.PageId) {
+	chunk.numEntries += 1
+	chunk.getEntries()[chunk.numEntries-1] = pageId
+}
+
+// Copy all entries and the next pointer of `other` into this chunk.
+func (chunk *freeListChunk) copyFrom(other *freeListChunk) {
+	chunk.numEntries = other.numEntries
+	chunk.next = other.next
+	copy(chunk.getEntries(), other.getEntries())
+}
@@ -58,7 +58,8 @@ func TestPopFreePage(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		hdr.pushFreePage(common.PageId(i))
 	}
-	for i := 0; i < 10; i++ {
+	for i := 9; i >= 0; i-- {
 		require.Equal(t, common.PageId(i), hdr.popFreePage())
 	}
+	require.False(t, hdr.hasFreePage())
 }
@@ -0,0 +1,87 @@
+package disk
+
+import (
+	"fmt"
+	"sync"
+
+	"simple-db-golang/src/common"
+)
+
+// MemoryPageStore keeps all pages in memory. It is meant for unit tests and
+// ephemeral databases; nothing survives `Close`.
+type MemoryPageStore struct {
+	pages      map[common.PageId][]byte
+	freePages  []common.PageId
+	nextPageId common.PageId
+	mu         sync.Mutex
+}
+
+func NewMemoryPageStore() *MemoryPageStore {
+	return &MemoryPageStore{
+		pages:      make(map[common.PageId][]byte),
+		nextPageId: 1, // Page 0 is reserved, as it is the header page of a `DiskManager`.
+	}
+}
+
+func (ms *MemoryPageStore) ReadPage(pageId common.PageId, data []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	buf, ok := ms.pages[pageId]
+	if !ok {
+		return fmt.Errorf("Page %d is not in the store.", pageId)
+	}
+	copy(data, buf)
+	return nil
+}
+
+func (ms *MemoryPageStore) WritePage(pageId common.PageId, data []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	buf, ok := ms.pages[pageId]
+	if !ok {
+		return fmt.Errorf("Page %d is not in the store.", pageId)
+	}
+	copy(buf, data)
+	return nil
+}
+
+func (ms *MemoryPageStore) AllocatePage() (common.PageId, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var pageId common.PageId
+	if n := len(ms.freePages); n > 0 {
+		pageId = ms.freePages[n-1]
+		ms.freePages = ms.freePages[:n-1]
+	} else {
+		pageId = ms.nextPageId
+		ms.nextPageId++
+	}
+	ms.pages[pageId] = make([]byte, pageSize)
+	return pageId, nil
+}
+
+func (ms *MemoryPageStore) DeallocatePage(pageId common.PageId) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if pageId >= ms.nextPageId {
+		return fmt.Errorf("Page %d is not in the store.", pageId)
+	}
+	if _, ok := ms.pages[pageId]; !ok {
+		return fmt.Errorf("Page %d is already deallocated.", pageId)
+	}
+	delete(ms.pages, pageId)
+	ms.freePages = append(ms.freePages, pageId)
+	return nil
+}
+
+func (ms *MemoryPageStore) Sync() error {
+	return nil
+}
+
+func (ms *MemoryPageStore) Close() error {
+	return nil
+}
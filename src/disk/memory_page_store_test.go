@@ -0,0 +1,53 @@
+package disk
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+)
+
+func TestMemoryPageStore_ReadWrite(t *testing.T) {
+	store := NewMemoryPageStore()
+	defer store.Close()
+
+	allData := make([][]byte, 0)
+	for i := 0; i < 10; i++ {
+		pageId, err := store.AllocatePage()
+		require.Nil(t, err)
+		require.Equal(t, common.PageId(i+1), pageId)
+		data := make([]byte, pageSize)
+		rand.Read(data)
+		allData = append(allData, data)
+		require.Nil(t, store.WritePage(pageId, data))
+	}
+	for i := 0; i < 10; i++ {
+		data := make([]byte, pageSize)
+		require.Nil(t, store.ReadPage(common.PageId(i+1), data))
+		require.Equal(t, allData[i], data)
+	}
+	require.NotNil(t, store.ReadPage(common.PageId(11), make([]byte, pageSize)))
+}
+
+func TestMemoryPageStore_AllocateAndDeallocate(t *testing.T) {
+	store := NewMemoryPageStore()
+	defer store.Close()
+
+	for i := 0; i < 5; i++ {
+		store.AllocatePage()
+	}
+	require.Nil(t, store.DeallocatePage(common.PageId(2)))
+	require.NotNil(t, store.DeallocatePage(common.PageId(2))) // Deallocate twice.
+	require.NotNil(t, store.DeallocatePage(common.PageId(6))) // Never allocated.
+	require.NotNil(t, store.ReadPage(common.PageId(2), make([]byte, pageSize)))
+	require.NotNil(t, store.WritePage(common.PageId(2), make([]byte, pageSize)))
+
+	pageId, err := store.AllocatePage()
+	require.Nil(t, err)
+	require.Equal(t, common.PageId(2), pageId)
+	pageId, err = store.AllocatePage()
+	require.Nil(t, err)
+	require.Equal(t, common.PageId(6), pageId)
+}
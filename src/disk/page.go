@@ -3,6 +3,7 @@ package disk
 import (
 	"simple-db-golang/src/common"
 	"sync"
+	"sync/atomic"
 )
 
 type Page struct {
@@ -10,13 +11,32 @@ type Page struct {
 	pageId   common.PageId
 	pinCount int
 	isDirty  bool
+	// LSN of the first log record that modified the page since it was last
+	// written back, accessed atomically since it is set under the page latch.
+	recLSN int64
+	// Next LSN of the log when the page was pinned. Records that modify the
+	// page while it is pinned are no older than it.
+	pinLSN common.LSN
 	sync.RWMutex
 }
 
-func (p *Page) Data() []byte { return p.data }
+func (p *Page) Data() []byte { return p.data[:PageDataSize] }
 
 func (p *Page) PageId() common.PageId { return p.pageId }
 
 func (p *Page) PinCount() int { return p.pinCount }
 
 func (p *Page) IsDirty() bool { return p.isDirty }
+
+// LSN of the latest log record that modified the page. It is stored in the
+// page trailer, so it is persisted together with the page.
+func (p *Page) LSN() common.LSN { return getPageTrailer(p.data).lsn }
+
+func (p *Page) SetLSN(lsn common.LSN) {
+	getPageTrailer(p.data).lsn = lsn
+	atomic.CompareAndSwapInt64(&p.recLSN, int64(common.InvalidLSN), int64(lsn))
+}
+
+func (p *Page) loadRecLSN() common.LSN { return common.LSN(atomic.LoadInt64(&p.recLSN)) }
+
+func (p *Page) resetRecLSN() { atomic.StoreInt64(&p.recLSN, int64(common.InvalidLSN)) }
@@ -0,0 +1,21 @@
+package disk
+
+import (
+	"simple-db-golang/src/common"
+)
+
+// PageStore is the storage backend the buffer pool reads pages from and
+// writes pages back to. Every page is exactly `pageSize` bytes.
+type PageStore interface {
+	ReadPage(pageId common.PageId, data []byte) error
+	WritePage(pageId common.PageId, data []byte) error
+	AllocatePage() (common.PageId, error)
+	DeallocatePage(pageId common.PageId) error
+	Sync() error
+	Close() error
+}
+
+var (
+	_ PageStore = (*DiskManager)(nil)
+	_ PageStore = (*MemoryPageStore)(nil)
+)
@@ -0,0 +1,73 @@
+package disk
+
+import (
+	"fmt"
+	"hash/crc32"
+	"unsafe"
+
+	"simple-db-golang/src/common"
+)
+
+const (
+	pageFormatVersion = uint32(1)
+	pageTrailerSize   = int(unsafe.Sizeof(pageTrailer{}))
+
+	// Number of bytes in a page that are available to the page's user. The
+	// rest of the page is occupied by the trailer.
+	PageDataSize = pageSize - pageTrailerSize
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Every page on disk ends with a trailer, which is stamped right before the
+// page is written and verified right after it is read.
+type pageTrailer struct {
+	lsn      common.LSN // LSN of the latest log record that modified the page.
+	version  uint32
+	checksum uint32 // CRC32C of all bytes in the page before this field.
+}
+
+// CorruptPageError is returned when a page read from disk fails verification,
+// e.g. because of a torn write or bit rot.
+type CorruptPageError struct {
+	PageId           common.PageId
+	Version          uint32
+	ExpectedChecksum uint32
+	ActualChecksum   uint32
+}
+
+func (e *CorruptPageError) Error() string {
+	if e.Version != pageFormatVersion {
+		return fmt.Sprintf("Page %d is corrupted: unknown page format version %d.", e.PageId, e.Version)
+	}
+	return fmt.Sprintf("Page %d is corrupted: checksum is %#08x, expected %#08x.",
+		e.PageId, e.ActualChecksum, e.ExpectedChecksum)
+}
+
+func getPageTrailer(data []byte) *pageTrailer {
+	return (*pageTrailer)(unsafe.Pointer(&data[PageDataSize]))
+}
+
+func computePageChecksum(data []byte) uint32 {
+	return crc32.Checksum(data[:pageSize-int(unsafe.Sizeof(uint32(0)))], crc32cTable)
+}
+
+func stampPage(data []byte) {
+	trailer := getPageTrailer(data)
+	trailer.version = pageFormatVersion
+	trailer.checksum = computePageChecksum(data)
+}
+
+func verifyPage(pageId common.PageId, data []byte) error {
+	trailer := getPageTrailer(data)
+	checksum := computePageChecksum(data)
+	if trailer.version != pageFormatVersion || trailer.checksum != checksum {
+		return &CorruptPageError{
+			PageId:           pageId,
+			Version:          trailer.version,
+			ExpectedChecksum: trailer.checksum,
+			ActualChecksum:   checksum,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package execution
+
+import (
+	"fmt"
+	"unsafe"
+
+	"simple-db-golang/src/sql"
+	"simple-db-golang/src/types"
+)
+
+type AggregateFunction uint8
+
+const (
+	CountAggregate AggregateFunction = iota
+	SumAggregate
+	MinAggregate
+	MaxAggregate
+	AvgAggregate
+)
+
+func (f AggregateFunction) String() string {
+	switch f {
+	case CountAggregate:
+		return "COUNT"
+	case SumAggregate:
+		return "SUM"
+	case MinAggregate:
+		return "MIN"
+	case MaxAggregate:
+		return "MAX"
+	default:
+		return "AVG"
+	}
+}
+
+// Aggregate computes a value from the values of an expression over the rows
+// of a group, leaving out NULLs, and only counting each value once if it is
+// DISTINCT. The aggregate of no value is NULL, except for COUNT which is 0.
+//
+// COUNT is a BIGINT, and so is the SUM of integers. AVG is a DOUBLE. MIN and
+// MAX have the type of their argument.
+type Aggregate struct {
+	Function AggregateFunction
+	Distinct bool
+	// Nil for `COUNT(*)`, which counts rows.
+	Arg    Expression
+	typeId types.TypeId
+}
+
+func NewAggregate(function AggregateFunction, distinct bool, arg Expression) (*Aggregate, error) {
+	a := &Aggregate{Function: function, Distinct: distinct, Arg: arg}
+	if arg == nil {
+		if function != CountAggregate || distinct {
+			return nil, fmt.Errorf("%s needs an argument.", a)
+		}
+		a.typeId = types.BigInt
+		return a, nil
+	}
+	switch function {
+	case CountAggregate:
+		a.typeId = types.BigInt
+	case SumAggregate, AvgAggregate:
+		if !isNumeric(arg) {
+			return nil, fmt.Errorf("%s cannot apply to %s.", function, arg.Type())
+		}
+		switch {
+		case function == AvgAggregate || arg.Type() == types.Double:
+			a.typeId = types.Double
+		default:
+			a.typeId = types.BigInt
+		}
+	default:
+		a.typeId = arg.Type()
+	}
+	return a, nil
+}
+
+func (a *Aggregate) Type() types.TypeId {
+	return a.typeId
+}
+
+func (a *Aggregate) String() string {
+	switch {
+	case a.Arg == nil:
+		return fmt.Sprintf("%s(*)", a.Function)
+	case a.Distinct:
+		return fmt.Sprintf("%s(DISTINCT %s)", a.Function, a.Arg)
+	default:
+		return fmt.Sprintf("%s(%s)", a.Function, a.Arg)
+	}
+}
+
+// aggregateState is the aggregate of the rows of a group seen so far.
+type aggregateState struct {
+	aggregate *Aggregate
+	count     int64
+	sum       types.Value // The sum for SUM and AVG, the value for MIN and MAX.
+	seen      map[string]struct{}
+}
+
+var aggregateStateSize = int(unsafe.Sizeof(aggregateState{}))
+
+func newAggregateState(aggregate *Aggregate) *aggregateState {
+	s := &aggregateState{aggregate: aggregate, sum: types.NewNull(aggregate.typeId)}
+	if aggregate.Distinct {
+		s.seen = make(map[string]struct{})
+	}
+	return s
+}
+
+// Add the value of the aggregate for a row. Returns the number of bytes the
+// state grows by, for the values of a DISTINCT aggregate.
+func (s *aggregateState) add(row []types.Value) (int, error) {
+	a := s.aggregate
+	if a.Arg == nil {
+		s.count++
+		return 0, nil
+	}
+	v, err := a.Arg.Evaluate(row)
+	if err != nil || v.IsNull() {
+		return 0, err
+	}
+	size := 0
+	if a.Distinct {
+		key := string(types.EncodeKey([]types.Value{v}))
+		if _, ok := s.seen[key]; ok {
+			return 0, nil
+		}
+		s.seen[key] = struct{}{}
+		size = len(key) + int(unsafe.Sizeof(key))
+	}
+	s.count++
+	switch a.Function {
+	case SumAggregate, AvgAggregate:
+		switch {
+		case s.sum.IsNull():
+			s.sum, err = v.CastAs(a.typeId)
+		case a.typeId == types.Double:
+			s.sum = types.NewDouble(s.sum.AsDouble() + asFloat(v))
+		default:
+			s.sum, err = integerResult(types.BigInt, s.sum.AsBigInt(), v.AsBigInt(), sql.OpAdd)
+		}
+	case MinAggregate, MaxAggregate:
+		if s.sum.IsNull() {
+			s.sum = v
+			break
+		}
+		cmp, err := v.Compare(s.sum)
+		if err != nil {
+			return 0, err
+		}
+		if (cmp < 0) == (a.Function == MinAggregate) && cmp != 0 {
+			s.sum = v
+		}
+	}
+	return size, err
+}
+
+// The aggregate of the rows added.
+func (s *aggregateState) result() types.Value {
+	switch {
+	case s.aggregate.Function == CountAggregate:
+		return types.NewBigInt(s.count)
+	case s.aggregate.Function == AvgAggregate && !s.sum.IsNull():
+		return types.NewDouble(s.sum.AsDouble() / float64(s.count))
+	default:
+		return s.sum
+	}
+}
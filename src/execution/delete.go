@@ -0,0 +1,54 @@
+package execution
+
+import (
+	"simple-db-golang/src/catalog"
+	"simple-db-golang/src/types"
+)
+
+// DeleteExecutor deletes the rows of a table found by its child, e.g. a scan
+// of the table, and produces a single row with the number of deleted rows.
+type DeleteExecutor struct {
+	ctx   *ExecutorContext
+	table *catalog.Table
+	child Executor
+	done  bool
+}
+
+func NewDeleteExecutor(ctx *ExecutorContext, table *catalog.Table, child Executor) *DeleteExecutor {
+	return &DeleteExecutor{ctx: ctx, table: table, child: child}
+}
+
+func (e *DeleteExecutor) OutputSchema() *types.Schema {
+	return countSchema()
+}
+
+func (e *DeleteExecutor) Init() error {
+	e.done = false
+	return nil
+}
+
+func (e *DeleteExecutor) Next() (Row, bool, error) {
+	if e.done {
+		return Row{}, false, nil
+	}
+	e.done = true
+	rows, err := collectRows(e.child)
+	if err != nil {
+		return Row{}, false, err
+	}
+	count := int64(0)
+	for _, row := range rows {
+		deleted, err := e.table.Delete(e.ctx.Txn, row.RID)
+		if err != nil {
+			return Row{}, false, err
+		}
+		if deleted {
+			count++
+		}
+	}
+	return Row{Values: []types.Value{types.NewBigInt(count)}, RID: NoRID}, true, nil
+}
+
+func (e *DeleteExecutor) Close() {
+	e.child.Close()
+}
@@ -0,0 +1,104 @@
+package execution
+
+import (
+	"simple-db-golang/src/catalog"
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/transaction"
+	"simple-db-golang/src/types"
+)
+
+// ExecutorContext is what the executors of a query plan run with.
+type ExecutorContext struct {
+	Txn               *transaction.Transaction
+	Catalog           *catalog.Catalog
+	BufferPoolManager *disk.BufferPoolManager
+}
+
+// Row is a tuple produced by an executor: a value for each column of the
+// output schema of the executor, of the type of the column or NULL, and the
+// RID of the row of a table it was read from, if any.
+type Row struct {
+	Values []types.Value
+	RID    common.RID
+}
+
+// The RID of rows which were not read from a table.
+var NoRID = common.RID{PageId: common.InvalidPageId, SlotNum: -1}
+
+// Executor is an operator of a query plan. Executors form a tree, where each
+// executor pulls the rows of its children one at a time and produces rows for
+// its parent.
+//
+// `Init` must be called before `Next`, and may be called again to start over,
+// e.g. to scan the inner side of a join once for each outer row. `Close`
+// releases what the executor holds, like pinned pages, and must be called once
+// the rows are no longer needed, even if `Init` or `Next` failed. Executors
+// are not safe for concurrent use.
+type Executor interface {
+	// The schema of the rows produced by the executor.
+	OutputSchema() *types.Schema
+	// Prepare to produce the first row.
+	Init() error
+	// Produce the next row. Returns false once there are no more rows.
+	Next() (Row, bool, error)
+	Close()
+}
+
+// Run an executor to the end, and return the rows it produced.
+func Execute(executor Executor) ([]Row, error) {
+	defer executor.Close()
+	if err := executor.Init(); err != nil {
+		return nil, err
+	}
+	rows := make([]Row, 0)
+	for {
+		row, ok, err := executor.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+}
+
+// The schema of the single row of a write executor: the number of rows it
+// wrote.
+func countSchema() *types.Schema {
+	return types.NewSchema(types.Column{Name: "count", Type: types.BigInt})
+}
+
+// Produce all the rows of `child` from the start, so that writing them cannot
+// affect which rows it produces, e.g. when it scans the table being written.
+func collectRows(child Executor) ([]Row, error) {
+	if err := child.Init(); err != nil {
+		return nil, err
+	}
+	rows := make([]Row, 0)
+	for {
+		row, ok, err := child.Next()
+		if err != nil || !ok {
+			return rows, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// Convert `values` to the types of the columns of `schema`, see
+// `types.Value.CastAs`.
+func castValues(schema *types.Schema, values []types.Value) ([]types.Value, error) {
+	if len(values) != len(schema.Columns) {
+		// Left to `types.NewTuple` to report.
+		return values, nil
+	}
+	cast := make([]types.Value, len(values))
+	for i, value := range values {
+		var err error
+		if cast[i], err = value.CastAs(schema.Columns[i].Type); err != nil {
+			return nil, err
+		}
+	}
+	return cast, nil
+}
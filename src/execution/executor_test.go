@@ -0,0 +1,336 @@
+package execution
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/catalog"
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/sql"
+	"simple-db-golang/src/transaction"
+	"simple-db-golang/src/types"
+)
+
+const testPoolSize = 32
+
+type testDatabase struct {
+	catalog    *catalog.Catalog
+	txnManager *transaction.TransactionManager
+	bpm        *disk.BufferPoolManager
+	store      *countingPageStore
+}
+
+func newTestDatabase() *testDatabase {
+	store := &countingPageStore{MemoryPageStore: disk.NewMemoryPageStore()}
+	bpm := disk.NewBufferPoolManager(testPoolSize, store, disk.NewLRUReplacer())
+	return &testDatabase{
+		catalog:    catalog.NewCatalog(bpm),
+		txnManager: transaction.NewTransactionManager(nil, nil),
+		bpm:        bpm,
+		store:      store,
+	}
+}
+
+// countingPageStore counts the pages allocated and not deallocated yet.
+type countingPageStore struct {
+	*disk.MemoryPageStore
+	numPages int
+}
+
+func (s *countingPageStore) AllocatePage() (common.PageId, error) {
+	s.numPages++
+	return s.MemoryPageStore.AllocatePage()
+}
+
+func (s *countingPageStore) DeallocatePage(pageId common.PageId) error {
+	s.numPages--
+	return s.MemoryPageStore.DeallocatePage(pageId)
+}
+
+// Check that no page of the buffer pool is pinned, as every frame can hold a
+// new page.
+func requireNoPinnedPages(t *testing.T, db *testDatabase) {
+	pageIds := make([]common.PageId, 0)
+	for i := 0; i < testPoolSize; i++ {
+		page, err := db.bpm.NewPage()
+		require.Nil(t, err)
+		pageIds = append(pageIds, page.PageId())
+	}
+	for _, pageId := range pageIds {
+		db.bpm.UnpinPage(pageId, false)
+		require.Nil(t, db.bpm.DeletePage(pageId))
+	}
+}
+
+func (db *testDatabase) context(txn *transaction.Transaction) *ExecutorContext {
+	return &ExecutorContext{Txn: txn, Catalog: db.catalog, BufferPoolManager: db.bpm}
+}
+
+// Create a table with an index on its first column, and insert `rows` into it.
+func (db *testDatabase) createTable(t *testing.T, name string, schema *types.Schema, rows [][]types.Value) {
+	txn := db.txnManager.Begin()
+	_, err := db.catalog.CreateTable(txn, name, schema)
+	require.Nil(t, err)
+	require.Nil(t, db.catalog.CreateIndex(txn, &catalog.IndexInfo{
+		Name: name + "_" + schema.Columns[0].Name, TableName: name, Columns: []string{schema.Columns[0].Name},
+		Kind: catalog.BPlusTreeIndex,
+	}))
+	table, err := db.catalog.OpenTable(txn, name)
+	require.Nil(t, err)
+	insert := NewInsertExecutor(db.context(txn), table, NewValuesExecutor(schema, rows))
+	_, err = Execute(insert)
+	require.Nil(t, err)
+	require.Nil(t, db.txnManager.Commit(txn))
+}
+
+func (db *testDatabase) openTable(t *testing.T, txn *transaction.Transaction, name string) *catalog.Table {
+	table, err := db.catalog.OpenTable(txn, name)
+	require.Nil(t, err)
+	return table
+}
+
+func usersSchema() *types.Schema {
+	return types.NewSchema(
+		types.Column{Name: "id", Type: types.Integer},
+		types.Column{Name: "name", Type: types.Varchar, Length: 10, Nullable: true},
+		types.Column{Name: "age", Type: types.Integer, Nullable: true},
+	)
+}
+
+func user(id int32, name string, age int32) []types.Value {
+	return []types.Value{types.NewInteger(id), types.NewVarchar(name), types.NewInteger(age)}
+}
+
+func createUsers(t *testing.T, db *testDatabase) {
+	db.createTable(t, "users", usersSchema(), [][]types.Value{
+		user(1, "alice", 30),
+		user(2, "bob", 17),
+		user(3, "carol", 45),
+		{types.NewInteger(4), types.NewNull(types.Varchar), types.NewNull(types.Integer)},
+		user(5, "bob", 52),
+	})
+}
+
+// The values of `rows`, ignoring their RIDs.
+func rowValues(rows []Row) [][]types.Value {
+	values := make([][]types.Value, len(rows))
+	for i, row := range rows {
+		values[i] = row.Values
+	}
+	return values
+}
+
+func column(t *testing.T, schema *types.Schema, name string) Expression {
+	expression, err := NewNamedColumn(schema, name)
+	require.Nil(t, err)
+	return expression
+}
+
+func binaryOp(t *testing.T, op sql.Operator, left, right Expression) Expression {
+	expression, err := NewBinary(op, left, right)
+	require.Nil(t, err)
+	return expression
+}
+
+func TestSeqScan(t *testing.T) {
+	db := newTestDatabase()
+	createUsers(t, db)
+	txn := db.txnManager.Begin()
+	users := db.openTable(t, txn, "users")
+	ctx := db.context(txn)
+
+	rows, err := Execute(NewSeqScanExecutor(ctx, users))
+	require.Nil(t, err)
+	require.Equal(t, 5, len(rows))
+	require.Equal(t, user(3, "carol", 45), rows[2].Values)
+	tuple, found, err := users.Get(txn, rows[2].RID)
+	require.Nil(t, err)
+	require.True(t, found)
+	require.Equal(t, rows[2].Values, tuple.Values(users.Info.Schema))
+
+	// SELECT name, age + 1 AS next FROM users WHERE age >= 18 LIMIT 2 OFFSET 1
+	schema := users.Info.Schema
+	filter := NewFilterExecutor(NewSeqScanExecutor(ctx, users),
+		binaryOp(t, sql.OpGreaterOrEqual, column(t, schema, "age"), NewConstant(types.NewInteger(18))))
+	projection := NewProjectionExecutor(filter, []Expression{
+		column(t, schema, "name"),
+		binaryOp(t, sql.OpAdd, column(t, schema, "age"), NewConstant(types.NewBigInt(1))),
+	}, []string{"name", "next"})
+	require.Equal(t, types.NewSchema(
+		types.Column{Name: "name", Type: types.Varchar, Length: 10, Nullable: true},
+		types.Column{Name: "next", Type: types.BigInt, Nullable: true},
+	), projection.OutputSchema())
+	limit := NewLimitExecutor(projection, 2, 1)
+	rows, err = Execute(limit)
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{
+		{types.NewVarchar("carol"), types.NewBigInt(46)},
+		{types.NewVarchar("bob"), types.NewBigInt(53)},
+	}, rowValues(rows))
+
+	// Executors start over when initialized again.
+	rows, err = Execute(limit)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(rows))
+	rows, err = Execute(NewLimitExecutor(NewSeqScanExecutor(ctx, users), 10, 7))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(rows))
+	// A negative limit, as for OFFSET without LIMIT, keeps all the rows.
+	rows, err = Execute(NewLimitExecutor(NewSeqScanExecutor(ctx, users), -1, 3))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{
+		{types.NewInteger(4), types.NewNull(types.Varchar), types.NewNull(types.Integer)},
+		user(5, "bob", 52),
+	}, rowValues(rows))
+	rows, err = Execute(NewLimitExecutor(NewSeqScanExecutor(ctx, users), 0, 0))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(rows))
+
+	// A condition must be a BOOLEAN.
+	_, err = Execute(NewFilterExecutor(NewSeqScanExecutor(ctx, users), column(t, schema, "age")))
+	require.NotNil(t, err)
+	require.Nil(t, db.txnManager.Commit(txn))
+}
+
+func TestIndexScan(t *testing.T) {
+	db := newTestDatabase()
+	createUsers(t, db)
+	txn := db.txnManager.Begin()
+	users := db.openTable(t, txn, "users")
+	ctx := db.context(txn)
+
+	rows, err := Execute(NewIndexScanExecutor(ctx, users, "users_id", []types.Value{types.NewInteger(3)}))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{user(3, "carol", 45)}, rowValues(rows))
+
+	// Keys are converted to the types of the key columns, if they can be.
+	rows, err = Execute(NewIndexScanExecutor(ctx, users, "users_id", []types.Value{types.NewDouble(5)}))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{user(5, "bob", 52)}, rowValues(rows))
+	for _, key := range []types.Value{types.NewDouble(4.5), types.NewBigInt(1 << 40), types.NewInteger(9), types.NewNull(types.Integer)} {
+		rows, err = Execute(NewIndexScanExecutor(ctx, users, "users_id", []types.Value{key}))
+		require.Nil(t, err)
+		require.Equal(t, 0, len(rows), "%v", key)
+	}
+	_, err = Execute(NewIndexScanExecutor(ctx, users, "users_name", []types.Value{types.NewVarchar("bob")}))
+	require.Equal(t, catalog.ErrIndexNotFound, err)
+	_, err = Execute(NewIndexScanExecutor(ctx, users, "users_id", []types.Value{types.NewVarchar("bob")}))
+	require.NotNil(t, err)
+	require.Nil(t, db.txnManager.Commit(txn))
+}
+
+func TestInsert(t *testing.T) {
+	db := newTestDatabase()
+	createUsers(t, db)
+	txn := db.txnManager.Begin()
+	users := db.openTable(t, txn, "users")
+	ctx := db.context(txn)
+
+	// Values are converted to the types of the columns.
+	values := NewValuesExecutor(types.NewSchema(
+		types.Column{Name: "a", Type: types.BigInt},
+		types.Column{Name: "b", Type: types.Varchar},
+		types.Column{Name: "c", Type: types.InvalidType},
+	), [][]types.Value{
+		{types.NewBigInt(6), types.NewVarchar("dave"), types.NewNull(types.InvalidType)},
+		{types.NewBigInt(7), types.NewVarchar("erin"), types.NewNull(types.InvalidType)},
+	})
+	rows, err := Execute(NewInsertExecutor(ctx, users, values))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{{types.NewBigInt(2)}}, rowValues(rows))
+	rows, err = Execute(NewIndexScanExecutor(ctx, users, "users_id", []types.Value{types.NewInteger(7)}))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{{types.NewInteger(7), types.NewVarchar("erin"), types.NewNull(types.Integer)}}, rowValues(rows))
+
+	// INSERT INTO users SELECT id + 10, name, age FROM users, which does not
+	// see its own rows.
+	schema := users.Info.Schema
+	projection := NewProjectionExecutor(NewSeqScanExecutor(ctx, users), []Expression{
+		binaryOp(t, sql.OpAdd, column(t, schema, "id"), NewConstant(types.NewInteger(10))),
+		column(t, schema, "name"),
+		column(t, schema, "age"),
+	}, []string{"id", "name", "age"})
+	rows, err = Execute(NewInsertExecutor(ctx, users, projection))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{{types.NewBigInt(7)}}, rowValues(rows))
+	rows, err = Execute(NewSeqScanExecutor(ctx, users))
+	require.Nil(t, err)
+	require.Equal(t, 14, len(rows))
+
+	// Rows which do not fit the table fail the insert.
+	for _, row := range [][]types.Value{
+		{types.NewInteger(20), types.NewVarchar("far too long a name"), types.NewInteger(1)},
+		{types.NewNull(types.Integer), types.NewVarchar("x"), types.NewInteger(1)},
+		{types.NewInteger(20), types.NewInteger(1), types.NewInteger(1)},
+		{types.NewInteger(20), types.NewVarchar("x")},
+	} {
+		_, err = Execute(NewInsertExecutor(ctx, users, NewValuesExecutor(schema, [][]types.Value{row})))
+		require.NotNil(t, err, "%v", row)
+	}
+	require.Nil(t, db.txnManager.Commit(txn))
+}
+
+func TestUpdateDelete(t *testing.T) {
+	db := newTestDatabase()
+	createUsers(t, db)
+	txn := db.txnManager.Begin()
+	users := db.openTable(t, txn, "users")
+	ctx := db.context(txn)
+	schema := users.Info.Schema
+
+	// UPDATE users SET id = id * 10, name = 'minor' WHERE age < 18 OR age IS NULL
+	filter := NewFilterExecutor(NewSeqScanExecutor(ctx, users), binaryOp(t, sql.OpOr,
+		binaryOp(t, sql.OpLess, column(t, schema, "age"), NewConstant(types.NewInteger(18))),
+		NewIsNull(column(t, schema, "age"), false)))
+	rows, err := Execute(NewUpdateExecutor(ctx, users, filter, map[int]Expression{
+		0: binaryOp(t, sql.OpMultiply, column(t, schema, "id"), NewConstant(types.NewInteger(10))),
+		1: NewConstant(types.NewVarchar("minor")),
+	}))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{{types.NewBigInt(2)}}, rowValues(rows))
+	rows, err = Execute(NewIndexScanExecutor(ctx, users, "users_id", []types.Value{types.NewInteger(40)}))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{{types.NewInteger(40), types.NewVarchar("minor"), types.NewNull(types.Integer)}}, rowValues(rows))
+	rows, err = Execute(NewIndexScanExecutor(ctx, users, "users_id", []types.Value{types.NewInteger(2)}))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(rows))
+
+	// A failed expression fails the update.
+	_, err = Execute(NewUpdateExecutor(ctx, users, NewSeqScanExecutor(ctx, users), map[int]Expression{
+		2: binaryOp(t, sql.OpDivide, column(t, schema, "age"), NewConstant(types.NewInteger(0))),
+	}))
+	require.Equal(t, ErrDivisionByZero, err)
+
+	// DELETE FROM users WHERE name = 'bob'
+	filter = NewFilterExecutor(NewSeqScanExecutor(ctx, users),
+		binaryOp(t, sql.OpEqual, column(t, schema, "name"), NewConstant(types.NewVarchar("bob"))))
+	rows, err = Execute(NewDeleteExecutor(ctx, users, filter))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{{types.NewBigInt(1)}}, rowValues(rows))
+	require.Nil(t, db.txnManager.Commit(txn))
+
+	txn = db.txnManager.Begin()
+	users = db.openTable(t, txn, "users")
+	rows, err = Execute(NewSeqScanExecutor(db.context(txn), users))
+	require.Nil(t, err)
+	require.ElementsMatch(t, [][]types.Value{
+		user(1, "alice", 30),
+		user(20, "minor", 17),
+		user(3, "carol", 45),
+		{types.NewInteger(40), types.NewVarchar("minor"), types.NewNull(types.Integer)},
+	}, rowValues(rows))
+
+	// Writes of an aborted transaction are rolled back, in the indexes too.
+	rows, err = Execute(NewDeleteExecutor(db.context(txn), users, NewSeqScanExecutor(db.context(txn), users)))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{{types.NewBigInt(4)}}, rowValues(rows))
+	db.txnManager.Abort(txn)
+	txn = db.txnManager.Begin()
+	users = db.openTable(t, txn, "users")
+	rows, err = Execute(NewIndexScanExecutor(db.context(txn), users, "users_id", []types.Value{types.NewInteger(20)}))
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{user(20, "minor", 17)}, rowValues(rows))
+	require.Nil(t, db.txnManager.Commit(txn))
+}
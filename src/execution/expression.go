@@ -0,0 +1,370 @@
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"simple-db-golang/src/sql"
+	"simple-db-golang/src/types"
+)
+
+var (
+	ErrDivisionByZero = errors.New("Division by zero.")
+	ErrOverflow       = errors.New("Integer overflow.")
+)
+
+// Expression computes a value from the values of a row. Its type is checked
+// when it is built, so evaluating it only fails on overflows and divisions by
+// zero.
+//
+// NULL follows SQL: operations on NULL are NULL, except that `NULL AND FALSE`
+// is FALSE and `NULL OR TRUE` is TRUE. A NULL of `types.InvalidType`, like the
+// NULL literal, goes with values of any type.
+type Expression interface {
+	// The type of the values, which may also be NULL.
+	Type() types.TypeId
+	Evaluate(row []types.Value) (types.Value, error)
+	String() string
+}
+
+// ColumnExpression is the value of a column of the row.
+type ColumnExpression struct {
+	Index  int
+	Column types.Column
+}
+
+// The column `i` of `schema`.
+func NewColumn(schema *types.Schema, i int) *ColumnExpression {
+	return &ColumnExpression{Index: i, Column: schema.Columns[i]}
+}
+
+// The column called `name` in `schema`.
+func NewNamedColumn(schema *types.Schema, name string) (*ColumnExpression, error) {
+	i, ok := schema.ColumnIndex(name)
+	if !ok {
+		return nil, fmt.Errorf("Column %s does not exist.", name)
+	}
+	return NewColumn(schema, i), nil
+}
+
+func (e *ColumnExpression) Type() types.TypeId { return e.Column.Type }
+
+func (e *ColumnExpression) Evaluate(row []types.Value) (types.Value, error) {
+	return row[e.Index], nil
+}
+
+func (e *ColumnExpression) String() string { return e.Column.Name }
+
+type ConstantExpression struct {
+	Value types.Value
+}
+
+func NewConstant(value types.Value) *ConstantExpression {
+	return &ConstantExpression{Value: value}
+}
+
+func (e *ConstantExpression) Type() types.TypeId { return e.Value.Type() }
+
+func (e *ConstantExpression) Evaluate(row []types.Value) (types.Value, error) {
+	return e.Value, nil
+}
+
+func (e *ConstantExpression) String() string {
+	if e.Value.Type() == types.Varchar && !e.Value.IsNull() {
+		return "'" + strings.ReplaceAll(e.Value.AsVarchar(), "'", "''") + "'"
+	}
+	return e.Value.String()
+}
+
+// UnaryExpression is `NOT operand` on a BOOLEAN, or `-operand` on a number.
+type UnaryExpression struct {
+	Op      sql.Operator
+	Operand Expression
+}
+
+func NewUnary(op sql.Operator, operand Expression) (*UnaryExpression, error) {
+	switch {
+	case op == sql.OpNot && isType(operand, types.Boolean):
+	case op == sql.OpNegate && isNumeric(operand):
+	default:
+		return nil, fmt.Errorf("Operator %s cannot apply to %s.", op, operand.Type())
+	}
+	return &UnaryExpression{Op: op, Operand: operand}, nil
+}
+
+func (e *UnaryExpression) Type() types.TypeId {
+	if e.Op == sql.OpNot {
+		return types.Boolean
+	}
+	return e.Operand.Type()
+}
+
+func (e *UnaryExpression) Evaluate(row []types.Value) (types.Value, error) {
+	v, err := e.Operand.Evaluate(row)
+	if err != nil {
+		return v, err
+	}
+	switch {
+	case v.IsNull():
+		return types.NewNull(e.Type()), nil
+	case e.Op == sql.OpNot:
+		return types.NewBoolean(!v.AsBoolean()), nil
+	case v.Type() == types.Double:
+		return types.NewDouble(-v.AsDouble()), nil
+	default:
+		return integerResult(v.Type(), 0, v.AsBigInt(), sql.OpSubtract)
+	}
+}
+
+func (e *UnaryExpression) String() string {
+	if e.Op == sql.OpNot {
+		return fmt.Sprintf("(NOT %s)", e.Operand)
+	}
+	return fmt.Sprintf("(-%s)", e.Operand)
+}
+
+// BinaryExpression is a logical operation on BOOLEANs, a comparison of values
+// which compare with each other, or an arithmetic operation on numbers. The
+// result of an arithmetic operation has the widest type of its operands, from
+// INTEGER to BIGINT to DOUBLE.
+type BinaryExpression struct {
+	Op     sql.Operator
+	Left   Expression
+	Right  Expression
+	typeId types.TypeId
+}
+
+func NewBinary(op sql.Operator, left, right Expression) (*BinaryExpression, error) {
+	e := &BinaryExpression{Op: op, Left: left, Right: right}
+	switch op {
+	case sql.OpAnd, sql.OpOr:
+		if !isType(left, types.Boolean) || !isType(right, types.Boolean) {
+			return nil, e.typeError()
+		}
+		e.typeId = types.Boolean
+	case sql.OpEqual, sql.OpNotEqual, sql.OpLess, sql.OpLessOrEqual, sql.OpGreater, sql.OpGreaterOrEqual:
+		if !comparable(left.Type(), right.Type()) {
+			return nil, fmt.Errorf("Cannot compare %s with %s.", left.Type(), right.Type())
+		}
+		e.typeId = types.Boolean
+	case sql.OpAdd, sql.OpSubtract, sql.OpMultiply, sql.OpDivide, sql.OpModulo:
+		if !isNumeric(left) || !isNumeric(right) {
+			return nil, e.typeError()
+		}
+		e.typeId = widerType(left.Type(), right.Type())
+	default:
+		return nil, fmt.Errorf("Operator %s takes one operand.", op)
+	}
+	return e, nil
+}
+
+func (e *BinaryExpression) typeError() error {
+	return fmt.Errorf("Operator %s cannot apply to %s and %s.", e.Op, e.Left.Type(), e.Right.Type())
+}
+
+func (e *BinaryExpression) Type() types.TypeId { return e.typeId }
+
+func (e *BinaryExpression) Evaluate(row []types.Value) (types.Value, error) {
+	left, err := e.Left.Evaluate(row)
+	if err != nil {
+		return left, err
+	}
+	// Skip the right operand when the left one decides.
+	if e.Op == sql.OpAnd && !left.IsNull() && !left.AsBoolean() {
+		return left, nil
+	}
+	if e.Op == sql.OpOr && !left.IsNull() && left.AsBoolean() {
+		return left, nil
+	}
+	right, err := e.Right.Evaluate(row)
+	if err != nil {
+		return right, err
+	}
+	switch e.Op {
+	case sql.OpAnd, sql.OpOr:
+		// The left operand is NULL, or does not decide, so the right one
+		// decides unless the left one is NULL and it does not either.
+		if right.IsNull() || (left.IsNull() && right.AsBoolean() == (e.Op == sql.OpAnd)) {
+			return types.NewNull(types.Boolean), nil
+		}
+		return right, nil
+	}
+	if left.IsNull() || right.IsNull() {
+		return types.NewNull(e.typeId), nil
+	}
+	if e.typeId == types.Boolean {
+		cmp, err := left.Compare(right)
+		if err != nil {
+			return types.Value{}, err
+		}
+		return types.NewBoolean(compareResult(e.Op, cmp)), nil
+	}
+	return arithmetic(e.Op, e.typeId, left, right)
+}
+
+func (e *BinaryExpression) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.Left, e.Op, e.Right)
+}
+
+// IsNullExpression is `operand IS [NOT] NULL`.
+type IsNullExpression struct {
+	Operand Expression
+	Not     bool
+}
+
+func NewIsNull(operand Expression, not bool) *IsNullExpression {
+	return &IsNullExpression{Operand: operand, Not: not}
+}
+
+func (e *IsNullExpression) Type() types.TypeId { return types.Boolean }
+
+func (e *IsNullExpression) Evaluate(row []types.Value) (types.Value, error) {
+	v, err := e.Operand.Evaluate(row)
+	if err != nil {
+		return v, err
+	}
+	return types.NewBoolean(v.IsNull() != e.Not), nil
+}
+
+func (e *IsNullExpression) String() string {
+	if e.Not {
+		return fmt.Sprintf("(%s IS NOT NULL)", e.Operand)
+	}
+	return fmt.Sprintf("(%s IS NULL)", e.Operand)
+}
+
+// Whether `condition` is TRUE for the row, rather than FALSE or NULL.
+func isTrue(condition Expression, row []types.Value) (bool, error) {
+	v, err := condition.Evaluate(row)
+	if err != nil {
+		return false, err
+	}
+	return !v.IsNull() && v.AsBoolean(), nil
+}
+
+// Check that `condition` can be used to select rows.
+func checkCondition(condition Expression) error {
+	if !isType(condition, types.Boolean) {
+		return fmt.Errorf("Condition %s is %s, not BOOLEAN.", condition, condition.Type())
+	}
+	return nil
+}
+
+func isType(e Expression, typeId types.TypeId) bool {
+	return e.Type() == typeId || e.Type() == types.InvalidType
+}
+
+func isNumeric(e Expression) bool {
+	return e.Type().IsNumeric() || e.Type() == types.InvalidType
+}
+
+func comparable(a, b types.TypeId) bool {
+	return a == b || a == types.InvalidType || b == types.InvalidType || (a.IsNumeric() && b.IsNumeric())
+}
+
+// The wider of two numeric types, or of a numeric type and the type of NULL.
+func widerType(a, b types.TypeId) types.TypeId {
+	switch {
+	case a == types.Double || b == types.Double:
+		return types.Double
+	case a == types.BigInt || b == types.BigInt:
+		return types.BigInt
+	case a == types.Integer || b == types.Integer:
+		return types.Integer
+	default:
+		return types.InvalidType
+	}
+}
+
+func compareResult(op sql.Operator, cmp int) bool {
+	switch op {
+	case sql.OpEqual:
+		return cmp == 0
+	case sql.OpNotEqual:
+		return cmp != 0
+	case sql.OpLess:
+		return cmp < 0
+	case sql.OpLessOrEqual:
+		return cmp <= 0
+	case sql.OpGreater:
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func asFloat(v types.Value) float64 {
+	if v.Type() == types.Double {
+		return v.AsDouble()
+	}
+	return float64(v.AsBigInt())
+}
+
+// Compute `left op right`, for numbers which are not NULL.
+func arithmetic(op sql.Operator, typeId types.TypeId, left, right types.Value) (types.Value, error) {
+	if typeId == types.Double {
+		a, b := asFloat(left), asFloat(right)
+		switch op {
+		case sql.OpAdd:
+			return types.NewDouble(a + b), nil
+		case sql.OpSubtract:
+			return types.NewDouble(a - b), nil
+		case sql.OpMultiply:
+			return types.NewDouble(a * b), nil
+		}
+		if b == 0 {
+			return types.Value{}, ErrDivisionByZero
+		}
+		if op == sql.OpDivide {
+			return types.NewDouble(a / b), nil
+		}
+		return types.NewDouble(math.Mod(a, b)), nil
+	}
+	return integerResult(typeId, left.AsBigInt(), right.AsBigInt(), op)
+}
+
+// Compute `a op b` for integers of `typeId`, checking for overflows.
+func integerResult(typeId types.TypeId, a, b int64, op sql.Operator) (types.Value, error) {
+	var r int64
+	switch op {
+	case sql.OpAdd:
+		r = a + b
+		if (a > 0 && b > 0 && r < 0) || (a < 0 && b < 0 && r >= 0) {
+			return types.Value{}, ErrOverflow
+		}
+	case sql.OpSubtract:
+		r = a - b
+		if (a >= 0 && b < 0 && r < 0) || (a < 0 && b > 0 && r >= 0) {
+			return types.Value{}, ErrOverflow
+		}
+	case sql.OpMultiply:
+		r = a * b
+		if a != 0 && (r/a != b || (a == -1 && b == math.MinInt64)) {
+			return types.Value{}, ErrOverflow
+		}
+	case sql.OpDivide, sql.OpModulo:
+		if b == 0 {
+			return types.Value{}, ErrDivisionByZero
+		}
+		if a == math.MinInt64 && b == -1 {
+			if op == sql.OpModulo {
+				return types.NewBigInt(0), nil
+			}
+			return types.Value{}, ErrOverflow
+		}
+		if op == sql.OpDivide {
+			r = a / b
+		} else {
+			r = a % b
+		}
+	}
+	if typeId == types.BigInt {
+		return types.NewBigInt(r), nil
+	}
+	if r < math.MinInt32 || r > math.MaxInt32 {
+		return types.Value{}, ErrOverflow
+	}
+	return types.NewInteger(int32(r)), nil
+}
@@ -0,0 +1,136 @@
+package execution
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/sql"
+	"simple-db-golang/src/types"
+)
+
+func TestExpression_Logic(t *testing.T) {
+	values := []types.Value{types.NewBoolean(true), types.NewBoolean(false), types.NewNull(types.Boolean)}
+	// Results for TRUE, FALSE and NULL on each side.
+	expected := map[sql.Operator][3][3]string{
+		sql.OpAnd: {{"true", "false", "NULL"}, {"false", "false", "false"}, {"NULL", "false", "NULL"}},
+		sql.OpOr:  {{"true", "true", "true"}, {"true", "false", "NULL"}, {"true", "NULL", "NULL"}},
+	}
+	for op, results := range expected {
+		for i, left := range values {
+			for j, right := range values {
+				e, err := NewBinary(op, NewConstant(left), NewConstant(right))
+				require.Nil(t, err)
+				v, err := e.Evaluate(nil)
+				require.Nil(t, err)
+				require.Equal(t, results[i][j], v.String(), "%v %v %v", left, op, right)
+				require.Equal(t, types.Boolean, v.Type())
+			}
+		}
+	}
+
+	not, err := NewUnary(sql.OpNot, NewConstant(types.NewNull(types.Boolean)))
+	require.Nil(t, err)
+	v, err := not.Evaluate(nil)
+	require.Nil(t, err)
+	require.True(t, v.IsNull())
+	isNull := NewIsNull(NewConstant(types.NewNull(types.InvalidType)), true)
+	v, err = isNull.Evaluate(nil)
+	require.Nil(t, err)
+	require.Equal(t, types.NewBoolean(false), v)
+}
+
+func TestExpression_Comparison(t *testing.T) {
+	schema := types.NewSchema(
+		types.Column{Name: "a", Type: types.Integer},
+		types.Column{Name: "b", Type: types.Double, Nullable: true},
+	)
+	row := []types.Value{types.NewInteger(2), types.NewDouble(2)}
+	for op, expected := range map[sql.Operator]bool{
+		sql.OpEqual: true, sql.OpNotEqual: false, sql.OpLess: false,
+		sql.OpLessOrEqual: true, sql.OpGreater: false, sql.OpGreaterOrEqual: true,
+	} {
+		e, err := NewBinary(op, NewColumn(schema, 0), NewColumn(schema, 1))
+		require.Nil(t, err)
+		v, err := e.Evaluate(row)
+		require.Nil(t, err)
+		require.Equal(t, types.NewBoolean(expected), v, "%v", op)
+	}
+
+	e, err := NewBinary(sql.OpLess, NewColumn(schema, 0), NewColumn(schema, 1))
+	require.Nil(t, err)
+	v, err := e.Evaluate([]types.Value{types.NewInteger(1), types.NewNull(types.Double)})
+	require.Nil(t, err)
+	require.Equal(t, types.NewNull(types.Boolean), v)
+	require.Equal(t, "(a < b)", e.String())
+
+	_, err = NewBinary(sql.OpEqual, NewColumn(schema, 0), NewConstant(types.NewVarchar("2")))
+	require.NotNil(t, err)
+	_, err = NewBinary(sql.OpAnd, NewColumn(schema, 0), NewConstant(types.NewBoolean(true)))
+	require.NotNil(t, err)
+	_, err = NewNamedColumn(schema, "c")
+	require.NotNil(t, err)
+}
+
+func TestExpression_Arithmetic(t *testing.T) {
+	integer, bigInt, double := types.NewInteger, types.NewBigInt, types.NewDouble
+	for _, c := range []struct {
+		op          sql.Operator
+		left, right types.Value
+		expected    types.Value
+	}{
+		{sql.OpAdd, integer(2), integer(3), integer(5)},
+		{sql.OpSubtract, integer(2), bigInt(3), bigInt(-1)},
+		{sql.OpMultiply, bigInt(4), double(0.5), double(2)},
+		{sql.OpDivide, integer(7), integer(-2), integer(-3)},
+		{sql.OpModulo, integer(-7), integer(2), integer(-1)},
+		{sql.OpDivide, double(1), integer(4), double(0.25)},
+		{sql.OpModulo, double(7.5), integer(2), double(1.5)},
+		{sql.OpModulo, bigInt(math.MinInt64), bigInt(-1), bigInt(0)},
+		{sql.OpAdd, integer(1), types.NewNull(types.InvalidType), types.NewNull(types.Integer)},
+		{sql.OpMultiply, types.NewNull(types.BigInt), double(1), types.NewNull(types.Double)},
+	} {
+		e, err := NewBinary(c.op, NewConstant(c.left), NewConstant(c.right))
+		require.Nil(t, err)
+		v, err := e.Evaluate(nil)
+		require.Nil(t, err)
+		require.Equal(t, c.expected, v, "%v %v %v", c.left, c.op, c.right)
+	}
+
+	for _, c := range []struct {
+		op          sql.Operator
+		left, right types.Value
+		err         error
+	}{
+		{sql.OpAdd, integer(math.MaxInt32), integer(1), ErrOverflow},
+		{sql.OpSubtract, bigInt(math.MinInt64), integer(1), ErrOverflow},
+		{sql.OpMultiply, bigInt(math.MaxInt64 / 2), integer(3), ErrOverflow},
+		{sql.OpMultiply, integer(-1), bigInt(math.MinInt64), ErrOverflow},
+		{sql.OpDivide, bigInt(math.MinInt64), integer(-1), ErrOverflow},
+		{sql.OpDivide, integer(1), integer(0), ErrDivisionByZero},
+		{sql.OpModulo, double(1), double(0), ErrDivisionByZero},
+	} {
+		e, err := NewBinary(c.op, NewConstant(c.left), NewConstant(c.right))
+		require.Nil(t, err)
+		_, err = e.Evaluate(nil)
+		require.Equal(t, c.err, err, "%v %v %v", c.left, c.op, c.right)
+	}
+
+	negate, err := NewUnary(sql.OpNegate, NewConstant(integer(math.MinInt32)))
+	require.Nil(t, err)
+	_, err = negate.Evaluate(nil)
+	require.Equal(t, ErrOverflow, err)
+	negate, err = NewUnary(sql.OpNegate, NewConstant(double(1.5)))
+	require.Nil(t, err)
+	v, err := negate.Evaluate(nil)
+	require.Nil(t, err)
+	require.Equal(t, double(-1.5), v)
+
+	_, err = NewBinary(sql.OpAdd, NewConstant(types.NewVarchar("a")), NewConstant(integer(1)))
+	require.NotNil(t, err)
+	_, err = NewUnary(sql.OpNegate, NewConstant(types.NewBoolean(true)))
+	require.NotNil(t, err)
+	_, err = NewBinary(sql.OpNot, NewConstant(types.NewBoolean(true)), NewConstant(types.NewBoolean(true)))
+	require.NotNil(t, err)
+}
@@ -0,0 +1,47 @@
+package execution
+
+import (
+	"simple-db-golang/src/types"
+)
+
+// FilterExecutor produces the rows of its child for which a condition is
+// TRUE.
+type FilterExecutor struct {
+	child     Executor
+	condition Expression
+}
+
+func NewFilterExecutor(child Executor, condition Expression) *FilterExecutor {
+	return &FilterExecutor{child: child, condition: condition}
+}
+
+func (e *FilterExecutor) OutputSchema() *types.Schema {
+	return e.child.OutputSchema()
+}
+
+func (e *FilterExecutor) Init() error {
+	if err := checkCondition(e.condition); err != nil {
+		return err
+	}
+	return e.child.Init()
+}
+
+func (e *FilterExecutor) Next() (Row, bool, error) {
+	for {
+		row, ok, err := e.child.Next()
+		if err != nil || !ok {
+			return Row{}, false, err
+		}
+		keep, err := isTrue(e.condition, row.Values)
+		if err != nil {
+			return Row{}, false, err
+		}
+		if keep {
+			return row, true, nil
+		}
+	}
+}
+
+func (e *FilterExecutor) Close() {
+	e.child.Close()
+}
@@ -0,0 +1,222 @@
+package execution
+
+import (
+	"simple-db-golang/src/types"
+)
+
+// HashAggregateExecutor groups the rows of its child by the values of
+// expressions, and produces a row per group, of these values followed by the
+// aggregates of its rows. Rows whose values are NULL go in the same group.
+// Without expressions to group by, all the rows go in one group, which is
+// produced even if there are none.
+//
+// The groups are kept in a hash table by key. Once the groups take more than
+// the memory budget, rows of the groups already in the table are still added
+// to them, while the rows of other groups are split into partitions by the
+// hash of their key and written to temporary pages. The partitions are
+// aggregated one at a time, split again if need be, after the groups in
+// memory are produced. The values of DISTINCT aggregates of the groups in
+// memory may still take more memory than the budget.
+type HashAggregateExecutor struct {
+	ctx          *ExecutorContext
+	child        Executor
+	groupBy      []Expression
+	aggregates   []*Aggregate
+	having       Expression
+	schema       *types.Schema
+	memoryBudget int
+
+	// The groups to produce, in the order they were found.
+	groups  []*aggregateGroup
+	pending []aggregatePartition
+	files   []*spillFile // All the spill files, to free them.
+}
+
+type aggregateGroup struct {
+	values []types.Value
+	states []*aggregateState
+}
+
+type aggregatePartition struct {
+	file  *spillFile
+	depth int // Number of splits the partition comes from.
+}
+
+// Aggregate the rows of `child` grouped by `groupBy`, keeping the groups for
+// which `having`, evaluated on the output row, is TRUE, or all of them if it
+// is nil. A column to group by which is a column of the child keeps its
+// definition, the others are nullable; the columns of the aggregates are named
+// after them. The groups are kept in memory up to `memoryBudget` bytes.
+func NewHashAggregateExecutor(ctx *ExecutorContext, child Executor, groupBy []Expression, aggregates []*Aggregate, having Expression, memoryBudget int) *HashAggregateExecutor {
+	columns := make([]types.Column, 0, len(groupBy)+len(aggregates))
+	for _, expression := range groupBy {
+		if column, ok := expression.(*ColumnExpression); ok {
+			columns = append(columns, column.Column)
+		} else {
+			columns = append(columns, types.Column{Name: expression.String(), Type: expression.Type(), Nullable: true})
+		}
+	}
+	for _, aggregate := range aggregates {
+		columns = append(columns, types.Column{
+			Name: aggregate.String(), Type: aggregate.Type(), Nullable: aggregate.Function != CountAggregate,
+		})
+	}
+	return &HashAggregateExecutor{
+		ctx:          ctx,
+		child:        child,
+		groupBy:      groupBy,
+		aggregates:   aggregates,
+		having:       having,
+		schema:       types.NewSchema(columns...),
+		memoryBudget: memoryBudget,
+	}
+}
+
+func (e *HashAggregateExecutor) OutputSchema() *types.Schema {
+	return e.schema
+}
+
+func (e *HashAggregateExecutor) Init() error {
+	e.release()
+	if e.having != nil {
+		if err := checkCondition(e.having); err != nil {
+			return err
+		}
+	}
+	if err := e.child.Init(); err != nil {
+		return err
+	}
+	if err := e.aggregate(executorSource{e.child}, 0); err != nil {
+		return err
+	}
+	if len(e.groups) == 0 && len(e.groupBy) == 0 {
+		e.groups = append(e.groups, e.newGroup(nil))
+	}
+	return nil
+}
+
+func (e *HashAggregateExecutor) Next() (Row, bool, error) {
+	for {
+		if len(e.groups) == 0 {
+			if ok, err := e.nextPartition(); err != nil || !ok {
+				return Row{}, false, err
+			}
+			continue
+		}
+		group := e.groups[0]
+		e.groups = e.groups[1:]
+		values := append(make([]types.Value, 0, len(group.values)+len(group.states)), group.values...)
+		for _, state := range group.states {
+			values = append(values, state.result())
+		}
+		if e.having != nil {
+			ok, err := isTrue(e.having, values)
+			if err != nil {
+				return Row{}, false, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		return Row{Values: values, RID: NoRID}, true, nil
+	}
+}
+
+func (e *HashAggregateExecutor) Close() {
+	e.release()
+	e.child.Close()
+}
+
+// Free the groups and the spill files.
+func (e *HashAggregateExecutor) release() {
+	for _, file := range e.files {
+		file.free()
+	}
+	e.groups = nil
+	e.pending = nil
+	e.files = nil
+}
+
+func (e *HashAggregateExecutor) newGroup(values []types.Value) *aggregateGroup {
+	group := &aggregateGroup{values: values, states: make([]*aggregateState, len(e.aggregates))}
+	for i, aggregate := range e.aggregates {
+		group.states[i] = newAggregateState(aggregate)
+	}
+	return group
+}
+
+// Aggregate the rows of `source`, which come from `depth` splits, into the
+// groups to produce, and queue the partitions of the rows which do not fit in
+// the memory budget.
+func (e *HashAggregateExecutor) aggregate(source rowSource, depth int) error {
+	table := make(map[string]*aggregateGroup)
+	size := 0
+	var parts []*spillFile
+	for {
+		row, ok, err := source.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		values := make([]types.Value, len(e.groupBy))
+		for i, expression := range e.groupBy {
+			if values[i], err = expression.Evaluate(row.Values); err != nil {
+				return err
+			}
+		}
+		key := types.EncodeKey(values)
+		group, ok := table[string(key)]
+		if !ok {
+			if parts != nil {
+				if err := parts[partitionOf(key, depth)].append(row); err != nil {
+					return err
+				}
+				continue
+			}
+			group = e.newGroup(values)
+			table[string(key)] = group
+			e.groups = append(e.groups, group)
+			size += rowSize(values) + len(key) + len(e.aggregates)*aggregateStateSize
+		}
+		for _, state := range group.states {
+			grown, err := state.add(row.Values)
+			if err != nil {
+				return err
+			}
+			size += grown
+		}
+		if parts == nil && size > e.memoryBudget && depth < maxSpillDepth {
+			parts = make([]*spillFile, spillFanout)
+			for i := range parts {
+				parts[i] = newSpillFile(e.ctx.BufferPoolManager, e.child.OutputSchema())
+			}
+			e.files = append(e.files, parts...)
+		}
+	}
+	for _, part := range parts {
+		part.finish()
+		if part.empty() {
+			part.free()
+		} else {
+			e.pending = append(e.pending, aggregatePartition{file: part, depth: depth + 1})
+		}
+	}
+	return nil
+}
+
+// Free the last partition aggregated, and aggregate the next one. Returns
+// false once all the partitions are aggregated.
+func (e *HashAggregateExecutor) nextPartition() (bool, error) {
+	if len(e.pending) == 0 {
+		return false, nil
+	}
+	p := e.pending[len(e.pending)-1]
+	e.pending = e.pending[:len(e.pending)-1]
+	reader := p.file.reader()
+	err := e.aggregate(reader, p.depth)
+	reader.close()
+	p.file.free()
+	return err == nil, err
+}
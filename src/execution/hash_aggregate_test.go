@@ -0,0 +1,143 @@
+package execution
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/sql"
+	"simple-db-golang/src/types"
+)
+
+func aggregate(t *testing.T, function AggregateFunction, distinct bool, arg Expression) *Aggregate {
+	a, err := NewAggregate(function, distinct, arg)
+	require.Nil(t, err)
+	return a
+}
+
+func TestHashAggregate(t *testing.T) {
+	db := newTestDatabase()
+	createUsers(t, db)
+	txn := db.txnManager.Begin()
+	defer db.txnManager.Abort(txn)
+	ctx := db.context(txn)
+	users := db.openTable(t, txn, "users")
+	schema := usersSchema()
+	name, age := column(t, schema, "name"), column(t, schema, "age")
+	aggregates := []*Aggregate{
+		aggregate(t, CountAggregate, false, nil),
+		aggregate(t, CountAggregate, false, age),
+		aggregate(t, SumAggregate, false, age),
+		aggregate(t, MinAggregate, false, age),
+		aggregate(t, MaxAggregate, false, age),
+		aggregate(t, AvgAggregate, false, age),
+	}
+	e := NewHashAggregateExecutor(ctx, NewSeqScanExecutor(ctx, users), []Expression{name}, aggregates, nil, DefaultMemoryBudget)
+	rows, err := Execute(e)
+	require.Nil(t, err)
+	integer, bigInt, double := types.NewInteger, types.NewBigInt, types.NewDouble
+	require.ElementsMatch(t, [][]types.Value{
+		{types.NewVarchar("alice"), bigInt(1), bigInt(1), bigInt(30), integer(30), integer(30), double(30)},
+		{types.NewVarchar("bob"), bigInt(2), bigInt(2), bigInt(69), integer(17), integer(52), double(34.5)},
+		{types.NewVarchar("carol"), bigInt(1), bigInt(1), bigInt(45), integer(45), integer(45), double(45)},
+		{
+			types.NewNull(types.Varchar), bigInt(1), bigInt(0), types.NewNull(types.BigInt),
+			types.NewNull(types.Integer), types.NewNull(types.Integer), types.NewNull(types.Double),
+		},
+	}, rowValues(rows))
+	columns := e.OutputSchema().Columns
+	require.Equal(t, schema.Columns[1], columns[0])
+	require.Equal(t, types.Column{Name: "COUNT(*)", Type: types.BigInt}, columns[1])
+	require.Equal(t, types.Column{Name: "AVG(age)", Type: types.Double, Nullable: true}, columns[6])
+
+	// HAVING applies to the output rows.
+	having := binaryOp(t, sql.OpGreater, NewColumn(e.OutputSchema(), 1), NewConstant(bigInt(1)))
+	e = NewHashAggregateExecutor(ctx, NewSeqScanExecutor(ctx, users), []Expression{name}, aggregates[:1], having, DefaultMemoryBudget)
+	rows, err = Execute(e)
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{{types.NewVarchar("bob"), bigInt(2)}}, rowValues(rows))
+
+	// Without GROUP BY, there is a group even without rows.
+	distinct := []*Aggregate{aggregate(t, CountAggregate, true, name), aggregate(t, SumAggregate, true, age)}
+	e = NewHashAggregateExecutor(ctx, NewSeqScanExecutor(ctx, users), nil, distinct, nil, DefaultMemoryBudget)
+	rows, err = Execute(e)
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{{bigInt(3), bigInt(144)}}, rowValues(rows))
+	empty := NewFilterExecutor(NewSeqScanExecutor(ctx, users), NewConstant(types.NewBoolean(false)))
+	e = NewHashAggregateExecutor(ctx, empty, nil, distinct, nil, DefaultMemoryBudget)
+	rows, err = Execute(e)
+	require.Nil(t, err)
+	require.Equal(t, [][]types.Value{{bigInt(0), types.NewNull(types.BigInt)}}, rowValues(rows))
+	e = NewHashAggregateExecutor(ctx, empty, []Expression{name}, distinct, nil, DefaultMemoryBudget)
+	rows, err = Execute(e)
+	require.Nil(t, err)
+	require.Empty(t, rows)
+
+	bigInts := types.NewSchema(types.Column{Name: "n", Type: types.BigInt})
+	values := NewValuesExecutor(bigInts, [][]types.Value{{bigInt(math.MaxInt64)}, {bigInt(1)}})
+	sum := aggregate(t, SumAggregate, false, NewColumn(bigInts, 0))
+	_, err = Execute(NewHashAggregateExecutor(ctx, values, nil, []*Aggregate{sum}, nil, DefaultMemoryBudget))
+	require.Equal(t, ErrOverflow, err)
+
+	_, err = NewAggregate(SumAggregate, false, name)
+	require.NotNil(t, err)
+	_, err = NewAggregate(MaxAggregate, false, nil)
+	require.NotNil(t, err)
+	_, err = Execute(NewHashAggregateExecutor(ctx, NewSeqScanExecutor(ctx, users), nil, aggregates, age, DefaultMemoryBudget))
+	require.NotNil(t, err)
+}
+
+func TestHashAggregate_Spill(t *testing.T) {
+	db := newTestDatabase()
+	orders := make([][]types.Value, 0)
+	for i := int32(0); i < 500; i++ {
+		orders = append(orders, order(i, types.NewBigInt(int64(i%50)), i%7))
+	}
+	orders = append(orders, order(500, types.NewNull(types.BigInt), 1))
+	db.createTable(t, "orders", ordersSchema(), orders)
+	numPages := db.store.numPages
+
+	txn := db.txnManager.Begin()
+	defer db.txnManager.Abort(txn)
+	ctx := db.context(txn)
+	table := db.openTable(t, txn, "orders")
+	schema := ordersSchema()
+	userId, amount := column(t, schema, "user_id"), column(t, schema, "amount")
+	aggregates := []*Aggregate{
+		aggregate(t, CountAggregate, false, nil),
+		aggregate(t, CountAggregate, true, amount),
+		aggregate(t, SumAggregate, false, amount),
+		aggregate(t, AvgAggregate, true, amount),
+	}
+	expected, err := Execute(NewHashAggregateExecutor(
+		ctx, NewSeqScanExecutor(ctx, table), []Expression{userId}, aggregates, nil, DefaultMemoryBudget))
+	require.Nil(t, err)
+	require.Len(t, expected, 51)
+
+	// A budget of a byte splits the rows as many times as possible.
+	e := NewHashAggregateExecutor(ctx, NewSeqScanExecutor(ctx, table), []Expression{userId}, aggregates, nil, 1)
+	require.Nil(t, e.Init())
+	require.NotEmpty(t, e.pending)
+	rows := make([]Row, 0)
+	for {
+		row, ok, err := e.Next()
+		require.Nil(t, err)
+		if !ok {
+			break
+		}
+		rows = append(rows, row)
+	}
+	require.ElementsMatch(t, rowValues(expected), rowValues(rows))
+	e.Close()
+	require.Equal(t, numPages, db.store.numPages)
+	requireNoPinnedPages(t, db)
+
+	// Closing before the end frees the partitions left.
+	require.Nil(t, e.Init())
+	_, _, err = e.Next()
+	require.Nil(t, err)
+	e.Close()
+	require.Equal(t, numPages, db.store.numPages)
+	requireNoPinnedPages(t, db)
+}
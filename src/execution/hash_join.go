@@ -0,0 +1,294 @@
+package execution
+
+import (
+	"simple-db-golang/src/types"
+)
+
+// HashJoinExecutor joins the rows of its children whose keys are equal. The
+// rows of the right child, the build side, are loaded into a hash table by
+// key, which the rows of the left child, the probe side, look their key up
+// in.
+//
+// If the build side takes more than the memory budget, both sides are split
+// into partitions by the hash of their keys, written to temporary pages, and
+// the partitions are joined one at a time, split again if need be. Only a page
+// per partition being written, and one per partition being read, are pinned
+// at once. The left rows of a partitioned join come out grouped by partition.
+type HashJoinExecutor struct {
+	ctx          *ExecutorContext
+	left         Executor
+	right        Executor
+	leftKey      *joinKey
+	rightKey     *joinKey
+	keyErr       error
+	state        *joinState
+	schema       *types.Schema
+	memoryBudget int
+
+	// The right rows of the partition being joined, by key.
+	table map[string][]Row
+	probe rowSource
+	// Whether the rows were split, and the partitions left to join.
+	spilled bool
+	pending []hashPartition
+	current *hashPartition
+	files   []*spillFile // All the spill files, to free them.
+
+	// Whether a left row is being joined, and the right rows with its key
+	// which may still match it.
+	active     bool
+	candidates []Row
+}
+
+type hashPartition struct {
+	left  *spillFile
+	right *spillFile
+	depth int // Number of splits the partition comes from.
+}
+
+// Join the rows of `left` and `right` for which `leftKeys[i] = rightKeys[i]`,
+// evaluated on each side, for every `i`, and for which `condition`, evaluated
+// on the columns of both, is TRUE. A nil condition matches every pair of
+// rows with equal keys. The right rows are kept in memory up to
+// `memoryBudget` bytes.
+func NewHashJoinExecutor(ctx *ExecutorContext, left, right Executor, leftKeys, rightKeys []Expression, joinType JoinType, condition Expression, memoryBudget int) *HashJoinExecutor {
+	leftKey, rightKey, err := newJoinKeys(leftKeys, rightKeys)
+	return &HashJoinExecutor{
+		ctx:          ctx,
+		left:         left,
+		right:        right,
+		leftKey:      leftKey,
+		rightKey:     rightKey,
+		keyErr:       err,
+		state:        newJoinState(joinType, condition, right.OutputSchema()),
+		schema:       joinSchema(left.OutputSchema(), right.OutputSchema(), joinType),
+		memoryBudget: memoryBudget,
+	}
+}
+
+func (e *HashJoinExecutor) OutputSchema() *types.Schema {
+	return e.schema
+}
+
+func (e *HashJoinExecutor) Init() error {
+	e.release()
+	if e.keyErr != nil {
+		return e.keyErr
+	}
+	if err := e.state.check(); err != nil {
+		return err
+	}
+	if err := e.right.Init(); err != nil {
+		return err
+	}
+	rightParts, err := e.build(executorSource{e.right}, 0)
+	if err != nil {
+		return err
+	}
+	if err := e.left.Init(); err != nil {
+		return err
+	}
+	if rightParts == nil {
+		e.probe = executorSource{e.left}
+		return nil
+	}
+	e.spilled = true
+	leftParts, err := e.split(executorSource{e.left}, e.leftKey, e.left.OutputSchema(), 0)
+	if err != nil {
+		return err
+	}
+	e.addPartitions(leftParts, rightParts, 1)
+	_, err = e.nextPartition()
+	return err
+}
+
+func (e *HashJoinExecutor) Next() (Row, bool, error) {
+	for {
+		if !e.active {
+			left, ok, err := e.probe.next()
+			if err != nil {
+				return Row{}, false, err
+			}
+			if !ok {
+				if !e.spilled {
+					return Row{}, false, nil
+				}
+				if ok, err := e.nextPartition(); err != nil || !ok {
+					return Row{}, false, err
+				}
+				continue
+			}
+			key, hasNull, err := e.leftKey.encode(left.Values)
+			if err != nil {
+				return Row{}, false, err
+			}
+			e.candidates = nil
+			if !hasNull {
+				e.candidates = e.table[string(key)]
+			}
+			e.state.start(left)
+			e.active = true
+		}
+		for len(e.candidates) > 0 && !e.state.done() {
+			right := e.candidates[0]
+			e.candidates = e.candidates[1:]
+			if row, ok, err := e.state.match(right); err != nil || ok {
+				return row, ok, err
+			}
+		}
+		e.active = false
+		if row, ok := e.state.finish(); ok {
+			return row, true, nil
+		}
+	}
+}
+
+func (e *HashJoinExecutor) Close() {
+	e.release()
+	e.left.Close()
+	e.right.Close()
+}
+
+// Free the hash table and the spill files.
+func (e *HashJoinExecutor) release() {
+	if reader, ok := e.probe.(*spillReader); ok {
+		reader.close()
+	}
+	for _, file := range e.files {
+		file.free()
+	}
+	e.table = nil
+	e.probe = nil
+	e.spilled = false
+	e.pending = nil
+	e.current = nil
+	e.files = nil
+	e.active = false
+	e.candidates = nil
+}
+
+// Load the right rows of `source`, which come from `depth` splits, into the
+// hash table. Rows with a NULL key, which match nothing, are left out. If the
+// rows take more than the memory budget, and may still be split, they are
+// split into partitions instead, which are returned.
+func (e *HashJoinExecutor) build(source rowSource, depth int) ([]*spillFile, error) {
+	e.table = make(map[string][]Row)
+	size := 0
+	var parts []*spillFile
+	for {
+		row, ok, err := source.next()
+		if err != nil || !ok {
+			return parts, err
+		}
+		key, hasNull, err := e.rightKey.encode(row.Values)
+		if err != nil {
+			return nil, err
+		}
+		if hasNull {
+			continue
+		}
+		if parts != nil {
+			if err := parts[partitionOf(key, depth)].append(row); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e.table[string(key)] = append(e.table[string(key)], row)
+		size += rowSize(row.Values)
+		if size <= e.memoryBudget || depth >= maxSpillDepth {
+			continue
+		}
+		parts = e.newPartitions(e.right.OutputSchema())
+		for key, rows := range e.table {
+			for _, row := range rows {
+				if err := parts[partitionOf([]byte(key), depth)].append(row); err != nil {
+					return nil, err
+				}
+			}
+		}
+		e.table = nil
+	}
+}
+
+// Split the rows of `source`, which come from `depth` splits, into partitions
+// by their key. Left rows with a NULL key are only kept for a left outer join.
+func (e *HashJoinExecutor) split(source rowSource, key *joinKey, schema *types.Schema, depth int) ([]*spillFile, error) {
+	parts := e.newPartitions(schema)
+	for {
+		row, ok, err := source.next()
+		if err != nil || !ok {
+			return parts, err
+		}
+		encoded, hasNull, err := key.encode(row.Values)
+		if err != nil {
+			return nil, err
+		}
+		if hasNull && e.state.joinType != LeftOuterJoin {
+			continue
+		}
+		if err := parts[partitionOf(encoded, depth)].append(row); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (e *HashJoinExecutor) newPartitions(schema *types.Schema) []*spillFile {
+	parts := make([]*spillFile, spillFanout)
+	for i := range parts {
+		parts[i] = newSpillFile(e.ctx.BufferPoolManager, schema)
+	}
+	e.files = append(e.files, parts...)
+	return parts
+}
+
+// Queue the partitions to join. Partitions without left rows produce nothing.
+func (e *HashJoinExecutor) addPartitions(leftParts, rightParts []*spillFile, depth int) {
+	for i := range leftParts {
+		leftParts[i].finish()
+		rightParts[i].finish()
+		if leftParts[i].empty() {
+			leftParts[i].free()
+			rightParts[i].free()
+			continue
+		}
+		e.pending = append(e.pending, hashPartition{left: leftParts[i], right: rightParts[i], depth: depth})
+	}
+}
+
+// Free the partition joined so far, and load the next one into the hash
+// table, splitting partitions which are too large. Returns false once all the
+// partitions are joined.
+func (e *HashJoinExecutor) nextPartition() (bool, error) {
+	if e.current != nil {
+		e.probe.(*spillReader).close()
+		e.current.left.free()
+		e.current = nil
+	}
+	for len(e.pending) > 0 {
+		p := e.pending[len(e.pending)-1]
+		e.pending = e.pending[:len(e.pending)-1]
+		reader := p.right.reader()
+		rightParts, err := e.build(reader, p.depth)
+		reader.close()
+		p.right.free()
+		if err != nil {
+			return false, err
+		}
+		if rightParts == nil {
+			e.current = &p
+			e.probe = p.left.reader()
+			return true, nil
+		}
+		reader = p.left.reader()
+		leftParts, err := e.split(reader, e.leftKey, e.left.OutputSchema(), p.depth)
+		reader.close()
+		p.left.free()
+		if err != nil {
+			return false, err
+		}
+		e.addPartitions(leftParts, rightParts, p.depth+1)
+	}
+	e.table = nil
+	e.probe = emptySource{}
+	return false, nil
+}
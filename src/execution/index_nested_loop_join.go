@@ -0,0 +1,116 @@
+package execution
+
+import (
+	"fmt"
+
+	"simple-db-golang/src/catalog"
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/types"
+)
+
+// IndexNestedLoopJoinExecutor joins each row of its left child with the rows
+// of a table found through an index, whose key equals the values of key
+// expressions on the left row.
+type IndexNestedLoopJoinExecutor struct {
+	ctx   *ExecutorContext
+	left  Executor
+	table *catalog.Table
+	index string
+	// Evaluated on the left rows, one for each key column of the index.
+	keys   []Expression
+	state  *joinState
+	schema *types.Schema
+	// Whether a left row is being joined, and the RIDs of the rows of the
+	// table that may still match it.
+	active bool
+	rids   []common.RID
+}
+
+// Join the rows of `left` with the rows of `table` whose key in the index
+// `index` is the values of `keys`, and for which `condition`, evaluated on the
+// columns of both, is TRUE. A nil condition matches every such pair of rows.
+func NewIndexNestedLoopJoinExecutor(ctx *ExecutorContext, left Executor, table *catalog.Table, index string, keys []Expression, joinType JoinType, condition Expression) *IndexNestedLoopJoinExecutor {
+	return &IndexNestedLoopJoinExecutor{
+		ctx:    ctx,
+		left:   left,
+		table:  table,
+		index:  index,
+		keys:   keys,
+		state:  newJoinState(joinType, condition, table.Info.Schema),
+		schema: joinSchema(left.OutputSchema(), table.Info.Schema, joinType),
+	}
+}
+
+func (e *IndexNestedLoopJoinExecutor) OutputSchema() *types.Schema {
+	return e.schema
+}
+
+func (e *IndexNestedLoopJoinExecutor) Init() error {
+	if err := e.state.check(); err != nil {
+		return err
+	}
+	for _, info := range e.table.Info.Indexes {
+		if info.Name == e.index && len(info.Columns) != len(e.keys) {
+			return fmt.Errorf("Index %s has %d key columns, not %d.", e.index, len(info.Columns), len(e.keys))
+		}
+	}
+	e.active = false
+	e.rids = nil
+	return e.left.Init()
+}
+
+func (e *IndexNestedLoopJoinExecutor) Next() (Row, bool, error) {
+	for {
+		if !e.active {
+			left, ok, err := e.left.Next()
+			if err != nil || !ok {
+				return Row{}, false, err
+			}
+			if e.rids, err = e.lookup(left); err != nil {
+				return Row{}, false, err
+			}
+			e.state.start(left)
+			e.active = true
+		}
+		for len(e.rids) > 0 && !e.state.done() {
+			rid := e.rids[0]
+			e.rids = e.rids[1:]
+			tuple, found, err := e.table.Get(e.ctx.Txn, rid)
+			if err != nil {
+				return Row{}, false, err
+			}
+			if !found {
+				continue
+			}
+			right := Row{Values: tuple.Values(e.table.Info.Schema), RID: rid}
+			if row, ok, err := e.state.match(right); err != nil || ok {
+				return row, ok, err
+			}
+		}
+		e.active = false
+		if row, ok := e.state.finish(); ok {
+			return row, true, nil
+		}
+	}
+}
+
+// The RIDs of the rows of the table with the key of a left row.
+func (e *IndexNestedLoopJoinExecutor) lookup(left Row) ([]common.RID, error) {
+	key := make([]types.Value, len(e.keys))
+	for i, expression := range e.keys {
+		var err error
+		if key[i], err = expression.Evaluate(left.Values); err != nil {
+			return nil, err
+		}
+	}
+	key, ok, err := lookupKey(e.table, e.index, key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return e.table.Lookup(e.ctx.Txn, e.index, key)
+}
+
+func (e *IndexNestedLoopJoinExecutor) Close() {
+	e.rids = nil
+	e.left.Close()
+}
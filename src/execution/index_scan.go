@@ -0,0 +1,83 @@
+package execution
+
+import (
+	"simple-db-golang/src/catalog"
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/types"
+)
+
+// IndexScanExecutor produces the rows of a table visible to the transaction
+// whose key in an index equals a given key, see `catalog.Table.Lookup`.
+type IndexScanExecutor struct {
+	ctx   *ExecutorContext
+	table *catalog.Table
+	index string
+	key   []types.Value
+	rids  []common.RID
+}
+
+// Scan the rows of `table` with `key` in the index `index`. The values of the
+// key are converted to the types of the key columns.
+func NewIndexScanExecutor(ctx *ExecutorContext, table *catalog.Table, index string, key []types.Value) *IndexScanExecutor {
+	return &IndexScanExecutor{ctx: ctx, table: table, index: index, key: key}
+}
+
+func (e *IndexScanExecutor) OutputSchema() *types.Schema {
+	return e.table.Info.Schema
+}
+
+func (e *IndexScanExecutor) Init() error {
+	key, ok, err := lookupKey(e.table, e.index, e.key)
+	if err != nil || !ok {
+		e.rids = nil
+		return err
+	}
+	e.rids, err = e.table.Lookup(e.ctx.Txn, e.index, key)
+	return err
+}
+
+func (e *IndexScanExecutor) Next() (Row, bool, error) {
+	for len(e.rids) > 0 {
+		rid := e.rids[0]
+		e.rids = e.rids[1:]
+		tuple, found, err := e.table.Get(e.ctx.Txn, rid)
+		if err != nil {
+			return Row{}, false, err
+		}
+		if found {
+			return Row{Values: tuple.Values(e.table.Info.Schema), RID: rid}, true, nil
+		}
+	}
+	return Row{}, false, nil
+}
+
+func (e *IndexScanExecutor) Close() {
+	e.rids = nil
+}
+
+// Convert `key` to the types of the key columns of the index `index`, as
+// `catalog.Table.Lookup` expects. Returns false if a number does not convert
+// exactly, in which case no row has the key.
+func lookupKey(table *catalog.Table, index string, key []types.Value) ([]types.Value, bool, error) {
+	for _, info := range table.Info.Indexes {
+		if info.Name != index || len(info.Columns) != len(key) {
+			continue
+		}
+		cast := make([]types.Value, len(key))
+		for i, name := range info.Columns {
+			column, _ := table.Info.Schema.ColumnIndex(name)
+			typeId := table.Info.Schema.Columns[column].Type
+			var err error
+			cast[i], err = key[i].CastAs(typeId)
+			if key[i].Type().IsNumeric() && typeId.IsNumeric() && (err != nil || !cast[i].Equals(key[i])) {
+				return nil, false, nil
+			}
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		return cast, true, nil
+	}
+	// Left to `catalog.Table.Lookup` to report.
+	return key, true, nil
+}
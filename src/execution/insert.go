@@ -0,0 +1,54 @@
+package execution
+
+import (
+	"simple-db-golang/src/catalog"
+	"simple-db-golang/src/types"
+)
+
+// InsertExecutor inserts the rows of its child into a table, and produces a
+// single row with the number of inserted rows. The rows of the child have a
+// value for each column of the table, converted to the type of the column.
+type InsertExecutor struct {
+	ctx   *ExecutorContext
+	table *catalog.Table
+	child Executor
+	done  bool
+}
+
+func NewInsertExecutor(ctx *ExecutorContext, table *catalog.Table, child Executor) *InsertExecutor {
+	return &InsertExecutor{ctx: ctx, table: table, child: child}
+}
+
+func (e *InsertExecutor) OutputSchema() *types.Schema {
+	return countSchema()
+}
+
+func (e *InsertExecutor) Init() error {
+	e.done = false
+	return nil
+}
+
+func (e *InsertExecutor) Next() (Row, bool, error) {
+	if e.done {
+		return Row{}, false, nil
+	}
+	e.done = true
+	rows, err := collectRows(e.child)
+	if err != nil {
+		return Row{}, false, err
+	}
+	for _, row := range rows {
+		values, err := castValues(e.table.Info.Schema, row.Values)
+		if err != nil {
+			return Row{}, false, err
+		}
+		if _, err := e.table.Insert(e.ctx.Txn, values); err != nil {
+			return Row{}, false, err
+		}
+	}
+	return Row{Values: []types.Value{types.NewBigInt(int64(len(rows)))}, RID: NoRID}, true, nil
+}
+
+func (e *InsertExecutor) Close() {
+	e.child.Close()
+}
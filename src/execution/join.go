@@ -0,0 +1,165 @@
+package execution
+
+import (
+	"fmt"
+
+	"simple-db-golang/src/types"
+)
+
+type JoinType uint8
+
+const (
+	// A row for each pair of a left row and a right row which match.
+	InnerJoin JoinType = iota
+	// Like an inner join, plus a row for each left row without a match, with
+	// NULL for the columns of the right side.
+	LeftOuterJoin
+	// Each left row which has a match, once, with the columns of the left side
+	// only.
+	SemiJoin
+)
+
+func (t JoinType) String() string {
+	switch t {
+	case InnerJoin:
+		return "INNER"
+	case LeftOuterJoin:
+		return "LEFT OUTER"
+	default:
+		return "SEMI"
+	}
+}
+
+// The output schema of a join: the columns of the left side, followed by
+// those of the right side unless it is a semi join.
+func joinSchema(left, right *types.Schema, joinType JoinType) *types.Schema {
+	columns := append(make([]types.Column, 0, len(left.Columns)+len(right.Columns)), left.Columns...)
+	if joinType == SemiJoin {
+		return types.NewSchema(columns...)
+	}
+	for _, column := range right.Columns {
+		if joinType == LeftOuterJoin {
+			column.Nullable = true
+		}
+		columns = append(columns, column)
+	}
+	return types.NewSchema(columns...)
+}
+
+// The schema a join condition is evaluated on: the columns of both sides.
+func conditionSchema(left, right *types.Schema) *types.Schema {
+	return joinSchema(left, right, InnerJoin)
+}
+
+// joinState joins a left row with the right rows that may match it, the
+// candidates, and produces the rows of the join for it.
+type joinState struct {
+	joinType JoinType
+	// Evaluated on the values of the left row followed by those of a
+	// candidate. Nil if every candidate matches.
+	condition  Expression
+	rightWidth int
+	left       Row
+	matched    bool
+}
+
+func newJoinState(joinType JoinType, condition Expression, right *types.Schema) *joinState {
+	return &joinState{joinType: joinType, condition: condition, rightWidth: right.NumColumns()}
+}
+
+func (s *joinState) check() error {
+	if s.condition == nil {
+		return nil
+	}
+	return checkCondition(s.condition)
+}
+
+// Start with a new left row.
+func (s *joinState) start(left Row) {
+	s.left = left
+	s.matched = false
+}
+
+// Whether the left row needs no more candidates.
+func (s *joinState) done() bool {
+	return s.joinType == SemiJoin && s.matched
+}
+
+// Produce the row of the join for a candidate, if it matches.
+func (s *joinState) match(right Row) (Row, bool, error) {
+	values := append(append(make([]types.Value, 0, len(s.left.Values)+len(right.Values)), s.left.Values...), right.Values...)
+	if s.condition != nil {
+		ok, err := isTrue(s.condition, values)
+		if err != nil || !ok {
+			return Row{}, false, err
+		}
+	}
+	s.matched = true
+	if s.joinType == SemiJoin {
+		return s.left, true, nil
+	}
+	return Row{Values: values, RID: NoRID}, true, nil
+}
+
+// Produce the row of the join for the left row once it has no more
+// candidates, if it has one without a match.
+func (s *joinState) finish() (Row, bool) {
+	if s.matched || s.joinType != LeftOuterJoin {
+		return Row{}, false
+	}
+	values := append(make([]types.Value, 0, len(s.left.Values)+s.rightWidth), s.left.Values...)
+	for i := 0; i < s.rightWidth; i++ {
+		values = append(values, types.NewNull(types.InvalidType))
+	}
+	return Row{Values: values, RID: NoRID}, true
+}
+
+// joinKey computes the key of the rows of a side of an equi-join, encoded
+// with `types.EncodeKey`, so that the keys of rows of both sides are equal
+// exactly when their values are. Numbers are converted to the wider type of
+// each pair of key columns first, so an INTEGER equals a BIGINT or a DOUBLE of
+// the same value. Keys with a NULL value match nothing.
+type joinKey struct {
+	expressions []Expression
+	typeIds     []types.TypeId
+}
+
+// The keys of the left and right sides of a join on `left[i] = right[i]`.
+func newJoinKeys(left, right []Expression) (*joinKey, *joinKey, error) {
+	if len(left) != len(right) || len(left) == 0 {
+		return nil, nil, fmt.Errorf("Join has %d left keys and %d right keys.", len(left), len(right))
+	}
+	typeIds := make([]types.TypeId, len(left))
+	for i := range left {
+		a, b := left[i].Type(), right[i].Type()
+		if !comparable(a, b) {
+			return nil, nil, fmt.Errorf("Cannot compare %s with %s.", a, b)
+		}
+		switch {
+		case a.IsNumeric() && b.IsNumeric():
+			typeIds[i] = widerType(a, b)
+		case a == types.InvalidType:
+			typeIds[i] = b
+		default:
+			typeIds[i] = a
+		}
+	}
+	return &joinKey{expressions: left, typeIds: typeIds}, &joinKey{expressions: right, typeIds: typeIds}, nil
+}
+
+// The key of a row, and whether it has a NULL value.
+func (k *joinKey) encode(row []types.Value) ([]byte, bool, error) {
+	values := make([]types.Value, len(k.expressions))
+	hasNull := false
+	for i, expression := range k.expressions {
+		value, err := expression.Evaluate(row)
+		if err != nil {
+			return nil, false, err
+		}
+		if values[i], err = value.CastAs(k.typeIds[i]); err != nil {
+			return nil, false, err
+		}
+		hasNull = hasNull || value.IsNull()
+	}
+	return types.EncodeKey(values), hasNull, nil
+}
@@ -0,0 +1,173 @@
+package execution
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/sql"
+	"simple-db-golang/src/types"
+)
+
+func ordersSchema() *types.Schema {
+	return types.NewSchema(
+		types.Column{Name: "id", Type: types.Integer},
+		types.Column{Name: "user_id", Type: types.BigInt, Nullable: true},
+		types.Column{Name: "amount", Type: types.Integer},
+	)
+}
+
+func order(id int32, userId types.Value, amount int32) []types.Value {
+	return []types.Value{types.NewInteger(id), userId, types.NewInteger(amount)}
+}
+
+func createOrders(t *testing.T, db *testDatabase) {
+	db.createTable(t, "orders", ordersSchema(), [][]types.Value{
+		order(10, types.NewBigInt(1), 100),
+		order(11, types.NewBigInt(1), 50),
+		order(12, types.NewBigInt(3), 20),
+		order(13, types.NewNull(types.BigInt), 5),
+		order(14, types.NewBigInt(9), 7),
+		order(15, types.NewBigInt(5), 60),
+	})
+}
+
+// The values of an order joined with a user, or with NULLs if `u` is nil.
+func joined(o, u []types.Value) []types.Value {
+	if u == nil {
+		u = []types.Value{types.NewNull(types.InvalidType), types.NewNull(types.InvalidType), types.NewNull(types.InvalidType)}
+	}
+	return append(append([]types.Value{}, o...), u...)
+}
+
+// Build `orders JOIN users ON orders.user_id = users.id [AND condition]` with
+// each join executor.
+func joinExecutors(t *testing.T, db *testDatabase, ctx *ExecutorContext, joinType JoinType, condition Expression, memoryBudget int) map[string]Executor {
+	orders := db.openTable(t, ctx.Txn, "orders")
+	users := db.openTable(t, ctx.Txn, "users")
+	schema := conditionSchema(ordersSchema(), usersSchema())
+	leftKeys := []Expression{NewColumn(ordersSchema(), 1)}
+	rightKeys := []Expression{NewColumn(usersSchema(), 0)}
+	equal := binaryOp(t, sql.OpEqual, NewColumn(schema, 1), NewColumn(schema, 3))
+	if condition != nil {
+		equal = binaryOp(t, sql.OpAnd, equal, condition)
+	}
+	return map[string]Executor{
+		"nested loop": NewNestedLoopJoinExecutor(
+			NewSeqScanExecutor(ctx, orders), NewSeqScanExecutor(ctx, users), joinType, equal),
+		"index nested loop": NewIndexNestedLoopJoinExecutor(
+			ctx, NewSeqScanExecutor(ctx, orders), users, "users_id", leftKeys, joinType, condition),
+		"hash": NewHashJoinExecutor(
+			ctx, NewSeqScanExecutor(ctx, orders), NewSeqScanExecutor(ctx, users),
+			leftKeys, rightKeys, joinType, condition, memoryBudget),
+		"sort merge": NewSortMergeJoinExecutor(
+			NewSeqScanExecutor(ctx, orders), NewSeqScanExecutor(ctx, users), leftKeys, rightKeys, joinType, condition),
+	}
+}
+
+func TestJoin(t *testing.T) {
+	db := newTestDatabase()
+	createUsers(t, db)
+	createOrders(t, db)
+	o10, o11, o12 := order(10, types.NewBigInt(1), 100), order(11, types.NewBigInt(1), 50), order(12, types.NewBigInt(3), 20)
+	o13, o14, o15 := order(13, types.NewNull(types.BigInt), 5), order(14, types.NewBigInt(9), 7), order(15, types.NewBigInt(5), 60)
+	alice, carol, bob := user(1, "alice", 30), user(3, "carol", 45), user(5, "bob", 52)
+	amount := NewColumn(conditionSchema(ordersSchema(), usersSchema()), 2)
+	bigOrders := binaryOp(t, sql.OpGreater, amount, NewConstant(types.NewInteger(30)))
+
+	for _, c := range []struct {
+		joinType  JoinType
+		condition Expression
+		expected  [][]types.Value
+	}{
+		{InnerJoin, nil, [][]types.Value{joined(o10, alice), joined(o11, alice), joined(o12, carol), joined(o15, bob)}},
+		{LeftOuterJoin, nil, [][]types.Value{
+			joined(o10, alice), joined(o11, alice), joined(o12, carol), joined(o13, nil), joined(o14, nil), joined(o15, bob),
+		}},
+		{SemiJoin, nil, [][]types.Value{o10, o11, o12, o15}},
+		{InnerJoin, bigOrders, [][]types.Value{joined(o10, alice), joined(o11, alice), joined(o15, bob)}},
+		{LeftOuterJoin, bigOrders, [][]types.Value{
+			joined(o10, alice), joined(o11, alice), joined(o12, nil), joined(o13, nil), joined(o14, nil), joined(o15, bob),
+		}},
+		{SemiJoin, bigOrders, [][]types.Value{o10, o11, o15}},
+	} {
+		txn := db.txnManager.Begin()
+		for name, e := range joinExecutors(t, db, db.context(txn), c.joinType, c.condition, DefaultMemoryBudget) {
+			message := fmt.Sprintf("%s %s join on %v", name, c.joinType, c.condition)
+			rows, err := Execute(e)
+			require.Nil(t, err, message)
+			require.ElementsMatch(t, c.expected, rowValues(rows), message)
+			if c.joinType == SemiJoin {
+				require.Equal(t, len(o10), e.OutputSchema().NumColumns())
+				require.NotEqual(t, NoRID, rows[0].RID)
+			} else {
+				require.True(t, e.OutputSchema().Columns[3].Nullable == (c.joinType == LeftOuterJoin))
+			}
+			// Joins can be restarted.
+			rows, err = Execute(e)
+			require.Nil(t, err, message)
+			require.ElementsMatch(t, c.expected, rowValues(rows), message)
+		}
+		require.Nil(t, db.txnManager.Commit(txn))
+	}
+
+	txn := db.txnManager.Begin()
+	defer db.txnManager.Abort(txn)
+	ctx := db.context(txn)
+	users := db.openTable(t, txn, "users")
+	name := NewColumn(usersSchema(), 1)
+	_, err := Execute(NewHashJoinExecutor(ctx, NewSeqScanExecutor(ctx, users), NewSeqScanExecutor(ctx, users),
+		[]Expression{name}, []Expression{NewColumn(usersSchema(), 0)}, InnerJoin, nil, DefaultMemoryBudget))
+	require.NotNil(t, err)
+	_, err = Execute(NewSortMergeJoinExecutor(NewSeqScanExecutor(ctx, users), NewSeqScanExecutor(ctx, users),
+		[]Expression{name}, nil, InnerJoin, nil))
+	require.NotNil(t, err)
+	_, err = Execute(NewIndexNestedLoopJoinExecutor(ctx, NewSeqScanExecutor(ctx, users), users, "users_id",
+		[]Expression{name, name}, InnerJoin, nil))
+	require.NotNil(t, err)
+	_, err = Execute(NewNestedLoopJoinExecutor(NewSeqScanExecutor(ctx, users), NewSeqScanExecutor(ctx, users),
+		InnerJoin, name))
+	require.NotNil(t, err)
+}
+
+func TestHashJoin_Spill(t *testing.T) {
+	db := newTestDatabase()
+	createUsers(t, db)
+	orders := make([][]types.Value, 0)
+	for i := int32(0); i < 300; i++ {
+		orders = append(orders, order(i, types.NewBigInt(int64(i%7)), i))
+	}
+	db.createTable(t, "orders", ordersSchema(), orders)
+	numPages := db.store.numPages
+
+	txn := db.txnManager.Begin()
+	defer db.txnManager.Abort(txn)
+	ctx := db.context(txn)
+	ordersTable, usersTable := db.openTable(t, txn, "orders"), db.openTable(t, txn, "users")
+	userId := []Expression{NewColumn(ordersSchema(), 1)}
+	for _, joinType := range []JoinType{InnerJoin, LeftOuterJoin, SemiJoin} {
+		expected, err := Execute(joinExecutors(t, db, ctx, joinType, nil, DefaultMemoryBudget)["nested loop"])
+		require.Nil(t, err)
+		// A budget of a byte splits the rows as many times as possible.
+		e := NewHashJoinExecutor(ctx, NewSeqScanExecutor(ctx, ordersTable), NewSeqScanExecutor(ctx, usersTable),
+			userId, []Expression{NewColumn(usersSchema(), 0)}, joinType, nil, 1)
+		rows, err := collectRows(e)
+		require.Nil(t, err)
+		require.True(t, e.spilled)
+		require.ElementsMatch(t, rowValues(expected), rowValues(rows), "%s join", joinType)
+		e.Close()
+		require.Equal(t, numPages, db.store.numPages)
+		requireNoPinnedPages(t, db)
+	}
+
+	// Orders with the same user, where partitions cannot be split further.
+	e := NewHashJoinExecutor(ctx, NewSeqScanExecutor(ctx, ordersTable), NewSeqScanExecutor(ctx, ordersTable),
+		userId, userId, InnerJoin, nil, 1)
+	rows, err := Execute(e)
+	require.Nil(t, err)
+	require.Len(t, rows, 6*43*43+42*42)
+	e.Close()
+	require.Equal(t, numPages, db.store.numPages)
+	requireNoPinnedPages(t, db)
+}
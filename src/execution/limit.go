@@ -0,0 +1,53 @@
+package execution
+
+import (
+	"simple-db-golang/src/types"
+)
+
+// LimitExecutor skips a number of rows of its child, and produces at most a
+// number of the following ones, or all of them if the limit is negative, like
+// the -1 of a SELECT without LIMIT.
+type LimitExecutor struct {
+	child    Executor
+	limit    int64
+	offset   int64
+	produced int64
+}
+
+func NewLimitExecutor(child Executor, limit int64, offset int64) *LimitExecutor {
+	return &LimitExecutor{child: child, limit: limit, offset: offset}
+}
+
+func (e *LimitExecutor) OutputSchema() *types.Schema {
+	return e.child.OutputSchema()
+}
+
+func (e *LimitExecutor) Init() error {
+	e.produced = 0
+	if err := e.child.Init(); err != nil {
+		return err
+	}
+	for i := int64(0); i < e.offset; i++ {
+		if _, ok, err := e.child.Next(); err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *LimitExecutor) Next() (Row, bool, error) {
+	// The child is not pulled once the limit is reached.
+	if e.limit >= 0 && e.produced >= e.limit {
+		return Row{}, false, nil
+	}
+	row, ok, err := e.child.Next()
+	if err != nil || !ok {
+		return Row{}, false, err
+	}
+	e.produced++
+	return row, true, nil
+}
+
+func (e *LimitExecutor) Close() {
+	e.child.Close()
+}
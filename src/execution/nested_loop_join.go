@@ -0,0 +1,76 @@
+package execution
+
+import (
+	"simple-db-golang/src/types"
+)
+
+// NestedLoopJoinExecutor joins each row of its left child with every row of
+// its right child, which is scanned again for each left row.
+type NestedLoopJoinExecutor struct {
+	left   Executor
+	right  Executor
+	state  *joinState
+	schema *types.Schema
+	// Whether a left row is being joined, and the right child scanned for it.
+	active bool
+}
+
+// Join the rows of `left` and `right` for which `condition`, evaluated on the
+// columns of both, is TRUE. A nil condition matches every pair of rows.
+func NewNestedLoopJoinExecutor(left, right Executor, joinType JoinType, condition Expression) *NestedLoopJoinExecutor {
+	return &NestedLoopJoinExecutor{
+		left:   left,
+		right:  right,
+		state:  newJoinState(joinType, condition, right.OutputSchema()),
+		schema: joinSchema(left.OutputSchema(), right.OutputSchema(), joinType),
+	}
+}
+
+func (e *NestedLoopJoinExecutor) OutputSchema() *types.Schema {
+	return e.schema
+}
+
+func (e *NestedLoopJoinExecutor) Init() error {
+	if err := e.state.check(); err != nil {
+		return err
+	}
+	e.active = false
+	return e.left.Init()
+}
+
+func (e *NestedLoopJoinExecutor) Next() (Row, bool, error) {
+	for {
+		if !e.active {
+			left, ok, err := e.left.Next()
+			if err != nil || !ok {
+				return Row{}, false, err
+			}
+			if err := e.right.Init(); err != nil {
+				return Row{}, false, err
+			}
+			e.state.start(left)
+			e.active = true
+		}
+		for !e.state.done() {
+			right, ok, err := e.right.Next()
+			if err != nil {
+				return Row{}, false, err
+			}
+			if !ok {
+				break
+			}
+			if row, ok, err := e.state.match(right); err != nil || ok {
+				return row, ok, err
+			}
+		}
+		e.active = false
+		if row, ok := e.state.finish(); ok {
+			return row, true, nil
+		}
+	}
+}
+
+func (e *NestedLoopJoinExecutor) Close() {
+	e.left.Close()
+	e.right.Close()
+}
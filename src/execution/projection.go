@@ -0,0 +1,55 @@
+package execution
+
+import (
+	"simple-db-golang/src/types"
+)
+
+// ProjectionExecutor produces a row of the values of expressions for each row
+// of its child. The RIDs of the rows of the child are kept.
+type ProjectionExecutor struct {
+	child       Executor
+	expressions []Expression
+	schema      *types.Schema
+}
+
+// Compute `expressions` on the rows of `child`, as columns called `names`. A
+// column that is a column of the child keeps its definition, the others are
+// nullable.
+func NewProjectionExecutor(child Executor, expressions []Expression, names []string) *ProjectionExecutor {
+	columns := make([]types.Column, len(expressions))
+	for i, expression := range expressions {
+		if column, ok := expression.(*ColumnExpression); ok {
+			columns[i] = column.Column
+		} else {
+			columns[i] = types.Column{Type: expression.Type(), Nullable: true}
+		}
+		columns[i].Name = names[i]
+	}
+	return &ProjectionExecutor{child: child, expressions: expressions, schema: types.NewSchema(columns...)}
+}
+
+func (e *ProjectionExecutor) OutputSchema() *types.Schema {
+	return e.schema
+}
+
+func (e *ProjectionExecutor) Init() error {
+	return e.child.Init()
+}
+
+func (e *ProjectionExecutor) Next() (Row, bool, error) {
+	row, ok, err := e.child.Next()
+	if err != nil || !ok {
+		return Row{}, false, err
+	}
+	values := make([]types.Value, len(e.expressions))
+	for i, expression := range e.expressions {
+		if values[i], err = expression.Evaluate(row.Values); err != nil {
+			return Row{}, false, err
+		}
+	}
+	return Row{Values: values, RID: row.RID}, true, nil
+}
+
+func (e *ProjectionExecutor) Close() {
+	e.child.Close()
+}
@@ -0,0 +1,47 @@
+package execution
+
+import (
+	"simple-db-golang/src/catalog"
+	"simple-db-golang/src/table"
+	"simple-db-golang/src/types"
+)
+
+// SeqScanExecutor produces the rows of a table visible to the transaction, in
+// the order of its table heap.
+type SeqScanExecutor struct {
+	ctx      *ExecutorContext
+	table    *catalog.Table
+	iterator *table.TableIterator
+}
+
+func NewSeqScanExecutor(ctx *ExecutorContext, table *catalog.Table) *SeqScanExecutor {
+	return &SeqScanExecutor{ctx: ctx, table: table}
+}
+
+func (e *SeqScanExecutor) OutputSchema() *types.Schema {
+	return e.table.Info.Schema
+}
+
+func (e *SeqScanExecutor) Init() error {
+	e.Close()
+	e.iterator = e.table.Info.Heap.Iterator(e.ctx.Txn)
+	return nil
+}
+
+func (e *SeqScanExecutor) Next() (Row, bool, error) {
+	if !e.iterator.Next() {
+		return Row{}, false, nil
+	}
+	tuple, err := types.DecodeTuple(e.table.Info.Schema, e.iterator.Record())
+	if err != nil {
+		return Row{}, false, err
+	}
+	return Row{Values: tuple.Values(e.table.Info.Schema), RID: e.iterator.RID()}, true, nil
+}
+
+func (e *SeqScanExecutor) Close() {
+	if e.iterator != nil {
+		e.iterator.Close()
+		e.iterator = nil
+	}
+}
@@ -0,0 +1,328 @@
+package execution
+
+import (
+	"container/heap"
+	"sort"
+
+	"simple-db-golang/src/types"
+)
+
+// Maximum number of runs a sort merges at once, each of which keeps a page
+// pinned while it is read.
+const sortMergeWidth = 8
+
+// SortKey is an expression to sort rows by. NULL comes before every other
+// value in ascending order, and after them in descending order.
+type SortKey struct {
+	Expression Expression
+	Desc       bool
+}
+
+// SortExecutor produces the rows of its child sorted by keys, rows with equal
+// keys keeping their order. The RIDs of the rows of the child are kept.
+//
+// The rows are sorted in memory up to the memory budget. Past it, they are
+// sorted in runs which fit the budget, written to temporary pages, and merged
+// `sortMergeWidth` at a time into longer runs until they can all be merged
+// into the output. Only a page per run being merged, and the page of the run
+// being written, are pinned at once. The runs are freed as soon as they are
+// merged, or when the executor is closed or restarted.
+type SortExecutor struct {
+	ctx          *ExecutorContext
+	child        Executor
+	keys         []SortKey
+	memoryBudget int
+
+	// The sorted rows when they fit in memory, or the merge of the runs.
+	rows  []sortedRow
+	merge *runMerge
+	files []*spillFile // All the runs, to free them.
+}
+
+type sortedRow struct {
+	row  Row
+	keys []types.Value
+}
+
+// Sort the rows of `child` by `keys`, keeping up to `memoryBudget` bytes of
+// rows in memory.
+func NewSortExecutor(ctx *ExecutorContext, child Executor, keys []SortKey, memoryBudget int) *SortExecutor {
+	return &SortExecutor{ctx: ctx, child: child, keys: keys, memoryBudget: memoryBudget}
+}
+
+func (e *SortExecutor) OutputSchema() *types.Schema {
+	return e.child.OutputSchema()
+}
+
+func (e *SortExecutor) Init() error {
+	e.release()
+	if err := e.child.Init(); err != nil {
+		return err
+	}
+	var runs []*spillFile
+	size := 0
+	for {
+		row, ok, err := e.child.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		keys, err := e.keysOf(row)
+		if err != nil {
+			return err
+		}
+		e.rows = append(e.rows, sortedRow{row: row, keys: keys})
+		size += rowSize(row.Values) + rowSize(keys)
+		if size > e.memoryBudget {
+			run, err := e.writeRun()
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			size = 0
+		}
+	}
+	if runs == nil {
+		e.sortRows()
+		return nil
+	}
+	if len(e.rows) > 0 {
+		run, err := e.writeRun()
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+	}
+	for len(runs) > sortMergeWidth {
+		merged := make([]*spillFile, 0, (len(runs)+sortMergeWidth-1)/sortMergeWidth)
+		for i := 0; i < len(runs); i += sortMergeWidth {
+			end := i + sortMergeWidth
+			if end > len(runs) {
+				end = len(runs)
+			}
+			run, err := e.mergeRuns(runs[i:end])
+			if err != nil {
+				return err
+			}
+			merged = append(merged, run)
+		}
+		runs = merged
+	}
+	merge, err := e.newRunMerge(runs)
+	e.merge = merge
+	return err
+}
+
+func (e *SortExecutor) Next() (Row, bool, error) {
+	if e.merge != nil {
+		return e.merge.next()
+	}
+	if len(e.rows) == 0 {
+		return Row{}, false, nil
+	}
+	row := e.rows[0].row
+	e.rows = e.rows[1:]
+	return row, true, nil
+}
+
+func (e *SortExecutor) Close() {
+	e.release()
+	e.child.Close()
+}
+
+// Free the rows and the runs.
+func (e *SortExecutor) release() {
+	if e.merge != nil {
+		e.merge.close()
+	}
+	for _, file := range e.files {
+		file.free()
+	}
+	e.rows = nil
+	e.merge = nil
+	e.files = nil
+}
+
+func (e *SortExecutor) keysOf(row Row) ([]types.Value, error) {
+	keys := make([]types.Value, len(e.keys))
+	for i, key := range e.keys {
+		var err error
+		if keys[i], err = key.Expression.Evaluate(row.Values); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// Compare the keys of two rows.
+func (e *SortExecutor) compare(a, b []types.Value) int {
+	for i, key := range e.keys {
+		// NULLs may have no type, which does not compare with the others, while
+		// the other values of an expression always compare with each other.
+		var cmp int
+		switch {
+		case a[i].IsNull() && b[i].IsNull():
+			cmp = 0
+		case a[i].IsNull():
+			cmp = -1
+		case b[i].IsNull():
+			cmp = 1
+		default:
+			cmp, _ = a[i].Compare(b[i])
+		}
+		if key.Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+func (e *SortExecutor) sortRows() {
+	sort.SliceStable(e.rows, func(i, j int) bool {
+		return e.compare(e.rows[i].keys, e.rows[j].keys) < 0
+	})
+}
+
+// Sort the rows in memory into a new run, and drop them.
+func (e *SortExecutor) writeRun() (*spillFile, error) {
+	e.sortRows()
+	run := e.newRun()
+	for _, row := range e.rows {
+		if err := run.append(row.row); err != nil {
+			return nil, err
+		}
+	}
+	run.finish()
+	e.rows = nil
+	return run, nil
+}
+
+// Merge consecutive runs into a new run, and free them.
+func (e *SortExecutor) mergeRuns(runs []*spillFile) (*spillFile, error) {
+	merge, err := e.newRunMerge(runs)
+	if err != nil {
+		return nil, err
+	}
+	defer merge.close()
+	run := e.newRun()
+	for {
+		row, ok, err := merge.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		if err := run.append(row); err != nil {
+			return nil, err
+		}
+	}
+	run.finish()
+	for _, merged := range runs {
+		merged.free()
+	}
+	return run, nil
+}
+
+func (e *SortExecutor) newRun() *spillFile {
+	run := newSpillFile(e.ctx.BufferPoolManager, e.child.OutputSchema())
+	e.files = append(e.files, run)
+	return run
+}
+
+// runMerge produces the rows of sorted runs in order, reading the next row of
+// each run, in a heap by key. Rows with equal keys come out in the order of
+// their runs.
+type runMerge struct {
+	executor *SortExecutor
+	inputs   []*mergeInput
+}
+
+type mergeInput struct {
+	reader  *spillReader
+	current sortedRow
+	run     int
+}
+
+func (e *SortExecutor) newRunMerge(runs []*spillFile) (*runMerge, error) {
+	m := &runMerge{executor: e}
+	for i, run := range runs {
+		input := &mergeInput{reader: run.reader(), run: i}
+		ok, err := m.advance(input)
+		if err != nil {
+			input.reader.close()
+			m.close()
+			return nil, err
+		}
+		if ok {
+			m.inputs = append(m.inputs, input)
+		}
+	}
+	heap.Init(m)
+	return m, nil
+}
+
+// Read the next row of an input. Returns false at the end of its run.
+func (m *runMerge) advance(input *mergeInput) (bool, error) {
+	row, ok, err := input.reader.next()
+	if err != nil || !ok {
+		return false, err
+	}
+	keys, err := m.executor.keysOf(row)
+	if err != nil {
+		return false, err
+	}
+	input.current = sortedRow{row: row, keys: keys}
+	return true, nil
+}
+
+func (m *runMerge) next() (Row, bool, error) {
+	if len(m.inputs) == 0 {
+		return Row{}, false, nil
+	}
+	input := m.inputs[0]
+	row := input.current.row
+	ok, err := m.advance(input)
+	if err != nil {
+		return Row{}, false, err
+	}
+	if ok {
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+	}
+	return row, true, nil
+}
+
+// Unpin the pages being read.
+func (m *runMerge) close() {
+	for _, input := range m.inputs {
+		input.reader.close()
+	}
+	m.inputs = nil
+}
+
+func (m *runMerge) Len() int { return len(m.inputs) }
+
+func (m *runMerge) Less(i, j int) bool {
+	a, b := m.inputs[i], m.inputs[j]
+	if cmp := m.executor.compare(a.current.keys, b.current.keys); cmp != 0 {
+		return cmp < 0
+	}
+	return a.run < b.run
+}
+
+func (m *runMerge) Swap(i, j int) { m.inputs[i], m.inputs[j] = m.inputs[j], m.inputs[i] }
+
+func (m *runMerge) Push(x interface{}) { m.inputs = append(m.inputs, x.(*mergeInput)) }
+
+func (m *runMerge) Pop() interface{} {
+	input := m.inputs[len(m.inputs)-1]
+	m.inputs = m.inputs[:len(m.inputs)-1]
+	return input
+}
@@ -0,0 +1,145 @@
+package execution
+
+import (
+	"bytes"
+	"sort"
+
+	"simple-db-golang/src/types"
+)
+
+// SortMergeJoinExecutor joins the rows of its children whose keys are equal
+// by sorting both sides by key in memory, and walking them side by side. Each
+// left row is joined with the group of right rows with its key.
+type SortMergeJoinExecutor struct {
+	left     Executor
+	right    Executor
+	leftKey  *joinKey
+	rightKey *joinKey
+	keyErr   error
+	state    *joinState
+	schema   *types.Schema
+
+	leftRows  []keyedRow
+	rightRows []keyedRow
+	nextLeft  int
+	// Start of the right rows with a key at least the one of the current left
+	// row.
+	groupStart int
+	// Whether a left row is being joined, and the right rows with its key
+	// which may still match it.
+	active        bool
+	nextCandidate int
+	groupEnd      int
+}
+
+type keyedRow struct {
+	row     Row
+	key     []byte
+	hasNull bool
+}
+
+// Join the rows of `left` and `right` for which `leftKeys[i] = rightKeys[i]`,
+// evaluated on each side, for every `i`, and for which `condition`, evaluated
+// on the columns of both, is TRUE. A nil condition matches every pair of
+// rows with equal keys. The rows come out in the order of the left keys.
+func NewSortMergeJoinExecutor(left, right Executor, leftKeys, rightKeys []Expression, joinType JoinType, condition Expression) *SortMergeJoinExecutor {
+	leftKey, rightKey, err := newJoinKeys(leftKeys, rightKeys)
+	return &SortMergeJoinExecutor{
+		left:     left,
+		right:    right,
+		leftKey:  leftKey,
+		rightKey: rightKey,
+		keyErr:   err,
+		state:    newJoinState(joinType, condition, right.OutputSchema()),
+		schema:   joinSchema(left.OutputSchema(), right.OutputSchema(), joinType),
+	}
+}
+
+func (e *SortMergeJoinExecutor) OutputSchema() *types.Schema {
+	return e.schema
+}
+
+func (e *SortMergeJoinExecutor) Init() error {
+	e.leftRows, e.rightRows = nil, nil
+	e.nextLeft, e.groupStart = 0, 0
+	e.active = false
+	if e.keyErr != nil {
+		return e.keyErr
+	}
+	if err := e.state.check(); err != nil {
+		return err
+	}
+	var err error
+	// Left rows with a NULL key are only kept for a left outer join, and
+	// right ones never, since they match nothing.
+	if e.leftRows, err = sortedRows(e.left, e.leftKey, e.state.joinType == LeftOuterJoin); err != nil {
+		return err
+	}
+	e.rightRows, err = sortedRows(e.right, e.rightKey, false)
+	return err
+}
+
+// The rows of `child` sorted by key, leaving out those with a NULL key unless
+// `keepNull`.
+func sortedRows(child Executor, key *joinKey, keepNull bool) ([]keyedRow, error) {
+	rows, err := collectRows(child)
+	if err != nil {
+		return nil, err
+	}
+	keyed := make([]keyedRow, 0, len(rows))
+	for _, row := range rows {
+		encoded, hasNull, err := key.encode(row.Values)
+		if err != nil {
+			return nil, err
+		}
+		if !hasNull || keepNull {
+			keyed = append(keyed, keyedRow{row: row, key: encoded, hasNull: hasNull})
+		}
+	}
+	sort.SliceStable(keyed, func(i, j int) bool {
+		return bytes.Compare(keyed[i].key, keyed[j].key) < 0
+	})
+	return keyed, nil
+}
+
+func (e *SortMergeJoinExecutor) Next() (Row, bool, error) {
+	for {
+		if !e.active {
+			if e.nextLeft >= len(e.leftRows) {
+				return Row{}, false, nil
+			}
+			left := e.leftRows[e.nextLeft]
+			e.nextLeft++
+			e.state.start(left.row)
+			e.active = true
+			e.nextCandidate, e.groupEnd = 0, 0
+			if !left.hasNull {
+				for e.groupStart < len(e.rightRows) && bytes.Compare(e.rightRows[e.groupStart].key, left.key) < 0 {
+					e.groupStart++
+				}
+				e.groupEnd = e.groupStart
+				for e.groupEnd < len(e.rightRows) && bytes.Equal(e.rightRows[e.groupEnd].key, left.key) {
+					e.groupEnd++
+				}
+				e.nextCandidate = e.groupStart
+			}
+		}
+		for e.nextCandidate < e.groupEnd && !e.state.done() {
+			right := e.rightRows[e.nextCandidate]
+			e.nextCandidate++
+			if row, ok, err := e.state.match(right.row); err != nil || ok {
+				return row, ok, err
+			}
+		}
+		e.active = false
+		if row, ok := e.state.finish(); ok {
+			return row, true, nil
+		}
+	}
+}
+
+func (e *SortMergeJoinExecutor) Close() {
+	e.leftRows, e.rightRows = nil, nil
+	e.left.Close()
+	e.right.Close()
+}
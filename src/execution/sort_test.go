@@ -0,0 +1,88 @@
+package execution
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/types"
+)
+
+func TestSort(t *testing.T) {
+	db := newTestDatabase()
+	createUsers(t, db)
+	txn := db.txnManager.Begin()
+	defer db.txnManager.Abort(txn)
+	ctx := db.context(txn)
+	users := db.openTable(t, txn, "users")
+	schema := usersSchema()
+	id, name, age := column(t, schema, "id"), column(t, schema, "name"), column(t, schema, "age")
+
+	for _, c := range []struct {
+		keys     []SortKey
+		expected []int32
+	}{
+		{[]SortKey{{Expression: age, Desc: true}}, []int32{5, 3, 1, 2, 4}},
+		{[]SortKey{{Expression: name}, {Expression: id, Desc: true}}, []int32{4, 1, 5, 2, 3}},
+		// Rows with equal keys keep their order.
+		{[]SortKey{{Expression: NewConstant(types.NewNull(types.InvalidType))}}, []int32{1, 2, 3, 4, 5}},
+	} {
+		rows, err := Execute(NewSortExecutor(ctx, NewSeqScanExecutor(ctx, users), c.keys, DefaultMemoryBudget))
+		require.Nil(t, err)
+		ids := make([]int32, len(rows))
+		for i, row := range rows {
+			ids[i] = row.Values[0].AsInteger()
+			require.NotEqual(t, NoRID, row.RID)
+		}
+		require.Equal(t, c.expected, ids)
+	}
+}
+
+func TestSort_External(t *testing.T) {
+	db := newTestDatabase()
+	orders := make([][]types.Value, 0)
+	for i := int32(0); i < 2000; i++ {
+		userId := types.NewBigInt(int64(i * 7919 % 101))
+		if i%13 == 0 {
+			userId = types.NewNull(types.BigInt)
+		}
+		orders = append(orders, order(i, userId, i%10))
+	}
+	db.createTable(t, "orders", ordersSchema(), orders)
+	numPages := db.store.numPages
+
+	txn := db.txnManager.Begin()
+	defer db.txnManager.Abort(txn)
+	ctx := db.context(txn)
+	table := db.openTable(t, txn, "orders")
+	schema := ordersSchema()
+	keys := []SortKey{{Expression: column(t, schema, "amount")}, {Expression: column(t, schema, "user_id"), Desc: true}}
+	expected, err := Execute(NewSortExecutor(ctx, NewSeqScanExecutor(ctx, table), keys, DefaultMemoryBudget))
+	require.Nil(t, err)
+	require.Len(t, expected, len(orders))
+
+	// Runs of a few rows take more than one pass to merge.
+	e := NewSortExecutor(ctx, NewSeqScanExecutor(ctx, table), keys, 2000)
+	rows, err := collectRows(e)
+	require.Nil(t, err)
+	require.NotNil(t, e.merge)
+	require.Equal(t, expected, rows)
+	for i := 1; i < len(rows); i++ {
+		a, b := rows[i-1].Values, rows[i].Values
+		require.True(t, a[2].AsInteger() <= b[2].AsInteger())
+		if a[2].Equals(b[2]) && a[1].Equals(b[1]) {
+			require.Less(t, a[0].AsInteger(), b[0].AsInteger())
+		}
+	}
+	e.Close()
+	require.Equal(t, numPages, db.store.numPages)
+	requireNoPinnedPages(t, db)
+
+	// Closing before the end frees the runs.
+	require.Nil(t, e.Init())
+	_, _, err = e.Next()
+	require.Nil(t, err)
+	e.Close()
+	require.Equal(t, numPages, db.store.numPages)
+	requireNoPinnedPages(t, db)
+}
@@ -0,0 +1,227 @@
+package execution
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/types"
+)
+
+// DefaultMemoryBudget is the number of bytes of rows an executor keeps in
+// memory by default before it spills them to temporary pages.
+const DefaultMemoryBudget = 4 << 20
+
+const (
+	// Number of partitions the rows of an executor are split into at once
+	// when they do not fit in its memory budget.
+	spillFanout = 8
+	// Number of times rows may be split, after which a partition is processed
+	// in memory whatever its size, e.g. when all its rows have the same key.
+	maxSpillDepth = 3
+)
+
+// An estimate of the memory taken by a row kept by an executor.
+func rowSize(values []types.Value) int {
+	size := int(unsafe.Sizeof(Row{})) + len(values)*int(unsafe.Sizeof(types.Value{}))
+	for _, value := range values {
+		if value.Type() == types.Varchar {
+			size += len(value.AsVarchar())
+		}
+	}
+	return size
+}
+
+// rowSource produces rows one at a time, like an executor or a spill file.
+type rowSource interface {
+	next() (Row, bool, error)
+}
+
+type emptySource struct{}
+
+func (emptySource) next() (Row, bool, error) {
+	return Row{}, false, nil
+}
+
+type executorSource struct {
+	Executor
+}
+
+func (s executorSource) next() (Row, bool, error) {
+	return s.Executor.Next()
+}
+
+// spillFile is a sequence of rows written to temporary pages of the buffer
+// pool, for executors whose rows do not fit in their memory budget. The rows
+// are encoded one after the other into a stream of bytes cut into pages, so a
+// row may span pages: `| length (4) | RID page id (4) | RID slot (4) | tuple
+// |`, little endian.
+//
+// Only the page being written, or the page being read by each reader, is
+// pinned. The pages are not logged, nor part of any transaction: they must be
+// freed with `free`, and are leaked by a crash.
+type spillFile struct {
+	bufferPoolManager *disk.BufferPoolManager
+	schema            *types.Schema
+	pageIds           []common.PageId
+	size              int
+	page              *disk.Page // The last page while it is written, pinned.
+}
+
+// Create an empty file for rows of `schema`.
+func newSpillFile(bufferPoolManager *disk.BufferPoolManager, schema *types.Schema) *spillFile {
+	return &spillFile{bufferPoolManager: bufferPoolManager, schema: spillSchema(schema)}
+}
+
+// A schema which encodes any row of `schema`: the rows of an executor are not
+// checked against the lengths and nullability of its columns.
+func spillSchema(schema *types.Schema) *types.Schema {
+	columns := make([]types.Column, len(schema.Columns))
+	for i, column := range schema.Columns {
+		columns[i] = types.Column{Name: column.Name, Type: column.Type, Length: math.MaxInt32, Nullable: true}
+	}
+	return types.NewSchema(columns...)
+}
+
+func (f *spillFile) append(row Row) error {
+	tuple, err := types.NewTuple(f.schema, row.Values)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 12, 12+len(tuple.Data()))
+	binary.LittleEndian.PutUint32(data, uint32(8+len(tuple.Data())))
+	binary.LittleEndian.PutUint32(data[4:], uint32(row.RID.PageId))
+	binary.LittleEndian.PutUint32(data[8:], uint32(int32(row.RID.SlotNum)))
+	data = append(data, tuple.Data()...)
+	for len(data) > 0 {
+		offset := f.size % disk.PageDataSize
+		if offset == 0 {
+			if err := f.newPage(); err != nil {
+				return err
+			}
+		}
+		n := copy(f.page.Data()[offset:], data)
+		data = data[n:]
+		f.size += n
+	}
+	return nil
+}
+
+func (f *spillFile) newPage() error {
+	f.finish()
+	page, err := f.bufferPoolManager.NewPage()
+	if err != nil {
+		return err
+	}
+	f.page = page
+	f.pageIds = append(f.pageIds, page.PageId())
+	return nil
+}
+
+// Stop writing, and unpin the last page.
+func (f *spillFile) finish() {
+	if f.page != nil {
+		f.bufferPoolManager.UnpinPage(f.page.PageId(), true)
+		f.page = nil
+	}
+}
+
+func (f *spillFile) empty() bool {
+	return f.size == 0
+}
+
+// Read the rows from the start. The file must not be written any more.
+func (f *spillFile) reader() *spillReader {
+	f.finish()
+	return &spillReader{file: f}
+}
+
+// Free the pages of the file.
+func (f *spillFile) free() {
+	f.finish()
+	for _, pageId := range f.pageIds {
+		if err := f.bufferPoolManager.DeletePage(pageId); err != nil {
+			log.WithError(err).Warnf("Cannot free temporary page %d.", pageId)
+		}
+	}
+	f.pageIds = nil
+	f.size = 0
+}
+
+type spillReader struct {
+	file   *spillFile
+	offset int
+	page   *disk.Page // The page being read, pinned, or nil.
+}
+
+// Read the next row. Returns false at the end of the file.
+func (r *spillReader) next() (Row, bool, error) {
+	if r.offset >= r.file.size {
+		r.close()
+		return Row{}, false, nil
+	}
+	header, err := r.read(4)
+	if err != nil {
+		return Row{}, false, err
+	}
+	data, err := r.read(int(binary.LittleEndian.Uint32(header)))
+	if err != nil {
+		return Row{}, false, err
+	}
+	tuple, err := types.DecodeTuple(r.file.schema, data[8:])
+	if err != nil {
+		return Row{}, false, err
+	}
+	rid := common.RID{
+		PageId:  common.PageId(binary.LittleEndian.Uint32(data)),
+		SlotNum: int(int32(binary.LittleEndian.Uint32(data[4:]))),
+	}
+	return Row{Values: tuple.Values(r.file.schema), RID: rid}, true, nil
+}
+
+// Read the next `n` bytes.
+func (r *spillReader) read(n int) ([]byte, error) {
+	data := make([]byte, 0, n)
+	for len(data) < n {
+		offset := r.offset % disk.PageDataSize
+		if r.page == nil {
+			page, err := r.file.bufferPoolManager.FetchPage(r.file.pageIds[r.offset/disk.PageDataSize])
+			if err != nil {
+				return nil, err
+			}
+			r.page = page
+		}
+		chunk := r.page.Data()[offset:]
+		if len(chunk) > n-len(data) {
+			chunk = chunk[:n-len(data)]
+		}
+		data = append(data, chunk...)
+		r.offset += len(chunk)
+		if r.offset%disk.PageDataSize == 0 {
+			r.close()
+		}
+	}
+	return data, nil
+}
+
+// Unpin the page being read.
+func (r *spillReader) close() {
+	if r.page != nil {
+		r.file.bufferPoolManager.UnpinPage(r.page.PageId(), false)
+		r.page = nil
+	}
+}
+
+// The partition of a key after `depth` splits. Each split hashes the keys
+// differently, so that the rows of a partition spread out when it is split.
+func partitionOf(key []byte, depth int) int {
+	h := fnv.New64a()
+	h.Write([]byte{byte(depth)})
+	h.Write(key)
+	return int(h.Sum64() % spillFanout)
+}
@@ -0,0 +1,54 @@
+package execution
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/types"
+)
+
+func TestSpillFile(t *testing.T) {
+	db := newTestDatabase()
+	schema := types.NewSchema(
+		types.Column{Name: "id", Type: types.Integer},
+		types.Column{Name: "text", Type: types.Varchar, Length: 1},
+	)
+	numPages := db.store.numPages
+	file := newSpillFile(db.bpm, schema)
+	require.True(t, file.empty())
+	rows := make([]Row, 0)
+	for i := 0; i < 50; i++ {
+		// Rows longer than a page span pages, and are not checked against the
+		// length of their column.
+		text := types.NewVarchar(strings.Repeat("x", i*disk.PageDataSize/10))
+		if i%7 == 0 {
+			text = types.NewNull(types.Varchar)
+		}
+		row := Row{Values: []types.Value{types.NewInteger(int32(i)), text}, RID: common.RID{PageId: common.PageId(i), SlotNum: i}}
+		require.Nil(t, file.append(row))
+		rows = append(rows, row)
+	}
+	require.False(t, file.empty())
+
+	for i := 0; i < 2; i++ {
+		reader := file.reader()
+		for _, expected := range rows {
+			row, ok, err := reader.next()
+			require.Nil(t, err)
+			require.True(t, ok)
+			require.Equal(t, expected, row)
+		}
+		_, ok, err := reader.next()
+		require.Nil(t, err)
+		require.False(t, ok)
+	}
+	require.Greater(t, db.store.numPages, numPages+100)
+
+	file.free()
+	require.Equal(t, numPages, db.store.numPages)
+	requireNoPinnedPages(t, db)
+}
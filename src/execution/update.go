@@ -0,0 +1,74 @@
+package execution
+
+import (
+	"fmt"
+
+	"simple-db-golang/src/catalog"
+	"simple-db-golang/src/types"
+)
+
+// UpdateExecutor updates the rows of a table found by its child, e.g. a scan
+// of the table, and produces a single row with the number of updated rows.
+type UpdateExecutor struct {
+	ctx   *ExecutorContext
+	table *catalog.Table
+	child Executor
+	// The new values of the updated columns by position, computed from the
+	// rows of the child, and converted to the types of the columns.
+	assignments map[int]Expression
+	done        bool
+}
+
+func NewUpdateExecutor(ctx *ExecutorContext, table *catalog.Table, child Executor, assignments map[int]Expression) *UpdateExecutor {
+	return &UpdateExecutor{ctx: ctx, table: table, child: child, assignments: assignments}
+}
+
+func (e *UpdateExecutor) OutputSchema() *types.Schema {
+	return countSchema()
+}
+
+func (e *UpdateExecutor) Init() error {
+	e.done = false
+	for i := range e.assignments {
+		if i < 0 || i >= e.table.Info.Schema.NumColumns() {
+			return fmt.Errorf("Table %s has no column %d.", e.table.Info.Name, i)
+		}
+	}
+	return nil
+}
+
+func (e *UpdateExecutor) Next() (Row, bool, error) {
+	if e.done {
+		return Row{}, false, nil
+	}
+	e.done = true
+	rows, err := collectRows(e.child)
+	if err != nil {
+		return Row{}, false, err
+	}
+	count := int64(0)
+	for _, row := range rows {
+		values := make([]types.Value, len(row.Values))
+		copy(values, row.Values)
+		for i, expression := range e.assignments {
+			if values[i], err = expression.Evaluate(row.Values); err != nil {
+				return Row{}, false, err
+			}
+		}
+		if values, err = castValues(e.table.Info.Schema, values); err != nil {
+			return Row{}, false, err
+		}
+		updated, err := e.table.Update(e.ctx.Txn, row.RID, values)
+		if err != nil {
+			return Row{}, false, err
+		}
+		if updated {
+			count++
+		}
+	}
+	return Row{Values: []types.Value{types.NewBigInt(count)}, RID: NoRID}, true, nil
+}
+
+func (e *UpdateExecutor) Close() {
+	e.child.Close()
+}
@@ -0,0 +1,35 @@
+package execution
+
+import (
+	"simple-db-golang/src/types"
+)
+
+// ValuesExecutor produces rows given up front, e.g. the rows of an INSERT.
+type ValuesExecutor struct {
+	schema *types.Schema
+	rows   [][]types.Value
+	next   int
+}
+
+func NewValuesExecutor(schema *types.Schema, rows [][]types.Value) *ValuesExecutor {
+	return &ValuesExecutor{schema: schema, rows: rows}
+}
+
+func (e *ValuesExecutor) OutputSchema() *types.Schema {
+	return e.schema
+}
+
+func (e *ValuesExecutor) Init() error {
+	e.next = 0
+	return nil
+}
+
+func (e *ValuesExecutor) Next() (Row, bool, error) {
+	if e.next >= len(e.rows) {
+		return Row{}, false, nil
+	}
+	e.next++
+	return Row{Values: e.rows[e.next-1], RID: NoRID}, true, nil
+}
+
+func (e *ValuesExecutor) Close() {}
@@ -0,0 +1,529 @@
+package index
+
+import (
+	"fmt"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/wal"
+)
+
+const (
+	// Keys may be at most this long, so that a node has room for at least
+	// five entries.
+	MaxKeySize = (disk.PageDataSize-nodeHeaderSize)/5 - keyLengthSize - valueSize
+)
+
+// BPlusTree maps keys to RIDs, ordered by the bytes of the keys. Each key
+// appears at most once. The nodes of the tree are pages of the buffer pool,
+// and the tree is identified by the page id of its header page.
+//
+// Goroutines latch the nodes from the root down, keeping the latch of a node
+// until its child is latched. Inserts and deletes first latch the leaf alone,
+// and only if the leaf has to be split or merged start again latching the
+// path exclusively, releasing the ancestors of every node which cannot be
+// split or merged.
+//
+// Changes to the tree are not logged, but for the creation of its header, so
+// after a crash it has to be emptied with `RecoverBPlusTree` and rebuilt from
+// its table.
+type BPlusTree struct {
+	bufferPoolManager *disk.BufferPoolManager
+	headerPageId      common.PageId
+	keySize           int
+	maxSize           int
+}
+
+// Create an empty tree with keys of at most `keySize` bytes.
+func NewBPlusTree(bufferPoolManager *disk.BufferPoolManager, keySize int) (*BPlusTree, error) {
+	if keySize <= 0 || keySize > MaxKeySize {
+		return nil, fmt.Errorf("Key size must be between 1 and %d.", MaxKeySize)
+	}
+	return newBPlusTree(bufferPoolManager, keySize, nodeCapacity(keySize)-1), nil
+}
+
+func newBPlusTree(bufferPoolManager *disk.BufferPoolManager, keySize int, maxSize int) *BPlusTree {
+	headerPage, err := bufferPoolManager.NewPage()
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot create B+ tree header page.")
+	}
+	t := &BPlusTree{
+		bufferPoolManager: bufferPoolManager,
+		headerPageId:      headerPage.PageId(),
+		keySize:           keySize,
+		maxSize:           maxSize,
+	}
+	rootPage, _ := t.newNode(leafNode)
+	header := createBPlusTreeHeader(headerPage.Data())
+	header.rootPageId = rootPage.PageId()
+	header.keySize = int32(keySize)
+	header.maxSize = int32(maxSize)
+	logHeader(bufferPoolManager, headerPage, int(unsafe.Sizeof(bPlusTreeHeader{})))
+	bufferPoolManager.UnpinPage(rootPage.PageId(), true)
+	bufferPoolManager.UnpinPage(headerPage.PageId(), true)
+	return t
+}
+
+// Open the tree with the header page `headerPageId`.
+func OpenBPlusTree(bufferPoolManager *disk.BufferPoolManager, headerPageId common.PageId) *BPlusTree {
+	t := &BPlusTree{
+		bufferPoolManager: bufferPoolManager,
+		headerPageId:      headerPageId,
+	}
+	headerPage := t.fetchPage(headerPageId)
+	header := createBPlusTreeHeader(headerPage.Data())
+	t.keySize = int(header.keySize)
+	t.maxSize = int(header.maxSize)
+	bufferPoolManager.UnpinPage(headerPageId, false)
+	return t
+}
+
+// Open the tree with the header page `headerPageId`, emptied, to fill it again
+// once the database is opened: changes to the tree are not logged, so its
+// nodes cannot be trusted after a crash. The old nodes are not freed.
+func RecoverBPlusTree(bufferPoolManager *disk.BufferPoolManager, headerPageId common.PageId) *BPlusTree {
+	t := OpenBPlusTree(bufferPoolManager, headerPageId)
+	headerPage := t.fetchPage(headerPageId)
+	headerPage.Lock()
+	rootPage, _ := t.newNode(leafNode)
+	createBPlusTreeHeader(headerPage.Data()).rootPageId = rootPage.PageId()
+	bufferPoolManager.UnpinPage(rootPage.PageId(), true)
+	release(bufferPoolManager, headerPage, true, true)
+	return t
+}
+
+func (t *BPlusTree) HeaderPageId() common.PageId {
+	return t.headerPageId
+}
+
+func (t *BPlusTree) KeySize() int {
+	return t.keySize
+}
+
+// Remove every key, freeing all the nodes. Nothing else may use the tree
+// meanwhile.
+func (t *BPlusTree) Clear() {
+	headerPage := t.fetchPage(t.headerPageId)
+	headerPage.Lock()
+	header := createBPlusTreeHeader(headerPage.Data())
+	t.freeSubtree(header.rootPageId)
+	rootPage, _ := t.newNode(leafNode)
+	header.rootPageId = rootPage.PageId()
+	t.bufferPoolManager.UnpinPage(rootPage.PageId(), true)
+	release(t.bufferPoolManager, headerPage, true, true)
+}
+
+// Give every page of the tree back to the buffer pool. The tree must not be
+// used any more.
+func (t *BPlusTree) Drop() {
+	headerPage := t.fetchPage(t.headerPageId)
+	rootPageId := createBPlusTreeHeader(headerPage.Data()).rootPageId
+	t.bufferPoolManager.UnpinPage(t.headerPageId, false)
+	t.freeSubtree(rootPageId)
+	freePage(t.bufferPoolManager, t.headerPageId)
+}
+
+// Free the node `pageId` and all of its descendants.
+func (t *BPlusTree) freeSubtree(pageId common.PageId) {
+	page := t.fetchPage(pageId)
+	node := createBPlusTreeNode(page.Data())
+	var children []common.PageId
+	if !node.isLeaf() {
+		for i := 0; i < int(node.size); i++ {
+			children = append(children, node.childAt(i))
+		}
+	}
+	t.bufferPoolManager.UnpinPage(pageId, false)
+	for _, child := range children {
+		t.freeSubtree(child)
+	}
+	freePage(t.bufferPoolManager, pageId)
+}
+
+// Nodes other than the root have at least this many entries.
+func (t *BPlusTree) minSize() int {
+	return t.maxSize / 2
+}
+
+// The RID of `key`, or false if it is not in the tree.
+func (t *BPlusTree) Get(key []byte) (common.RID, bool) {
+	page, _ := t.findLeaf(key)
+	defer t.releaseRead(page)
+	node := createBPlusTreeNode(page.Data())
+	i, found := node.keyIndex(key)
+	if !found {
+		return common.RID{}, false
+	}
+	return node.ridAt(i), true
+}
+
+// Add `key`, returning false if it is already in the tree.
+func (t *BPlusTree) Insert(key []byte, rid common.RID) (bool, error) {
+	if len(key) > t.keySize {
+		return false, fmt.Errorf("Key is longer than %d bytes.", t.keySize)
+	}
+	insert := func(node *bPlusTreeNode) bool {
+		i, found := node.keyIndex(key)
+		if found {
+			return false
+		}
+		node.insertAt(i)
+		node.setKeyAt(i, key)
+		node.setRIDAt(i, rid)
+		return true
+	}
+	if inserted, done := t.modifyLeaf(key, t.safeForInsert, insert); done {
+		return inserted, nil
+	}
+
+	path := t.latchPath(key, t.safeForInsert)
+	defer path.release()
+	leaf := path.nodes[len(path.nodes)-1]
+	if !insert(leaf.node) {
+		return false, nil
+	}
+	leaf.dirty = true
+	for k := len(path.nodes) - 1; k >= 0 && int(path.nodes[k].node.size) > t.maxSize; k-- {
+		t.split(path, k)
+	}
+	return true, nil
+}
+
+// Remove `key`, returning false if it is not in the tree.
+func (t *BPlusTree) Delete(key []byte) bool {
+	remove := func(node *bPlusTreeNode) bool {
+		i, found := node.keyIndex(key)
+		if !found {
+			return false
+		}
+		node.removeAt(i)
+		return true
+	}
+	if removed, done := t.modifyLeaf(key, t.safeForDelete, remove); done {
+		return removed
+	}
+
+	path := t.latchPath(key, t.safeForDelete)
+	defer path.release()
+	leaf := path.nodes[len(path.nodes)-1]
+	if !remove(leaf.node) {
+		return false
+	}
+	leaf.dirty = true
+	for k := len(path.nodes) - 1; k >= 0; k-- {
+		if !t.rebalance(path, k) {
+			break
+		}
+	}
+	return true
+}
+
+// An insert into the node cannot split it.
+func (t *BPlusTree) safeForInsert(node *bPlusTreeNode, isRoot bool) bool {
+	return int(node.size) < t.maxSize
+}
+
+// A delete from the node cannot merge it, or remove the root.
+func (t *BPlusTree) safeForDelete(node *bPlusTreeNode, isRoot bool) bool {
+	if isRoot {
+		return node.isLeaf() || node.size > 2
+	}
+	return int(node.size) > t.minSize()
+}
+
+// Latch the leaf which may hold `key` exclusively, and apply `modify` to it
+// if it is safe. Returns false if it is not, without changing anything.
+func (t *BPlusTree) modifyLeaf(key []byte, safe func(*bPlusTreeNode, bool) bool, modify func(*bPlusTreeNode) bool) (bool, bool) {
+	parent := t.fetchPage(t.headerPageId)
+	parent.RLock()
+	pageId := createBPlusTreeHeader(parent.Data()).rootPageId
+	for isRoot := true; ; isRoot = false {
+		page := t.fetchPage(pageId)
+		page.RLock()
+		node := createBPlusTreeNode(page.Data())
+		if node.isLeaf() {
+			// Nobody can split or merge the leaf while its parent is latched.
+			page.RUnlock()
+			page.Lock()
+			t.releaseRead(parent)
+			if !safe(node, isRoot) {
+				release(t.bufferPoolManager, page, true, false)
+				return false, false
+			}
+			modified := modify(node)
+			release(t.bufferPoolManager, page, true, modified)
+			return modified, true
+		}
+		t.releaseRead(parent)
+		parent = page
+		pageId = node.childAt(node.childIndex(key))
+	}
+}
+
+// Latch the leaf which may hold `key` for reading. A nil key leads to the
+// first leaf. Also returns the smallest key which may be in the leaves after
+// it, or nil if it is the last leaf.
+func (t *BPlusTree) findLeaf(key []byte) (*disk.Page, []byte) {
+	parent := t.fetchPage(t.headerPageId)
+	parent.RLock()
+	pageId := createBPlusTreeHeader(parent.Data()).rootPageId
+	var high []byte
+	for {
+		page := t.fetchPage(pageId)
+		page.RLock()
+		t.releaseRead(parent)
+		node := createBPlusTreeNode(page.Data())
+		if node.isLeaf() {
+			return page, high
+		}
+		i := node.childIndex(key)
+		if i+1 < int(node.size) {
+			high = append([]byte{}, node.keyAt(i+1)...)
+		}
+		parent = page
+		pageId = node.childAt(i)
+	}
+}
+
+// writePath holds the pages latched exclusively to change the structure of
+// the tree, from the header page down to a leaf.
+type writePath struct {
+	tree        *BPlusTree
+	header      *disk.Page // Nil once released.
+	headerDirty bool
+	nodes       []*pathNode
+}
+
+type pathNode struct {
+	page  *disk.Page // Nil once freed.
+	node  *bPlusTreeNode
+	index int // Position in the parent.
+	dirty bool
+}
+
+// Latch the path to the leaf which may hold `key` exclusively. Once a node
+// is latched, its ancestors are released if `safe` says that it will not have
+// to change them.
+func (t *BPlusTree) latchPath(key []byte, safe func(*bPlusTreeNode, bool) bool) *writePath {
+	path := &writePath{tree: t, header: t.fetchPage(t.headerPageId)}
+	path.header.Lock()
+	pageId := createBPlusTreeHeader(path.header.Data()).rootPageId
+	index := -1
+	for depth := 0; ; depth++ {
+		page := t.fetchPage(pageId)
+		page.Lock()
+		node := createBPlusTreeNode(page.Data())
+		if safe(node, depth == 0) {
+			path.release()
+		}
+		path.nodes = append(path.nodes, &pathNode{page: page, node: node, index: index})
+		if node.isLeaf() {
+			return path
+		}
+		index = node.childIndex(key)
+		pageId = node.childAt(index)
+	}
+}
+
+func (path *writePath) release() {
+	bufferPoolManager := path.tree.bufferPoolManager
+	if path.header != nil {
+		release(bufferPoolManager, path.header, true, path.headerDirty)
+		path.header = nil
+	}
+	for _, n := range path.nodes {
+		if n.page != nil {
+			release(bufferPoolManager, n.page, true, n.dirty)
+		}
+	}
+	path.nodes = nil
+}
+
+// Split the node at `k` in the path in two, adding the new node to its
+// parent, or to a new root.
+func (t *BPlusTree) split(path *writePath, k int) {
+	n := path.nodes[k]
+	siblingPage, sibling := t.newNode(n.node.kind)
+	sibling.moveFrom(n.node, int(n.node.size)/2)
+	separator := append([]byte(nil), sibling.keyAt(0)...)
+	if n.node.isLeaf() {
+		sibling.next = n.node.next
+		n.node.next = siblingPage.PageId()
+	}
+	n.dirty = true
+
+	if k == 0 {
+		if path.header == nil {
+			log.Fatalf("B+ tree node %d is split without its parent.", n.page.PageId())
+		}
+		rootPage, root := t.newNode(internalNode)
+		root.size = 2
+		root.setKeyAt(0, nil)
+		root.setChildAt(0, n.page.PageId())
+		root.setKeyAt(1, separator)
+		root.setChildAt(1, siblingPage.PageId())
+		createBPlusTreeHeader(path.header.Data()).rootPageId = rootPage.PageId()
+		path.headerDirty = true
+		t.bufferPoolManager.UnpinPage(rootPage.PageId(), true)
+	} else {
+		parent := path.nodes[k-1]
+		i := n.index + 1
+		parent.node.insertAt(i)
+		parent.node.setKeyAt(i, separator)
+		parent.node.setChildAt(i, siblingPage.PageId())
+		parent.dirty = true
+	}
+	// The new node cannot be reached before its parent is released.
+	t.bufferPoolManager.UnpinPage(siblingPage.PageId(), true)
+}
+
+// Fix the node at `k` in the path if it has too few entries, by merging it
+// with a sibling or moving an entry from one. Returns true if its parent lost
+// an entry.
+func (t *BPlusTree) rebalance(path *writePath, k int) bool {
+	n := path.nodes[k]
+	if k == 0 {
+		if path.header != nil && !n.node.isLeaf() && n.node.size == 1 {
+			// The only child of the root becomes the root.
+			createBPlusTreeHeader(path.header.Data()).rootPageId = n.node.childAt(0)
+			path.headerDirty = true
+			t.freeNode(n)
+		}
+		return false
+	}
+	if int(n.node.size) >= t.minSize() {
+		return false
+	}
+
+	parent := path.nodes[k-1]
+	siblingIndex := n.index + 1
+	if n.index > 0 {
+		siblingIndex = n.index - 1
+	}
+	siblingPage := t.fetchPage(parent.node.childAt(siblingIndex))
+	siblingPage.Lock()
+	sibling := &pathNode{page: siblingPage, node: createBPlusTreeNode(siblingPage.Data()), index: siblingIndex, dirty: true}
+	left, right := sibling, n
+	if n.index == 0 {
+		left, right = n, sibling
+	}
+	left.dirty = true
+	parent.dirty = true
+
+	if int(left.node.size+right.node.size) <= t.maxSize {
+		if !left.node.isLeaf() {
+			// The separator becomes the key of the first child of the right node.
+			right.node.setKeyAt(0, parent.node.keyAt(right.index))
+		}
+		left.node.moveFrom(right.node, 0)
+		left.node.next = right.node.next
+		parent.node.removeAt(right.index)
+		t.freeNode(right)
+		if left == sibling {
+			t.releaseNode(sibling)
+		}
+		return true
+	}
+
+	if left == sibling {
+		// Move the last entry of the left sibling to the front of the node.
+		last := int(left.node.size) - 1
+		right.node.insertAt(0)
+		copy(right.node.entry(0), left.node.entry(last))
+		if !right.node.isLeaf() {
+			right.node.setKeyAt(1, parent.node.keyAt(right.index))
+		}
+		parent.node.setKeyAt(right.index, left.node.keyAt(last))
+		left.node.size--
+	} else {
+		// Move the first entry of the right sibling to the end of the node.
+		size := int(left.node.size)
+		copy(left.node.entry(size), right.node.entry(0))
+		if !left.node.isLeaf() {
+			left.node.setKeyAt(size, parent.node.keyAt(right.index))
+		}
+		left.node.size++
+		// The second key of the right node becomes its first.
+		parent.node.setKeyAt(right.index, right.node.keyAt(1))
+		right.node.removeAt(0)
+	}
+	t.releaseNode(sibling)
+	return false
+}
+
+func (t *BPlusTree) releaseNode(n *pathNode) {
+	release(t.bufferPoolManager, n.page, true, n.dirty)
+}
+
+// Give the page of a node unlinked from the tree back to the buffer pool.
+// Nobody else can have it pinned, since its parent is latched, and others
+// unpin it before releasing its latch.
+func (t *BPlusTree) freeNode(n *pathNode) {
+	pageId := n.page.PageId()
+	release(t.bufferPoolManager, n.page, true, false)
+	n.page = nil
+	freePage(t.bufferPoolManager, pageId)
+}
+
+func (t *BPlusTree) fetchPage(pageId common.PageId) *disk.Page {
+	page, err := t.bufferPoolManager.FetchPage(pageId)
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot fetch page %d.", pageId)
+	}
+	return page
+}
+
+func (t *BPlusTree) releaseRead(page *disk.Page) {
+	release(t.bufferPoolManager, page, false, false)
+}
+
+// Allocate a node, pinned.
+func (t *BPlusTree) newNode(kind int32) (*disk.Page, *bPlusTreeNode) {
+	page, err := t.bufferPoolManager.NewPage()
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot allocate new page.")
+	}
+	node := createBPlusTreeNode(page.Data())
+	node.init(kind, t.keySize)
+	return page, node
+}
+
+// Unpin a latched page, then release its latch. Pages are unpinned first so
+// that once a goroutine latches a page unlinked from the index, nobody else has
+// it pinned, and it can be freed.
+func release(bufferPoolManager *disk.BufferPoolManager, page *disk.Page, exclusive bool, isDirty bool) {
+	bufferPoolManager.UnpinPage(page.PageId(), isDirty)
+	if exclusive {
+		page.Unlock()
+	} else {
+		page.RUnlock()
+	}
+}
+
+// Give an unpinned page of an index back to the buffer pool.
+func freePage(bufferPoolManager *disk.BufferPoolManager, pageId common.PageId) {
+	if err := bufferPoolManager.DeletePage(pageId); err != nil {
+		log.WithError(err).Warnf("Cannot free index page %d.", pageId)
+	}
+}
+
+// Log the first `size` bytes of the header page of an index when it is
+// created, so that the index can be found again after a crash. The write
+// belongs to no transaction, and is only ever redone.
+func logHeader(bufferPoolManager *disk.BufferPoolManager, page *disk.Page, size int) {
+	logManager := bufferPoolManager.LogManager()
+	if logManager == nil {
+		return
+	}
+	lsn := logManager.AppendLogRecord(&wal.LogRecord{
+		PrevLSN: common.InvalidLSN,
+		TxnId:   common.InvalidTxnId,
+		Type:    wal.PageWriteRecord,
+		PageId:  page.PageId(),
+		Data:    append([]byte(nil), page.Data()[:size]...),
+	})
+	page.SetLSN(lsn)
+}
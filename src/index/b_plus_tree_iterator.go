@@ -0,0 +1,82 @@
+package index
+
+import (
+	"bytes"
+
+	"simple-db-golang/src/common"
+)
+
+// BPlusTreeIterator walks the keys of a B+ tree in order. It copies the
+// entries of a leaf at a time, and finds the next leaf again from the root
+// with the last key it returned, so that no latch is held between calls to
+// `Next`, and the leaves are never latched from left to right, which could
+// deadlock with a merge. Keys are returned at most once, in increasing order; keys added or
+// removed during the scan may or may not be seen.
+type BPlusTreeIterator struct {
+	tree *BPlusTree
+	end  []byte
+	keys [][]byte // Entries of the current leaf not returned yet.
+	rids []common.RID
+	last bool // The current leaf is the last one.
+	key  []byte
+	rid  common.RID
+}
+
+// Create an iterator over the keys from `start`, included, to `end`,
+// excluded. A nil `start` begins with the smallest key, and a nil `end` goes
+// on to the largest one.
+func (t *BPlusTree) Iterator(start []byte, end []byte) *BPlusTreeIterator {
+	it := &BPlusTreeIterator{tree: t, end: end}
+	it.load(start, true)
+	return it
+}
+
+// Move to the next key. Returns false once there are no more keys.
+func (it *BPlusTreeIterator) Next() bool {
+	for len(it.keys) == 0 {
+		if it.last {
+			return false
+		}
+		it.load(it.key, false)
+	}
+	it.key, it.rid = it.keys[0], it.rids[0]
+	it.keys, it.rids = it.keys[1:], it.rids[1:]
+	if it.end != nil && bytes.Compare(it.key, it.end) >= 0 {
+		it.keys, it.rids, it.last = nil, nil, true
+		return false
+	}
+	return true
+}
+
+func (it *BPlusTreeIterator) Key() []byte { return it.key }
+
+func (it *BPlusTreeIterator) RID() common.RID { return it.rid }
+
+// Copy the entries of the leaf holding `from` which come after it, or from
+// it if `inclusive`. Moves on to the next leaves until one has such entries.
+func (it *BPlusTreeIterator) load(from []byte, inclusive bool) {
+	for {
+		page, high := it.tree.findLeaf(from)
+		node := createBPlusTreeNode(page.Data())
+		i := 0
+		if from != nil {
+			var found bool
+			if i, found = node.keyIndex(from); found && !inclusive {
+				i++
+			}
+		}
+		it.keys = make([][]byte, 0, int(node.size)-i)
+		it.rids = make([]common.RID, 0, int(node.size)-i)
+		for ; i < int(node.size); i++ {
+			it.keys = append(it.keys, append([]byte{}, node.keyAt(i)...))
+			it.rids = append(it.rids, node.ridAt(i))
+		}
+		it.tree.releaseRead(page)
+		it.last = high == nil
+		if len(it.keys) > 0 || it.last {
+			return
+		}
+		// Every key of the leaf is before `from`, go on with the next leaf.
+		from, inclusive = high, true
+	}
+}
@@ -0,0 +1,184 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"unsafe"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+)
+
+// bPlusTreeHeader is the header page of a B+ tree. Its page id identifies the
+// tree, while the root moves as the tree grows and shrinks.
+type bPlusTreeHeader struct {
+	rootPageId common.PageId
+	keySize    int32 // Maximum size of a key.
+	maxSize    int32 // Maximum number of entries in a node.
+}
+
+func createBPlusTreeHeader(data []byte) *bPlusTreeHeader {
+	return (*bPlusTreeHeader)(unsafe.Pointer(&data[0]))
+}
+
+const (
+	leafNode     = int32(1)
+	internalNode = int32(2)
+)
+
+// bPlusTreeNode is a node of a B+ tree, followed by its entries. An entry is
+// `| key length (2) | key (key size) | value (8) |`, where the value is a RID
+// in a leaf and the page id of a child in an internal node.
+//
+// The entries of a node are sorted by key. In an internal node, the child of
+// entry `i` holds the keys from the key of entry `i`, included, to the key of
+// entry `i + 1`, excluded. The key of the first entry is unused, the first
+// child holding all the keys smaller than the second one.
+//
+// A node has room for one more entry than `maxSize`, so that an entry can be
+// added before the node is split.
+type bPlusTreeNode struct {
+	kind    int32
+	size    int32 // Number of entries.
+	keySize int32
+	next    common.PageId // Next leaf, or InvalidPageId. Unused in internal nodes.
+	ptr     struct{}
+}
+
+const (
+	nodeHeaderSize = int(unsafe.Offsetof(bPlusTreeNode{}.ptr))
+	keyLengthSize  = 2
+	valueSize      = 8
+)
+
+func createBPlusTreeNode(data []byte) *bPlusTreeNode {
+	return (*bPlusTreeNode)(unsafe.Pointer(&data[0]))
+}
+
+func (n *bPlusTreeNode) init(kind int32, keySize int) {
+	n.kind = kind
+	n.size = 0
+	n.keySize = int32(keySize)
+	n.next = common.InvalidPageId
+}
+
+func (n *bPlusTreeNode) isLeaf() bool {
+	return n.kind == leafNode
+}
+
+func entrySize(keySize int) int {
+	return keyLengthSize + keySize + valueSize
+}
+
+// Number of entries that fit in a node, which is one more than the maximum
+// size of a node.
+func nodeCapacity(keySize int) int {
+	return (disk.PageDataSize - nodeHeaderSize) / entrySize(keySize)
+}
+
+func (n *bPlusTreeNode) entries() []byte {
+	size := entrySize(int(n.keySize))
+	return (*[math.MaxInt32]byte)(unsafe.Pointer(&n.ptr))[:nodeCapacity(int(n.keySize))*size]
+}
+
+func (n *bPlusTreeNode) entry(i int) []byte {
+	size := entrySize(int(n.keySize))
+	return n.entries()[i*size : (i+1)*size]
+}
+
+// Key of entry `i`, pointing into the page.
+func (n *bPlusTreeNode) keyAt(i int) []byte {
+	entry := n.entry(i)
+	length := int(binary.LittleEndian.Uint16(entry))
+	return entry[keyLengthSize : keyLengthSize+length]
+}
+
+func (n *bPlusTreeNode) setKeyAt(i int, key []byte) {
+	entry := n.entry(i)
+	binary.LittleEndian.PutUint16(entry, uint16(len(key)))
+	copy(entry[keyLengthSize:], key)
+}
+
+func (n *bPlusTreeNode) value(i int) []byte {
+	return n.entry(i)[keyLengthSize+int(n.keySize):]
+}
+
+func (n *bPlusTreeNode) ridAt(i int) common.RID {
+	value := n.value(i)
+	return common.RID{
+		PageId:  common.PageId(binary.LittleEndian.Uint32(value)),
+		SlotNum: int(int32(binary.LittleEndian.Uint32(value[4:]))),
+	}
+}
+
+func (n *bPlusTreeNode) setRIDAt(i int, rid common.RID) {
+	value := n.value(i)
+	binary.LittleEndian.PutUint32(value, uint32(rid.PageId))
+	binary.LittleEndian.PutUint32(value[4:], uint32(int32(rid.SlotNum)))
+}
+
+func (n *bPlusTreeNode) childAt(i int) common.PageId {
+	return common.PageId(binary.LittleEndian.Uint32(n.value(i)))
+}
+
+func (n *bPlusTreeNode) setChildAt(i int, child common.PageId) {
+	value := n.value(i)
+	binary.LittleEndian.PutUint32(value, uint32(child))
+	binary.LittleEndian.PutUint32(value[4:], 0)
+}
+
+// Make room for an entry at `i`, shifting the following ones.
+func (n *bPlusTreeNode) insertAt(i int) {
+	size := entrySize(int(n.keySize))
+	entries := n.entries()
+	copy(entries[(i+1)*size:(int(n.size)+1)*size], entries[i*size:int(n.size)*size])
+	n.size++
+}
+
+func (n *bPlusTreeNode) removeAt(i int) {
+	size := entrySize(int(n.keySize))
+	entries := n.entries()
+	copy(entries[i*size:], entries[(i+1)*size:int(n.size)*size])
+	n.size--
+}
+
+// Move the entries from `from` to the end of the node.
+func (n *bPlusTreeNode) moveFrom(other *bPlusTreeNode, from int) {
+	size := entrySize(int(n.keySize))
+	copy(n.entries()[int(n.size)*size:], other.entries()[from*size:int(other.size)*size])
+	n.size += other.size - int32(from)
+	other.size = int32(from)
+}
+
+// Position of `key` in a leaf, or of the first larger key if it is not there.
+func (n *bPlusTreeNode) keyIndex(key []byte) (int, bool) {
+	low, high := 0, int(n.size)
+	for low < high {
+		mid := (low + high) / 2
+		if bytes.Compare(n.keyAt(mid), key) < 0 {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low, low < int(n.size) && bytes.Equal(n.keyAt(low), key)
+}
+
+// Position of the child of an internal node which holds `key`. A nil key is
+// smaller than every key.
+func (n *bPlusTreeNode) childIndex(key []byte) int {
+	if key == nil {
+		return 0
+	}
+	low, high := 1, int(n.size)
+	for low < high {
+		mid := (low + high) / 2
+		if bytes.Compare(n.keyAt(mid), key) <= 0 {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low - 1
+}
@@ -0,0 +1,287 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+)
+
+func testKey(i int) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(i))
+	return key
+}
+
+func testRID(i int) common.RID {
+	return common.RID{PageId: common.PageId(i / 10), SlotNum: i % 10}
+}
+
+// The keys of the tree from `start` to `end`, in the order of the iterator.
+func collectKeys(tree *BPlusTree, start, end []byte) []int {
+	keys := make([]int, 0)
+	it := tree.Iterator(start, end)
+	for it.Next() {
+		keys = append(keys, int(binary.BigEndian.Uint32(it.Key())))
+	}
+	return keys
+}
+
+// Check the structure of the tree, which must not be modified meanwhile.
+func checkBPlusTree(t *BPlusTree) error {
+	headerPage := t.fetchPage(t.headerPageId)
+	rootPageId := createBPlusTreeHeader(headerPage.Data()).rootPageId
+	t.bufferPoolManager.UnpinPage(t.headerPageId, false)
+	// The next leaf of the last one visited.
+	nextLeaf := common.InvalidPageId
+	leafDepth := -1
+	var walk func(pageId common.PageId, low, high []byte, depth int) error
+	walk = func(pageId common.PageId, low, high []byte, depth int) error {
+		page := t.fetchPage(pageId)
+		defer t.bufferPoolManager.UnpinPage(pageId, false)
+		node := createBPlusTreeNode(page.Data())
+		if depth > 0 && int(node.size) < t.minSize() || int(node.size) > t.maxSize ||
+			depth == 0 && !node.isLeaf() && node.size < 2 {
+			return fmt.Errorf("Node %d has %d entries.", pageId, node.size)
+		}
+		start := 0
+		if !node.isLeaf() {
+			start = 1
+		}
+		for i := start; i < int(node.size); i++ {
+			key := node.keyAt(i)
+			if i > start && bytes.Compare(node.keyAt(i-1), key) >= 0 ||
+				low != nil && bytes.Compare(key, low) < 0 || high != nil && bytes.Compare(key, high) >= 0 {
+				return fmt.Errorf("Node %d is out of order.", pageId)
+			}
+		}
+		if node.isLeaf() {
+			if leafDepth != -1 && leafDepth != depth {
+				return fmt.Errorf("Leaf %d is at depth %d.", pageId, depth)
+			}
+			if leafDepth != -1 && nextLeaf != pageId {
+				return fmt.Errorf("Leaf %d is not linked.", pageId)
+			}
+			nextLeaf = node.next
+			leafDepth = depth
+			return nil
+		}
+		for i := 0; i < int(node.size); i++ {
+			childLow, childHigh := low, high
+			if i > 0 {
+				childLow = node.keyAt(i)
+			}
+			if i+1 < int(node.size) {
+				childHigh = node.keyAt(i + 1)
+			}
+			if err := walk(node.childAt(i), childLow, childHigh, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(rootPageId, nil, nil, 0); err != nil {
+		return err
+	}
+	if nextLeaf != common.InvalidPageId {
+		return fmt.Errorf("Last leaf has a next leaf.")
+	}
+	return nil
+}
+
+func TestBPlusTree(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(32, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tree := newBPlusTree(bufferPoolManager, 4, 4)
+
+	require.Equal(t, []int{}, collectKeys(tree, nil, nil))
+	_, found := tree.Get(testKey(1))
+	require.False(t, found)
+	require.False(t, tree.Delete(testKey(1)))
+
+	total := 1000
+	order := rand.Perm(total)
+	for _, i := range order {
+		inserted, err := tree.Insert(testKey(i), testRID(i))
+		require.Nil(t, err)
+		require.True(t, inserted)
+	}
+	require.Nil(t, checkBPlusTree(tree))
+	inserted, err := tree.Insert(testKey(order[0]), testRID(0))
+	require.Nil(t, err)
+	require.False(t, inserted)
+	_, err = tree.Insert([]byte("too long"), testRID(0))
+	require.NotNil(t, err)
+	for i := 0; i < total; i++ {
+		rid, found := tree.Get(testKey(i))
+		require.True(t, found)
+		require.Equal(t, testRID(i), rid)
+	}
+
+	expected := make([]int, total)
+	for i := range expected {
+		expected[i] = i
+	}
+	require.Equal(t, expected, collectKeys(tree, nil, nil))
+	require.Equal(t, expected[100:200], collectKeys(tree, testKey(100), testKey(200)))
+	require.Equal(t, expected[990:], collectKeys(tree, testKey(990), nil))
+	require.Equal(t, []int{}, collectKeys(tree, testKey(total), nil))
+
+	// Delete every other key, then all of them.
+	for _, i := range order {
+		if i%2 == 0 {
+			require.True(t, tree.Delete(testKey(i)))
+		}
+	}
+	require.Nil(t, checkBPlusTree(tree))
+	odd := make([]int, 0)
+	for i := 1; i < total; i += 2 {
+		odd = append(odd, i)
+	}
+	require.Equal(t, odd, collectKeys(tree, nil, nil))
+	// The start of a range may be missing.
+	require.Equal(t, odd[50:], collectKeys(tree, testKey(100), nil))
+	for _, i := range order {
+		require.Equal(t, i%2 == 1, tree.Delete(testKey(i)))
+	}
+	require.Nil(t, checkBPlusTree(tree))
+	require.Equal(t, []int{}, collectKeys(tree, nil, nil))
+
+	// The pages of the tree are all unpinned.
+	require.Nil(t, bufferPoolManager.FlushAllPages())
+	for i := 0; i < 32; i++ {
+		_, err := bufferPoolManager.NewPage()
+		require.Nil(t, err)
+	}
+}
+
+func TestBPlusTree_VariableKeys(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(32, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	_, err := NewBPlusTree(bufferPoolManager, MaxKeySize+1)
+	require.NotNil(t, err)
+	tree, err := NewBPlusTree(bufferPoolManager, MaxKeySize)
+	require.Nil(t, err)
+
+	keys := make([][]byte, 0)
+	for i := 0; i < 300; i++ {
+		key := make([]byte, rand.Intn(MaxKeySize+1))
+		rand.Read(key)
+		if _, found := tree.Get(key); found {
+			continue
+		}
+		inserted, err := tree.Insert(key, testRID(i))
+		require.Nil(t, err)
+		require.True(t, inserted)
+		keys = append(keys, key)
+	}
+	require.Nil(t, checkBPlusTree(tree))
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	it := tree.Iterator(nil, nil)
+	for _, key := range keys {
+		require.True(t, it.Next())
+		require.Equal(t, key, it.Key())
+	}
+	require.False(t, it.Next())
+
+	// The tree can be opened again from its header page.
+	require.Nil(t, bufferPoolManager.FlushAllPages())
+	tree = OpenBPlusTree(bufferPoolManager, tree.HeaderPageId())
+	require.Equal(t, MaxKeySize, tree.KeySize())
+	for _, key := range keys {
+		require.True(t, tree.Delete(key))
+	}
+	require.Nil(t, checkBPlusTree(tree))
+}
+
+func TestBPlusTree_Clear(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(16, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tree := newBPlusTree(bufferPoolManager, 4, 4)
+	fill := func() {
+		for i := 0; i < 100; i++ {
+			inserted, err := tree.Insert(testKey(i), testRID(i))
+			require.Nil(t, err)
+			require.True(t, inserted)
+		}
+	}
+
+	// A cleared tree is empty, and can be filled again.
+	fill()
+	tree.Clear()
+	require.Equal(t, []int{}, collectKeys(tree, nil, nil))
+	require.Nil(t, checkBPlusTree(tree))
+	fill()
+	require.Nil(t, checkBPlusTree(tree))
+
+	// A recovered tree is empty.
+	tree = RecoverBPlusTree(bufferPoolManager, tree.HeaderPageId())
+	require.Equal(t, []int{}, collectKeys(tree, nil, nil))
+	fill()
+	require.Nil(t, checkBPlusTree(tree))
+
+	tree.Drop()
+	_, err := bufferPoolManager.FetchPage(tree.HeaderPageId())
+	require.NotNil(t, err)
+}
+
+func TestBPlusTree_Concurrent(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(256, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tree := newBPlusTree(bufferPoolManager, 4, 4)
+
+	numWorkers := 8
+	perWorker := 500
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Each worker owns the keys equal to `w` modulo the number of
+			// workers, and deletes the ones which are a multiple of three.
+			for _, i := range rand.Perm(perWorker) {
+				key := i*numWorkers + w
+				inserted, err := tree.Insert(testKey(key), testRID(key))
+				if err != nil || !inserted {
+					panic(fmt.Sprintf("Cannot insert %d.", key))
+				}
+			}
+			for _, i := range rand.Perm(perWorker) {
+				key := i*numWorkers + w
+				if key%3 == 0 && !tree.Delete(testKey(key)) {
+					panic(fmt.Sprintf("Cannot delete %d.", key))
+				}
+				if rid, found := tree.Get(testKey(key)); found != (key%3 != 0) || found && rid != testRID(key) {
+					panic(fmt.Sprintf("Wrong lookup of %d.", key))
+				}
+			}
+		}(w)
+	}
+	// Scans see the keys in order while the tree changes.
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				keys := collectKeys(tree, nil, nil)
+				if !sort.IntsAreSorted(keys) {
+					panic("Keys are out of order.")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Nil(t, checkBPlusTree(tree))
+	expected := make([]int, 0)
+	for key := 0; key < numWorkers*perWorker; key++ {
+		if key%3 != 0 {
+			expected = append(expected, key)
+		}
+	}
+	require.Equal(t, expected, collectKeys(tree, nil, nil))
+}
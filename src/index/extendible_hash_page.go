@@ -0,0 +1,150 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"unsafe"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+)
+
+const (
+	maxGlobalDepth = 9
+	directorySize  = 1 << maxGlobalDepth
+)
+
+// hashDirectory is the directory page of an extendible hash table. Entry `i`
+// of the directory points to the bucket holding the keys whose hash ends with
+// the `globalDepth` lowest bits of `i`. A bucket with a local depth of `d` is
+// pointed to by all the entries which have the same `d` lowest bits.
+type hashDirectory struct {
+	globalDepth   int32
+	keySize       int32 // Maximum size of a key.
+	bucketSize    int32 // Maximum number of entries in a bucket.
+	localDepths   [directorySize]uint8
+	bucketPageIds [directorySize]common.PageId
+}
+
+func createHashDirectory(data []byte) *hashDirectory {
+	return (*hashDirectory)(unsafe.Pointer(&data[0]))
+}
+
+func (dir *hashDirectory) size() int {
+	return 1 << uint(dir.globalDepth)
+}
+
+func (dir *hashDirectory) index(hash uint32) int {
+	return int(hash & uint32(dir.size()-1))
+}
+
+// Double the directory, the new half pointing to the same buckets as the old
+// one.
+func (dir *hashDirectory) grow() {
+	size := dir.size()
+	copy(dir.localDepths[size:2*size], dir.localDepths[:size])
+	copy(dir.bucketPageIds[size:2*size], dir.bucketPageIds[:size])
+	dir.globalDepth++
+}
+
+// Halve the directory as long as no bucket needs all of its bits.
+func (dir *hashDirectory) shrink() {
+	for dir.globalDepth > 0 {
+		for i := 0; i < dir.size(); i++ {
+			if int32(dir.localDepths[i]) == dir.globalDepth {
+				return
+			}
+		}
+		dir.globalDepth--
+	}
+}
+
+// The distinct buckets of the directory.
+func (dir *hashDirectory) buckets() []common.PageId {
+	seen := make(map[common.PageId]bool)
+	pageIds := make([]common.PageId, 0)
+	for i := 0; i < dir.size(); i++ {
+		if pageId := dir.bucketPageIds[i]; !seen[pageId] {
+			seen[pageId] = true
+			pageIds = append(pageIds, pageId)
+		}
+	}
+	return pageIds
+}
+
+// hashBucket is a bucket page of an extendible hash table, followed by its
+// entries in no particular order. Entries are laid out like those of a B+ tree
+// leaf.
+type hashBucket struct {
+	size    int32 // Number of entries.
+	keySize int32
+	ptr     struct{}
+}
+
+const (
+	bucketHeaderSize = int(unsafe.Offsetof(hashBucket{}.ptr))
+)
+
+func createHashBucket(data []byte) *hashBucket {
+	return (*hashBucket)(unsafe.Pointer(&data[0]))
+}
+
+func (b *hashBucket) init(keySize int) {
+	b.size = 0
+	b.keySize = int32(keySize)
+}
+
+// Number of entries that fit in a bucket.
+func bucketCapacity(keySize int) int {
+	return (disk.PageDataSize - bucketHeaderSize) / entrySize(keySize)
+}
+
+func (b *hashBucket) entry(i int) []byte {
+	size := entrySize(int(b.keySize))
+	entries := (*[math.MaxInt32]byte)(unsafe.Pointer(&b.ptr))[:bucketCapacity(int(b.keySize))*size]
+	return entries[i*size : (i+1)*size]
+}
+
+func (b *hashBucket) keyAt(i int) []byte {
+	entry := b.entry(i)
+	length := int(binary.LittleEndian.Uint16(entry))
+	return entry[keyLengthSize : keyLengthSize+length]
+}
+
+func (b *hashBucket) ridAt(i int) common.RID {
+	value := b.entry(i)[keyLengthSize+int(b.keySize):]
+	return common.RID{
+		PageId:  common.PageId(binary.LittleEndian.Uint32(value)),
+		SlotNum: int(int32(binary.LittleEndian.Uint32(value[4:]))),
+	}
+}
+
+func (b *hashBucket) push(key []byte, rid common.RID) {
+	entry := b.entry(int(b.size))
+	binary.LittleEndian.PutUint16(entry, uint16(len(key)))
+	copy(entry[keyLengthSize:], key)
+	value := entry[keyLengthSize+int(b.keySize):]
+	binary.LittleEndian.PutUint32(value, uint32(rid.PageId))
+	binary.LittleEndian.PutUint32(value[4:], uint32(int32(rid.SlotNum)))
+	b.size++
+}
+
+// Remove entry `i`, moving the last entry in its place.
+func (b *hashBucket) removeAt(i int) {
+	last := int(b.size) - 1
+	if i != last {
+		copy(b.entry(i), b.entry(last))
+	}
+	b.size--
+}
+
+// Position of the entry of `key` and `rid`, or -1 if there is none.
+func (b *hashBucket) find(key []byte, rid common.RID) int {
+	for i := 0; i < int(b.size); i++ {
+		if b.ridAt(i) == rid && bytes.Equal(b.keyAt(i), key) {
+			return i
+		}
+	}
+	return -1
+}
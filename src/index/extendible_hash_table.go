@@ -0,0 +1,355 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+)
+
+var (
+	ErrBucketFull = errors.New("Hash bucket is full and cannot be split.")
+)
+
+// ExtendibleHashTable maps keys to RIDs for equality lookups. A key may have
+// several RIDs, but each pair of a key and a RID appears at most once. The
+// table is identified by the page id of its directory page.
+//
+// Buckets are split when they overflow, doubling the directory when needed,
+// and merged with their split image once they are empty. Lookups and changes
+// which fit in their bucket latch the directory for reading, then the bucket;
+// splits and merges latch the directory exclusively.
+//
+// Changes to the table are not logged, but for the creation of its directory,
+// so after a crash it has to be emptied with `RecoverExtendibleHashTable` and
+// rebuilt from its table.
+type ExtendibleHashTable struct {
+	bufferPoolManager *disk.BufferPoolManager
+	directoryPageId   common.PageId
+	keySize           int
+	bucketSize        int
+}
+
+// Create an empty hash table with keys of at most `keySize` bytes.
+func NewExtendibleHashTable(bufferPoolManager *disk.BufferPoolManager, keySize int) (*ExtendibleHashTable, error) {
+	if keySize <= 0 || keySize > MaxKeySize {
+		return nil, fmt.Errorf("Key size must be between 1 and %d.", MaxKeySize)
+	}
+	return newExtendibleHashTable(bufferPoolManager, keySize, bucketCapacity(keySize)), nil
+}
+
+func newExtendibleHashTable(bufferPoolManager *disk.BufferPoolManager, keySize int, bucketSize int) *ExtendibleHashTable {
+	directoryPage, err := bufferPoolManager.NewPage()
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot create hash directory page.")
+	}
+	h := &ExtendibleHashTable{
+		bufferPoolManager: bufferPoolManager,
+		directoryPageId:   directoryPage.PageId(),
+		keySize:           keySize,
+		bucketSize:        bucketSize,
+	}
+	dir := createHashDirectory(directoryPage.Data())
+	dir.keySize = int32(keySize)
+	dir.bucketSize = int32(bucketSize)
+	h.reset(dir)
+	logHeader(bufferPoolManager, directoryPage, int(unsafe.Sizeof(hashDirectory{})))
+	bufferPoolManager.UnpinPage(directoryPage.PageId(), true)
+	return h
+}
+
+// Open the hash table with the directory page `directoryPageId`.
+func OpenExtendibleHashTable(bufferPoolManager *disk.BufferPoolManager, directoryPageId common.PageId) *ExtendibleHashTable {
+	h := &ExtendibleHashTable{
+		bufferPoolManager: bufferPoolManager,
+		directoryPageId:   directoryPageId,
+	}
+	directoryPage := h.fetchPage(directoryPageId)
+	dir := createHashDirectory(directoryPage.Data())
+	h.keySize = int(dir.keySize)
+	h.bucketSize = int(dir.bucketSize)
+	bufferPoolManager.UnpinPage(directoryPageId, false)
+	return h
+}
+
+// Open the hash table with the directory page `directoryPageId`, emptied, to
+// fill it again once the database is opened: changes to the table are not
+// logged, so its buckets cannot be trusted after a crash. The old buckets are
+// not freed.
+func RecoverExtendibleHashTable(bufferPoolManager *disk.BufferPoolManager, directoryPageId common.PageId) *ExtendibleHashTable {
+	h := OpenExtendibleHashTable(bufferPoolManager, directoryPageId)
+	directoryPage := h.fetchPage(directoryPageId)
+	directoryPage.Lock()
+	h.reset(createHashDirectory(directoryPage.Data()))
+	h.releaseWrite(directoryPage, true)
+	return h
+}
+
+// Point the directory to a single new bucket.
+func (h *ExtendibleHashTable) reset(dir *hashDirectory) {
+	bucketPage := h.newBucket()
+	dir.globalDepth = 0
+	dir.localDepths[0] = 0
+	dir.bucketPageIds[0] = bucketPage.PageId()
+	h.bufferPoolManager.UnpinPage(bucketPage.PageId(), true)
+}
+
+// Remove every key, freeing all the buckets. Nothing else may use the table
+// meanwhile.
+func (h *ExtendibleHashTable) Clear() {
+	directoryPage := h.fetchPage(h.directoryPageId)
+	directoryPage.Lock()
+	dir := createHashDirectory(directoryPage.Data())
+	for _, pageId := range dir.buckets() {
+		freePage(h.bufferPoolManager, pageId)
+	}
+	h.reset(dir)
+	h.releaseWrite(directoryPage, true)
+}
+
+// Give every page of the table back to the buffer pool. The table must not be
+// used any more.
+func (h *ExtendibleHashTable) Drop() {
+	directoryPage := h.fetchPage(h.directoryPageId)
+	bucketPageIds := createHashDirectory(directoryPage.Data()).buckets()
+	h.bufferPoolManager.UnpinPage(h.directoryPageId, false)
+	for _, pageId := range bucketPageIds {
+		freePage(h.bufferPoolManager, pageId)
+	}
+	freePage(h.bufferPoolManager, h.directoryPageId)
+}
+
+func (h *ExtendibleHashTable) DirectoryPageId() common.PageId {
+	return h.directoryPageId
+}
+
+func (h *ExtendibleHashTable) KeySize() int {
+	return h.keySize
+}
+
+func hashKey(key []byte) uint32 {
+	hash := fnv.New32a()
+	hash.Write(key)
+	return hash.Sum32()
+}
+
+// The RIDs of `key`, in no particular order.
+func (h *ExtendibleHashTable) Get(key []byte) []common.RID {
+	bucketPage := h.latchBucket(key, false)
+	defer h.releaseRead(bucketPage)
+	bucket := createHashBucket(bucketPage.Data())
+	rids := make([]common.RID, 0)
+	for i := 0; i < int(bucket.size); i++ {
+		if bytes.Equal(bucket.keyAt(i), key) {
+			rids = append(rids, bucket.ridAt(i))
+		}
+	}
+	return rids
+}
+
+// Add `key` with `rid`, returning false if the pair is already in the table.
+// Fails with `ErrBucketFull` if a bucket cannot hold all the keys with the
+// same hash.
+func (h *ExtendibleHashTable) Insert(key []byte, rid common.RID) (bool, error) {
+	if len(key) > h.keySize {
+		return false, fmt.Errorf("Key is longer than %d bytes.", h.keySize)
+	}
+	bucketPage := h.latchBucket(key, true)
+	bucket := createHashBucket(bucketPage.Data())
+	if bucket.find(key, rid) != -1 {
+		h.releaseWrite(bucketPage, false)
+		return false, nil
+	}
+	if int(bucket.size) < h.bucketSize {
+		bucket.push(key, rid)
+		h.releaseWrite(bucketPage, true)
+		return true, nil
+	}
+	h.releaseWrite(bucketPage, false)
+	return h.splitInsert(key, rid)
+}
+
+// Insert with the directory latched exclusively, splitting the bucket of the
+// key until it has room.
+func (h *ExtendibleHashTable) splitInsert(key []byte, rid common.RID) (bool, error) {
+	directoryPage := h.fetchPage(h.directoryPageId)
+	directoryPage.Lock()
+	dir := createHashDirectory(directoryPage.Data())
+	dirty := false
+	defer func() {
+		h.releaseWrite(directoryPage, dirty)
+	}()
+
+	hash := hashKey(key)
+	for {
+		index := dir.index(hash)
+		bucketPage := h.fetchPage(dir.bucketPageIds[index])
+		bucketPage.Lock()
+		bucket := createHashBucket(bucketPage.Data())
+		if bucket.find(key, rid) != -1 {
+			h.releaseWrite(bucketPage, false)
+			return false, nil
+		}
+		if int(bucket.size) < h.bucketSize {
+			bucket.push(key, rid)
+			h.releaseWrite(bucketPage, true)
+			return true, nil
+		}
+
+		localDepth := int32(dir.localDepths[index])
+		if localDepth == dir.globalDepth {
+			if dir.globalDepth == maxGlobalDepth {
+				h.releaseWrite(bucketPage, false)
+				return false, ErrBucketFull
+			}
+			dir.grow()
+		}
+		// Move the keys with the next bit of the hash set to a new bucket.
+		imagePage := h.newBucket()
+		image := createHashBucket(imagePage.Data())
+		bit := uint32(1) << uint(localDepth)
+		for i := 0; i < int(bucket.size); {
+			if hashKey(bucket.keyAt(i))&bit != 0 {
+				image.push(bucket.keyAt(i), bucket.ridAt(i))
+				bucket.removeAt(i)
+			} else {
+				i++
+			}
+		}
+		for i := 0; i < dir.size(); i++ {
+			if dir.bucketPageIds[i] == bucketPage.PageId() {
+				dir.localDepths[i] = uint8(localDepth + 1)
+				if uint32(i)&bit != 0 {
+					dir.bucketPageIds[i] = imagePage.PageId()
+				}
+			}
+		}
+		dirty = true
+		h.bufferPoolManager.UnpinPage(imagePage.PageId(), true)
+		h.releaseWrite(bucketPage, true)
+	}
+}
+
+// Remove `key` with `rid`, returning false if the pair is not in the table.
+func (h *ExtendibleHashTable) Delete(key []byte, rid common.RID) bool {
+	bucketPage := h.latchBucket(key, true)
+	bucket := createHashBucket(bucketPage.Data())
+	i := bucket.find(key, rid)
+	if i == -1 {
+		h.releaseWrite(bucketPage, false)
+		return false
+	}
+	bucket.removeAt(i)
+	empty := bucket.size == 0
+	h.releaseWrite(bucketPage, true)
+	if empty {
+		h.merge(hashKey(key))
+	}
+	return true
+}
+
+// Merge the bucket of `hash` with its split image while either is empty, and
+// shrink the directory.
+func (h *ExtendibleHashTable) merge(hash uint32) {
+	directoryPage := h.fetchPage(h.directoryPageId)
+	directoryPage.Lock()
+	dir := createHashDirectory(directoryPage.Data())
+	dirty := false
+	defer func() {
+		h.releaseWrite(directoryPage, dirty)
+	}()
+
+	for {
+		index := dir.index(hash)
+		localDepth := dir.localDepths[index]
+		if localDepth == 0 {
+			return
+		}
+		imageIndex := index ^ (1 << (localDepth - 1))
+		if dir.localDepths[imageIndex] != localDepth {
+			return
+		}
+		bucketPageId, imagePageId := dir.bucketPageIds[index], dir.bucketPageIds[imageIndex]
+		// Other goroutines may still hold the latch of a bucket they found
+		// before the directory was latched.
+		bucketPage := h.fetchPage(bucketPageId)
+		bucketPage.Lock()
+		imagePage := h.fetchPage(imagePageId)
+		imagePage.Lock()
+		emptyPage, keptPage := bucketPage, imagePage
+		if createHashBucket(bucketPage.Data()).size != 0 {
+			emptyPage, keptPage = imagePage, bucketPage
+		}
+		if createHashBucket(emptyPage.Data()).size != 0 {
+			h.releaseWrite(bucketPage, false)
+			h.releaseWrite(imagePage, false)
+			return
+		}
+		for i := 0; i < dir.size(); i++ {
+			if dir.bucketPageIds[i] == bucketPageId || dir.bucketPageIds[i] == imagePageId {
+				dir.bucketPageIds[i] = keptPage.PageId()
+				dir.localDepths[i] = localDepth - 1
+			}
+		}
+		dir.shrink()
+		dirty = true
+		h.releaseWrite(keptPage, false)
+		h.freeBucket(emptyPage)
+	}
+}
+
+// Latch the bucket of `key`, exclusively or not.
+func (h *ExtendibleHashTable) latchBucket(key []byte, exclusive bool) *disk.Page {
+	directoryPage := h.fetchPage(h.directoryPageId)
+	directoryPage.RLock()
+	dir := createHashDirectory(directoryPage.Data())
+	bucketPage := h.fetchPage(dir.bucketPageIds[dir.index(hashKey(key))])
+	if exclusive {
+		bucketPage.Lock()
+	} else {
+		bucketPage.RLock()
+	}
+	h.releaseRead(directoryPage)
+	return bucketPage
+}
+
+func (h *ExtendibleHashTable) fetchPage(pageId common.PageId) *disk.Page {
+	page, err := h.bufferPoolManager.FetchPage(pageId)
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot fetch page %d.", pageId)
+	}
+	return page
+}
+
+func (h *ExtendibleHashTable) releaseRead(page *disk.Page) {
+	release(h.bufferPoolManager, page, false, false)
+}
+
+func (h *ExtendibleHashTable) releaseWrite(page *disk.Page, isDirty bool) {
+	release(h.bufferPoolManager, page, true, isDirty)
+}
+
+// Allocate a bucket, pinned.
+func (h *ExtendibleHashTable) newBucket() *disk.Page {
+	page, err := h.bufferPoolManager.NewPage()
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot allocate new page.")
+	}
+	createHashBucket(page.Data()).init(h.keySize)
+	return page
+}
+
+// Give a latched bucket which is no longer in the directory back to the
+// buffer pool. Nobody else can have it pinned, since buckets are latched with
+// the directory latched, and the directory is latched exclusively.
+func (h *ExtendibleHashTable) freeBucket(page *disk.Page) {
+	pageId := page.PageId()
+	h.releaseWrite(page, false)
+	freePage(h.bufferPoolManager, pageId)
+}
@@ -0,0 +1,162 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+)
+
+func globalDepth(h *ExtendibleHashTable) int {
+	directoryPage := h.fetchPage(h.directoryPageId)
+	defer h.bufferPoolManager.UnpinPage(h.directoryPageId, false)
+	return int(createHashDirectory(directoryPage.Data()).globalDepth)
+}
+
+func TestExtendibleHashTable(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(32, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	h := newExtendibleHashTable(bufferPoolManager, 4, 4)
+
+	require.Equal(t, []common.RID{}, h.Get(testKey(1)))
+	require.False(t, h.Delete(testKey(1), testRID(1)))
+
+	total := 1000
+	order := rand.Perm(total)
+	for _, i := range order {
+		inserted, err := h.Insert(testKey(i), testRID(i))
+		require.Nil(t, err)
+		require.True(t, inserted)
+	}
+	require.True(t, globalDepth(h) >= 8)
+	inserted, err := h.Insert(testKey(order[0]), testRID(order[0]))
+	require.Nil(t, err)
+	require.False(t, inserted)
+	// A key may have several RIDs.
+	inserted, err = h.Insert(testKey(order[0]), testRID(total))
+	require.Nil(t, err)
+	require.True(t, inserted)
+	require.ElementsMatch(t, []common.RID{testRID(order[0]), testRID(total)}, h.Get(testKey(order[0])))
+	require.True(t, h.Delete(testKey(order[0]), testRID(total)))
+	_, err = h.Insert([]byte("too long"), testRID(0))
+	require.NotNil(t, err)
+	for i := 0; i < total; i++ {
+		require.Equal(t, []common.RID{testRID(i)}, h.Get(testKey(i)))
+	}
+
+	// The table can be opened again from its directory page.
+	require.Nil(t, bufferPoolManager.FlushAllPages())
+	h = OpenExtendibleHashTable(bufferPoolManager, h.DirectoryPageId())
+	require.Equal(t, 4, h.KeySize())
+	for _, i := range order {
+		require.False(t, h.Delete(testKey(i), testRID(i+1)))
+		require.True(t, h.Delete(testKey(i), testRID(i)))
+		require.Equal(t, []common.RID{}, h.Get(testKey(i)))
+	}
+	// Empty buckets are merged until a single one is left.
+	require.Equal(t, 0, globalDepth(h))
+
+	// The pages of the table are all unpinned.
+	for i := 0; i < 32; i++ {
+		_, err := bufferPoolManager.NewPage()
+		require.Nil(t, err)
+	}
+}
+
+func TestExtendibleHashTable_Clear(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(16, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	h := newExtendibleHashTable(bufferPoolManager, 4, 4)
+	fill := func() {
+		for i := 0; i < 200; i++ {
+			inserted, err := h.Insert(testKey(i), testRID(i))
+			require.Nil(t, err)
+			require.True(t, inserted)
+		}
+	}
+
+	fill()
+	h.Clear()
+	require.Equal(t, 0, globalDepth(h))
+	require.Equal(t, []common.RID{}, h.Get(testKey(1)))
+	fill()
+	require.Equal(t, []common.RID{testRID(1)}, h.Get(testKey(1)))
+
+	// A recovered table is empty.
+	h = RecoverExtendibleHashTable(bufferPoolManager, h.DirectoryPageId())
+	require.Equal(t, 0, globalDepth(h))
+	require.Equal(t, []common.RID{}, h.Get(testKey(1)))
+	fill()
+
+	h.Drop()
+	_, err := bufferPoolManager.FetchPage(h.DirectoryPageId())
+	require.NotNil(t, err)
+}
+
+func TestExtendibleHashTable_BucketFull(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	_, err := NewExtendibleHashTable(bufferPoolManager, MaxKeySize+1)
+	require.NotNil(t, err)
+	h, err := NewExtendibleHashTable(bufferPoolManager, 100)
+	require.Nil(t, err)
+
+	// The RIDs of a single key cannot be split into several buckets.
+	capacity := bucketCapacity(100)
+	for i := 0; i < capacity; i++ {
+		inserted, err := h.Insert([]byte("key"), testRID(i))
+		require.Nil(t, err)
+		require.True(t, inserted)
+	}
+	_, err = h.Insert([]byte("key"), testRID(capacity))
+	require.Equal(t, ErrBucketFull, err)
+	require.Equal(t, capacity, len(h.Get([]byte("key"))))
+	// Other keys still have room.
+	inserted, err := h.Insert([]byte("other"), testRID(0))
+	require.Nil(t, err)
+	require.True(t, inserted)
+	require.Equal(t, []common.RID{testRID(0)}, h.Get([]byte("other")))
+}
+
+func TestExtendibleHashTable_Concurrent(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(64, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	h := newExtendibleHashTable(bufferPoolManager, 4, 16)
+
+	numWorkers := 8
+	perWorker := 500
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for round := 0; round < 2; round++ {
+				for _, i := range rand.Perm(perWorker) {
+					key := i*numWorkers + w
+					inserted, err := h.Insert(testKey(key), testRID(key))
+					if err != nil || !inserted {
+						panic(fmt.Sprintf("Cannot insert %d.", key))
+					}
+				}
+				// Delete everything in the first round, so that buckets are
+				// merged while others split them.
+				for _, i := range rand.Perm(perWorker) {
+					key := i*numWorkers + w
+					if (round == 0 || key%3 == 0) && !h.Delete(testKey(key), testRID(key)) {
+						panic(fmt.Sprintf("Cannot delete %d.", key))
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for key := 0; key < numWorkers*perWorker; key++ {
+		if key%3 == 0 {
+			require.Equal(t, []common.RID{}, h.Get(testKey(key)))
+		} else {
+			require.Equal(t, []common.RID{testRID(key)}, h.Get(testKey(key)))
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+
+	"simple-db-golang/src/types"
+)
+
+// Statement is a parsed statement: a `*CreateTableStatement`,
+// `*DropTableStatement`, `*InsertStatement`, `*SelectStatement`,
+// `*UpdateStatement` or `*DeleteStatement`.
+type Statement interface {
+	// Position of the first token.
+	Pos() Pos
+	statementNode()
+}
+
+// Expr is a parsed expression: a `*Literal`, `*ColumnRef`, `*UnaryExpr`,
+// `*BinaryExpr`, `*IsNullExpr` or `*FunctionCall`.
+type Expr interface {
+	// Position of the first token.
+	Pos() Pos
+	// The expression in SQL, with every operation in parentheses.
+	String() string
+	exprNode()
+}
+
+// CreateTableStatement is `CREATE TABLE [IF NOT EXISTS] name (column type
+// [NOT NULL | NULL], ...)`. Columns are nullable unless declared NOT NULL.
+type CreateTableStatement struct {
+	Start       Pos
+	Name        string
+	IfNotExists bool
+	Columns     []types.Column
+}
+
+// DropTableStatement is `DROP TABLE [IF EXISTS] name`.
+type DropTableStatement struct {
+	Start    Pos
+	Name     string
+	IfExists bool
+}
+
+// InsertStatement is `INSERT INTO table [(column, ...)] VALUES (value, ...),
+// ...`.
+type InsertStatement struct {
+	Start Pos
+	Table TableRef
+	// Nil if the values are given for every column, in order.
+	Columns []string
+	Rows    [][]Expr
+}
+
+// SelectStatement is `SELECT items [FROM table [joins]] [WHERE condition]
+// [GROUP BY expressions [HAVING condition]] [ORDER BY expressions] [LIMIT count
+// [OFFSET count]]`.
+type SelectStatement struct {
+	Start Pos
+	Items []SelectItem
+	// Nil without a FROM clause, in which case there are no joins.
+	From    *TableRef
+	Joins   []Join
+	Where   Expr // Nil if there is none, like Having.
+	GroupBy []Expr
+	Having  Expr
+	OrderBy []OrderItem
+	Limit   int64 // -1 if there is none.
+	Offset  int64
+}
+
+// SelectItem is either `expression [[AS] alias]`, or `*` or `table.*` for all
+// the columns of the tables, or of one.
+type SelectItem struct {
+	Start Pos
+	Star  bool
+	Table string // Of `table.*`.
+	Expr  Expr
+	Alias string
+}
+
+// TableRef is `table [[AS] alias]`.
+type TableRef struct {
+	Start Pos
+	Name  string
+	Alias string
+}
+
+// The name the columns of the table are qualified with.
+func (t *TableRef) RefName() string {
+	if t.Alias != "" {
+		return t.Alias
+	}
+	return t.Name
+}
+
+type JoinKind uint8
+
+const (
+	InnerJoin JoinKind = iota // `[INNER] JOIN table ON condition`.
+	LeftJoin                  // `LEFT [OUTER] JOIN table ON condition`.
+	CrossJoin                 // `CROSS JOIN table`, or `, table`.
+)
+
+func (k JoinKind) String() string {
+	switch k {
+	case InnerJoin:
+		return "INNER JOIN"
+	case LeftJoin:
+		return "LEFT JOIN"
+	default:
+		return "CROSS JOIN"
+	}
+}
+
+// Join joins a table to those before it in the FROM clause.
+type Join struct {
+	Start Pos
+	Kind  JoinKind
+	Table TableRef
+	On    Expr // Nil for a cross join.
+}
+
+// OrderItem is `expression [ASC | DESC]`.
+type OrderItem struct {
+	Expr Expr
+	Desc bool
+}
+
+// UpdateStatement is `UPDATE table SET column = value, ... [WHERE
+// condition]`.
+type UpdateStatement struct {
+	Start       Pos
+	Table       TableRef
+	Assignments []Assignment
+	Where       Expr // Nil if there is none.
+}
+
+type Assignment struct {
+	Start  Pos
+	Column string
+	Value  Expr
+}
+
+// DeleteStatement is `DELETE FROM table [WHERE condition]`.
+type DeleteStatement struct {
+	Start Pos
+	Table TableRef
+	Where Expr // Nil if there is none.
+}
+
+func (s *CreateTableStatement) Pos() Pos { return s.Start }
+func (s *DropTableStatement) Pos() Pos   { return s.Start }
+func (s *InsertStatement) Pos() Pos      { return s.Start }
+func (s *SelectStatement) Pos() Pos      { return s.Start }
+func (s *UpdateStatement) Pos() Pos      { return s.Start }
+func (s *DeleteStatement) Pos() Pos      { return s.Start }
+
+func (*CreateTableStatement) statementNode() {}
+func (*DropTableStatement) statementNode()   {}
+func (*InsertStatement) statementNode()      {}
+func (*SelectStatement) statementNode()      {}
+func (*UpdateStatement) statementNode()      {}
+func (*DeleteStatement) statementNode()      {}
+
+// Literal is a constant: a number, a string, TRUE, FALSE, NULL, or
+// `TIMESTAMP 'yyyy-mm-dd hh:mm:ss'`. Integers are INTEGER values if they fit,
+// BIGINT values otherwise, and NULL is a NULL value of `types.InvalidType`.
+type Literal struct {
+	Start Pos
+	Value types.Value
+}
+
+// ColumnRef is `column` or `table.column`.
+type ColumnRef struct {
+	Start  Pos
+	Table  string // Empty if the column is not qualified.
+	Column string
+}
+
+type Operator uint8
+
+const (
+	OpOr Operator = iota
+	OpAnd
+	OpNot
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpLessOrEqual
+	OpGreater
+	OpGreaterOrEqual
+	OpAdd
+	OpSubtract
+	OpMultiply
+	OpDivide
+	OpModulo
+	OpNegate
+)
+
+func (o Operator) String() string {
+	return [...]string{"OR", "AND", "NOT", "=", "<>", "<", "<=", ">", ">=", "+", "-", "*", "/", "%", "-"}[o]
+}
+
+// UnaryExpr is `NOT operand` or `-operand`.
+type UnaryExpr struct {
+	Start   Pos
+	Op      Operator
+	Operand Expr
+}
+
+type BinaryExpr struct {
+	Start Pos
+	Op    Operator
+	Left  Expr
+	Right Expr
+}
+
+// IsNullExpr is `operand IS [NOT] NULL`.
+type IsNullExpr struct {
+	Start   Pos
+	Operand Expr
+	Not     bool
+}
+
+// FunctionCall is `name([DISTINCT] argument, ...)` or `name(*)`. The name is in
+// upper case.
+type FunctionCall struct {
+	Start    Pos
+	Name     string
+	Distinct bool
+	Star     bool
+	Args     []Expr
+}
+
+func (e *Literal) Pos() Pos      { return e.Start }
+func (e *ColumnRef) Pos() Pos    { return e.Start }
+func (e *UnaryExpr) Pos() Pos    { return e.Start }
+func (e *BinaryExpr) Pos() Pos   { return e.Start }
+func (e *IsNullExpr) Pos() Pos   { return e.Start }
+func (e *FunctionCall) Pos() Pos { return e.Start }
+
+func (*Literal) exprNode()      {}
+func (*ColumnRef) exprNode()    {}
+func (*UnaryExpr) exprNode()    {}
+func (*BinaryExpr) exprNode()   {}
+func (*IsNullExpr) exprNode()   {}
+func (*FunctionCall) exprNode() {}
+
+func (e *Literal) String() string {
+	switch {
+	case e.Value.IsNull():
+		return "NULL"
+	case e.Value.Type() == types.Varchar:
+		return quoteString(e.Value.AsVarchar())
+	case e.Value.Type() == types.Timestamp:
+		return "TIMESTAMP " + quoteString(e.Value.String())
+	default:
+		return strings.ToUpper(e.Value.String())
+	}
+}
+
+func (e *ColumnRef) String() string {
+	if e.Table != "" {
+		return e.Table + "." + e.Column
+	}
+	return e.Column
+}
+
+func (e *UnaryExpr) String() string {
+	if e.Op == OpNot {
+		return fmt.Sprintf("(NOT %s)", e.Operand)
+	}
+	return fmt.Sprintf("(-%s)", e.Operand)
+}
+
+func (e *BinaryExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.Left, e.Op, e.Right)
+}
+
+func (e *IsNullExpr) String() string {
+	if e.Not {
+		return fmt.Sprintf("(%s IS NOT NULL)", e.Operand)
+	}
+	return fmt.Sprintf("(%s IS NULL)", e.Operand)
+}
+
+func (e *FunctionCall) String() string {
+	if e.Star {
+		return e.Name + "(*)"
+	}
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.String()
+	}
+	distinct := ""
+	if e.Distinct {
+		distinct = "DISTINCT "
+	}
+	return fmt.Sprintf("%s(%s%s)", e.Name, distinct, strings.Join(args, ", "))
+}
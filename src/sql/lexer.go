@@ -0,0 +1,283 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Pos is a position in the text of a statement. Lines and columns start at 1,
+// and columns count characters.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// Error is a syntax error at a position of the text of a statement.
+type Error struct {
+	Pos     Pos
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Line %d, column %d: %s", e.Pos.Line, e.Pos.Column, e.Message)
+}
+
+func errorAt(pos Pos, format string, args ...interface{}) error {
+	return &Error{Pos: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+type TokenKind uint8
+
+const (
+	TokenEOF TokenKind = iota
+	TokenIdentifier
+	TokenKeyword
+	TokenNumber
+	TokenString
+	TokenSymbol
+)
+
+// Token is a word of a statement. Keywords are in upper case, unquoted
+// identifiers in lower case, and strings and quoted identifiers are unquoted.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  Pos
+}
+
+// Describe the token in an error message.
+func (t Token) String() string {
+	switch t.Kind {
+	case TokenEOF:
+		return "the end of the input"
+	case TokenKeyword:
+		return t.Text
+	case TokenString:
+		return quoteString(t.Text)
+	default:
+		return fmt.Sprintf("%q", t.Text)
+	}
+}
+
+// Reserved words, which cannot be used as identifiers unless quoted. Type and
+// function names are identifiers.
+var keywords = map[string]bool{
+	"AND": true, "AS": true, "ASC": true, "BY": true, "CREATE": true,
+	"CROSS": true, "DELETE": true, "DESC": true, "DISTINCT": true,
+	"DROP": true, "EXISTS": true, "FALSE": true, "FROM": true, "GROUP": true,
+	"HAVING": true, "IF": true, "INNER": true, "INSERT": true, "INTO": true,
+	"IS": true, "JOIN": true, "LEFT": true, "LIMIT": true, "NOT": true,
+	"NULL": true, "OFFSET": true, "ON": true, "OR": true, "ORDER": true,
+	"OUTER": true, "SELECT": true, "SET": true, "TABLE": true, "TRUE": true,
+	"UPDATE": true, "VALUES": true, "WHERE": true,
+}
+
+// Symbols of two characters, tried before those of one.
+var symbols = []string{"<>", "!=", "<=", ">=", "(", ")", ",", ";", ".", "*", "+", "-", "/", "%", "=", "<", ">"}
+
+type lexer struct {
+	input  []rune
+	offset int
+	pos    Pos
+}
+
+// Lex splits the text of statements into tokens, ending with a `TokenEOF`.
+// Comments, either `-- ...` to the end of the line or `/* ... */`, are
+// skipped along with white space.
+func Lex(text string) ([]Token, error) {
+	l := &lexer{input: []rune(text), pos: Pos{Line: 1, Column: 1}}
+	tokens := make([]Token, 0)
+	for {
+		token, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		if token.Kind == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// The character `n` characters ahead, or 0 past the end of the input.
+func (l *lexer) peek(n int) rune {
+	if l.offset+n < len(l.input) {
+		return l.input[l.offset+n]
+	}
+	return 0
+}
+
+func (l *lexer) atEnd() bool {
+	return l.offset >= len(l.input)
+}
+
+func (l *lexer) advance() rune {
+	r := l.input[l.offset]
+	l.offset++
+	if r == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+	return r
+}
+
+func (l *lexer) skipSpaceAndComments() error {
+	for !l.atEnd() {
+		switch r := l.peek(0); {
+		case unicode.IsSpace(r):
+			l.advance()
+		case r == '-' && l.peek(1) == '-':
+			for !l.atEnd() && l.peek(0) != '\n' {
+				l.advance()
+			}
+		case r == '/' && l.peek(1) == '*':
+			start := l.pos
+			l.advance()
+			l.advance()
+			for !(l.peek(0) == '*' && l.peek(1) == '/') {
+				if l.atEnd() {
+					return errorAt(start, "Unterminated comment.")
+				}
+				l.advance()
+			}
+			l.advance()
+			l.advance()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) next() (Token, error) {
+	if err := l.skipSpaceAndComments(); err != nil {
+		return Token{}, err
+	}
+	start := l.pos
+	if l.atEnd() {
+		return Token{Kind: TokenEOF, Pos: start}, nil
+	}
+	r := l.peek(0)
+	switch {
+	case isIdentifierStart(r):
+		var b strings.Builder
+		for !l.atEnd() && isIdentifierPart(l.peek(0)) {
+			b.WriteRune(l.advance())
+		}
+		if word := strings.ToUpper(b.String()); keywords[word] {
+			return Token{Kind: TokenKeyword, Text: word, Pos: start}, nil
+		}
+		return Token{Kind: TokenIdentifier, Text: strings.ToLower(b.String()), Pos: start}, nil
+	case r == '"':
+		text, err := l.quoted('"')
+		if err != nil {
+			return Token{}, err
+		}
+		if text == "" {
+			return Token{}, errorAt(start, "Empty quoted identifier.")
+		}
+		return Token{Kind: TokenIdentifier, Text: text, Pos: start}, nil
+	case r == '\'':
+		text, err := l.quoted('\'')
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Kind: TokenString, Text: text, Pos: start}, nil
+	case isDigit(r) || (r == '.' && isDigit(l.peek(1))):
+		return l.number()
+	}
+	for _, symbol := range symbols {
+		if l.hasPrefix(symbol) {
+			for range symbol {
+				l.advance()
+			}
+			return Token{Kind: TokenSymbol, Text: symbol, Pos: start}, nil
+		}
+	}
+	return Token{}, errorAt(start, "Unexpected character %q.", r)
+}
+
+func (l *lexer) hasPrefix(s string) bool {
+	for i, r := range []rune(s) {
+		if l.offset+i >= len(l.input) || l.input[l.offset+i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// Read text between `quote`s, where a doubled quote stands for itself.
+func (l *lexer) quoted(quote rune) (string, error) {
+	start := l.pos
+	l.advance()
+	var b strings.Builder
+	for {
+		if l.atEnd() {
+			if quote == '\'' {
+				return "", errorAt(start, "Unterminated string.")
+			}
+			return "", errorAt(start, "Unterminated quoted identifier.")
+		}
+		r := l.advance()
+		if r == quote {
+			if l.peek(0) != quote {
+				return b.String(), nil
+			}
+			l.advance()
+		}
+		b.WriteRune(r)
+	}
+}
+
+// Read a number: digits with an optional fraction and exponent.
+func (l *lexer) number() (Token, error) {
+	start := l.pos
+	var b strings.Builder
+	digits := func() {
+		for !l.atEnd() && isDigit(l.peek(0)) {
+			b.WriteRune(l.advance())
+		}
+	}
+	digits()
+	if l.peek(0) == '.' {
+		b.WriteRune(l.advance())
+		digits()
+	}
+	if r := l.peek(0); r == 'e' || r == 'E' {
+		b.WriteRune(l.advance())
+		if r := l.peek(0); r == '+' || r == '-' {
+			b.WriteRune(l.advance())
+		}
+		if !isDigit(l.peek(0)) {
+			return Token{}, errorAt(start, "Invalid number %s.", b.String())
+		}
+		digits()
+	}
+	if isIdentifierPart(l.peek(0)) {
+		return Token{}, errorAt(start, "Invalid number %s%c.", b.String(), l.peek(0))
+	}
+	return Token{Kind: TokenNumber, Text: b.String(), Pos: start}, nil
+}
+
+func isIdentifierStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentifierPart(r rune) bool {
+	return isIdentifierStart(r) || unicode.IsDigit(r)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func quoteString(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", "''") + "'"
+}
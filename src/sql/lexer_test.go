@@ -0,0 +1,51 @@
+package sql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLex(t *testing.T) {
+	tokens, err := Lex("SELECT Name, \"Mixed Case\" -- comment\nFROM t\n  WHERE x<>'it''s' /* a\nb */ AND y >= 1.5e3;")
+	require.Nil(t, err)
+	require.Equal(t, []Token{
+		{Kind: TokenKeyword, Text: "SELECT", Pos: Pos{1, 1}},
+		{Kind: TokenIdentifier, Text: "name", Pos: Pos{1, 8}},
+		{Kind: TokenSymbol, Text: ",", Pos: Pos{1, 12}},
+		{Kind: TokenIdentifier, Text: "Mixed Case", Pos: Pos{1, 14}},
+		{Kind: TokenKeyword, Text: "FROM", Pos: Pos{2, 1}},
+		{Kind: TokenIdentifier, Text: "t", Pos: Pos{2, 6}},
+		{Kind: TokenKeyword, Text: "WHERE", Pos: Pos{3, 3}},
+		{Kind: TokenIdentifier, Text: "x", Pos: Pos{3, 9}},
+		{Kind: TokenSymbol, Text: "<>", Pos: Pos{3, 10}},
+		{Kind: TokenString, Text: "it's", Pos: Pos{3, 12}},
+		{Kind: TokenKeyword, Text: "AND", Pos: Pos{4, 6}},
+		{Kind: TokenIdentifier, Text: "y", Pos: Pos{4, 10}},
+		{Kind: TokenSymbol, Text: ">=", Pos: Pos{4, 12}},
+		{Kind: TokenNumber, Text: "1.5e3", Pos: Pos{4, 15}},
+		{Kind: TokenSymbol, Text: ";", Pos: Pos{4, 20}},
+		{Kind: TokenEOF, Pos: Pos{4, 21}},
+	}, tokens)
+
+	// Columns count characters, not bytes.
+	tokens, err = Lex("'héllo' .5")
+	require.Nil(t, err)
+	require.Equal(t, Token{Kind: TokenNumber, Text: ".5", Pos: Pos{1, 9}}, tokens[1])
+}
+
+func TestLex_Errors(t *testing.T) {
+	for text, expected := range map[string]string{
+		"SELECT 'abc":     "Line 1, column 8: Unterminated string.",
+		"SELECT \"abc":    "Line 1, column 8: Unterminated quoted identifier.",
+		"SELECT \"\"":     "Line 1, column 8: Empty quoted identifier.",
+		"SELECT\n  /* x":  "Line 2, column 3: Unterminated comment.",
+		"SELECT 1 # 2":    "Line 1, column 10: Unexpected character '#'.",
+		"SELECT 12abc":    "Line 1, column 8: Invalid number 12a.",
+		"SELECT 1e+ FROM": "Line 1, column 8: Invalid number 1e+.",
+	} {
+		_, err := Lex(text)
+		require.NotNil(t, err, text)
+		require.Equal(t, expected, err.Error())
+	}
+}
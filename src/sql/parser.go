@@ -0,0 +1,800 @@
+package sql
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"simple-db-golang/src/types"
+)
+
+type parser struct {
+	tokens []Token
+	i      int
+}
+
+// Parse a single statement, optionally followed by a semicolon.
+func Parse(text string) (Statement, error) {
+	p, err := newParser(text)
+	if err != nil {
+		return nil, err
+	}
+	statement, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptSymbol(";")
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+// Parse statements separated by semicolons.
+func ParseScript(text string) ([]Statement, error) {
+	p, err := newParser(text)
+	if err != nil {
+		return nil, err
+	}
+	statements := make([]Statement, 0)
+	for {
+		for p.acceptSymbol(";") {
+		}
+		if p.peek().Kind == TokenEOF {
+			return statements, nil
+		}
+		statement, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+		if !p.acceptSymbol(";") {
+			if err := p.expectEOF(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func newParser(text string) (*parser, error) {
+	tokens, err := Lex(text)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{tokens: tokens}, nil
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.i]
+}
+
+// The token after the next one.
+func (p *parser) peekSecond() Token {
+	if p.i+1 < len(p.tokens) {
+		return p.tokens[p.i+1]
+	}
+	return p.tokens[p.i]
+}
+
+// Consume the next token. The final `TokenEOF` is never consumed.
+func (p *parser) next() Token {
+	token := p.tokens[p.i]
+	if token.Kind != TokenEOF {
+		p.i++
+	}
+	return token
+}
+
+// An error for the next token, which is not `expected`.
+func (p *parser) unexpected(expected string) error {
+	token := p.peek()
+	return errorAt(token.Pos, "Expected %s, found %s.", expected, token)
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.Kind == TokenKeyword && token.Text == keyword
+}
+
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.unexpected(keyword)
+	}
+	return nil
+}
+
+func (p *parser) isSymbol(symbol string) bool {
+	token := p.peek()
+	return token.Kind == TokenSymbol && token.Text == symbol
+}
+
+func (p *parser) acceptSymbol(symbol string) bool {
+	if p.isSymbol(symbol) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return p.unexpected(`"` + symbol + `"`)
+	}
+	return nil
+}
+
+// Consume an identifier, described as `what` in errors.
+func (p *parser) expectIdentifier(what string) (string, error) {
+	if p.peek().Kind != TokenIdentifier {
+		return "", p.unexpected(what)
+	}
+	return p.next().Text, nil
+}
+
+func (p *parser) expectEOF() error {
+	if p.peek().Kind != TokenEOF {
+		return p.unexpected("the end of the statement")
+	}
+	return nil
+}
+
+func (p *parser) parseStatement() (Statement, error) {
+	switch {
+	case p.isKeyword("CREATE"):
+		return p.parseCreateTable()
+	case p.isKeyword("DROP"):
+		return p.parseDropTable()
+	case p.isKeyword("INSERT"):
+		return p.parseInsert()
+	case p.isKeyword("SELECT"):
+		return p.parseSelect()
+	case p.isKeyword("UPDATE"):
+		return p.parseUpdate()
+	case p.isKeyword("DELETE"):
+		return p.parseDelete()
+	default:
+		return nil, p.unexpected("a statement")
+	}
+}
+
+func (p *parser) parseCreateTable() (*CreateTableStatement, error) {
+	s := &CreateTableStatement{Start: p.next().Pos}
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("NOT"); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+		s.IfNotExists = true
+	}
+	var err error
+	if s.Name, err = p.expectIdentifier("a table name"); err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		column, err := p.parseColumn()
+		if err != nil {
+			return nil, err
+		}
+		s.Columns = append(s.Columns, column)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+var typeNames = map[string]types.TypeId{
+	"int":       types.Integer,
+	"integer":   types.Integer,
+	"bigint":    types.BigInt,
+	"double":    types.Double,
+	"float":     types.Double,
+	"bool":      types.Boolean,
+	"boolean":   types.Boolean,
+	"varchar":   types.Varchar,
+	"timestamp": types.Timestamp,
+}
+
+// Parse `name type [(length)] [NOT NULL | NULL]`.
+func (p *parser) parseColumn() (types.Column, error) {
+	column := types.Column{Nullable: true}
+	var err error
+	if column.Name, err = p.expectIdentifier("a column name"); err != nil {
+		return column, err
+	}
+	typeToken := p.peek()
+	if typeToken.Kind != TokenIdentifier {
+		return column, p.unexpected("a type")
+	}
+	p.next()
+	var ok bool
+	if column.Type, ok = typeNames[typeToken.Text]; !ok {
+		return column, errorAt(typeToken.Pos, "Unknown type %s.", typeToken.Text)
+	}
+	if column.Type == types.Varchar {
+		if err := p.expectSymbol("("); err != nil {
+			return column, err
+		}
+		lengthToken := p.peek()
+		if lengthToken.Kind != TokenNumber {
+			return column, p.unexpected("a length")
+		}
+		p.next()
+		length, err := strconv.ParseInt(lengthToken.Text, 10, 32)
+		if err != nil || length <= 0 {
+			return column, errorAt(lengthToken.Pos, "Invalid length %s.", lengthToken.Text)
+		}
+		column.Length = int(length)
+		if err := p.expectSymbol(")"); err != nil {
+			return column, err
+		}
+	}
+	if p.acceptKeyword("NOT") {
+		if err := p.expectKeyword("NULL"); err != nil {
+			return column, err
+		}
+		column.Nullable = false
+	} else {
+		p.acceptKeyword("NULL")
+	}
+	return column, nil
+}
+
+func (p *parser) parseDropTable() (*DropTableStatement, error) {
+	s := &DropTableStatement{Start: p.next().Pos}
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+		s.IfExists = true
+	}
+	var err error
+	if s.Name, err = p.expectIdentifier("a table name"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *parser) parseInsert() (*InsertStatement, error) {
+	s := &InsertStatement{Start: p.next().Pos}
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	table, err := p.parseTableRef(false)
+	if err != nil {
+		return nil, err
+	}
+	s.Table = table
+	if p.acceptSymbol("(") {
+		for {
+			column, err := p.expectIdentifier("a column name")
+			if err != nil {
+				return nil, err
+			}
+			s.Columns = append(s.Columns, column)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		row, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		s.Rows = append(s.Rows, row)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) parseSelect() (*SelectStatement, error) {
+	s := &SelectStatement{Start: p.next().Pos, Limit: -1}
+	for {
+		item, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+		s.Items = append(s.Items, item)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if p.acceptKeyword("FROM") {
+		from, err := p.parseTableRef(true)
+		if err != nil {
+			return nil, err
+		}
+		s.From = &from
+		for {
+			join, ok, err := p.parseJoin()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			s.Joins = append(s.Joins, join)
+		}
+	}
+	var err error
+	if p.acceptKeyword("WHERE") {
+		if s.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if s.GroupBy, err = p.parseExprList(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("HAVING") {
+			if s.Having, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := OrderItem{Expr: expr}
+			if p.acceptKeyword("DESC") {
+				item.Desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			s.OrderBy = append(s.OrderBy, item)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		if s.Limit, err = p.parseCount(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("OFFSET") {
+			if s.Offset, err = p.parseCount(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) parseSelectItem() (SelectItem, error) {
+	item := SelectItem{Start: p.peek().Pos}
+	if p.acceptSymbol("*") {
+		item.Star = true
+		return item, nil
+	}
+	if p.peek().Kind == TokenIdentifier && p.peekSecond().Kind == TokenSymbol && p.peekSecond().Text == "." &&
+		p.i+2 < len(p.tokens) && p.tokens[p.i+2].Kind == TokenSymbol && p.tokens[p.i+2].Text == "*" {
+		item.Star = true
+		item.Table = p.next().Text
+		p.next()
+		p.next()
+		return item, nil
+	}
+	var err error
+	if item.Expr, err = p.parseExpr(); err != nil {
+		return item, err
+	}
+	item.Alias, err = p.parseAlias()
+	return item, err
+}
+
+// Parse `[AS] alias`, or nothing.
+func (p *parser) parseAlias() (string, error) {
+	if p.acceptKeyword("AS") {
+		return p.expectIdentifier("an alias")
+	}
+	if p.peek().Kind == TokenIdentifier {
+		return p.next().Text, nil
+	}
+	return "", nil
+}
+
+// Parse `table`, followed by `[AS] alias` if `withAlias`.
+func (p *parser) parseTableRef(withAlias bool) (TableRef, error) {
+	table := TableRef{Start: p.peek().Pos}
+	var err error
+	if table.Name, err = p.expectIdentifier("a table name"); err != nil {
+		return table, err
+	}
+	if withAlias {
+		table.Alias, err = p.parseAlias()
+	}
+	return table, err
+}
+
+// Parse a join, returning false if there is none.
+func (p *parser) parseJoin() (Join, bool, error) {
+	join := Join{Start: p.peek().Pos}
+	switch {
+	case p.acceptSymbol(","):
+		join.Kind = CrossJoin
+	case p.acceptKeyword("CROSS"):
+		join.Kind = CrossJoin
+		if err := p.expectKeyword("JOIN"); err != nil {
+			return join, false, err
+		}
+	case p.acceptKeyword("LEFT"):
+		join.Kind = LeftJoin
+		p.acceptKeyword("OUTER")
+		if err := p.expectKeyword("JOIN"); err != nil {
+			return join, false, err
+		}
+	case p.acceptKeyword("INNER"):
+		if err := p.expectKeyword("JOIN"); err != nil {
+			return join, false, err
+		}
+	case p.acceptKeyword("JOIN"):
+	default:
+		return join, false, nil
+	}
+	var err error
+	if join.Table, err = p.parseTableRef(true); err != nil {
+		return join, false, err
+	}
+	if join.Kind != CrossJoin {
+		if err := p.expectKeyword("ON"); err != nil {
+			return join, false, err
+		}
+		if join.On, err = p.parseExpr(); err != nil {
+			return join, false, err
+		}
+	}
+	return join, true, nil
+}
+
+// Parse the non-negative integer of LIMIT or OFFSET.
+func (p *parser) parseCount() (int64, error) {
+	token := p.peek()
+	if token.Kind != TokenNumber {
+		return 0, p.unexpected("a count")
+	}
+	p.next()
+	count, err := strconv.ParseInt(token.Text, 10, 64)
+	if err != nil {
+		return 0, errorAt(token.Pos, "Invalid count %s.", token.Text)
+	}
+	return count, nil
+}
+
+func (p *parser) parseUpdate() (*UpdateStatement, error) {
+	s := &UpdateStatement{Start: p.next().Pos}
+	var err error
+	if s.Table, err = p.parseTableRef(true); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		assignment := Assignment{Start: p.peek().Pos}
+		if assignment.Column, err = p.expectIdentifier("a column name"); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		if assignment.Value, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		s.Assignments = append(s.Assignments, assignment)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if p.acceptKeyword("WHERE") {
+		if s.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) parseDelete() (*DeleteStatement, error) {
+	s := &DeleteStatement{Start: p.next().Pos}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	var err error
+	if s.Table, err = p.parseTableRef(true); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("WHERE") {
+		if s.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) parseExprList() ([]Expr, error) {
+	exprs := make([]Expr, 0)
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !p.acceptSymbol(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// Operators bind, from the loosest to the tightest: OR, AND, NOT, comparisons
+// and IS [NOT] NULL, + and -, *, / and %, and the unary -.
+func (p *parser) parseExpr() (Expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Start: left.Pos(), Op: OpOr, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Start: left.Pos(), Op: OpAnd, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.isKeyword("NOT") {
+		start := p.next().Pos
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Start: start, Op: OpNot, Operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisons = map[string]Operator{
+	"=":  OpEqual,
+	"<>": OpNotEqual,
+	"!=": OpNotEqual,
+	"<":  OpLess,
+	"<=": OpLessOrEqual,
+	">":  OpGreater,
+	">=": OpGreaterOrEqual,
+}
+
+// Comparisons do not chain: `a = b = c` is an error.
+func (p *parser) parseComparison() (Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return &IsNullExpr{Start: left.Pos(), Operand: left, Not: not}, nil
+	}
+	if token := p.peek(); token.Kind == TokenSymbol {
+		if op, ok := comparisons[token.Text]; ok {
+			p.next()
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			return &BinaryExpr{Start: left.Pos(), Op: op, Left: left, Right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (Expr, error) {
+	return p.parseBinary(map[string]Operator{"+": OpAdd, "-": OpSubtract}, p.parseMultiplicative)
+}
+
+func (p *parser) parseMultiplicative() (Expr, error) {
+	return p.parseBinary(map[string]Operator{"*": OpMultiply, "/": OpDivide, "%": OpModulo}, p.parseUnary)
+}
+
+// Parse left-associative operations of `operators` on operands parsed by
+// `parseOperand`.
+func (p *parser) parseBinary(operators map[string]Operator, parseOperand func() (Expr, error)) (Expr, error) {
+	left, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token := p.peek()
+		op, ok := operators[token.Text]
+		if token.Kind != TokenSymbol || !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Start: left.Pos(), Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if !p.isSymbol("-") {
+		return p.parsePrimary()
+	}
+	start := p.next().Pos
+	if token := p.peek(); token.Kind == TokenNumber {
+		// A negative literal, so that the smallest integers fit.
+		p.next()
+		return numberLiteral(start, "-"+token.Text)
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &UnaryExpr{Start: start, Op: OpNegate, Operand: operand}, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	token := p.peek()
+	switch token.Kind {
+	case TokenNumber:
+		p.next()
+		return numberLiteral(token.Pos, token.Text)
+	case TokenString:
+		p.next()
+		return &Literal{Start: token.Pos, Value: types.NewVarchar(token.Text)}, nil
+	case TokenKeyword:
+		switch token.Text {
+		case "TRUE", "FALSE":
+			p.next()
+			return &Literal{Start: token.Pos, Value: types.NewBoolean(token.Text == "TRUE")}, nil
+		case "NULL":
+			p.next()
+			return &Literal{Start: token.Pos, Value: types.NewNull(types.InvalidType)}, nil
+		}
+	case TokenSymbol:
+		if token.Text == "(" {
+			p.next()
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
+	case TokenIdentifier:
+		p.next()
+		second := p.peek()
+		switch {
+		case second.Kind == TokenSymbol && second.Text == "(":
+			return p.parseFunctionCall(token)
+		case second.Kind == TokenString && token.Text == "timestamp":
+			p.next()
+			value, err := types.ParseTimestamp(second.Text)
+			if err != nil {
+				return nil, errorAt(second.Pos, "Invalid timestamp %s.", second)
+			}
+			return &Literal{Start: token.Pos, Value: value}, nil
+		case p.acceptSymbol("."):
+			column, err := p.expectIdentifier("a column name")
+			if err != nil {
+				return nil, err
+			}
+			return &ColumnRef{Start: token.Pos, Table: token.Text, Column: column}, nil
+		default:
+			return &ColumnRef{Start: token.Pos, Column: token.Text}, nil
+		}
+	}
+	return nil, p.unexpected("an expression")
+}
+
+// Parse the arguments of a call to the function `name`, after its name.
+func (p *parser) parseFunctionCall(name Token) (Expr, error) {
+	p.next()
+	call := &FunctionCall{Start: name.Pos, Name: strings.ToUpper(name.Text), Args: make([]Expr, 0)}
+	switch {
+	case p.acceptSymbol("*"):
+		call.Star = true
+	case p.isSymbol(")"):
+	default:
+		call.Distinct = p.acceptKeyword("DISTINCT")
+		args, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = args
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// A literal for the number `text`: an INTEGER or BIGINT value if it is an
+// integer, a DOUBLE value otherwise.
+func numberLiteral(start Pos, text string) (Expr, error) {
+	if strings.ContainsAny(text, ".eE") {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, errorAt(start, "Number %s is out of range.", text)
+		}
+		return &Literal{Start: start, Value: types.NewDouble(f)}, nil
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return nil, errorAt(start, "Number %s is out of range.", text)
+	}
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		return &Literal{Start: start, Value: types.NewBigInt(n)}, nil
+	}
+	return &Literal{Start: start, Value: types.NewInteger(int32(n))}, nil
+}
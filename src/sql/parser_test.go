@@ -0,0 +1,214 @@
+package sql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/types"
+)
+
+func TestParse_CreateTable(t *testing.T) {
+	statement, err := Parse("CREATE TABLE IF NOT EXISTS users (id INT NOT NULL, name VARCHAR(20), score double NULL, born TIMESTAMP);")
+	require.Nil(t, err)
+	require.Equal(t, &CreateTableStatement{
+		Start:       Pos{1, 1},
+		Name:        "users",
+		IfNotExists: true,
+		Columns: []types.Column{
+			{Name: "id", Type: types.Integer},
+			{Name: "name", Type: types.Varchar, Length: 20, Nullable: true},
+			{Name: "score", Type: types.Double, Nullable: true},
+			{Name: "born", Type: types.Timestamp, Nullable: true},
+		},
+	}, statement)
+
+	statement, err = Parse("DROP TABLE IF EXISTS users")
+	require.Nil(t, err)
+	require.Equal(t, &DropTableStatement{Start: Pos{1, 1}, Name: "users", IfExists: true}, statement)
+}
+
+func TestParse_Insert(t *testing.T) {
+	statement, err := Parse("INSERT INTO users (id, name) VALUES (1, 'a'), (-2147483648, NULL)")
+	require.Nil(t, err)
+	require.Equal(t, &InsertStatement{
+		Start:   Pos{1, 1},
+		Table:   TableRef{Start: Pos{1, 13}, Name: "users"},
+		Columns: []string{"id", "name"},
+		Rows: [][]Expr{
+			{
+				&Literal{Start: Pos{1, 38}, Value: types.NewInteger(1)},
+				&Literal{Start: Pos{1, 41}, Value: types.NewVarchar("a")},
+			},
+			{
+				&Literal{Start: Pos{1, 48}, Value: types.NewInteger(-2147483648)},
+				&Literal{Start: Pos{1, 61}, Value: types.NewNull(types.InvalidType)},
+			},
+		},
+	}, statement)
+
+	statement, err = Parse("INSERT INTO t VALUES (3000000000, 2.5, TRUE, TIMESTAMP '2020-01-02 03:04:05')")
+	require.Nil(t, err)
+	insert := statement.(*InsertStatement)
+	require.Nil(t, insert.Columns)
+	values := make([]types.Value, 0)
+	for _, expr := range insert.Rows[0] {
+		values = append(values, expr.(*Literal).Value)
+	}
+	require.Equal(t, []types.Value{
+		types.NewBigInt(3000000000),
+		types.NewDouble(2.5),
+		types.NewBoolean(true),
+		types.NewTimestamp(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)),
+	}, values)
+}
+
+func TestParse_Select(t *testing.T) {
+	statement, err := Parse(`
+		SELECT u.*, o.amount * 2 AS double_amount, COUNT(DISTINCT o.id) n, COUNT(*)
+		FROM users AS u
+		JOIN orders o ON o.user_id = u.id
+		LEFT OUTER JOIN payments p ON p.order_id = o.id, regions
+		CROSS JOIN countries
+		WHERE u.age >= 18 AND NOT u.banned OR u.name IS NOT NULL
+		GROUP BY u.id, o.amount
+		HAVING COUNT(*) > 1
+		ORDER BY n DESC, u.id ASC, o.amount
+		LIMIT 10 OFFSET 5`)
+	require.Nil(t, err)
+	s := statement.(*SelectStatement)
+	require.Equal(t, Pos{2, 3}, s.Pos())
+
+	require.Equal(t, 4, len(s.Items))
+	require.Equal(t, SelectItem{Start: Pos{2, 10}, Star: true, Table: "u"}, s.Items[0])
+	require.Equal(t, "(o.amount * 2)", s.Items[1].Expr.String())
+	require.Equal(t, "double_amount", s.Items[1].Alias)
+	require.Equal(t, "COUNT(DISTINCT o.id)", s.Items[2].Expr.String())
+	require.Equal(t, "n", s.Items[2].Alias)
+	require.Equal(t, &FunctionCall{Start: Pos{2, 70}, Name: "COUNT", Star: true, Args: []Expr{}}, s.Items[3].Expr)
+
+	require.Equal(t, &TableRef{Start: Pos{3, 8}, Name: "users", Alias: "u"}, s.From)
+	require.Equal(t, 4, len(s.Joins))
+	require.Equal(t, InnerJoin, s.Joins[0].Kind)
+	require.Equal(t, "o", s.Joins[0].Table.RefName())
+	require.Equal(t, "(o.user_id = u.id)", s.Joins[0].On.String())
+	require.Equal(t, LeftJoin, s.Joins[1].Kind)
+	require.Equal(t, "payments", s.Joins[1].Table.Name)
+	require.Equal(t, Join{Start: Pos{5, 50}, Kind: CrossJoin, Table: TableRef{Start: Pos{5, 52}, Name: "regions"}}, s.Joins[2])
+	require.Equal(t, CrossJoin, s.Joins[3].Kind)
+	require.Equal(t, "countries", s.Joins[3].Table.RefName())
+
+	require.Equal(t, "(((u.age >= 18) AND (NOT u.banned)) OR (u.name IS NOT NULL))", s.Where.String())
+	require.Equal(t, 2, len(s.GroupBy))
+	require.Equal(t, "(COUNT(*) > 1)", s.Having.String())
+	require.Equal(t, []OrderItem{
+		{Expr: &ColumnRef{Start: Pos{10, 12}, Column: "n"}, Desc: true},
+		{Expr: &ColumnRef{Start: Pos{10, 20}, Table: "u", Column: "id"}},
+		{Expr: &ColumnRef{Start: Pos{10, 30}, Table: "o", Column: "amount"}},
+	}, s.OrderBy)
+	require.Equal(t, int64(10), s.Limit)
+	require.Equal(t, int64(5), s.Offset)
+
+	statement, err = Parse("SELECT 1")
+	require.Nil(t, err)
+	s = statement.(*SelectStatement)
+	require.Nil(t, s.From)
+	require.Nil(t, s.Where)
+	require.Equal(t, int64(-1), s.Limit)
+}
+
+func TestParse_UpdateDelete(t *testing.T) {
+	statement, err := Parse("UPDATE users SET name = 'b', age = age + 1 WHERE id = 3")
+	require.Nil(t, err)
+	update := statement.(*UpdateStatement)
+	require.Equal(t, "users", update.Table.Name)
+	require.Equal(t, 2, len(update.Assignments))
+	require.Equal(t, "name", update.Assignments[0].Column)
+	require.Equal(t, "'b'", update.Assignments[0].Value.String())
+	require.Equal(t, Pos{1, 30}, update.Assignments[1].Start)
+	require.Equal(t, "(age + 1)", update.Assignments[1].Value.String())
+	require.Equal(t, "(id = 3)", update.Where.String())
+
+	statement, err = Parse("DELETE FROM users")
+	require.Nil(t, err)
+	require.Equal(t, &DeleteStatement{Start: Pos{1, 1}, Table: TableRef{Start: Pos{1, 13}, Name: "users"}}, statement)
+}
+
+func TestParse_Expressions(t *testing.T) {
+	for text, expected := range map[string]string{
+		"1 + 2 * 3 - 4":               "((1 + (2 * 3)) - 4)",
+		"(1 + 2) * 3 % 4 / 5":         "((((1 + 2) * 3) % 4) / 5)",
+		"-a - -3":                     "((-a) - -3)",
+		"- (a)":                       "(-a)",
+		"a = 1 OR b = 2 AND c <> 3":   "((a = 1) OR ((b = 2) AND (c <> 3)))",
+		"NOT a = 1 AND NOT NOT b":     "((NOT (a = 1)) AND (NOT (NOT b)))",
+		"a + 1 IS NULL":               "((a + 1) IS NULL)",
+		"a != 'x''y'":                 "(a <> 'x''y')",
+		"t.a <= 2.5 AND b >= FALSE":   "((t.a <= 2.5) AND (b >= FALSE))",
+		"lower(name, 'x') > now()":    "(LOWER(name, 'x') > NOW())",
+		"timestamp '2020-01-02' < ts": "(TIMESTAMP '2020-01-02 00:00:00' < ts)",
+		"\"Weird \"\"col\"\"\"":       "Weird \"col\"",
+	} {
+		statement, err := Parse("SELECT " + text)
+		require.Nil(t, err, text)
+		require.Equal(t, expected, statement.(*SelectStatement).Items[0].Expr.String(), text)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	statements, err := ParseScript(";CREATE TABLE t (a INT);\n;INSERT INTO t VALUES (1); SELECT * FROM t")
+	require.Nil(t, err)
+	require.Equal(t, 3, len(statements))
+	require.Equal(t, Pos{2, 2}, statements[1].Pos())
+	require.Equal(t, []SelectItem{{Start: Pos{2, 35}, Star: true}}, statements[2].(*SelectStatement).Items)
+
+	statements, err = ParseScript(" -- nothing")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(statements))
+
+	_, err = ParseScript("SELECT 1 SELECT 2")
+	require.Equal(t, "Line 1, column 10: Expected the end of the statement, found SELECT.", err.Error())
+}
+
+func TestParse_Errors(t *testing.T) {
+	for text, expected := range map[string]string{
+		"":                                  "Line 1, column 1: Expected a statement, found the end of the input.",
+		"SELEC 1":                           "Line 1, column 1: Expected a statement, found \"selec\".",
+		"SELECT 1; SELECT 2":                "Line 1, column 11: Expected the end of the statement, found SELECT.",
+		"SELECT FROM t":                     "Line 1, column 8: Expected an expression, found FROM.",
+		"SELECT a FROM":                     "Line 1, column 14: Expected a table name, found the end of the input.",
+		"SELECT a\nFROM t\nWHERE a = ":      "Line 3, column 11: Expected an expression, found the end of the input.",
+		"SELECT a FROM t WHERE a = 1 = 2":   "Line 1, column 29: Expected the end of the statement, found \"=\".",
+		"SELECT a FROM t JOIN u":            "Line 1, column 23: Expected ON, found the end of the input.",
+		"SELECT a FROM t ORDER a":           "Line 1, column 23: Expected BY, found \"a\".",
+		"SELECT a FROM t LIMIT 'x'":         "Line 1, column 23: Expected a count, found 'x'.",
+		"SELECT a FROM t LIMIT 1.5":         "Line 1, column 23: Invalid count 1.5.",
+		"SELECT count(* FROM t":             "Line 1, column 16: Expected \")\", found FROM.",
+		"SELECT (a + 1":                     "Line 1, column 14: Expected \")\", found the end of the input.",
+		"SELECT 99999999999999999999":       "Line 1, column 8: Number 99999999999999999999 is out of range.",
+		"SELECT TIMESTAMP 'noon'":           "Line 1, column 18: Invalid timestamp 'noon'.",
+		"CREATE TABLE t (a BLOB)":           "Line 1, column 19: Unknown type blob.",
+		"CREATE TABLE t (a VARCHAR)":        "Line 1, column 26: Expected \"(\", found \")\".",
+		"CREATE TABLE t (a VARCHAR(0))":     "Line 1, column 27: Invalid length 0.",
+		"CREATE TABLE t (select INT)":       "Line 1, column 17: Expected a column name, found SELECT.",
+		"CREATE TABLE t (a INT NOT)":        "Line 1, column 26: Expected NULL, found \")\".",
+		"CREATE TABLE t ()":                 "Line 1, column 17: Expected a column name, found \")\".",
+		"DROP t":                            "Line 1, column 6: Expected TABLE, found \"t\".",
+		"INSERT INTO t (a) VALUES (1":       "Line 1, column 28: Expected \")\", found the end of the input.",
+		"INSERT INTO t VALUES":              "Line 1, column 21: Expected \"(\", found the end of the input.",
+		"UPDATE t SET a 1":                  "Line 1, column 16: Expected \"=\", found \"1\".",
+		"DELETE t":                          "Line 1, column 8: Expected FROM, found \"t\".",
+		"SELECT 'unterminated":              "Line 1, column 8: Unterminated string.",
+		"SELECT a FROM t GROUP BY a HAVING": "Line 1, column 34: Expected an expression, found the end of the input.",
+		"SELECT a FROM t LEFT t2 ON a = b":  "Line 1, column 22: Expected JOIN, found \"t2\".",
+		"SELECT a AS FROM t":                "Line 1, column 13: Expected an alias, found FROM.",
+		"SELECT t. FROM t":                  "Line 1, column 11: Expected a column name, found FROM.",
+	} {
+		_, err := Parse(text)
+		require.NotNil(t, err, text)
+		require.Equal(t, expected, err.Error(), text)
+		_, ok := err.(*Error)
+		require.True(t, ok)
+	}
+}
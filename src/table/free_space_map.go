@@ -0,0 +1,323 @@
+package table
+
+import (
+	"math"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/wal"
+)
+
+// The free space map of a table heap lists its table pages, and roughly how
+// much room each of them has left, so that inserts find a page with enough
+// room without looking at every page. It is a tree of map pages under the
+// header page:
+//   - A map page has up to `freeSpaceMapFanout` entries. The entries of a leaf
+//     are table pages, the entries of an inner map page are map pages of the
+//     level below.
+//   - Each map page keeps a max-tree over the free space categories of its
+//     entries, see `freeSpaceCategory`. The category of a map page in its
+//     parent is the root of its max-tree, so a page with enough room is found
+//     in `log2(freeSpaceMapFanout)` steps per level.
+//   - Table pages are numbered in the order they are added, and the digits of
+//     their index in base `freeSpaceMapFanout` are the path to their entry.
+//     The tree grows a new root once it is full, so three levels are enough
+//     for over a hundred million pages.
+//
+// The whole map is guarded by the latch of the header page. Adding pages is
+// logged with PageWriteRecords, while free space is not logged at all: it is
+// recomputed when the table heap is opened.
+const (
+	freeSpaceMapFanout = 512
+
+	// Free space is tracked in units of this many bytes, rounded down, so that
+	// the category of any page fits in a byte.
+	freeSpaceUnit = 16
+)
+
+type freeSpaceMapPage struct {
+	level   int32 // 0 for leaves.
+	pageIds [freeSpaceMapFanout]common.PageId
+	// Entry i is node `freeSpaceMapFanout-1+i`, and node j is the max of nodes
+	// 2j+1 and 2j+2. Unused entries are 0.
+	tree [2*freeSpaceMapFanout - 1]uint8
+}
+
+func createFreeSpaceMapPage(data []byte) *freeSpaceMapPage {
+	return (*freeSpaceMapPage)(unsafe.Pointer(&data[0]))
+}
+
+func (mp *freeSpaceMapPage) init(level int32, firstPageId common.PageId) {
+	mp.level = level
+	for i := range mp.pageIds {
+		mp.pageIds[i] = common.InvalidPageId
+	}
+	mp.pageIds[0] = firstPageId
+	for i := range mp.tree {
+		mp.tree[i] = 0
+	}
+}
+
+// The highest category of the entries.
+func (mp *freeSpaceMapPage) maxCategory() uint8 {
+	return mp.tree[0]
+}
+
+func (mp *freeSpaceMapPage) setCategory(slot int, category uint8) {
+	node := freeSpaceMapFanout - 1 + slot
+	mp.tree[node] = category
+	for node > 0 {
+		node = (node - 1) / 2
+		left, right := mp.tree[2*node+1], mp.tree[2*node+2]
+		if left < right {
+			left = right
+		}
+		mp.tree[node] = left
+	}
+}
+
+// Call `visit` on the entries of at least `category` below `node`, from left
+// to right, until it succeeds. Subtrees without such entries are skipped.
+func (mp *freeSpaceMapPage) search(node int, category uint8, visit func(slot int) (common.PageId, bool)) (common.PageId, bool) {
+	if mp.tree[node] < category {
+		return common.InvalidPageId, false
+	}
+	if node >= freeSpaceMapFanout-1 {
+		return visit(node - (freeSpaceMapFanout - 1))
+	}
+	if pageId, ok := mp.search(2*node+1, category, visit); ok {
+		return pageId, true
+	}
+	return mp.search(2*node+2, category, visit)
+}
+
+// The category of a page with `freeSpace` bytes left for an insert. A page
+// has at least `freeSpaceUnit` times its category left.
+func freeSpaceCategory(freeSpace int32) uint8 {
+	if freeSpace <= 0 {
+		return 0
+	}
+	if freeSpace/freeSpaceUnit > math.MaxUint8 {
+		return math.MaxUint8
+	}
+	return uint8(freeSpace / freeSpaceUnit)
+}
+
+// freeSpaceMap gives access to the free space map of a table heap while its
+// header page is latched.
+type freeSpaceMap struct {
+	tableHeap  *TableHeap
+	headerPage *disk.Page
+	header     *heapFileHeader
+}
+
+func (th *TableHeap) getFreeSpaceMap(headerPage *disk.Page) *freeSpaceMap {
+	return &freeSpaceMap{
+		tableHeap:  th,
+		headerPage: headerPage,
+		header:     createHeapFileHeader(headerPage.Data()),
+	}
+}
+
+func (fsm *freeSpaceMap) numPages() int {
+	return int(fsm.header.numPages)
+}
+
+// Position of the entry of the `index`-th table page in its map page at
+// `level`.
+func freeSpaceMapSlot(index int, level int32) int {
+	for ; level > 0; level-- {
+		index /= freeSpaceMapFanout
+	}
+	return index % freeSpaceMapFanout
+}
+
+// The number of table pages a tree with `depth` levels below the root holds.
+func freeSpaceMapCapacity(depth int32) int {
+	capacity := freeSpaceMapFanout
+	for ; depth > 0; depth-- {
+		capacity *= freeSpaceMapFanout
+	}
+	return capacity
+}
+
+func (fsm *freeSpaceMap) fetchMapPage(pageId common.PageId) (*disk.Page, *freeSpaceMapPage) {
+	page, err := fsm.tableHeap.bufferPoolManager.FetchPage(pageId)
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot fetch free space map page %d.", pageId)
+	}
+	return page, createFreeSpaceMapPage(page.Data())
+}
+
+func (fsm *freeSpaceMap) unpinMapPage(page *disk.Page, isDirty bool) {
+	fsm.tableHeap.bufferPoolManager.UnpinPage(page.PageId(), isDirty)
+}
+
+// The `index`-th table page.
+func (fsm *freeSpaceMap) pageId(index int) common.PageId {
+	pageId := fsm.header.rootPageId
+	for level := fsm.header.depth; level >= 0; level-- {
+		page, mp := fsm.fetchMapPage(pageId)
+		pageId = mp.pageIds[freeSpaceMapSlot(index, level)]
+		fsm.unpinMapPage(page, false)
+	}
+	return pageId
+}
+
+// All table pages, in the order they were added.
+func (fsm *freeSpaceMap) pageIds() []common.PageId {
+	pageIds := make([]common.PageId, 0, fsm.numPages())
+	if fsm.numPages() > 0 {
+		fsm.collectPageIds(fsm.header.rootPageId, &pageIds)
+	}
+	return pageIds
+}
+
+func (fsm *freeSpaceMap) collectPageIds(mapPageId common.PageId, pageIds *[]common.PageId) {
+	page, mp := fsm.fetchMapPage(mapPageId)
+	defer fsm.unpinMapPage(page, false)
+	for slot := 0; slot < freeSpaceMapFanout && len(*pageIds) < fsm.numPages(); slot++ {
+		if mp.level == 0 {
+			*pageIds = append(*pageIds, mp.pageIds[slot])
+		} else {
+			fsm.collectPageIds(mp.pageIds[slot], pageIds)
+		}
+	}
+}
+
+// All map pages.
+func (fsm *freeSpaceMap) mapPageIds() []common.PageId {
+	mapPageIds := make([]common.PageId, 0)
+	if fsm.numPages() > 0 {
+		fsm.collectMapPageIds(fsm.header.rootPageId, 0, &mapPageIds)
+	}
+	return mapPageIds
+}
+
+// Collect a map page and the map pages below it. `index` is the first table
+// page below the map page.
+func (fsm *freeSpaceMap) collectMapPageIds(mapPageId common.PageId, index int, mapPageIds *[]common.PageId) {
+	*mapPageIds = append(*mapPageIds, mapPageId)
+	page, mp := fsm.fetchMapPage(mapPageId)
+	defer fsm.unpinMapPage(page, false)
+	if mp.level == 0 {
+		return
+	}
+	step := freeSpaceMapCapacity(mp.level - 1)
+	for slot := 0; slot < freeSpaceMapFanout && index < fsm.numPages(); slot++ {
+		fsm.collectMapPageIds(mp.pageIds[slot], index, mapPageIds)
+		index += step
+	}
+}
+
+// Find a table page other than `excludedPageId` with at least `size` bytes
+// left for an insert. The free space is rounded down, so pages with barely
+// enough room may be missed.
+func (fsm *freeSpaceMap) find(size int, excludedPageId common.PageId) (common.PageId, bool) {
+	category := (size + freeSpaceUnit - 1) / freeSpaceUnit
+	if category < 1 {
+		category = 1
+	}
+	if fsm.numPages() == 0 || category > math.MaxUint8 {
+		return common.InvalidPageId, false
+	}
+	return fsm.findIn(fsm.header.rootPageId, uint8(category), excludedPageId)
+}
+
+func (fsm *freeSpaceMap) findIn(mapPageId common.PageId, category uint8, excludedPageId common.PageId) (common.PageId, bool) {
+	page, mp := fsm.fetchMapPage(mapPageId)
+	defer fsm.unpinMapPage(page, false)
+	return mp.search(0, category, func(slot int) (common.PageId, bool) {
+		if mp.level > 0 {
+			return fsm.findIn(mp.pageIds[slot], category, excludedPageId)
+		}
+		return mp.pageIds[slot], mp.pageIds[slot] != excludedPageId
+	})
+}
+
+// Record the free space of the `index`-th table page.
+func (fsm *freeSpaceMap) set(index int, freeSpace int32) {
+	fsm.setIn(fsm.header.rootPageId, fsm.header.depth, index, freeSpaceCategory(freeSpace))
+}
+
+// Set the category of the `index`-th table page in the subtree of a map page,
+// and return the new max category of the map page.
+func (fsm *freeSpaceMap) setIn(mapPageId common.PageId, level int32, index int, category uint8) uint8 {
+	page, mp := fsm.fetchMapPage(mapPageId)
+	defer fsm.unpinMapPage(page, true)
+	slot := freeSpaceMapSlot(index, level)
+	if level > 0 {
+		category = fsm.setIn(mp.pageIds[slot], level-1, index, category)
+	}
+	mp.setCategory(slot, category)
+	return mp.maxCategory()
+}
+
+// Add a table page with `freeSpace` bytes left, and return its index. The
+// header is written last, so that a crash in between leaves at most some
+// unreachable map pages behind.
+func (fsm *freeSpaceMap) push(pageId common.PageId, freeSpace int32) int {
+	index := fsm.numPages()
+	if index == 0 {
+		fsm.header.depth = 0
+		fsm.header.rootPageId = fsm.newMapPage(0, common.InvalidPageId)
+	} else if index == freeSpaceMapCapacity(fsm.header.depth) {
+		rootPage, root := fsm.fetchMapPage(fsm.header.rootPageId)
+		category := root.maxCategory()
+		fsm.unpinMapPage(rootPage, false)
+
+		fsm.header.depth++
+		fsm.header.rootPageId = fsm.newMapPage(fsm.header.depth, fsm.header.rootPageId)
+		rootPage, root = fsm.fetchMapPage(fsm.header.rootPageId)
+		root.setCategory(0, category)
+		fsm.unpinMapPage(rootPage, true)
+	}
+	fsm.pushIn(fsm.header.rootPageId, fsm.header.depth, index, pageId)
+	fsm.header.numPages++
+	fsm.logWrite(fsm.headerPage, 0, heapFileHeaderSize)
+	fsm.set(index, freeSpace)
+	return index
+}
+
+func (fsm *freeSpaceMap) pushIn(mapPageId common.PageId, level int32, index int, pageId common.PageId) {
+	page, mp := fsm.fetchMapPage(mapPageId)
+	defer fsm.unpinMapPage(page, true)
+	slot := freeSpaceMapSlot(index, level)
+	if level > 0 {
+		if index%freeSpaceMapCapacity(level-1) == 0 {
+			// First page of the subtree, which does not exist yet.
+			mp.pageIds[slot] = fsm.newMapPage(level-1, common.InvalidPageId)
+			fsm.logWrite(page, int(unsafe.Offsetof(mp.pageIds))+slot*int(unsafe.Sizeof(pageId)), int(unsafe.Sizeof(pageId)))
+		}
+		fsm.pushIn(mp.pageIds[slot], level-1, index, pageId)
+		return
+	}
+	mp.pageIds[slot] = pageId
+	fsm.logWrite(page, int(unsafe.Offsetof(mp.pageIds))+slot*int(unsafe.Sizeof(pageId)), int(unsafe.Sizeof(pageId)))
+}
+
+// Allocate a map page at `level`, whose first entry is `firstPageId`.
+func (fsm *freeSpaceMap) newMapPage(level int32, firstPageId common.PageId) common.PageId {
+	page, err := fsm.tableHeap.bufferPoolManager.NewPage()
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot allocate free space map page.")
+	}
+	createFreeSpaceMapPage(page.Data()).init(level, firstPageId)
+	fsm.logWrite(page, 0, int(unsafe.Sizeof(freeSpaceMapPage{})))
+	fsm.unpinMapPage(page, true)
+	return page.PageId()
+}
+
+// Log a change of `size` bytes at `offset` in a page of the map. The changes
+// are not part of any transaction, and are only ever redone.
+func (fsm *freeSpaceMap) logWrite(page *disk.Page, offset int, size int) {
+	fsm.tableHeap.appendLogRecord(nil, &wal.LogRecord{
+		Type:   wal.PageWriteRecord,
+		PageId: page.PageId(),
+		Offset: offset,
+		Data:   append([]byte(nil), page.Data()[offset:offset+size]...),
+	}, page)
+}
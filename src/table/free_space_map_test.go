@@ -0,0 +1,143 @@
+package table
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/wal"
+)
+
+// The category recorded for the `index`-th table page.
+func mapCategory(fsm *freeSpaceMap, index int) uint8 {
+	pageId := fsm.header.rootPageId
+	for level := fsm.header.depth; ; level-- {
+		page, mp := fsm.fetchMapPage(pageId)
+		slot := freeSpaceMapSlot(index, level)
+		pageId = mp.pageIds[slot]
+		category := mp.tree[freeSpaceMapFanout-1+slot]
+		fsm.unpinMapPage(page, false)
+		if level == 0 {
+			return category
+		}
+	}
+}
+
+func TestFreeSpaceMap(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	headerPage := tableHeapFile.getHeaderPage(true)
+	defer tableHeapFile.releaseHeaderPage(headerPage, true)
+	fsm := tableHeapFile.getFreeSpaceMap(headerPage)
+
+	_, ok := fsm.find(1, common.InvalidPageId)
+	require.False(t, ok)
+
+	// Two levels below the root. The table pages are made up, the map does
+	// not look at them.
+	numPages := freeSpaceMapFanout*freeSpaceMapFanout + 1
+	freeSpaces := make([]int32, numPages)
+	for i := range freeSpaces {
+		freeSpaces[i] = int32(rand.Intn(disk.PageDataSize / 2))
+		require.Equal(t, i, fsm.push(common.PageId(1000000+i), freeSpaces[i]))
+	}
+	require.Equal(t, int32(2), fsm.header.depth)
+	require.Equal(t, numPages, fsm.numPages())
+	pageIds := fsm.pageIds()
+	require.Equal(t, numPages, len(pageIds))
+	for i := 0; i < numPages; i += 997 {
+		require.Equal(t, common.PageId(1000000+i), pageIds[i])
+		require.Equal(t, pageIds[i], fsm.pageId(i))
+		require.Equal(t, freeSpaceCategory(freeSpaces[i]), mapCategory(fsm, i))
+	}
+
+	// The first page with enough room is found.
+	firstFit := func(size int, excluded common.PageId) (common.PageId, bool) {
+		for i, freeSpace := range freeSpaces {
+			if int(freeSpaceCategory(freeSpace))*freeSpaceUnit >= size && pageIds[i] != excluded {
+				return pageIds[i], true
+			}
+		}
+		return common.InvalidPageId, false
+	}
+	for _, size := range []int{1, 100, 1000, 2000, disk.PageDataSize / 2, disk.PageDataSize} {
+		expected, expectedOk := firstFit(size, common.InvalidPageId)
+		pageId, ok := fsm.find(size, common.InvalidPageId)
+		require.Equal(t, expectedOk, ok)
+		require.Equal(t, expected, pageId)
+		if ok {
+			expected, expectedOk = firstFit(size, pageId)
+			pageId, ok = fsm.find(size, pageId)
+			require.Equal(t, expectedOk, ok)
+			require.Equal(t, expected, pageId)
+		}
+	}
+
+	// Only the last page has room.
+	for i := range freeSpaces {
+		if i < numPages-1 {
+			freeSpaces[i] = 0
+		} else {
+			freeSpaces[i] = int32(disk.PageDataSize / 2)
+		}
+		fsm.set(i, freeSpaces[i])
+	}
+	pageId, ok := fsm.find(100, common.InvalidPageId)
+	require.True(t, ok)
+	require.Equal(t, pageIds[numPages-1], pageId)
+	_, ok = fsm.find(100, pageIds[numPages-1])
+	require.False(t, ok)
+}
+
+// Tables are not limited to what fits in one header page.
+func TestTableHeap_ManyPages(t *testing.T) {
+	defer os.Remove("test.db")
+	defer wal.RemoveLogFiles("test.log")
+
+	h := openLoggedTableHeap(true)
+	// Two records per page.
+	numRecords := 2 * (freeSpaceMapFanout + 100)
+	allData := make([][]byte, numRecords)
+	allRIDs := make([]common.RID, numRecords)
+	txn := h.txnManager.Begin()
+	for i := range allData {
+		allData[i] = make([]byte, disk.PageDataSize/3)
+		rand.Read(allData[i])
+		rid, err := h.tableHeap.Insert(txn, allData[i])
+		require.Nil(t, err)
+		allRIDs[i] = rid
+	}
+	require.Nil(t, h.txnManager.Commit(txn))
+	headerPage := h.tableHeap.getHeaderPage(false)
+	fsm := h.tableHeap.getFreeSpaceMap(headerPage)
+	require.Equal(t, numRecords/2, fsm.numPages())
+	require.Equal(t, int32(1), fsm.header.depth)
+	h.tableHeap.releaseHeaderPage(headerPage, false)
+
+	// Deleted records leave room in the middle of the table, which is used
+	// again.
+	txn = h.txnManager.Begin()
+	deleted, err := h.tableHeap.Delete(txn, allRIDs[700])
+	require.Nil(t, err)
+	require.True(t, deleted)
+	require.Nil(t, h.txnManager.Commit(txn))
+	require.Equal(t, 1, h.tableHeap.CollectGarbage(h.txnManager.OldestSnapshot()))
+	txn = h.txnManager.Begin()
+	rid, err := h.tableHeap.Insert(txn, allData[700])
+	require.Nil(t, err)
+	require.Equal(t, allRIDs[700], rid)
+	require.Nil(t, h.txnManager.Commit(txn))
+
+	// Crash, and recover the free space map from the log.
+	h.close()
+	h = openLoggedTableHeap(false)
+	testTableDataFunc(t, h.txnManager, h.tableHeap, allData, allRIDs)
+	txn = h.txnManager.Begin()
+	require.Equal(t, numRecords, len(scanTableHeap(t, h.tableHeap, txn)))
+	require.Nil(t, h.txnManager.Commit(txn))
+	h.close()
+}
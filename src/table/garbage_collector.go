@@ -0,0 +1,85 @@
+package table
+
+import (
+	"time"
+
+	"simple-db-golang/src/transaction"
+)
+
+const DefaultGarbageCollectionInterval = time.Second
+
+// Collectable keeps things that older snapshots may still read, like the
+// record versions of a table heap, until no snapshot can see them any more.
+type Collectable interface {
+	// Remove what no snapshot at or after `oldestSnapshot` can see. Returns
+	// the number of removed things.
+	CollectGarbage(oldestSnapshot transaction.Timestamp) int
+}
+
+// GarbageCollector removes the record versions that no snapshot can see any
+// more from the table heaps, so that their space can be reused. Deleted
+// records keep their space until then, since older snapshots may still read
+// them. Other collectables, e.g. the indexes of a catalog, are cleaned up
+// along.
+type GarbageCollector struct {
+	txnManager   *transaction.TransactionManager
+	collectables []Collectable
+	interval     time.Duration
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+func NewGarbageCollector(txnManager *transaction.TransactionManager, interval time.Duration, collectables ...Collectable) *GarbageCollector {
+	return &GarbageCollector{
+		txnManager:   txnManager,
+		collectables: collectables,
+		interval:     interval,
+	}
+}
+
+// Start collecting garbage periodically.
+func (gc *GarbageCollector) Start() {
+	if gc.stop != nil {
+		return
+	}
+	gc.stop = make(chan struct{})
+	gc.done = make(chan struct{})
+	go gc.run(gc.stop, gc.done)
+}
+
+// Stop collecting garbage periodically, and wait for the current collection to
+// finish.
+func (gc *GarbageCollector) Stop() {
+	if gc.stop == nil {
+		return
+	}
+	close(gc.stop)
+	<-gc.done
+	gc.stop = nil
+	gc.done = nil
+}
+
+func (gc *GarbageCollector) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(gc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			gc.Collect()
+		}
+	}
+}
+
+// Collect garbage now. Returns the number of removed records and other
+// things.
+func (gc *GarbageCollector) Collect() int {
+	oldestSnapshot := gc.txnManager.OldestSnapshot()
+	numRemoved := 0
+	for _, collectable := range gc.collectables {
+		numRemoved += collectable.CollectGarbage(oldestSnapshot)
+	}
+	return numRemoved
+}
@@ -0,0 +1,154 @@
+package table
+
+import (
+	"errors"
+	"math"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/wal"
+)
+
+const (
+	// Records longer than this are stored in a chain of overflow pages, and
+	// only a pointer to the chain is kept on the table page. Anything shorter
+	// fits on an empty table page, even once moved by an update.
+	maxInlineRecordSize = disk.PageDataSize - int(unsafe.Offsetof(TablePage{}.ptr)) - RecordSlotSize - (1 + storedRIDSize)
+
+	// Records longer than this are rejected with `ErrRecordTooLarge`.
+	MaxRecordSize = 1 << 24
+
+	overflowPageCapacity = disk.PageDataSize - int(unsafe.Offsetof(OverflowPage{}.ptr))
+)
+
+var (
+	ErrRecordTooLarge = errors.New("Record is too large.")
+)
+
+// OverflowPage holds a piece of a record too large for a table page. The
+// pieces of a record are chained through `nextPageId`.
+type OverflowPage struct {
+	nextPageId common.PageId
+	size       int32
+	ptr        struct{}
+}
+
+func createOverflowPage(data []byte) *OverflowPage {
+	return (*OverflowPage)(unsafe.Pointer(&data[0]))
+}
+
+func (op *OverflowPage) init(nextPageId common.PageId, data []byte) {
+	op.nextPageId = nextPageId
+	op.size = int32(len(data))
+	copy(op.getData(), data)
+}
+
+func (op *OverflowPage) getData() []byte {
+	return (*[math.MaxInt32]byte)(unsafe.Pointer(&op.ptr))[:int(op.size)]
+}
+
+// The used part of an overflow page, which is all that is logged.
+func (op *OverflowPage) getRawData() []byte {
+	return (*[math.MaxInt32]byte)(unsafe.Pointer(op))[:int(unsafe.Offsetof(op.ptr))+int(op.size)]
+}
+
+// Store a record in a chain of new overflow pages, and return the first page.
+// The pages are not part of any transaction: they are only reachable from the
+// record pointing to them, and are freed along with it.
+func writeOverflowPages(bufferPoolManager *disk.BufferPoolManager, record []byte) common.PageId {
+	nextPageId := common.InvalidPageId
+	// Write the chain backwards, so that every page knows its successor.
+	for end := len(record); end > 0; {
+		start := (end - 1) / overflowPageCapacity * overflowPageCapacity
+		page, err := bufferPoolManager.NewPage()
+		if err != nil {
+			log.WithError(err).Fatalf("Cannot allocate overflow page.")
+		}
+		page.Lock()
+		overflowPage := createOverflowPage(page.Data())
+		overflowPage.init(nextPageId, record[start:end])
+		if logManager := bufferPoolManager.LogManager(); logManager != nil {
+			page.SetLSN(logManager.AppendLogRecord(&wal.LogRecord{
+				PrevLSN: common.InvalidLSN,
+				TxnId:   common.InvalidTxnId,
+				Type:    wal.OverflowPageRecord,
+				PageId:  page.PageId(),
+				Data:    overflowPage.getRawData(),
+			}))
+		}
+		page.Unlock()
+		bufferPoolManager.UnpinPage(page.PageId(), true)
+		nextPageId = page.PageId()
+		end = start
+	}
+	return nextPageId
+}
+
+// Read a record of `size` bytes stored from the overflow page `pageId` on.
+func readOverflowPages(bufferPoolManager *disk.BufferPoolManager, pageId common.PageId, size int) []byte {
+	record := make([]byte, 0, size)
+	for pageId != common.InvalidPageId {
+		page, err := bufferPoolManager.FetchPage(pageId)
+		if err != nil {
+			log.WithError(err).Fatalf("Cannot fetch overflow page %d.", pageId)
+		}
+		page.RLock()
+		overflowPage := createOverflowPage(page.Data())
+		record = append(record, overflowPage.getData()...)
+		nextPageId := overflowPage.nextPageId
+		page.RUnlock()
+		bufferPoolManager.UnpinPage(pageId, false)
+		pageId = nextPageId
+	}
+	return record
+}
+
+// Give the chain of overflow pages starting at `pageId` back to the page
+// store. Nothing may point to the chain any more.
+func freeOverflowPages(bufferPoolManager *disk.BufferPoolManager, pageId common.PageId) {
+	freePages(bufferPoolManager, overflowChain(bufferPoolManager, pageId))
+}
+
+// The pages of the chain starting at `pageId`.
+func overflowChain(bufferPoolManager *disk.BufferPoolManager, pageId common.PageId) []common.PageId {
+	pageIds := make([]common.PageId, 0)
+	for pageId != common.InvalidPageId {
+		pageIds = append(pageIds, pageId)
+		page, err := bufferPoolManager.FetchPage(pageId)
+		if err != nil {
+			log.WithError(err).Fatalf("Cannot fetch overflow page %d.", pageId)
+		}
+		page.RLock()
+		pageId = createOverflowPage(page.Data()).nextPageId
+		page.RUnlock()
+		bufferPoolManager.UnpinPage(page.PageId(), false)
+	}
+	return pageIds
+}
+
+// Give pages back to the page store. Freeing a page takes effect right away,
+// so the frees are logged and flushed first: recovery must not redo anything
+// on the pages after the frees.
+func freePages(bufferPoolManager *disk.BufferPoolManager, pageIds []common.PageId) {
+	if logManager := bufferPoolManager.LogManager(); logManager != nil {
+		for _, pageId := range pageIds {
+			logManager.AppendLogRecord(&wal.LogRecord{
+				PrevLSN: common.InvalidLSN,
+				TxnId:   common.InvalidTxnId,
+				Type:    wal.FreePageRecord,
+				PageId:  pageId,
+			})
+		}
+		if err := logManager.Flush(logManager.LastLSN()); err != nil {
+			log.WithError(err).Fatalf("Cannot flush the log.")
+		}
+	}
+	for _, pageId := range pageIds {
+		if err := bufferPoolManager.DeletePage(pageId); err != nil {
+			log.WithError(err).Fatalf("Cannot free page %d.", pageId)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package table
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/wal"
+)
+
+// Recover brings the pages behind `bufferPoolManager` back to a consistent
+// state after a crash, following ARIES:
+//   - Analysis: scan the log to find the transactions that did not finish
+//     (losers) and the pages that may have unwritten changes.
+//   - Redo: repeat history, i.e. reapply every logged change that is not
+//     reflected in its page yet, including changes of losers.
+//   - Undo: roll back the losers, writing compensation log records so that a
+//     crash during recovery does not undo anything twice.
+//
+// Analysis starts at the last checkpoint rather than the beginning of the log.
+// Once done, a new checkpoint is taken, so that the next recovery does not
+// have to repeat the work.
+//
+// Recovering an already consistent database is a no-op apart from scanning
+// the log. Does nothing if logging is disabled.
+func Recover(bufferPoolManager *disk.BufferPoolManager) error {
+	logManager := bufferPoolManager.LogManager()
+	if logManager == nil {
+		return nil
+	}
+	rm := &recoveryManager{
+		bufferPoolManager: bufferPoolManager,
+		logManager:        logManager,
+		activeTxns:        make(map[common.TxnId]common.LSN),
+		dirtyPages:        make(map[common.PageId]common.LSN),
+		freePages:         make(map[common.PageId]bool),
+	}
+	if err := rm.analyze(); err != nil {
+		return err
+	}
+	if err := rm.redo(); err != nil {
+		return err
+	}
+	if err := rm.undo(); err != nil {
+		return err
+	}
+	if err := logManager.Flush(logManager.LastLSN()); err != nil {
+		return err
+	}
+	if err := bufferPoolManager.FlushAllPages(); err != nil {
+		return err
+	}
+	return disk.NewCheckpointManager(bufferPoolManager, disk.DefaultCheckpointInterval).Checkpoint()
+}
+
+type recoveryManager struct {
+	bufferPoolManager *disk.BufferPoolManager
+	logManager        *wal.LogManager
+
+	// Transactions without a commit or abort record, mapped to their last LSN.
+	activeTxns map[common.TxnId]common.LSN
+	// Pages that may be out of date on disk, mapped to the LSN of the first
+	// log record that may not be reflected in them.
+	dirtyPages map[common.PageId]common.LSN
+	// Pages which were given back to the page store, and have not been
+	// allocated again since. They cannot be fetched any more, and nothing
+	// needs to be redone on them.
+	freePages map[common.PageId]bool
+}
+
+// Pages modified by a log record.
+func affectedPages(record *wal.LogRecord) []common.PageId {
+	switch record.Type {
+	case wal.InsertRecord, wal.DeleteRecord, wal.MarkDeleteRecord, wal.UpdateRecord,
+		wal.UndoInsertRecord, wal.UndoDeleteRecord, wal.UndoMarkDeleteRecord, wal.UndoUpdateRecord:
+		return []common.PageId{record.RID.PageId}
+	case wal.NewPageRecord, wal.OverflowPageRecord, wal.PageWriteRecord:
+		return []common.PageId{record.PageId}
+	default:
+		return nil
+	}
+}
+
+func (rm *recoveryManager) analyze() error {
+	it, err := rm.logManager.Iterator(rm.logManager.CheckpointLSN())
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for {
+		record, ok := it.Next()
+		if !ok {
+			break
+		}
+		switch record.Type {
+		case wal.FreePageRecord:
+			rm.freePages[record.PageId] = true
+		case wal.NewPageRecord, wal.OverflowPageRecord, wal.PageWriteRecord:
+			delete(rm.freePages, record.PageId)
+		}
+		switch {
+		case record.Type == wal.EndCheckpointRecord:
+			rm.mergeCheckpoint(record)
+		case record.TxnId == common.InvalidTxnId:
+			// Not part of a transaction, never undone.
+		case record.Type == wal.CommitRecord || record.Type == wal.AbortRecord:
+			delete(rm.activeTxns, record.TxnId)
+		default:
+			rm.activeTxns[record.TxnId] = record.LSN
+		}
+		for _, pageId := range affectedPages(record) {
+			if _, ok := rm.dirtyPages[pageId]; !ok {
+				rm.dirtyPages[pageId] = record.LSN
+			}
+		}
+	}
+	return nil
+}
+
+// Add the tables logged by a checkpoint to the ones built from the records
+// after it. A page may have been dirtied before the checkpoint began, and a
+// transaction may have written records before it and none after it.
+func (rm *recoveryManager) mergeCheckpoint(record *wal.LogRecord) {
+	for pageId, recLSN := range record.DirtyPages {
+		if lsn, ok := rm.dirtyPages[pageId]; !ok || recLSN < lsn {
+			rm.dirtyPages[pageId] = recLSN
+		}
+	}
+	for txnId, lastLSN := range record.ActiveTxns {
+		if lsn, ok := rm.activeTxns[txnId]; !ok || lastLSN > lsn {
+			rm.activeTxns[txnId] = lastLSN
+		}
+	}
+}
+
+func (rm *recoveryManager) redo() error {
+	if len(rm.dirtyPages) == 0 {
+		return nil
+	}
+	startLSN := common.LSN(1<<63 - 1)
+	for _, recLSN := range rm.dirtyPages {
+		if recLSN < startLSN {
+			startLSN = recLSN
+		}
+	}
+	it, err := rm.logManager.Iterator(startLSN)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for {
+		record, ok := it.Next()
+		if !ok {
+			break
+		}
+		for _, pageId := range affectedPages(record) {
+			if recLSN, ok := rm.dirtyPages[pageId]; !ok || record.LSN < recLSN || rm.freePages[pageId] {
+				continue
+			}
+			if err := rm.redoOnPage(record, pageId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (rm *recoveryManager) redoOnPage(record *wal.LogRecord, pageId common.PageId) error {
+	page, err := rm.bufferPoolManager.FetchPage(pageId)
+	if err != nil {
+		return err
+	}
+	page.Lock()
+	defer func() {
+		page.Unlock()
+		rm.bufferPoolManager.UnpinPage(pageId, true)
+	}()
+	if page.LSN() >= record.LSN {
+		return nil // Already on the page.
+	}
+	if err := applyLogRecord(page, record); err != nil {
+		return err
+	}
+	page.SetLSN(record.LSN)
+	return nil
+}
+
+func (rm *recoveryManager) undo() error {
+	// Next LSN to undo of each loser, and the LSN of its last record, which
+	// compensation records are chained to.
+	nextLSNs := make(map[common.TxnId]common.LSN)
+	for txnId, lastLSN := range rm.activeTxns {
+		nextLSNs[txnId] = lastLSN
+	}
+	for len(nextLSNs) > 0 {
+		// Undo in the reverse order of the log, across all losers.
+		txnId, lsn := common.InvalidTxnId, common.InvalidLSN
+		for id, nextLSN := range nextLSNs {
+			if txnId == common.InvalidTxnId || nextLSN > lsn {
+				txnId, lsn = id, nextLSN
+			}
+		}
+		if lsn == common.InvalidLSN {
+			rm.abort(txnId)
+			delete(nextLSNs, txnId)
+			continue
+		}
+		record, err := rm.logManager.ReadLogRecord(lsn)
+		if err != nil {
+			return err
+		}
+		switch {
+		case record.Type.IsCompensation():
+			nextLSNs[txnId] = record.UndoNextLSN
+		case record.Type == wal.InsertRecord || record.Type == wal.DeleteRecord ||
+			record.Type == wal.MarkDeleteRecord || record.Type == wal.UpdateRecord:
+			if err := rm.undoRecord(record); err != nil {
+				return err
+			}
+			nextLSNs[txnId] = record.PrevLSN
+		default:
+			nextLSNs[txnId] = record.PrevLSN
+		}
+	}
+	return nil
+}
+
+// Revert the change of `record` on its page, and log the compensation.
+func (rm *recoveryManager) undoRecord(record *wal.LogRecord) error {
+	page, err := rm.bufferPoolManager.FetchPage(record.RID.PageId)
+	if err != nil {
+		return err
+	}
+	page.Lock()
+	defer func() {
+		page.Unlock()
+		rm.bufferPoolManager.UnpinPage(record.RID.PageId, true)
+	}()
+
+	clr := compensationLogRecord(record)
+	clr.PrevLSN = rm.activeTxns[record.TxnId]
+	if err := applyLogRecord(page, clr); err != nil {
+		return err
+	}
+	lsn := rm.logManager.AppendLogRecord(clr)
+	rm.activeTxns[record.TxnId] = lsn
+	page.SetLSN(lsn)
+
+	// Free the overflow pages of a record which is inserted or written by an
+	// update, and is gone now.
+	if record.Type == wal.InsertRecord || record.Type == wal.UpdateRecord {
+		if kind, payload := decodeStoredRecord(record.Data); kind == overflowRecord {
+			pageId, _ := decodeOverflowRecord(payload)
+			freeOverflowPages(rm.bufferPoolManager, pageId)
+		}
+	}
+	return nil
+}
+
+func (rm *recoveryManager) abort(txnId common.TxnId) {
+	rm.logManager.AppendLogRecord(&wal.LogRecord{
+		PrevLSN: rm.activeTxns[txnId],
+		TxnId:   txnId,
+		Type:    wal.AbortRecord,
+	})
+	delete(rm.activeTxns, txnId)
+}
+
+// Build the compensation log record that reverts an insert, a delete or an
+// update.
+func compensationLogRecord(record *wal.LogRecord) *wal.LogRecord {
+	clr := &wal.LogRecord{
+		TxnId:       record.TxnId,
+		RID:         record.RID,
+		Data:        record.Data,
+		OldData:     record.OldData,
+		UndoNextLSN: record.PrevLSN,
+	}
+	switch record.Type {
+	case wal.InsertRecord:
+		clr.Type = wal.UndoInsertRecord
+	case wal.DeleteRecord:
+		clr.Type = wal.UndoDeleteRecord
+	case wal.MarkDeleteRecord:
+		clr.Type = wal.UndoMarkDeleteRecord
+	case wal.UpdateRecord:
+		clr.Type = wal.UndoUpdateRecord
+	}
+	return clr
+}
+
+// Apply the change described by a log record to a page latched exclusively.
+func applyLogRecord(page *disk.Page, record *wal.LogRecord) error {
+	switch record.Type {
+	case wal.InsertRecord, wal.UndoDeleteRecord:
+		tablePage := createTablePage(page.Data())
+		if !tablePage.insertAt(record.RID.SlotNum, record.Data) {
+			return fmt.Errorf("Cannot put record back to %s.", record.RID.String())
+		}
+	case wal.DeleteRecord, wal.UndoInsertRecord:
+		tablePage := createTablePage(page.Data())
+		if !tablePage.Delete(record.RID) {
+			return fmt.Errorf("Cannot delete record %s.", record.RID.String())
+		}
+	case wal.MarkDeleteRecord:
+		tablePage := createTablePage(page.Data())
+		if !tablePage.MarkDelete(record.RID) {
+			return fmt.Errorf("Cannot mark record %s as deleted.", record.RID.String())
+		}
+	case wal.UndoMarkDeleteRecord:
+		tablePage := createTablePage(page.Data())
+		if !tablePage.RollbackDelete(record.RID) {
+			return fmt.Errorf("Cannot restore record %s.", record.RID.String())
+		}
+	case wal.UpdateRecord, wal.UndoUpdateRecord:
+		data := record.Data
+		if record.Type == wal.UndoUpdateRecord {
+			data = record.OldData
+		}
+		tablePage := createTablePage(page.Data())
+		if !tablePage.Update(record.RID, data) {
+			return fmt.Errorf("Cannot update record %s.", record.RID.String())
+		}
+	case wal.OverflowPageRecord:
+		copy(page.Data(), record.Data)
+	case wal.PageWriteRecord:
+		copy(page.Data()[record.Offset:], record.Data)
+	case wal.NewPageRecord:
+		// The page is added to the free space map by separate records.
+		tablePage := createTablePage(page.Data())
+		tablePage.init(record.PageId, int32(len(page.Data())))
+	default:
+		log.Warnf("Unexpected log record %s to apply.", record.Type)
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package table
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/transaction"
+	"simple-db-golang/src/wal"
+)
+
+type loggedTableHeap struct {
+	diskManager       *disk.DiskManager
+	logManager        *wal.LogManager
+	bufferPoolManager *disk.BufferPoolManager
+	lockManager       *transaction.LockManager
+	txnManager        *transaction.TransactionManager
+	tableHeap         *TableHeap
+}
+
+// The header page of the table heap of `openLoggedTableHeap`, which is the
+// first page of the database file.
+const loggedTableHeapId = common.PageId(1)
+
+// Open the database files, recovering them unless they are new, and the table
+// heap in them.
+func openLoggedTableHeap(isNew bool) *loggedTableHeap {
+	h := openLoggedDatabase(isNew)
+	if isNew {
+		h.tableHeap = NewTableHeap(h.bufferPoolManager)
+		if h.tableHeap.HeaderPageId() != loggedTableHeapId {
+			panic("Unexpected header page id.")
+		}
+	} else {
+		h.tableHeap = OpenTableHeap(h.bufferPoolManager, loggedTableHeapId)
+	}
+	return h
+}
+
+func openLoggedDatabase(isNew bool) *loggedTableHeap {
+	if isNew {
+		// A test which failed may have left its files behind. The table heap
+		// must get the first page, and recovery must not replay an old log.
+		os.Remove("test.db")
+		wal.RemoveLogFiles("test.log")
+	}
+	diskManager := disk.NewDiskManager("test.db")
+	logManager := wal.NewLogManager("test.log")
+	bufferPoolManager := disk.NewBufferPoolManager(8, diskManager, disk.NewLRUReplacer())
+	bufferPoolManager.SetLogManager(logManager)
+	if !isNew {
+		if err := Recover(bufferPoolManager); err != nil {
+			panic(err)
+		}
+	}
+	lockManager := transaction.NewLockManager(transaction.DeadlockDetection)
+	return &loggedTableHeap{
+		diskManager:       diskManager,
+		logManager:        logManager,
+		bufferPoolManager: bufferPoolManager,
+		lockManager:       lockManager,
+		txnManager:        transaction.NewTransactionManager(logManager, lockManager),
+	}
+}
+
+// Close the files without flushing the buffer pool, which is what a crash
+// leaves behind.
+func (h *loggedTableHeap) close() {
+	h.lockManager.Close()
+	h.diskManager.Close()
+	h.logManager.Close()
+}
+
+func TestRecover_RedoCommitted(t *testing.T) {
+	defer os.Remove("test.db")
+	defer wal.RemoveLogFiles("test.log")
+
+	h := openLoggedTableHeap(true)
+	allData, allRIDs := insertDeleteUtilsFunc(h.txnManager, h.tableHeap, 200, 0.7)
+	// Crash: dirty pages in the buffer pool are lost.
+	h.close()
+
+	h = openLoggedTableHeap(false)
+	testTableDataFunc(t, h.txnManager, h.tableHeap, allData, allRIDs)
+	h.close()
+}
+
+func TestRecover_UndoUncommitted(t *testing.T) {
+	defer os.Remove("test.db")
+	defer wal.RemoveLogFiles("test.log")
+
+	h := openLoggedTableHeap(true)
+	allData, allRIDs := insertDeleteUtilsFunc(h.txnManager, h.tableHeap, 50, 1.0)
+
+	// A transaction which inserts and deletes some records but never commits.
+	txn := h.txnManager.Begin()
+	for i := 0; i < 20; i++ {
+		data := make([]byte, rand.Intn(512)+1)
+		rand.Read(data)
+		_, err := h.tableHeap.Insert(txn, data)
+		require.Nil(t, err)
+	}
+	deleted, err := h.tableHeap.Delete(txn, allRIDs[len(allRIDs)-1])
+	require.Nil(t, err)
+	require.True(t, deleted)
+
+	// Crash after the uncommitted changes reached the disk.
+	h.logManager.Flush(h.logManager.LastLSN())
+	h.bufferPoolManager.FlushAllPages()
+	h.close()
+
+	h = openLoggedTableHeap(false)
+	testTableDataFunc(t, h.txnManager, h.tableHeap, allData, allRIDs)
+	require.Equal(t, len(allRIDs), countRecords(h.tableHeap))
+	h.diskManager.Close()
+
+	// The rollback is logged, so recovering again changes nothing.
+	it, _ := h.logManager.Iterator(common.InvalidLSN)
+	numCompensations, numAborts := 0, 0
+	for {
+		record, ok := it.Next()
+		if !ok {
+			break
+		}
+		if record.Type.IsCompensation() {
+			require.Equal(t, txn.TxnId(), record.TxnId)
+			numCompensations++
+		} else if record.Type == wal.AbortRecord {
+			require.Equal(t, txn.TxnId(), record.TxnId)
+			numAborts++
+		}
+	}
+	it.Close()
+	require.Equal(t, 21, numCompensations)
+	require.Equal(t, 1, numAborts)
+	h.logManager.Close()
+
+	h = openLoggedTableHeap(false)
+	testTableDataFunc(t, h.txnManager, h.tableHeap, allData, allRIDs)
+	require.Equal(t, len(allRIDs), countRecords(h.tableHeap))
+	h.close()
+}
+
+func TestRecover_MultipleTables(t *testing.T) {
+	defer os.Remove("test.db")
+	defer wal.RemoveLogFiles("test.log")
+
+	h := openLoggedDatabase(true)
+	tableHeaps := make([]*TableHeap, 3)
+	for i := range tableHeaps {
+		tableHeaps[i] = NewTableHeap(h.bufferPoolManager)
+	}
+	// Interleave the tables, so that their pages are mixed in the file.
+	allData := make([][][]byte, len(tableHeaps))
+	allRIDs := make([][]common.RID, len(tableHeaps))
+	for round := 0; round < 10; round++ {
+		for i, tableHeap := range tableHeaps {
+			data, rids := insertDeleteUtilsFunc(h.txnManager, tableHeap, 20, 0.8)
+			allData[i] = append(allData[i], data...)
+			allRIDs[i] = append(allRIDs[i], rids...)
+		}
+	}
+	// An unfinished transaction writing to all the tables.
+	txn := h.txnManager.Begin()
+	for _, tableHeap := range tableHeaps {
+		_, err := tableHeap.Insert(txn, []byte("uncommitted"))
+		require.Nil(t, err)
+	}
+	h.logManager.Flush(h.logManager.LastLSN())
+	h.close()
+
+	h = openLoggedDatabase(false)
+	for i := range tableHeaps {
+		tableHeaps[i] = OpenTableHeap(h.bufferPoolManager, tableHeaps[i].HeaderPageId())
+		testTableDataFunc(t, h.txnManager, tableHeaps[i], allData[i], allRIDs[i])
+		require.Equal(t, len(allRIDs[i]), countRecords(tableHeaps[i]))
+	}
+	// Records are only found through their own table.
+	txn = h.txnManager.Begin()
+	_, found, err := tableHeaps[1].Get(txn, allRIDs[0][0])
+	require.Nil(t, err)
+	require.False(t, found)
+	require.Nil(t, h.txnManager.Commit(txn))
+	h.close()
+}
+
+func TestRecover_FromCheckpoint(t *testing.T) {
+	defer os.Remove("test.db")
+	defer wal.RemoveLogFiles("test.log")
+
+	h := openLoggedTableHeap(true)
+	h.logManager.SetSegmentSize(16 << 10)
+	checkpointManager := disk.NewCheckpointManager(h.bufferPoolManager, disk.DefaultCheckpointInterval)
+	allData, allRIDs := insertDeleteUtilsFunc(h.txnManager, h.tableHeap, 200, 0.7)
+	require.Nil(t, h.bufferPoolManager.FlushAllPages())
+	require.Nil(t, checkpointManager.Checkpoint())
+	require.Equal(t, 1, h.logManager.NumSegments()) // Nothing before the checkpoint is needed.
+
+	moreData, moreRIDs := insertDeleteUtilsFunc(h.txnManager, h.tableHeap, 100, 0.7)
+	require.Nil(t, checkpointManager.Checkpoint())
+	allData = append(allData, moreData...)
+	allRIDs = append(allRIDs, moreRIDs...)
+	moreData, moreRIDs = insertDeleteUtilsFunc(h.txnManager, h.tableHeap, 100, 0.7)
+	allData = append(allData, moreData...)
+	allRIDs = append(allRIDs, moreRIDs...)
+	// Crash: changes before and after the last checkpoint are only in the log.
+	h.close()
+
+	h = openLoggedTableHeap(false)
+	testTableDataFunc(t, h.txnManager, h.tableHeap, allData, allRIDs)
+	require.Equal(t, len(allRIDs), countRecords(h.tableHeap))
+	h.close()
+}
+
+// Count the records in all pages of a table heap.
+func countRecords(tableHeapFile *TableHeap) int {
+	count := 0
+	headerPage := tableHeapFile.getHeaderPage(false)
+	for _, pageId := range tableHeapFile.getFreeSpaceMap(headerPage).pageIds() {
+		page, _ := tableHeapFile.bufferPoolManager.FetchPage(pageId)
+		tablePage := createTablePage(page.Data())
+		for i := 0; i < int(tablePage.numRecords); i++ {
+			if tablePage.getRecordSize(i) > 0 {
+				count++
+			}
+		}
+		tableHeapFile.bufferPoolManager.UnpinPage(pageId, false)
+	}
+	tableHeapFile.releaseHeaderPage(headerPage, false)
+	return count
+}
+
+func TestRecover_Update(t *testing.T) {
+	defer os.Remove("test.db")
+	defer wal.RemoveLogFiles("test.log")
+
+	h := openLoggedTableHeap(true)
+	allData, allRIDs := insertDeleteUtilsFunc(h.txnManager, h.tableHeap, 20, 1.0)
+	txn := h.txnManager.Begin()
+	for i, rid := range allRIDs {
+		allData[i] = make([]byte, rand.Intn(3000)+1)
+		rand.Read(allData[i])
+		updated, err := h.tableHeap.Update(txn, rid, allData[i])
+		require.Nil(t, err)
+		require.True(t, updated)
+	}
+	require.Nil(t, h.txnManager.Commit(txn))
+
+	// Updates which never commit, moving records as well.
+	txn = h.txnManager.Begin()
+	for _, rid := range allRIDs {
+		data := make([]byte, rand.Intn(3000)+1)
+		_, err := h.tableHeap.Update(txn, rid, data)
+		require.Nil(t, err)
+	}
+	h.logManager.Flush(h.logManager.LastLSN())
+	h.bufferPoolManager.FlushAllPages()
+	h.close()
+
+	h = openLoggedTableHeap(false)
+	testTableDataFunc(t, h.txnManager, h.tableHeap, allData, allRIDs)
+	txn = h.txnManager.Begin()
+	require.Equal(t, len(allRIDs), len(scanTableHeap(t, h.tableHeap, txn)))
+	require.Nil(t, h.txnManager.Commit(txn))
+	h.close()
+}
+
+func TestRecover_LargeRecords(t *testing.T) {
+	defer os.Remove("test.db")
+	defer wal.RemoveLogFiles("test.log")
+
+	h := openLoggedTableHeap(true)
+	allData, allRIDs := make([][]byte, 0), make([]common.RID, 0)
+	txn := h.txnManager.Begin()
+	for i := 0; i < 10; i++ {
+		data := make([]byte, rand.Intn(20000)+maxInlineRecordSize)
+		rand.Read(data)
+		rid, err := h.tableHeap.Insert(txn, data)
+		require.Nil(t, err)
+		allData = append(allData, data)
+		allRIDs = append(allRIDs, rid)
+	}
+	require.Nil(t, h.txnManager.Commit(txn))
+
+	// Records whose overflow pages are freed before the crash, so that
+	// nothing is redone on them.
+	txn = h.txnManager.Begin()
+	for _, rid := range allRIDs[:5] {
+		deleted, err := h.tableHeap.Delete(txn, rid)
+		require.Nil(t, err)
+		require.True(t, deleted)
+	}
+	require.Nil(t, h.txnManager.Commit(txn))
+	require.Equal(t, 5, h.tableHeap.CollectGarbage(h.txnManager.OldestSnapshot()))
+	allData, allRIDs = allData[5:], allRIDs[5:]
+
+	// An insert which never commits.
+	txn = h.txnManager.Begin()
+	rid, err := h.tableHeap.Insert(txn, make([]byte, 20000))
+	require.Nil(t, err)
+	pageIds := overflowPageIds(t, h.tableHeap, rid)
+	h.logManager.Flush(h.logManager.LastLSN())
+	h.close()
+
+	h = openLoggedTableHeap(false)
+	testTableDataFunc(t, h.txnManager, h.tableHeap, allData, allRIDs)
+	requireFreed(t, h.bufferPoolManager, pageIds)
+	h.close()
+}
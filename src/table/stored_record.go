@@ -0,0 +1,103 @@
+package table
+
+import (
+	"encoding/binary"
+
+	"simple-db-golang/src/common"
+)
+
+// Records are stored on table pages behind a one-byte kind, which tells where
+// the record actually lives:
+//   - plainRecord: `| kind | record |`.
+//   - forwardRecord: `| kind | page id | slot num |`, a forwarding pointer
+//     left at the RID of a record that outgrew its page and was moved to
+//     another one, so that the RID keeps working.
+//   - movedRecord: `| kind | home page id | home slot num | record |`, a
+//     record moved away from its RID, which is the home RID. Moved records
+//     are only reached through their forwarding pointer.
+//   - overflowRecord: `| kind | size | first page id |`, a record longer than
+//     `maxInlineRecordSize`, stored in a chain of overflow pages.
+//
+// If `paddedFlag` is set on the kind, the kind is followed by the length of
+// the rest, and the stored record is padded with zeros. Padding keeps the
+// space of a record that shrank, so that the change can always be rolled back
+// in place. Every stored record is at least `minStoredRecordSize` long, so
+// that a forwarding pointer fits in its place.
+const (
+	plainRecord    = byte(0)
+	forwardRecord  = byte(1)
+	movedRecord    = byte(2)
+	overflowRecord = byte(3)
+	paddedFlag     = byte(0x80)
+
+	storedRIDSize       = 8
+	minStoredRecordSize = 1 + storedRIDSize
+)
+
+// Encode a record of the given kind, padded to at least `size` bytes.
+func encodeStoredRecord(kind byte, payload []byte, size int) []byte {
+	if 1+len(payload) >= size {
+		return append([]byte{kind}, payload...)
+	}
+	if size < 5+len(payload) {
+		size = 5 + len(payload)
+	}
+	data := make([]byte, size)
+	data[0] = kind | paddedFlag
+	binary.LittleEndian.PutUint32(data[1:], uint32(len(payload)))
+	copy(data[5:], payload)
+	return data
+}
+
+func decodeStoredRecord(data []byte) (byte, []byte) {
+	kind := data[0]
+	if kind&paddedFlag == 0 {
+		return kind, data[1:]
+	}
+	size := binary.LittleEndian.Uint32(data[1:])
+	return kind &^ paddedFlag, data[5 : 5+size]
+}
+
+func encodePlainRecord(record []byte, size int) []byte {
+	return encodeStoredRecord(plainRecord, record, size)
+}
+
+func encodeForwardRecord(target common.RID, size int) []byte {
+	return encodeStoredRecord(forwardRecord, encodeStoredRID(target), size)
+}
+
+func encodeMovedRecord(home common.RID, record []byte) []byte {
+	return encodeStoredRecord(movedRecord, append(encodeStoredRID(home), record...), 0)
+}
+
+func encodeOverflowRecord(pageId common.PageId, recordSize int, size int) []byte {
+	payload := make([]byte, 8)
+	binary.LittleEndian.PutUint32(payload[0:], uint32(recordSize))
+	binary.LittleEndian.PutUint32(payload[4:], uint32(pageId))
+	return encodeStoredRecord(overflowRecord, payload, size)
+}
+
+// The first overflow page and the size of an overflow record.
+func decodeOverflowRecord(payload []byte) (common.PageId, int) {
+	return common.PageId(binary.LittleEndian.Uint32(payload[4:])), int(binary.LittleEndian.Uint32(payload[0:]))
+}
+
+// Drop the padding of a stored record.
+func compactStoredRecord(data []byte) []byte {
+	kind, payload := decodeStoredRecord(data)
+	return encodeStoredRecord(kind, payload, minStoredRecordSize)
+}
+
+func encodeStoredRID(rid common.RID) []byte {
+	data := make([]byte, storedRIDSize)
+	binary.LittleEndian.PutUint32(data[0:], uint32(rid.PageId))
+	binary.LittleEndian.PutUint32(data[4:], uint32(rid.SlotNum))
+	return data
+}
+
+func decodeStoredRID(data []byte) common.RID {
+	return common.RID{
+		PageId:  common.PageId(binary.LittleEndian.Uint32(data[0:])),
+		SlotNum: int(int32(binary.LittleEndian.Uint32(data[4:]))),
+	}
+}
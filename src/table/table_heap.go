@@ -1,41 +1,156 @@
 package table
 
 import (
+	"errors"
+	"runtime"
+	"sync"
+
 	"simple-db-golang/src/common"
 	"simple-db-golang/src/disk"
+	"simple-db-golang/src/transaction"
+	"simple-db-golang/src/wal"
 
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	heapFileHeaderPageId = common.PageId(1) // Simply assume the header page is always page ID 1.
+var (
+	// The record was deleted by a transaction which is still running, or which
+	// committed after the snapshot of the writing transaction. The writing
+	// transaction should abort.
+	ErrWriteConflict = errors.New("Record is changed by a concurrent transaction.")
 )
 
 type TableHeap struct {
 	bufferPoolManager *disk.BufferPoolManager
+	headerPageId      common.PageId
+
+	// Index of every table page in the free space map. Guarded by the latch of
+	// the header page.
+	pageIndexes map[common.PageId]int
+
+	// Versions of updated records older than the one on the page, from the
+	// oldest to the newest, which snapshots taken before the updates read.
+	// Guarded by `versionsMu`, which is taken after page latches.
+	versions   map[common.RID][]recordVersion
+	versionsMu sync.Mutex
+}
+
+// A version of a record replaced by an update, visible from `begin` until the
+// begin of the next version.
+type recordVersion struct {
+	record []byte
+	begin  versionTimestamp
 }
 
-func NewTableHeap(bufferPoolManager *disk.BufferPoolManager, isNew bool) *TableHeap {
-	th := &TableHeap{
+// Create an empty table heap. It is identified by its header page, see
+// `HeaderPageId`.
+func NewTableHeap(bufferPoolManager *disk.BufferPoolManager) *TableHeap {
+	page, err := bufferPoolManager.NewPage()
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot create table heap header page.")
+	}
+	th := newTableHeap(bufferPoolManager, page.PageId())
+	page.Lock()
+	createHeapFileHeader(page.Data()).init()
+	// The page may have been used before, so the header has to be logged.
+	th.getFreeSpaceMap(page).logWrite(page, 0, heapFileHeaderSize)
+	page.Unlock()
+	bufferPoolManager.UnpinPage(page.PageId(), true)
+	return th
+}
+
+// Open the table heap with the given header page. The database must have been
+// recovered with `Recover` before, once for all of its table heaps.
+func OpenTableHeap(bufferPoolManager *disk.BufferPoolManager, headerPageId common.PageId) *TableHeap {
+	th := newTableHeap(bufferPoolManager, headerPageId)
+	th.resetVersions()
+	return th
+}
+
+func newTableHeap(bufferPoolManager *disk.BufferPoolManager, headerPageId common.PageId) *TableHeap {
+	return &TableHeap{
 		bufferPoolManager: bufferPoolManager,
+		headerPageId:      headerPageId,
+		pageIndexes:       make(map[common.PageId]int),
+		versions:          make(map[common.RID][]recordVersion),
+	}
+}
+
+// The header page, which identifies the table heap in its database.
+func (th *TableHeap) HeaderPageId() common.PageId {
+	return th.headerPageId
+}
+
+// Bring every page in the free space map up to date when the table heap is
+// opened, possibly after recovery:
+//   - Remove the records that are still marked as deleted. Recovery has rolled
+//     back the deletes of unfinished transactions, so these were deleted by
+//     committed transactions, and no snapshot can see them any more.
+//   - Remove the moved records no forwarding pointer leads to any more, i.e.
+//     the ones of removed records and the ones left behind by updates of
+//     committed transactions, and free the overflow pages of removed records.
+//   - Make the other records visible to every snapshot. Timestamps are not
+//     kept across restarts.
+//   - Recompute the free space. Changes to the free space are not logged, so
+//     it may be stale.
+func (th *TableHeap) resetVersions() {
+	headerPage := th.getHeaderPage(true)
+	fsm := th.getFreeSpaceMap(headerPage)
+	pageIds := fsm.pageIds()
+	for i, pageId := range pageIds {
+		th.pageIndexes[pageId] = i
 	}
-	if isNew {
-		if page, err := bufferPoolManager.NewPage(); err != nil {
-			log.WithError(err).Fatalf("Cannot create table heap header page.")
-		} else {
-			if page.PageId() != heapFileHeaderPageId {
-				log.Fatalf("Unexpected: header page id is not 1.")
+	forwarded := make(map[common.RID]bool)
+	overflowRecords := make([][]byte, 0)
+	th.forEachRecord(fsm, pageIds, func(tablePage *TablePage, rid common.RID, page *disk.Page) {
+		if tablePage.isDeleted(rid.SlotNum) {
+			overflowRecords = append(overflowRecords, append([]byte(nil), tablePage.getRecord(rid.SlotNum)...))
+			th.purge(page, rid)
+			return
+		}
+		tablePage.setBegin(rid.SlotNum, committedAt(0))
+		if kind, payload := decodeStoredRecord(tablePage.getRecord(rid.SlotNum)); kind == forwardRecord {
+			forwarded[decodeStoredRID(payload)] = true
+		}
+	})
+	th.forEachRecord(fsm, pageIds, func(tablePage *TablePage, rid common.RID, page *disk.Page) {
+		if kind, _ := decodeStoredRecord(tablePage.getRecord(rid.SlotNum)); kind == movedRecord && !forwarded[rid] {
+			th.purge(page, rid)
+		}
+	})
+	for _, data := range overflowRecords {
+		th.freeOverflowRecord(data)
+	}
+	th.releaseHeaderPage(headerPage, true)
+}
+
+// Call `fn` on every record of the given pages, latched exclusively one at a
+// time, then recompute the free space of the pages, which are all the pages of
+// `fsm` in order.
+func (th *TableHeap) forEachRecord(fsm *freeSpaceMap, pageIds []common.PageId, fn func(tablePage *TablePage, rid common.RID, page *disk.Page)) {
+	for i, pageId := range pageIds {
+		page, err := th.bufferPoolManager.FetchPage(pageId)
+		if err != nil {
+			log.WithError(err).Fatalf("Cannot fetch page %d.", pageId)
+		}
+		page.Lock()
+		tablePage := createTablePage(page.Data())
+		isDirty := false
+		for slotNum := 0; slotNum < int(tablePage.numRecords); slotNum++ {
+			if tablePage.getRecordSize(slotNum) == 0 {
+				continue
 			}
-			header := createHeapFileHeader(page.Data())
-			header.init()
-			th.bufferPoolManager.UnpinPage(page.PageId(), true)
+			fn(tablePage, common.RID{PageId: pageId, SlotNum: slotNum}, page)
+			isDirty = true
 		}
+		fsm.set(i, tablePage.getFreeSpaceForInsert())
+		page.Unlock()
+		th.bufferPoolManager.UnpinPage(pageId, isDirty)
 	}
-	return th
 }
 
 func (th *TableHeap) getHeaderPage(exclusive bool) *disk.Page {
-	page, err := th.bufferPoolManager.FetchPage(heapFileHeaderPageId)
+	page, err := th.bufferPoolManager.FetchPage(th.headerPageId)
 	if err != nil {
 		log.WithError(err).Fatalf("Cannot fetch heap header page.")
 	}
@@ -53,141 +168,762 @@ func (th *TableHeap) releaseHeaderPage(page *disk.Page, exclusive bool) {
 	} else {
 		page.RUnlock()
 	}
-	th.bufferPoolManager.UnpinPage(heapFileHeaderPageId, exclusive)
+	th.bufferPoolManager.UnpinPage(th.headerPageId, exclusive)
 }
 
-func (th *TableHeap) Insert(record []byte) common.RID {
-	internalLoop := func() (common.RID, bool) {
+// Insert a record. Records longer than `maxInlineRecordSize` are stored in
+// overflow pages, and records longer than `MaxRecordSize` are rejected with
+// `ErrRecordTooLarge`. When locking is enabled, `txn` takes an IX lock on the
+// table and an X lock on the new record.
+func (th *TableHeap) Insert(txn *transaction.Transaction, record []byte) (common.RID, error) {
+	if len(record) > MaxRecordSize {
+		return common.RID{}, ErrRecordTooLarge
+	}
+	if err := th.lock(txn, th.tableLockId(), transaction.IntentionExclusive); err != nil {
+		return common.RID{}, err
+	}
+	data := th.encodeRecord(record, minStoredRecordSize)
+	rid, err := th.insertStored(txn, data, common.InvalidPageId)
+	if err != nil {
+		th.freeOverflowRecord(data)
+	}
+	return rid, err
+}
+
+// Insert an encoded record into any page but `excludedPageId`.
+func (th *TableHeap) insertStored(txn *transaction.Transaction, record []byte, excludedPageId common.PageId) (common.RID, error) {
+	internalLoop := func() (common.RID, bool, error) {
 		headerPage := th.getHeaderPage(false)
-		header := createHeapFileHeader(headerPage.Data())
-		pageInfoList := header.getPageInfoList()
-
-		for _, info := range pageInfoList {
-			if int(info.leftSpace) >= len(record) {
-				th.releaseHeaderPage(headerPage, false)
-				rid, ok := th.insertIntoPage(record, info.pageId)
-				if !ok {
-					log.Warnf("Insert a record of length %d into page %d failed.", len(record), info.pageId)
-					return common.RID{}, false
-				} else {
-					return rid, ok
-				}
-			}
-		}
+		pageId, ok := th.getFreeSpaceMap(headerPage).find(len(record), excludedPageId)
 		th.releaseHeaderPage(headerPage, false)
+		if ok {
+			return th.insertIntoPage(txn, record, pageId)
+		}
 		// insert into new page
 		newPage, err := th.bufferPoolManager.NewPage()
 		if err != nil {
 			log.WithError(err).Fatalf("Cannot allocate new page.")
 		}
+		// Nobody else can insert into the page before it is pushed to the
+		// free space map, so the lock is granted without waiting for long.
+		rid := common.RID{PageId: newPage.PageId(), SlotNum: 0}
+		if err := th.lock(txn, rid, transaction.Exclusive); err != nil {
+			th.bufferPoolManager.UnpinPage(newPage.PageId(), false)
+			return common.RID{}, false, err
+		}
 		newPage.Lock()
 
 		newTablePage := createTablePage(newPage.Data())
 		newTablePage.init(newPage.PageId(), int32(len(newPage.Data())))
-		rid, _ := newTablePage.Insert(record) // must be successful
+		newTablePage.Insert(record) // must be successful
+		newTablePage.setBegin(rid.SlotNum, writtenBy(txn))
 
 		headerPage = th.getHeaderPage(true)
-		header = createHeapFileHeader(headerPage.Data())
-		header.pushPageInfo(pageInfo{
-			pageId:    newPage.PageId(),
-			leftSpace: newTablePage.getFreeSpaceForInsert(),
-		})
+		th.appendLogRecord(txn, &wal.LogRecord{
+			Type:         wal.NewPageRecord,
+			PageId:       newPage.PageId(),
+			HeaderPageId: th.headerPageId,
+		}, newPage)
+		index := th.getFreeSpaceMap(headerPage).push(newPage.PageId(), newTablePage.getFreeSpaceForInsert())
+		th.pageIndexes[newPage.PageId()] = index
 		th.releaseHeaderPage(headerPage, true)
+		th.appendWriteRecord(txn, transaction.InsertWrite, rid)
+		th.appendLogRecord(txn, &wal.LogRecord{Type: wal.InsertRecord, RID: rid, Data: record}, newPage)
 
 		newPage.Unlock()
 		th.bufferPoolManager.UnpinPage(newPage.PageId(), true)
-		return rid, true
+		return rid, true, nil
 	}
 	for {
-		rid, ok := internalLoop()
+		rid, ok, err := internalLoop()
+		if err != nil {
+			return common.RID{}, err
+		}
 		if ok {
-			return rid
+			return rid, nil
 		}
+		runtime.Gosched()
 	}
 }
 
-func (th *TableHeap) insertIntoPage(record []byte, pageId common.PageId) (common.RID, bool) {
+// Insert a record into the given page. Returns false if the page is full, or
+// if the slot the record would take is still locked by another transaction,
+// e.g. one that is rolling back an insert into it.
+func (th *TableHeap) insertIntoPage(txn *transaction.Transaction, record []byte, pageId common.PageId) (common.RID, bool, error) {
 	page, err := th.bufferPoolManager.FetchPage(pageId)
 	if err != nil {
 		log.WithError(err).Fatalf("Cannot fetch page %d.", pageId)
 	}
 	page.Lock()
 	tablePage := createTablePage(page.Data())
-	rid, ok := tablePage.Insert(record)
-	if !ok {
+	if tablePage.getFreeSpace() < int32(RecordSlotSize+len(record)) {
 		page.Unlock()
 		th.bufferPoolManager.UnpinPage(pageId, false)
-		return common.RID{}, false
+		log.Warnf("Insert a record of length %d into page %d failed.", len(record), pageId)
+		return common.RID{}, false, nil
 	}
-
-	headerPage := th.getHeaderPage(true)
-	header := createHeapFileHeader(headerPage.Data())
-	header.setPageInfo(pageId, pageInfo{
-		pageId:    pageId,
-		leftSpace: tablePage.getFreeSpaceForInsert(),
-	})
-	th.releaseHeaderPage(headerPage, true)
+	// Waiting for a lock while holding a latch may deadlock, so only try it.
+	rid := common.RID{PageId: pageId, SlotNum: tablePage.getInsertIndex()}
+	if ok, err := th.tryLock(txn, rid, transaction.Exclusive); !ok || err != nil {
+		page.Unlock()
+		th.bufferPoolManager.UnpinPage(pageId, false)
+		return common.RID{}, false, err
+	}
+	tablePage.Insert(record) // must be successful
+	tablePage.setBegin(rid.SlotNum, writtenBy(txn))
+	th.appendWriteRecord(txn, transaction.InsertWrite, rid)
+	th.appendLogRecord(txn, &wal.LogRecord{Type: wal.InsertRecord, RID: rid, Data: record}, page)
+	th.setFreeSpace(pageId, tablePage.getFreeSpaceForInsert())
 
 	page.Unlock()
 	th.bufferPoolManager.UnpinPage(pageId, true)
-	return rid, true
+	return rid, true, nil
 }
 
-func (th *TableHeap) Delete(rid common.RID) bool {
-	headerPage := th.getHeaderPage(false)
-	header := createHeapFileHeader(headerPage.Data())
-	_, ok := header.getPageInfo(rid.PageId)
-	th.releaseHeaderPage(headerPage, false)
+// Delete a record. The record is only marked as deleted, and is removed once
+// no snapshot can see it any more, see `CollectGarbage`. When locking is
+// enabled, `txn` takes an IX lock on the table and an X lock on the record,
+// so that concurrent writers wait for it. Returns `ErrWriteConflict` if the
+// record was changed by a transaction `txn` cannot see.
+func (th *TableHeap) Delete(txn *transaction.Transaction, rid common.RID) (bool, error) {
+	if err := th.lock(txn, th.tableLockId(), transaction.IntentionExclusive); err != nil {
+		return false, err
+	}
+	isNewLock, err := th.lockRecord(txn, rid, transaction.Exclusive)
+	if err != nil {
+		return false, err
+	}
+	page, ok := th.fetchTablePage(rid.PageId)
 	if !ok {
+		th.unlockMissingRecord(txn, rid, isNewLock)
+		return false, nil
+	}
+	page.Lock()
 
-		return false
+	tablePage := createTablePage(page.Data())
+	if ok, err := th.checkWrite(txn, tablePage, rid); !ok {
+		page.Unlock()
+		th.bufferPoolManager.UnpinPage(rid.PageId, false)
+		if err == nil {
+			th.unlockMissingRecord(txn, rid, isNewLock)
+		}
+		return false, err
 	}
+	data := append([]byte(nil), tablePage.getRecord(rid.SlotNum)...)
+	tablePage.MarkDelete(rid)
+	tablePage.setEnd(rid.SlotNum, writtenBy(txn))
+	th.appendWriteRecord(txn, transaction.DeleteWrite, rid)
+	th.appendLogRecord(txn, &wal.LogRecord{Type: wal.MarkDeleteRecord, RID: rid, Data: data}, page)
 
-	page, err := th.bufferPoolManager.FetchPage(rid.PageId)
+	page.Unlock()
+	th.bufferPoolManager.UnpinPage(rid.PageId, true)
+	return true, nil
+}
+
+// Replace a record. The record is rewritten in place if its page has room for
+// it, and is moved to another page otherwise, leaving a forwarding pointer
+// behind so that `rid` keeps working. Snapshots taken before the update keep
+// reading the previous version. Takes the same locks as `Delete`, and returns
+// `ErrWriteConflict` in the same cases. Records are limited in size as with
+// `Insert`.
+func (th *TableHeap) Update(txn *transaction.Transaction, rid common.RID, record []byte) (bool, error) {
+	if len(record) > MaxRecordSize {
+		return false, ErrRecordTooLarge
+	}
+	if err := th.lock(txn, th.tableLockId(), transaction.IntentionExclusive); err != nil {
+		return false, err
+	}
+	isNewLock, err := th.lockRecord(txn, rid, transaction.Exclusive)
 	if err != nil {
-		log.WithError(err).Fatalf("Unexpected page not found.")
+		return false, err
 	}
-	page.Lock()
+	page, ok := th.fetchTablePage(rid.PageId)
+	if !ok {
+		th.unlockMissingRecord(txn, rid, isNewLock)
+		return false, nil
+	}
+	page.RLock()
+	tablePage := createTablePage(page.Data())
+	ok, err = th.checkWrite(txn, tablePage, rid)
+	var oldData []byte
+	if ok {
+		oldData = append([]byte(nil), tablePage.getRecord(rid.SlotNum)...)
+	}
+	page.RUnlock()
+	th.bufferPoolManager.UnpinPage(rid.PageId, false)
+	if !ok {
+		if err == nil {
+			th.unlockMissingRecord(txn, rid, isNewLock)
+		}
+		return false, err
+	}
+
+	// The X lock keeps the record as it is until it is rewritten.
+	oldRecord, ok := th.readStoredRecord(txn, rid, oldData)
+	if !ok {
+		log.Fatalf("Cannot read the moved record of %s.", rid.String())
+	}
+	// The new record is padded to the length of the old one, so that the old
+	// one fits again when rolling back. Overflow records always fit, only
+	// plain records may have to be moved.
+	if th.rewrite(txn, rid, th.encodeRecord(record, len(oldData)), oldData, oldRecord) {
+		return true, nil
+	}
+	movedTo, err := th.insertStored(txn, encodeMovedRecord(rid, record), rid.PageId)
+	if err != nil {
+		return false, err
+	}
+	if !th.rewrite(txn, rid, encodeForwardRecord(movedTo, len(oldData)), oldData, oldRecord) {
+		log.Fatalf("Cannot leave a forwarding pointer at %s.", rid.String())
+	}
+	return true, nil
+}
 
+// Replace the stored record at `rid` with `data` in place, and keep the
+// previous version for older snapshots. Returns false if the page does not
+// have enough free space.
+func (th *TableHeap) rewrite(txn *transaction.Transaction, rid common.RID, data []byte, oldData []byte, oldRecord []byte) bool {
+	page, ok := th.fetchTablePage(rid.PageId)
+	if !ok {
+		log.Fatalf("Unexpected page not found.")
+	}
+	page.Lock()
 	tablePage := createTablePage(page.Data())
-	deleted := tablePage.Delete(rid)
-	freeSpace := tablePage.getFreeSpaceForInsert()
-	if !deleted {
-		th.bufferPoolManager.UnpinPage(rid.PageId, false)
+	if !tablePage.Update(rid, data) {
 		page.Unlock()
+		th.bufferPoolManager.UnpinPage(rid.PageId, false)
 		return false
 	}
+	th.pushVersion(rid, recordVersion{record: oldRecord, begin: tablePage.getRecordSlot(rid.SlotNum).begin})
+	tablePage.setBegin(rid.SlotNum, writtenBy(txn))
+	th.appendWriteRecord(txn, transaction.UpdateWrite, rid).OldData = oldData
+	th.appendLogRecord(txn, &wal.LogRecord{Type: wal.UpdateRecord, RID: rid, Data: data, OldData: oldData}, page)
+	th.setFreeSpace(rid.PageId, tablePage.getFreeSpaceForInsert())
 
-	headerPage = th.getHeaderPage(true)
-	header = createHeapFileHeader(headerPage.Data())
-	header.setPageInfo(rid.PageId, pageInfo{
-		pageId:    rid.PageId,
-		leftSpace: freeSpace,
-	})
-	th.releaseHeaderPage(headerPage, true)
-	
 	page.Unlock()
 	th.bufferPoolManager.UnpinPage(rid.PageId, true)
 	return true
 }
 
-func (th *TableHeap) Get(rid common.RID) ([]byte, bool) {
+// Check whether `txn` may write the record at `rid`, whose page is latched.
+// Returns false if `txn` does not see the record, and `ErrWriteConflict` if
+// the version `txn` sees was replaced or deleted by a transaction `txn`
+// cannot see.
+func (th *TableHeap) checkWrite(txn *transaction.Transaction, tablePage *TablePage, rid common.RID) (bool, error) {
+	slot, ok := tablePage.getVersion(rid)
+	if !ok {
+		return false, nil
+	}
+	if kind, _ := decodeStoredRecord(tablePage.getRecord(rid.SlotNum)); kind == movedRecord {
+		return false, nil
+	}
+	if !slot.begin.visibleTo(txn) {
+		if _, ok := th.oldVersion(txn, rid); ok {
+			return false, ErrWriteConflict
+		}
+		return false, nil
+	}
+	if tablePage.isDeleted(rid.SlotNum) {
+		if slot.end.visibleTo(txn) {
+			return false, nil
+		}
+		return false, ErrWriteConflict
+	}
+	return true, nil
+}
+
+// Get the version of a record in the snapshot of `txn`. Readers take no locks,
+// so they neither wait for writers nor hold them up.
+func (th *TableHeap) Get(txn *transaction.Transaction, rid common.RID) ([]byte, bool, error) {
+	page, ok := th.fetchTablePage(rid.PageId)
+	if !ok {
+		return nil, false, nil
+	}
+	page, record, found := th.readVersion(txn, page, rid)
+	th.bufferPoolManager.UnpinPage(page.PageId(), false)
+	return record, found, nil
+}
+
+// Read the version of the record at `rid` in the snapshot of `txn` from its
+// page, which must be pinned but not latched. The page is unpinned while a
+// record moved to another page is read, so that only one page is pinned at a
+// time, and pinned again after. Returns the page pinned, which may be in
+// another frame.
+func (th *TableHeap) readVersion(txn *transaction.Transaction, page *disk.Page, rid common.RID) (*disk.Page, []byte, bool) {
+	for {
+		page.RLock()
+		record, movedTo, ok := th.readVersionInPage(txn, createTablePage(page.Data()), rid)
+		page.RUnlock()
+		if !ok || movedTo == nil {
+			return page, record, ok
+		}
+		th.bufferPoolManager.UnpinPage(rid.PageId, false)
+		record, ok = th.readMovedRecord(txn, rid, *movedTo)
+		var err error
+		if page, err = th.bufferPoolManager.FetchPage(rid.PageId); err != nil {
+			log.WithError(err).Fatalf("Cannot fetch page %d.", rid.PageId)
+		}
+		if ok {
+			return page, record, true
+		}
+		// The record was moved again meanwhile, read the home page again.
+	}
+}
+
+// Read the version of the record at `rid` in the snapshot of `txn` from its
+// latched page. If the version was moved to another page, only the RID it was
+// moved to is returned, and it is read with `readMovedRecord` once the latch
+// is released: readers never hold two table page latches at once, since
+// coupling them could deadlock with writers waiting in between. Overflow pages
+// are only ever latched after their table page, so they are read right away.
+func (th *TableHeap) readVersionInPage(txn *transaction.Transaction, tablePage *TablePage, rid common.RID) ([]byte, *common.RID, bool) {
+	slot, ok := tablePage.getVersion(rid)
+	if !ok {
+		return nil, nil, false
+	}
+	kind, payload := decodeStoredRecord(tablePage.getRecord(rid.SlotNum))
+	switch {
+	case kind == movedRecord:
+		return nil, nil, false // Only reached through its forwarding pointer.
+	case !slot.begin.visibleTo(txn):
+		record, ok := th.oldVersion(txn, rid)
+		return record, nil, ok
+	case !slot.visibleTo(txn):
+		return nil, nil, false
+	case kind == forwardRecord:
+		movedTo := decodeStoredRID(payload)
+		return nil, &movedTo, true
+	default:
+		return th.decodeRecord(kind, payload), nil, true
+	}
+}
+
+// Read the record moved from `home` to `movedTo`. Returns false if the record
+// has been moved again since the forwarding pointer was read, and the slot
+// was reused.
+func (th *TableHeap) readMovedRecord(txn *transaction.Transaction, home common.RID, movedTo common.RID) ([]byte, bool) {
+	page, err := th.bufferPoolManager.FetchPage(movedTo.PageId)
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot fetch page %d.", movedTo.PageId)
+	}
+	page.RLock()
+	defer func() {
+		page.RUnlock()
+		th.bufferPoolManager.UnpinPage(movedTo.PageId, false)
+	}()
+	tablePage := createTablePage(page.Data())
+	// The moved record is written along with the forwarding pointer, so it is
+	// visible too unless it was written later.
+	slot, ok := tablePage.getVersion(movedTo)
+	if !ok || !slot.begin.visibleTo(txn) {
+		return nil, false
+	}
+	kind, payload := decodeStoredRecord(tablePage.getRecord(movedTo.SlotNum))
+	if kind != movedRecord || decodeStoredRID(payload) != home {
+		return nil, false
+	}
+	return append([]byte{}, payload[storedRIDSize:]...), true
+}
+
+// The record held by the stored record `data` at `rid`, following the
+// forwarding pointer if it was moved.
+func (th *TableHeap) readStoredRecord(txn *transaction.Transaction, rid common.RID, data []byte) ([]byte, bool) {
+	kind, payload := decodeStoredRecord(data)
+	if kind == forwardRecord {
+		return th.readMovedRecord(txn, rid, decodeStoredRID(payload))
+	}
+	return th.decodeRecord(kind, payload), true
+}
+
+// The record held by a stored record which is neither moved nor a forwarding
+// pointer.
+func (th *TableHeap) decodeRecord(kind byte, payload []byte) []byte {
+	if kind == overflowRecord {
+		pageId, size := decodeOverflowRecord(payload)
+		return readOverflowPages(th.bufferPoolManager, pageId, size)
+	}
+	return append([]byte{}, payload...)
+}
+
+// Encode a record to replace a stored record of `size` bytes, spilling it
+// into overflow pages if it is too long.
+func (th *TableHeap) encodeRecord(record []byte, size int) []byte {
+	if len(record) > maxInlineRecordSize {
+		return encodeOverflowRecord(writeOverflowPages(th.bufferPoolManager, record), len(record), size)
+	}
+	return encodePlainRecord(record, size)
+}
+
+// The newest version replaced by an update that is visible to `txn`.
+func (th *TableHeap) oldVersion(txn *transaction.Transaction, rid common.RID) ([]byte, bool) {
+	th.versionsMu.Lock()
+	defer th.versionsMu.Unlock()
+	versions := th.versions[rid]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].begin.visibleTo(txn) {
+			return append([]byte{}, versions[i].record...), true
+		}
+	}
+	return nil, false
+}
+
+func (th *TableHeap) pushVersion(rid common.RID, version recordVersion) {
+	th.versionsMu.Lock()
+	defer th.versionsMu.Unlock()
+	th.versions[rid] = append(th.versions[rid], version)
+}
+
+func (th *TableHeap) popVersion(rid common.RID) recordVersion {
+	th.versionsMu.Lock()
+	defer th.versionsMu.Unlock()
+	versions := th.versions[rid]
+	version := versions[len(versions)-1]
+	if len(versions) == 1 {
+		delete(th.versions, rid)
+	} else {
+		th.versions[rid] = versions[:len(versions)-1]
+	}
+	return version
+}
+
+// Drop the versions of `rid` no snapshot can see any more, i.e. the ones
+// replaced by a version committed at or before `oldestSnapshot`. `begin` is
+// the begin timestamp of the version on the page.
+func (th *TableHeap) pruneVersions(rid common.RID, begin versionTimestamp, oldestSnapshot transaction.Timestamp) {
+	th.versionsMu.Lock()
+	defer th.versionsMu.Unlock()
+	versions, ok := th.versions[rid]
+	if !ok {
+		return
+	}
+	for i := len(versions); i > 0; i-- {
+		next := begin
+		if i < len(versions) {
+			next = versions[i].begin
+		}
+		if next.isCommitted() && transaction.Timestamp(next) <= oldestSnapshot {
+			versions = versions[i:]
+			break
+		}
+	}
+	if len(versions) == 0 {
+		delete(th.versions, rid)
+	} else {
+		th.versions[rid] = versions
+	}
+}
+
+// Stamp the writes of a committed transaction with its commit timestamp,
+// which makes them visible to the snapshots taken from then on. The stamps
+// are not logged, since timestamps are not kept across restarts. Updated
+// records lose their padding, and what the replaced records pointed to is
+// released.
+func (th *TableHeap) CommitWrite(txn *transaction.Transaction, record *transaction.WriteRecord) {
+	page, ok := th.fetchTablePage(record.RID.PageId)
+	if !ok {
+		log.Fatalf("Unexpected page not found.")
+	}
+	page.Lock()
+	tablePage := createTablePage(page.Data())
+	switch record.Type {
+	case transaction.InsertWrite:
+		tablePage.setBegin(record.RID.SlotNum, committedAt(txn.CommitTimestamp()))
+	case transaction.DeleteWrite:
+		tablePage.setEnd(record.RID.SlotNum, committedAt(txn.CommitTimestamp()))
+	case transaction.UpdateWrite:
+		tablePage.setBegin(record.RID.SlotNum, committedAt(txn.CommitTimestamp()))
+		data := tablePage.getRecord(record.RID.SlotNum)
+		if compact := compactStoredRecord(data); len(compact) < len(data) {
+			oldData := append([]byte(nil), data...)
+			tablePage.Update(record.RID, compact)
+			th.appendLogRecord(nil, &wal.LogRecord{Type: wal.UpdateRecord, RID: record.RID, Data: compact, OldData: oldData}, page)
+			th.setFreeSpace(record.RID.PageId, tablePage.getFreeSpaceForInsert())
+		}
+	}
+	page.Unlock()
+	th.bufferPoolManager.UnpinPage(record.RID.PageId, true)
+
+	// Forwarding pointers and overflow records are never rewritten in place,
+	// so what the replaced record pointed to is not reachable any more.
+	if record.Type == transaction.UpdateWrite {
+		th.releaseStoredRecord(record.OldData)
+	}
+}
+
+// Remove the records deleted by transactions which committed at or before
+// `oldestSnapshot`, i.e. which no snapshot can see any more, as well as the
+// versions replaced by updates that no snapshot can see. The overflow pages of
+// removed records are freed. Returns the number of removed records.
+func (th *TableHeap) CollectGarbage(oldestSnapshot transaction.Timestamp) int {
 	headerPage := th.getHeaderPage(false)
-	header := createHeapFileHeader(headerPage.Data())
-	_, ok := header.getPageInfo(rid.PageId)
+	pageIds := th.getFreeSpaceMap(headerPage).pageIds()
 	th.releaseHeaderPage(headerPage, false)
+
+	numRemoved := 0
+	for _, pageId := range pageIds {
+		page, err := th.bufferPoolManager.FetchPage(pageId)
+		if err != nil {
+			log.WithError(err).Fatalf("Cannot fetch page %d.", pageId)
+		}
+		page.Lock()
+		tablePage := createTablePage(page.Data())
+		isDirty := false
+		var removedRecords [][]byte
+		for slotNum := 0; slotNum < int(tablePage.numRecords); slotNum++ {
+			rid := common.RID{PageId: pageId, SlotNum: slotNum}
+			slot, ok := tablePage.getVersion(rid)
+			if !ok {
+				continue
+			}
+			if !tablePage.isDeleted(slotNum) || !slot.end.isCommitted() ||
+				transaction.Timestamp(slot.end) > oldestSnapshot {
+				th.pruneVersions(rid, slot.begin, oldestSnapshot)
+				continue
+			}
+			removedRecords = append(removedRecords, append([]byte(nil), tablePage.getRecord(slotNum)...))
+			th.purge(page, rid)
+			th.pruneVersions(rid, slot.end, oldestSnapshot)
+			isDirty = true
+			numRemoved++
+		}
+		if isDirty {
+			th.setFreeSpace(pageId, tablePage.getFreeSpaceForInsert())
+		}
+		page.Unlock()
+		th.bufferPoolManager.UnpinPage(pageId, isDirty)
+		for _, data := range removedRecords {
+			th.releaseStoredRecord(data)
+		}
+	}
+	return numRemoved
+}
+
+// Remove a deleted record, whose transaction has committed. The removal is
+// never undone, so it is logged on its own. The page must be latched
+// exclusively.
+func (th *TableHeap) purge(page *disk.Page, rid common.RID) {
+	tablePage := createTablePage(page.Data())
+	data := append([]byte(nil), tablePage.getRecord(rid.SlotNum)...)
+	if !tablePage.Delete(rid) {
+		log.Fatalf("Cannot delete record %s.", rid.String())
+	}
+	th.appendLogRecord(nil, &wal.LogRecord{Type: wal.DeleteRecord, RID: rid, Data: data}, page)
+}
+
+// Remove a moved record no forwarding pointer leads to any more.
+func (th *TableHeap) purgeMovedRecord(rid common.RID) {
+	page, ok := th.fetchTablePage(rid.PageId)
 	if !ok {
-		return nil, false
+		log.Fatalf("Unexpected page not found.")
 	}
+	page.Lock()
+	th.purge(page, rid)
+	th.setFreeSpace(rid.PageId, createTablePage(page.Data()).getFreeSpaceForInsert())
+	page.Unlock()
+	th.bufferPoolManager.UnpinPage(rid.PageId, true)
+}
 
-	page, err := th.bufferPoolManager.FetchPage(rid.PageId)
-	if err != nil {
-		log.WithError(err).Fatalf("Unexpected page not found.")
+// Release what a stored record which was removed or replaced points to: the
+// moved record of a forwarding pointer, or the pages of an overflow record.
+func (th *TableHeap) releaseStoredRecord(data []byte) {
+	if kind, payload := decodeStoredRecord(data); kind == forwardRecord {
+		th.purgeMovedRecord(decodeStoredRID(payload))
+	} else {
+		th.freeOverflowRecord(data)
+	}
+}
+
+func (th *TableHeap) freeOverflowRecord(data []byte) {
+	if kind, payload := decodeStoredRecord(data); kind == overflowRecord {
+		pageId, _ := decodeOverflowRecord(payload)
+		freeOverflowPages(th.bufferPoolManager, pageId)
+	}
+}
+
+// Revert a write of an aborting transaction, and log the compensation.
+func (th *TableHeap) RollbackWrite(txn *transaction.Transaction, record *transaction.WriteRecord) {
+	page, ok := th.fetchTablePage(record.RID.PageId)
+	if !ok {
+		log.Fatalf("Unexpected page not found.")
 	}
 	page.Lock()
 	tablePage := createTablePage(page.Data())
-	data, found := tablePage.Get(rid)
+	data := append([]byte(nil), tablePage.getRecord(record.RID.SlotNum)...)
+	clr := &wal.LogRecord{RID: record.RID, Data: data, UndoNextLSN: record.UndoNextLSN}
+	switch record.Type {
+	case transaction.InsertWrite:
+		clr.Type = wal.UndoInsertRecord
+		if !tablePage.Delete(record.RID) {
+			log.Fatalf("Cannot delete record %s.", record.RID.String())
+		}
+		th.setFreeSpace(record.RID.PageId, tablePage.getFreeSpaceForInsert())
+	case transaction.DeleteWrite:
+		clr.Type = wal.UndoMarkDeleteRecord
+		if !tablePage.RollbackDelete(record.RID) {
+			log.Fatalf("Cannot restore record %s.", record.RID.String())
+		}
+	case transaction.UpdateWrite:
+		// The updated record is never shorter, so the old one fits.
+		clr.Type = wal.UndoUpdateRecord
+		clr.OldData = record.OldData
+		if !tablePage.Update(record.RID, record.OldData) {
+			log.Fatalf("Cannot restore record %s.", record.RID.String())
+		}
+		tablePage.setBegin(record.RID.SlotNum, th.popVersion(record.RID).begin)
+		th.setFreeSpace(record.RID.PageId, tablePage.getFreeSpaceForInsert())
+	}
+	th.appendLogRecord(txn, clr, page)
+
 	page.Unlock()
-	th.bufferPoolManager.UnpinPage(rid.PageId, false)
-	return data, found
+	th.bufferPoolManager.UnpinPage(record.RID.PageId, true)
+	// Moved records are removed by rolling back their inserts.
+	if record.Type == transaction.InsertWrite || record.Type == transaction.UpdateWrite {
+		th.freeOverflowRecord(data)
+	}
+}
+
+// Give all pages of the table heap back to the page store, including the
+// overflow pages of its records. The table heap must not be used any more,
+// e.g. because its table was dropped and no transaction can see it.
+func (th *TableHeap) Drop() {
+	headerPage := th.getHeaderPage(true)
+	fsm := th.getFreeSpaceMap(headerPage)
+	pageIds := fsm.pageIds()
+	mapPageIds := fsm.mapPageIds()
+	th.releaseHeaderPage(headerPage, true)
+
+	freedPageIds := make([]common.PageId, 0)
+	for _, pageId := range pageIds {
+		page, err := th.bufferPoolManager.FetchPage(pageId)
+		if err != nil {
+			log.WithError(err).Fatalf("Cannot fetch page %d.", pageId)
+		}
+		page.RLock()
+		tablePage := createTablePage(page.Data())
+		var firstPageIds []common.PageId
+		for slotNum := 0; slotNum < int(tablePage.numRecords); slotNum++ {
+			if tablePage.getRecordSize(slotNum) == 0 {
+				continue
+			}
+			if kind, payload := decodeStoredRecord(tablePage.getRecord(slotNum)); kind == overflowRecord {
+				firstPageId, _ := decodeOverflowRecord(payload)
+				firstPageIds = append(firstPageIds, firstPageId)
+			}
+		}
+		page.RUnlock()
+		th.bufferPoolManager.UnpinPage(pageId, false)
+		for _, firstPageId := range firstPageIds {
+			freedPageIds = append(freedPageIds, overflowChain(th.bufferPoolManager, firstPageId)...)
+		}
+	}
+	freedPageIds = append(freedPageIds, pageIds...)
+	freedPageIds = append(freedPageIds, mapPageIds...)
+	freePages(th.bufferPoolManager, append(freedPageIds, th.headerPageId))
+}
+
+// Fetch a page of the table heap, or return false if the page does not
+// belong to it.
+func (th *TableHeap) fetchTablePage(pageId common.PageId) (*disk.Page, bool) {
+	headerPage := th.getHeaderPage(false)
+	_, ok := th.pageIndexes[pageId]
+	th.releaseHeaderPage(headerPage, false)
+	if !ok {
+		return nil, false
+	}
+
+	page, err := th.bufferPoolManager.FetchPage(pageId)
+	if err != nil {
+		log.WithError(err).Fatalf("Unexpected page not found.")
+	}
+	return page, true
+}
+
+// Lock the table as a whole for `txn`, e.g. exclusively to keep writers out
+// while the table is dropped. Does nothing if locking is disabled.
+func (th *TableHeap) LockTable(txn *transaction.Transaction, mode transaction.LockMode) error {
+	return th.lock(txn, th.tableLockId(), mode)
+}
+
+// The lock on the table as a whole, which sits above the record locks in the
+// locking hierarchy.
+func (th *TableHeap) tableLockId() common.RID {
+	return common.RID{PageId: th.headerPageId, SlotNum: -1}
+}
+
+// Take a lock for `txn`, or do nothing if locking is disabled. Locks are only
+// waited for without holding any latch.
+func (th *TableHeap) lock(txn *transaction.Transaction, rid common.RID, mode transaction.LockMode) error {
+	lockManager := txn.LockManager()
+	if lockManager == nil {
+		return nil
+	}
+	return lockManager.Lock(txn, rid, mode)
+}
+
+func (th *TableHeap) tryLock(txn *transaction.Transaction, rid common.RID, mode transaction.LockMode) (bool, error) {
+	lockManager := txn.LockManager()
+	if lockManager == nil {
+		return true, nil
+	}
+	return lockManager.TryLock(txn, rid, mode)
+}
+
+// Like `lock`, but also return whether `txn` did not hold any lock on the
+// record before.
+func (th *TableHeap) lockRecord(txn *transaction.Transaction, rid common.RID, mode transaction.LockMode) (bool, error) {
+	_, held := txn.HeldLockMode(rid)
+	if err := th.lock(txn, rid, mode); err != nil {
+		return false, err
+	}
+	return !held, nil
+}
+
+// Release a lock taken on a record that does not exist, since it protects
+// nothing. Locks held before are kept, e.g. for a record `txn` has deleted.
+func (th *TableHeap) unlockMissingRecord(txn *transaction.Transaction, rid common.RID, isNewLock bool) {
+	if lockManager := txn.LockManager(); lockManager != nil && isNewLock {
+		lockManager.Unlock(txn, rid)
+	}
+}
+
+func (th *TableHeap) setFreeSpace(pageId common.PageId, freeSpace int32) {
+	headerPage := th.getHeaderPage(true)
+	th.getFreeSpaceMap(headerPage).set(th.pageIndexes[pageId], freeSpace)
+	th.releaseHeaderPage(headerPage, true)
+}
+
+// Record a write in the write set of `txn`. Must be called right before the
+// write is logged.
+func (th *TableHeap) appendWriteRecord(txn *transaction.Transaction, writeType transaction.WriteType, rid common.RID) *transaction.WriteRecord {
+	record := &transaction.WriteRecord{
+		Type:        writeType,
+		RID:         rid,
+		Table:       th,
+		UndoNextLSN: txn.PrevLSN(),
+	}
+	txn.AppendWriteRecord(record)
+	return record
+}
+
+// Append a record of `txn` to the write-ahead log if logging is enabled, and
+// stamp its LSN onto the modified pages. The pages must be latched
+// exclusively. A nil `txn` logs a record that belongs to no transaction.
+func (th *TableHeap) appendLogRecord(txn *transaction.Transaction, record *wal.LogRecord, pages ...*disk.Page) {
+	logManager := th.bufferPoolManager.LogManager()
+	if logManager == nil {
+		return
+	}
+	record.TxnId = common.InvalidTxnId
+	record.PrevLSN = common.InvalidLSN
+	if txn != nil {
+		record.TxnId = txn.TxnId()
+		record.PrevLSN = txn.PrevLSN()
+	}
+	lsn := logManager.AppendLogRecord(record)
+	if txn != nil {
+		txn.SetPrevLSN(lsn)
+	}
+	for _, page := range pages {
+		page.SetLSN(lsn)
+	}
 }
@@ -0,0 +1,176 @@
+package table
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/wal"
+)
+
+const (
+	crashWorkloadEnv = "TABLE_HEAP_CRASH_WORKLOAD"
+	crashRounds      = 5
+)
+
+// Not a real test: runs the insert/delete workload of `insertDeleteUtilsFunc`
+// forever in a child process started by `TestTableHeap_CrashRecovery`, and
+// reports every operation on stdout. An operation is announced before it
+// starts and acknowledged once it has returned, i.e. once it is committed.
+// Checkpoints are taken in the background all along.
+func TestTableHeap_CrashWorkload(t *testing.T) {
+	if os.Getenv(crashWorkloadEnv) == "" {
+		t.Skip("Only runs as the child process of TestTableHeap_CrashRecovery.")
+	}
+	h := openLoggedTableHeap(os.Getenv(crashWorkloadEnv) == "new")
+	h.logManager.SetSegmentSize(16 << 10)
+	disk.NewCheckpointManager(h.bufferPoolManager, time.Millisecond).Start()
+	out := bufio.NewWriter(os.Stdout)
+
+	liveRIDs := make([]common.RID, 0)
+	for {
+		if rand.Float64() <= 0.7 || len(liveRIDs) == 0 {
+			data := make([]byte, rand.Intn(512)+1)
+			rand.Read(data)
+			fmt.Fprintf(out, "insert %s\n", hex.EncodeToString(data))
+			out.Flush()
+			txn := h.txnManager.Begin()
+			rid, _ := h.tableHeap.Insert(txn, data)
+			h.txnManager.Commit(txn)
+			liveRIDs = append(liveRIDs, rid)
+			fmt.Fprintf(out, "done %d %d\n", rid.PageId, rid.SlotNum)
+		} else {
+			idx := rand.Intn(len(liveRIDs))
+			rid := liveRIDs[idx]
+			fmt.Fprintf(out, "delete %d %d\n", rid.PageId, rid.SlotNum)
+			out.Flush()
+			txn := h.txnManager.Begin()
+			h.tableHeap.Delete(txn, rid)
+			h.txnManager.Commit(txn)
+			liveRIDs = append(liveRIDs[:idx], liveRIDs[idx+1:]...)
+			fmt.Fprintf(out, "done\n")
+		}
+		out.Flush()
+	}
+}
+
+// Kill the workload at random points, then check that recovery keeps exactly
+// the acknowledged operations, plus possibly the one in flight.
+func TestTableHeap_CrashRecovery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skip crash recovery in short mode.")
+	}
+	defer os.Remove("test.db")
+	defer wal.RemoveLogFiles("test.log")
+	os.Remove("test.db")
+	wal.RemoveLogFiles("test.log")
+
+	liveRecords := make(map[common.RID][]byte)
+	for round := 0; round < crashRounds; round++ {
+		mode := "open"
+		if round == 0 {
+			mode = "new"
+		}
+		cmd := exec.Command(os.Args[0], "-test.run=^TestTableHeap_CrashWorkload$")
+		cmd.Env = append(os.Environ(), crashWorkloadEnv+"="+mode)
+		stdout, err := cmd.StdoutPipe()
+		require.Nil(t, err)
+		require.Nil(t, cmd.Start())
+
+		// Let the child run for a random number of operations, then kill it
+		// and drain whatever it acknowledged before dying.
+		killAfter := rand.Intn(300) + 20
+		var pendingOp string
+		numLines := 0
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 4096), 1<<20)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "done") {
+				applyAcknowledgedOp(t, liveRecords, pendingOp, line)
+				pendingOp = ""
+			} else {
+				pendingOp = line
+			}
+			numLines++
+			if numLines == killAfter {
+				cmd.Process.Kill()
+			}
+		}
+		cmd.Wait()
+		require.True(t, numLines >= killAfter, "Round %d: workload exited unexpectedly.", round)
+
+		h := openLoggedTableHeap(false)
+		txn := h.txnManager.Begin()
+		expectedCount := len(liveRecords)
+		for rid, data := range liveRecords {
+			readData, found, err := h.tableHeap.Get(txn, rid)
+			require.Nil(t, err)
+			if !found && strings.HasPrefix(pendingOp, fmt.Sprintf("delete %d %d", rid.PageId, rid.SlotNum)) {
+				// The in-flight delete committed.
+				delete(liveRecords, rid)
+				expectedCount--
+				continue
+			}
+			require.True(t, found, "Round %d: record %s is lost.", round, rid.String())
+			require.Equal(t, data, readData)
+		}
+		require.Nil(t, h.txnManager.Commit(txn))
+		count := countRecords(h.tableHeap)
+		if count == expectedCount+1 && strings.HasPrefix(pendingOp, "insert") {
+			// The in-flight insert committed, but we do not know where it went.
+			data, _ := hex.DecodeString(strings.TrimPrefix(pendingOp, "insert "))
+			rid, ok := findRecord(h.tableHeap, data, liveRecords)
+			require.True(t, ok)
+			liveRecords[rid] = data
+			count--
+		}
+		require.Equal(t, expectedCount, count, "Round %d: unexpected number of records.", round)
+		h.close()
+	}
+}
+
+func applyAcknowledgedOp(t *testing.T, liveRecords map[common.RID][]byte, op string, ack string) {
+	var rid common.RID
+	if strings.HasPrefix(op, "insert ") {
+		data, err := hex.DecodeString(strings.TrimPrefix(op, "insert "))
+		require.Nil(t, err)
+		fmt.Sscanf(ack, "done %d %d", &rid.PageId, &rid.SlotNum)
+		liveRecords[rid] = data
+	} else {
+		fmt.Sscanf(op, "delete %d %d", &rid.PageId, &rid.SlotNum)
+		delete(liveRecords, rid)
+	}
+}
+
+// Find the RID of a record with the given data, which is not one of `known`.
+func findRecord(tableHeapFile *TableHeap, data []byte, known map[common.RID][]byte) (common.RID, bool) {
+	headerPage := tableHeapFile.getHeaderPage(false)
+	defer tableHeapFile.releaseHeaderPage(headerPage, false)
+	for _, pageId := range tableHeapFile.getFreeSpaceMap(headerPage).pageIds() {
+		page, _ := tableHeapFile.bufferPoolManager.FetchPage(pageId)
+		tablePage := createTablePage(page.Data())
+		for i := 0; i < int(tablePage.numRecords); i++ {
+			rid := common.RID{PageId: pageId, SlotNum: i}
+			if _, ok := known[rid]; ok {
+				continue
+			}
+			if string(tablePage.getRecord(i)) == string(encodePlainRecord(data, minStoredRecordSize)) {
+				tableHeapFile.bufferPoolManager.UnpinPage(pageId, false)
+				return rid, true
+			}
+		}
+		tableHeapFile.bufferPoolManager.UnpinPage(pageId, false)
+	}
+	return common.RID{}, false
+}
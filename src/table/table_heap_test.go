@@ -5,21 +5,22 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"simple-db-golang/src/common"
 	"simple-db-golang/src/disk"
+	"simple-db-golang/src/transaction"
+	"simple-db-golang/src/wal"
 )
 
 func TestNewTableHeap(t *testing.T) {
-	defer os.Remove("test.db")
-	diskManager := disk.NewDiskManager("test.db")
-	defer diskManager.Close()
+	store := disk.NewMemoryPageStore()
 	replacer := disk.NewLRUReplacer()
-	bufferPoolManager := disk.NewBufferPoolManager(8, diskManager, replacer)
+	bufferPoolManager := disk.NewBufferPoolManager(8, store, replacer)
 
-	tableHeapFile := NewTableHeap(bufferPoolManager, true)
+	tableHeapFile := NewTableHeap(bufferPoolManager)
 
 	headerPage := tableHeapFile.getHeaderPage(false)
 	header := createHeapFileHeader(headerPage.Data())
@@ -27,25 +28,29 @@ func TestNewTableHeap(t *testing.T) {
 	tableHeapFile.releaseHeaderPage(headerPage, false)
 }
 
-func testTableDataFunc(t *testing.T, tableHeapFile *TableHeap, allData [][]byte, allRIDs []common.RID) {
+func testTableDataFunc(t *testing.T, txnManager *transaction.TransactionManager, tableHeapFile *TableHeap, allData [][]byte, allRIDs []common.RID) {
 	headerPage := tableHeapFile.getHeaderPage(false)
-	header := createHeapFileHeader(headerPage.Data())
-	pageInfoList := header.getPageInfoList()
-	for _, info := range pageInfoList {
-		page, _ := tableHeapFile.bufferPoolManager.FetchPage(info.pageId)
+	fsm := tableHeapFile.getFreeSpaceMap(headerPage)
+	for i, pageId := range fsm.pageIds() {
+		page, _ := tableHeapFile.bufferPoolManager.FetchPage(pageId)
 		tablePage := createTablePage(page.Data())
-		require.Equal(t, info.leftSpace, tablePage.getFreeSpaceForInsert())
-		tableHeapFile.bufferPoolManager.UnpinPage(info.pageId, false)
+		require.Equal(t, freeSpaceCategory(tablePage.getFreeSpaceForInsert()), mapCategory(fsm, i))
+		tableHeapFile.bufferPoolManager.UnpinPage(pageId, false)
 	}
+	tableHeapFile.releaseHeaderPage(headerPage, false)
 
+	txn := txnManager.Begin()
 	for i, rid := range allRIDs {
-		data, found := tableHeapFile.Get(rid)
+		data, found, err := tableHeapFile.Get(txn, rid)
+		require.Nil(t, err)
 		require.True(t, found)
 		require.Equal(t, allData[i], data)
 	}
+	require.Nil(t, txnManager.Commit(txn))
 }
 
-func insertDeleteUtilsFunc(tableHeapFile *TableHeap, total int, insertProb float64) ([][]byte, []common.RID) {
+// Insert and delete random records, each in its own transaction.
+func insertDeleteUtilsFunc(txnManager *transaction.TransactionManager, tableHeapFile *TableHeap, total int, insertProb float64) ([][]byte, []common.RID) {
 	allData := make([][]byte, 0)
 	allRIDs := make([]common.RID, 0)
 	for i := 0; i < total; i++ {
@@ -54,12 +59,16 @@ func insertDeleteUtilsFunc(tableHeapFile *TableHeap, total int, insertProb float
 			length := rand.Intn(512) + 1
 			randStr := make([]byte, length)
 			rand.Read(randStr)
-			rid := tableHeapFile.Insert(randStr)
+			txn := txnManager.Begin()
+			rid, _ := tableHeapFile.Insert(txn, randStr)
+			txnManager.Commit(txn)
 			allData = append(allData, randStr)
 			allRIDs = append(allRIDs, rid)
 		} else { // is delete
 			idx := rand.Intn(len(allRIDs))
-			tableHeapFile.Delete(allRIDs[idx])
+			txn := txnManager.Begin()
+			tableHeapFile.Delete(txn, allRIDs[idx])
+			txnManager.Commit(txn)
 
 			allData = append(allData[:idx], allData[idx+1:]...)
 			allRIDs = append(allRIDs[:idx], allRIDs[idx+1:]...)
@@ -77,17 +86,21 @@ func TestTableHeap_Insert(t *testing.T) {
 	diskManager := disk.NewDiskManager("test.db")
 	replacer := disk.NewLRUReplacer()
 	bufferPoolManager := disk.NewBufferPoolManager(8, diskManager, replacer)
-	tableHeapFile := NewTableHeap(bufferPoolManager, true)
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
 
+	txn := txnManager.Begin()
 	for i := 0; i < 100; i++ {
 		length := rand.Intn(512) + 1
 		randStr := make([]byte, length)
 		rand.Read(randStr)
-		rid := tableHeapFile.Insert(randStr)
+		rid, err := tableHeapFile.Insert(txn, randStr)
+		require.Nil(t, err)
 		allData = append(allData, randStr)
 		allRIDs = append(allRIDs, rid)
 	}
-	testTableDataFunc(t, tableHeapFile, allData, allRIDs)
+	require.Nil(t, txnManager.Commit(txn))
+	testTableDataFunc(t, txnManager, tableHeapFile, allData, allRIDs)
 	bufferPoolManager.FlushAllPages()
 	diskManager.Close()
 
@@ -95,8 +108,8 @@ func TestTableHeap_Insert(t *testing.T) {
 	secondDiskManager := disk.NewDiskManager("test.db")
 	secondReplacer := disk.NewLRUReplacer()
 	secondBufferPoolManager := disk.NewBufferPoolManager(8, secondDiskManager, secondReplacer)
-	secondTableHeapFile := NewTableHeap(secondBufferPoolManager, false)
-	testTableDataFunc(t, secondTableHeapFile, allData, allRIDs)
+	secondTableHeapFile := OpenTableHeap(secondBufferPoolManager, tableHeapFile.HeaderPageId())
+	testTableDataFunc(t, txnManager, secondTableHeapFile, allData, allRIDs)
 	secondDiskManager.Close()
 }
 
@@ -106,10 +119,11 @@ func TestTableHeap_Insert_Delete_Mixed(t *testing.T) {
 	diskManager := disk.NewDiskManager("test.db")
 	replacer := disk.NewLRUReplacer()
 	bufferPoolManager := disk.NewBufferPoolManager(8, diskManager, replacer)
-	tableHeapFile := NewTableHeap(bufferPoolManager, true)
-	allData, allRIDs := insertDeleteUtilsFunc(tableHeapFile, 100, 0.70)
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+	allData, allRIDs := insertDeleteUtilsFunc(txnManager, tableHeapFile, 100, 0.70)
 
-	testTableDataFunc(t, tableHeapFile, allData, allRIDs)
+	testTableDataFunc(t, txnManager, tableHeapFile, allData, allRIDs)
 	bufferPoolManager.FlushAllPages()
 	diskManager.Close()
 
@@ -117,17 +131,19 @@ func TestTableHeap_Insert_Delete_Mixed(t *testing.T) {
 	secondDiskManager := disk.NewDiskManager("test.db")
 	secondReplacer := disk.NewLRUReplacer()
 	secondBufferPoolManager := disk.NewBufferPoolManager(8, secondDiskManager, secondReplacer)
-	secondTableHeapFile := NewTableHeap(secondBufferPoolManager, false)
-	testTableDataFunc(t, secondTableHeapFile, allData, allRIDs)
+	secondTableHeapFile := OpenTableHeap(secondBufferPoolManager, tableHeapFile.HeaderPageId())
+	testTableDataFunc(t, txnManager, secondTableHeapFile, allData, allRIDs)
 	secondDiskManager.Close()
 }
 
 func TestTableHeap_Insert_Delete_Concurrent(t *testing.T) {
-	defer os.Remove("test.db")
-	diskManager := disk.NewDiskManager("test.db")
+	store := disk.NewMemoryPageStore()
 	replacer := disk.NewLRUReplacer()
-	bufferPoolManager := disk.NewBufferPoolManager(16, diskManager, replacer)
-	tableHeapFile := NewTableHeap(bufferPoolManager, true)
+	bufferPoolManager := disk.NewBufferPoolManager(16, store, replacer)
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	lockManager := transaction.NewLockManager(transaction.DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := transaction.NewTransactionManager(nil, lockManager)
 
 	allData := make([][]byte, 0)
 	allRIDs := make([]common.RID, 0)
@@ -136,7 +152,7 @@ func TestTableHeap_Insert_Delete_Concurrent(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		wg.Add(1)
 		go func() {
-			partialData, partialRIDs := insertDeleteUtilsFunc(tableHeapFile, 100, 0.7)
+			partialData, partialRIDs := insertDeleteUtilsFunc(txnManager, tableHeapFile, 100, 0.7)
 			mu.Lock()
 			allData = append(allData, partialData...)
 			allRIDs = append(allRIDs, partialRIDs...)
@@ -145,7 +161,500 @@ func TestTableHeap_Insert_Delete_Concurrent(t *testing.T) {
 		}()
 	}
 	wg.Wait()
-	testTableDataFunc(t, tableHeapFile, allData, allRIDs)
-	diskManager.Close()
+	testTableDataFunc(t, txnManager, tableHeapFile, allData, allRIDs)
+}
+
+func TestTableHeap_WriteAheadLogging(t *testing.T) {
+	defer wal.RemoveLogFiles("test.log")
+	store := disk.NewMemoryPageStore()
+	logManager := wal.NewLogManager("test.log")
+	defer logManager.Close()
+	replacer := disk.NewLRUReplacer()
+	bufferPoolManager := disk.NewBufferPoolManager(8, store, replacer)
+	bufferPoolManager.SetLogManager(logManager)
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(logManager, nil)
+
+	allData := make([][]byte, 0)
+	allRIDs := make([]common.RID, 0)
+	for i := 0; i < 20; i++ {
+		data := make([]byte, 1024)
+		rand.Read(data)
+		allData = append(allData, data)
+		txn := txnManager.Begin()
+		rid, err := tableHeapFile.Insert(txn, data)
+		require.Nil(t, err)
+		allRIDs = append(allRIDs, rid)
+		require.Nil(t, txnManager.Commit(txn))
+	}
+	txn := txnManager.Begin()
+	deleted, err := tableHeapFile.Delete(txn, allRIDs[3])
+	require.Nil(t, err)
+	require.True(t, deleted)
+	require.Nil(t, txnManager.Commit(txn))
+	require.Equal(t, 1, tableHeapFile.CollectGarbage(txnManager.OldestSnapshot()))
+	logManager.Flush(logManager.LastLSN())
+
+	it, err := logManager.Iterator(common.LSN(0))
+	require.Nil(t, err)
+	defer it.Close()
+	numNewPages, numInserts := 0, 0
+	for numInserts < len(allRIDs) {
+		record, ok := it.Next()
+		require.True(t, ok)
+		// New pages are added to the free space map by page writes.
+		if record.Type == wal.BeginRecord || record.Type == wal.CommitRecord || record.Type == wal.PageWriteRecord {
+			continue
+		}
+		if record.Type == wal.NewPageRecord {
+			require.Equal(t, allRIDs[numInserts].PageId, record.PageId)
+			require.Equal(t, tableHeapFile.HeaderPageId(), record.HeaderPageId)
+			numNewPages++
+			continue
+		}
+		require.Equal(t, wal.InsertRecord, record.Type)
+		require.Equal(t, allRIDs[numInserts], record.RID)
+		require.Equal(t, encodePlainRecord(allData[numInserts], minStoredRecordSize), record.Data)
+		numInserts++
+	}
+	require.Equal(t, 7, numNewPages) // 3 records per page.
+
+	record, ok := it.Next()
+	require.True(t, ok)
+	require.Equal(t, wal.CommitRecord, record.Type)
+	record, ok = it.Next()
+	require.True(t, ok)
+	require.Equal(t, wal.BeginRecord, record.Type)
+	record, ok = it.Next()
+	require.True(t, ok)
+	require.Equal(t, wal.MarkDeleteRecord, record.Type)
+	require.Equal(t, allRIDs[3], record.RID)
+	require.Equal(t, encodePlainRecord(allData[3], minStoredRecordSize), record.Data)
+	txnId := record.TxnId
+	record, ok = it.Next()
+	require.True(t, ok)
+	require.Equal(t, wal.CommitRecord, record.Type)
+	require.Equal(t, txnId, record.TxnId)
+
+	// The record is removed by the garbage collection, outside of the
+	// transaction.
+	record, ok = it.Next()
+	require.True(t, ok)
+	require.Equal(t, wal.DeleteRecord, record.Type)
+	require.Equal(t, common.InvalidTxnId, record.TxnId)
+	require.Equal(t, allRIDs[3], record.RID)
+	require.Equal(t, encodePlainRecord(allData[3], minStoredRecordSize), record.Data)
+	deleteLSN := record.LSN
+	_, ok = it.Next()
+	require.False(t, ok)
+
+	// Every modified page carries the LSN of the last record touching it.
+	page, _ := bufferPoolManager.FetchPage(allRIDs[3].PageId)
+	require.Equal(t, deleteLSN, page.LSN())
+	bufferPoolManager.UnpinPage(page.PageId(), false)
+}
+
+func TestTableHeap_Abort(t *testing.T) {
+	defer wal.RemoveLogFiles("test.log")
+	logManager := wal.NewLogManager("test.log")
+	defer logManager.Close()
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	bufferPoolManager.SetLogManager(logManager)
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(logManager, nil)
+	allData, allRIDs := insertDeleteUtilsFunc(txnManager, tableHeapFile, 50, 1.0)
+
+	txn := txnManager.Begin()
+	insertedRIDs := make([]common.RID, 0)
+	for i := 0; i < 20; i++ {
+		data := make([]byte, rand.Intn(512)+1)
+		rand.Read(data)
+		rid, err := tableHeapFile.Insert(txn, data)
+		require.Nil(t, err)
+		insertedRIDs = append(insertedRIDs, rid)
+	}
+	for _, rid := range allRIDs[:10] {
+		deleted, err := tableHeapFile.Delete(txn, rid)
+		require.Nil(t, err)
+		require.True(t, deleted)
+		deleted, err = tableHeapFile.Delete(txn, rid)
+		require.Nil(t, err)
+		require.False(t, deleted)
+		_, found, err := tableHeapFile.Get(txn, rid)
+		require.Nil(t, err)
+		require.False(t, found)
+	}
+	require.Nil(t, txnManager.Abort(txn))
+	require.Equal(t, transaction.Aborted, txn.State())
+	require.NotNil(t, txnManager.Commit(txn))
+
+	txn = txnManager.Begin()
+	for _, rid := range insertedRIDs {
+		_, found, err := tableHeapFile.Get(txn, rid)
+		require.Nil(t, err)
+		require.False(t, found)
+	}
+	require.Nil(t, txnManager.Commit(txn))
+	testTableDataFunc(t, txnManager, tableHeapFile, allData, allRIDs)
+	require.Equal(t, len(allRIDs), countRecords(tableHeapFile))
+}
+
+func TestTableHeap_GarbageCollection(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+	gc := NewGarbageCollector(txnManager, DefaultGarbageCollectionInterval, tableHeapFile)
+	allData, allRIDs := insertDeleteUtilsFunc(txnManager, tableHeapFile, 20, 1.0)
+
+	// A deleted record keeps its space while some snapshot may see it.
+	reader := txnManager.Begin()
+	txn := txnManager.Begin()
+	deleted, err := tableHeapFile.Delete(txn, allRIDs[0])
+	require.Nil(t, err)
+	require.True(t, deleted)
+	require.Equal(t, 0, gc.Collect())
+	require.Nil(t, txnManager.Commit(txn))
+	require.Equal(t, transaction.Committed, txn.State())
+	require.Equal(t, 0, gc.Collect())
+	require.Equal(t, len(allRIDs), countRecords(tableHeapFile))
+	data, found, err := tableHeapFile.Get(reader, allRIDs[0])
+	require.Nil(t, err)
+	require.True(t, found)
+	require.Equal(t, allData[0], data)
+
+	require.Nil(t, txnManager.Commit(reader))
+	require.Equal(t, 1, gc.Collect())
+	require.Equal(t, len(allRIDs)-1, countRecords(tableHeapFile))
+	testTableDataFunc(t, txnManager, tableHeapFile, allData[1:], allRIDs[1:])
+
+	// In the background as well.
+	txn = txnManager.Begin()
+	for _, rid := range allRIDs[1:] {
+		deleted, err := tableHeapFile.Delete(txn, rid)
+		require.Nil(t, err)
+		require.True(t, deleted)
+	}
+	require.Nil(t, txnManager.Commit(txn))
+	gc = NewGarbageCollector(txnManager, time.Millisecond, tableHeapFile)
+	gc.Start()
+	require.Eventually(t, func() bool { return countRecords(tableHeapFile) == 0 }, time.Second, time.Millisecond)
+	gc.Stop()
+}
+
+func TestTableHeap_Snapshot(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	lockManager := transaction.NewLockManager(transaction.DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := transaction.NewTransactionManager(nil, lockManager)
+	allData, allRIDs := insertDeleteUtilsFunc(txnManager, tableHeapFile, 10, 1.0)
+	requireVisible := func(txn *transaction.Transaction, rid common.RID, visible bool) {
+		_, found, err := tableHeapFile.Get(txn, rid)
+		require.Nil(t, err)
+		require.Equal(t, visible, found)
+	}
+
+	// Readers neither wait for uncommitted inserts nor see them.
+	writer := txnManager.Begin()
+	rid, err := tableHeapFile.Insert(writer, []byte("new"))
+	require.Nil(t, err)
+	reader := txnManager.Begin()
+	requireVisible(reader, rid, false)
+	requireVisible(writer, rid, true)
+	require.Nil(t, txnManager.Commit(writer))
+	// The snapshot is taken when the reader begins.
+	requireVisible(reader, rid, false)
+	requireVisible(reader, allRIDs[0], true)
+
+	// Deletes do not hide records from older snapshots either.
+	writer = txnManager.Begin()
+	deleted, err := tableHeapFile.Delete(writer, allRIDs[0])
+	require.Nil(t, err)
+	require.True(t, deleted)
+	requireVisible(reader, allRIDs[0], true)
+	requireVisible(writer, allRIDs[0], false)
+
+	// A concurrent writer waits for the lock, and fails once the delete commits.
+	other := txnManager.Begin()
+	done := make(chan error, 1)
+	go func() {
+		_, err := tableHeapFile.Delete(other, allRIDs[0])
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatalf("Writer does not wait for the other writer.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Nil(t, txnManager.Commit(writer))
+	require.Equal(t, ErrWriteConflict, <-done)
+	require.Nil(t, txnManager.Abort(other))
+	requireVisible(reader, allRIDs[0], true)
+	require.Nil(t, txnManager.Commit(reader))
+
+	// Locks on records that do not exist are released right away.
+	txn := txnManager.Begin()
+	missing := common.RID{PageId: rid.PageId, SlotNum: 100}
+	deleted, err = tableHeapFile.Delete(txn, missing)
+	require.Nil(t, err)
+	require.False(t, deleted)
+	_, ok := txn.HeldLockMode(missing)
+	require.False(t, ok)
+	require.Nil(t, txnManager.Commit(txn))
+	testTableDataFunc(t, txnManager, tableHeapFile, append(allData[1:], []byte("new")), append(allRIDs[1:], rid))
+}
+
+func TestTableHeap_Update(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+	// All records fit on the same page.
+	allData := make([][]byte, 30)
+	allRIDs := make([]common.RID, 30)
+	txn := txnManager.Begin()
+	for i := range allData {
+		allData[i] = make([]byte, 100)
+		rand.Read(allData[i])
+		rid, err := tableHeapFile.Insert(txn, allData[i])
+		require.Nil(t, err)
+		allRIDs[i] = rid
+	}
+	require.Nil(t, txnManager.Commit(txn))
+	update := func(rid common.RID, data []byte) {
+		txn := txnManager.Begin()
+		updated, err := tableHeapFile.Update(txn, rid, data)
+		require.Nil(t, err)
+		require.True(t, updated)
+		require.Nil(t, txnManager.Commit(txn))
+	}
+
+	// Shrink and grow records in place.
+	for i := 0; i < 10; i++ {
+		allData[i] = allData[i][:50]
+		update(allRIDs[i], allData[i])
+		allData[i+10] = append(allData[i+10], allData[i]...)
+		update(allRIDs[i+10], allData[i+10])
+	}
+	testTableDataFunc(t, txnManager, tableHeapFile, allData, allRIDs)
+	require.Equal(t, len(allRIDs), countRecords(tableHeapFile))
+
+	// A record that outgrows its page is moved, and is still found at its RID.
+	allData[20] = make([]byte, 3000)
+	rand.Read(allData[20])
+	update(allRIDs[20], allData[20])
+	testTableDataFunc(t, txnManager, tableHeapFile, allData, allRIDs)
+	require.Equal(t, len(allRIDs)+1, countRecords(tableHeapFile))
+	txn = txnManager.Begin()
+	records := scanTableHeap(t, tableHeapFile, txn)
+	require.Equal(t, len(allRIDs), len(records))
+	require.Equal(t, allData[20], records[allRIDs[20]])
+	require.Nil(t, txnManager.Commit(txn))
+
+	// Moving it again, or back to its page, leaves no record behind.
+	allData[20] = append(allData[20], 1)
+	update(allRIDs[20], allData[20])
+	require.Equal(t, len(allRIDs)+1, countRecords(tableHeapFile))
+	allData[20] = []byte("small")
+	update(allRIDs[20], allData[20])
+	require.Equal(t, len(allRIDs), countRecords(tableHeapFile))
+	testTableDataFunc(t, txnManager, tableHeapFile, allData, allRIDs)
+
+	// Removing a moved record removes the record it was moved to as well.
+	update(allRIDs[21], make([]byte, 3000))
+	txn = txnManager.Begin()
+	deleted, err := tableHeapFile.Delete(txn, allRIDs[21])
+	require.Nil(t, err)
+	require.True(t, deleted)
+	require.Nil(t, txnManager.Commit(txn))
+	require.Equal(t, 1, tableHeapFile.CollectGarbage(txnManager.OldestSnapshot()))
+	require.Equal(t, len(allRIDs)-1, countRecords(tableHeapFile))
+
+	txn = txnManager.Begin()
+	updated, err := tableHeapFile.Update(txn, common.RID{PageId: allRIDs[0].PageId, SlotNum: 100}, []byte("missing"))
+	require.Nil(t, err)
+	require.False(t, updated)
+	require.Nil(t, txnManager.Commit(txn))
+}
+
+func TestTableHeap_UpdateSnapshot(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	lockManager := transaction.NewLockManager(transaction.DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := transaction.NewTransactionManager(nil, lockManager)
+	allData, allRIDs := insertDeleteUtilsFunc(txnManager, tableHeapFile, 10, 1.0)
+	requireRecord := func(txn *transaction.Transaction, rid common.RID, expected []byte) {
+		data, found, err := tableHeapFile.Get(txn, rid)
+		require.Nil(t, err)
+		require.True(t, found)
+		require.Equal(t, expected, data)
+	}
+
+	// Older snapshots keep reading the replaced versions, whether the record
+	// is rewritten in place or moved.
+	reader := txnManager.Begin()
+	moved := make([]byte, 3000)
+	rand.Read(moved)
+	writer := txnManager.Begin()
+	for _, data := range [][]byte{[]byte("first"), moved, []byte("second")} {
+		updated, err := tableHeapFile.Update(writer, allRIDs[0], data)
+		require.Nil(t, err)
+		require.True(t, updated)
+		requireRecord(writer, allRIDs[0], data)
+		requireRecord(reader, allRIDs[0], allData[0])
+	}
+	updated, err := tableHeapFile.Update(writer, allRIDs[1], moved)
+	require.Nil(t, err)
+	require.True(t, updated)
+	require.Nil(t, txnManager.Commit(writer))
+	requireRecord(reader, allRIDs[0], allData[0])
+	require.Equal(t, allData[1], scanTableHeap(t, tableHeapFile, reader)[allRIDs[1]])
+	later := txnManager.Begin()
+	requireRecord(later, allRIDs[0], []byte("second"))
+	requireRecord(later, allRIDs[1], moved)
+	require.Nil(t, txnManager.Commit(later))
+
+	// The reader cannot write a version it does not see.
+	_, err = tableHeapFile.Update(reader, allRIDs[0], []byte("stale"))
+	require.Equal(t, ErrWriteConflict, err)
+	require.Nil(t, txnManager.Abort(reader))
+
+	// Rolled back updates leave the records as they were.
+	numRecords := countRecords(tableHeapFile)
+	txn := txnManager.Begin()
+	for _, rid := range allRIDs {
+		updated, err := tableHeapFile.Update(txn, rid, moved)
+		require.Nil(t, err)
+		require.True(t, updated)
+	}
+	require.Nil(t, txnManager.Abort(txn))
+	require.Equal(t, numRecords, countRecords(tableHeapFile))
+	allData[0], allData[1] = []byte("second"), moved
+	testTableDataFunc(t, txnManager, tableHeapFile, allData, allRIDs)
+
+	// Replaced versions are dropped once no snapshot can see them.
+	require.NotEqual(t, 0, len(tableHeapFile.versions))
+	tableHeapFile.CollectGarbage(txnManager.OldestSnapshot())
+	require.Equal(t, 0, len(tableHeapFile.versions))
+}
+
+// The overflow pages holding the record at `rid`.
+func overflowPageIds(t *testing.T, tableHeapFile *TableHeap, rid common.RID) []common.PageId {
+	page, err := tableHeapFile.bufferPoolManager.FetchPage(rid.PageId)
+	require.Nil(t, err)
+	kind, payload := decodeStoredRecord(createTablePage(page.Data()).getRecord(rid.SlotNum))
+	tableHeapFile.bufferPoolManager.UnpinPage(rid.PageId, false)
+	require.Equal(t, overflowRecord, kind)
+
+	pageIds := make([]common.PageId, 0)
+	for pageId, _ := decodeOverflowRecord(payload); pageId != common.InvalidPageId; {
+		pageIds = append(pageIds, pageId)
+		page, err := tableHeapFile.bufferPoolManager.FetchPage(pageId)
+		require.Nil(t, err)
+		pageId = createOverflowPage(page.Data()).nextPageId
+		tableHeapFile.bufferPoolManager.UnpinPage(page.PageId(), false)
+	}
+	return pageIds
+}
+
+func requireFreed(t *testing.T, bufferPoolManager *disk.BufferPoolManager, pageIds []common.PageId) {
+	for _, pageId := range pageIds {
+		_, err := bufferPoolManager.FetchPage(pageId)
+		require.NotNil(t, err)
+	}
+}
+
+func TestTableHeap_LargeRecords(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+
+	txn := txnManager.Begin()
+	allData := make([][]byte, 0)
+	allRIDs := make([]common.RID, 0)
+	for _, size := range []int{maxInlineRecordSize, maxInlineRecordSize + 1, 3 * overflowPageCapacity, 20000} {
+		data := make([]byte, size)
+		rand.Read(data)
+		rid, err := tableHeapFile.Insert(txn, data)
+		require.Nil(t, err)
+		allData = append(allData, data)
+		allRIDs = append(allRIDs, rid)
+	}
+	_, err := tableHeapFile.Insert(txn, make([]byte, MaxRecordSize+1))
+	require.Equal(t, ErrRecordTooLarge, err)
+	require.Nil(t, txnManager.Commit(txn))
+	testTableDataFunc(t, txnManager, tableHeapFile, allData, allRIDs)
+	require.Equal(t, 3, len(overflowPageIds(t, tableHeapFile, allRIDs[2])))
+	txn = txnManager.Begin()
+	require.Equal(t, len(allRIDs), len(scanTableHeap(t, tableHeapFile, txn)))
+	require.Nil(t, txnManager.Commit(txn))
+
+	// The overflow pages of a rolled back insert are freed right away.
+	txn = txnManager.Begin()
+	rid, err := tableHeapFile.Insert(txn, make([]byte, 10000))
+	require.Nil(t, err)
+	pageIds := overflowPageIds(t, tableHeapFile, rid)
+	require.Nil(t, txnManager.Abort(txn))
+	requireFreed(t, bufferPoolManager, pageIds)
+
+	// The overflow pages of a replaced record are freed once the update
+	// commits, and the ones of a deleted record once no snapshot can see it.
+	pageIds = overflowPageIds(t, tableHeapFile, allRIDs[3])
+	txn = txnManager.Begin()
+	updated, err := tableHeapFile.Update(txn, allRIDs[3], []byte("small"))
+	require.Nil(t, err)
+	require.True(t, updated)
+	updated, err = tableHeapFile.Update(txn, allRIDs[0], allData[3])
+	require.Nil(t, err)
+	require.True(t, updated)
+	require.Nil(t, txnManager.Commit(txn))
+	requireFreed(t, bufferPoolManager, pageIds)
+	allData[0], allData[3] = allData[3], []byte("small")
+	testTableDataFunc(t, txnManager, tableHeapFile, allData, allRIDs)
+
+	pageIds = overflowPageIds(t, tableHeapFile, allRIDs[2])
+	txn = txnManager.Begin()
+	deleted, err := tableHeapFile.Delete(txn, allRIDs[2])
+	require.Nil(t, err)
+	require.True(t, deleted)
+	require.Nil(t, txnManager.Commit(txn))
+	require.Equal(t, 1, tableHeapFile.CollectGarbage(txnManager.OldestSnapshot()))
+	requireFreed(t, bufferPoolManager, pageIds)
+}
+
+func TestTableHeap_Drop(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	otherTableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+
+	txn := txnManager.Begin()
+	otherRID, err := otherTableHeapFile.Insert(txn, []byte("kept"))
+	require.Nil(t, err)
+	largeRID, err := tableHeapFile.Insert(txn, make([]byte, 3*overflowPageCapacity))
+	require.Nil(t, err)
+	for i := 0; i < 50; i++ {
+		_, err := tableHeapFile.Insert(txn, make([]byte, 1000))
+		require.Nil(t, err)
+	}
+	require.Nil(t, txnManager.Commit(txn))
+
+	pageIds := overflowPageIds(t, tableHeapFile, largeRID)
+	headerPage := tableHeapFile.getHeaderPage(false)
+	fsm := tableHeapFile.getFreeSpaceMap(headerPage)
+	pageIds = append(pageIds, fsm.pageIds()...)
+	pageIds = append(pageIds, fsm.mapPageIds()...)
+	tableHeapFile.releaseHeaderPage(headerPage, false)
+	pageIds = append(pageIds, tableHeapFile.HeaderPageId())
+
+	tableHeapFile.Drop()
+	requireFreed(t, bufferPoolManager, pageIds)
 
+	// Other table heaps are untouched.
+	txn = txnManager.Begin()
+	data, found, err := otherTableHeapFile.Get(txn, otherRID)
+	require.Nil(t, err)
+	require.True(t, found)
+	require.Equal(t, []byte("kept"), data)
+	require.Nil(t, txnManager.Commit(txn))
 }
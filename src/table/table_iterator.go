@@ -0,0 +1,107 @@
+package table
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/transaction"
+)
+
+// TableIterator walks the records of a table heap visible to a transaction,
+// page by page in the order of the free space map. Only one page is pinned at a
+// time, and its latch is only held within `Next`, so other goroutines may
+// insert meanwhile. Pages added to the table heap during the scan are
+// visited as well, but the records on them are only returned if they are
+// visible to the transaction. Records moved to another page by an update are
+// returned at their own RID.
+type TableIterator struct {
+	tableHeap *TableHeap
+	txn       *transaction.Transaction
+	pageIndex int        // Index of the current page in the free space map.
+	page      *disk.Page // The current page, pinned, or nil.
+	rid       common.RID
+	record    []byte
+}
+
+// Create an iterator over the records visible to `txn`. It must be closed.
+func (th *TableHeap) Iterator(txn *transaction.Transaction) *TableIterator {
+	return &TableIterator{
+		tableHeap: th,
+		txn:       txn,
+		rid:       common.RID{PageId: common.InvalidPageId, SlotNum: -1},
+	}
+}
+
+// Move to the next record. Returns false once there are no more records.
+func (it *TableIterator) Next() bool {
+	for {
+		if it.page == nil && !it.fetchPage() {
+			it.record = nil
+			return false
+		}
+		if it.nextInPage() {
+			return true
+		}
+		it.unpinPage()
+		it.pageIndex++
+	}
+}
+
+// Pin the page at `pageIndex`, or return false if there is none.
+func (it *TableIterator) fetchPage() bool {
+	headerPage := it.tableHeap.getHeaderPage(false)
+	fsm := it.tableHeap.getFreeSpaceMap(headerPage)
+	if it.pageIndex >= fsm.numPages() {
+		it.tableHeap.releaseHeaderPage(headerPage, false)
+		return false
+	}
+	pageId := fsm.pageId(it.pageIndex)
+	it.tableHeap.releaseHeaderPage(headerPage, false)
+
+	page, err := it.tableHeap.bufferPoolManager.FetchPage(pageId)
+	if err != nil {
+		log.WithError(err).Fatalf("Cannot fetch page %d.", pageId)
+	}
+	it.page = page
+	it.rid = common.RID{PageId: pageId, SlotNum: -1}
+	return true
+}
+
+func (it *TableIterator) unpinPage() {
+	it.tableHeap.bufferPoolManager.UnpinPage(it.page.PageId(), false)
+	it.page = nil
+}
+
+// Move to the next visible record on the current page.
+func (it *TableIterator) nextInPage() bool {
+	for it.rid.SlotNum+1 < it.numRecords() {
+		it.rid.SlotNum++
+		var record []byte
+		var ok bool
+		if it.page, record, ok = it.tableHeap.readVersion(it.txn, it.page, it.rid); ok {
+			it.record = record
+			return true
+		}
+	}
+	return false
+}
+
+func (it *TableIterator) numRecords() int {
+	it.page.RLock()
+	defer it.page.RUnlock()
+	return int(createTablePage(it.page.Data()).numRecords)
+}
+
+// The current record. Only valid after `Next` returned true.
+func (it *TableIterator) Record() []byte { return it.record }
+
+// RID of the current record. Only valid after `Next` returned true.
+func (it *TableIterator) RID() common.RID { return it.rid }
+
+// Unpin the current page.
+func (it *TableIterator) Close() {
+	if it.page != nil {
+		it.unpinPage()
+	}
+}
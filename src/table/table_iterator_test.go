@@ -0,0 +1,138 @@
+package table
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/transaction"
+)
+
+// Scan the table heap, and return the records by RID.
+func scanTableHeap(t *testing.T, tableHeapFile *TableHeap, txn *transaction.Transaction) map[common.RID][]byte {
+	records := make(map[common.RID][]byte)
+	it := tableHeapFile.Iterator(txn)
+	defer it.Close()
+	for it.Next() {
+		_, ok := records[it.RID()]
+		require.False(t, ok)
+		records[it.RID()] = it.Record()
+	}
+	require.False(t, it.Next())
+	return records
+}
+
+func TestTableIterator(t *testing.T) {
+	// Only the header page and the page being scanned are pinned.
+	bufferPoolManager := disk.NewBufferPoolManager(3, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+
+	txn := txnManager.Begin()
+	require.Equal(t, 0, len(scanTableHeap(t, tableHeapFile, txn)))
+	require.Nil(t, txnManager.Commit(txn))
+
+	allData, allRIDs := insertDeleteUtilsFunc(txnManager, tableHeapFile, 200, 0.7)
+	txn = txnManager.Begin()
+	records := scanTableHeap(t, tableHeapFile, txn)
+	require.Equal(t, len(allRIDs), len(records))
+	for i, rid := range allRIDs {
+		require.Equal(t, allData[i], records[rid])
+	}
+
+	// Records deleted by the transaction itself are skipped.
+	deleted, err := tableHeapFile.Delete(txn, allRIDs[0])
+	require.Nil(t, err)
+	require.True(t, deleted)
+	records = scanTableHeap(t, tableHeapFile, txn)
+	require.Equal(t, len(allRIDs)-1, len(records))
+	_, ok := records[allRIDs[0]]
+	require.False(t, ok)
+	require.Nil(t, txnManager.Commit(txn))
+}
+
+func TestTableIterator_MovedRecords(t *testing.T) {
+	store := disk.NewMemoryPageStore()
+	bufferPoolManager := disk.NewBufferPoolManager(8, store, disk.NewLRUReplacer())
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+	allData, allRIDs := insertDeleteUtilsFunc(txnManager, tableHeapFile, 100, 1.0)
+	for i := 0; i < len(allRIDs); i += 10 {
+		allData[i] = make([]byte, 3000)
+		rand.Read(allData[i])
+		txn := txnManager.Begin()
+		updated, err := tableHeapFile.Update(txn, allRIDs[i], allData[i])
+		require.Nil(t, err)
+		require.True(t, updated)
+		require.Nil(t, txnManager.Commit(txn))
+	}
+	bufferPoolManager.FlushAllPages()
+
+	// Reading a moved record unpins its page before pinning the page it was
+	// moved to, so a record is got with a single frame.
+	reopen := func(frames int) *TableHeap {
+		reopened := newTableHeap(disk.NewBufferPoolManager(frames, store, disk.NewLRUReplacer()), tableHeapFile.HeaderPageId())
+		reopened.pageIndexes = tableHeapFile.pageIndexes
+		return reopened
+	}
+	reopened := reopen(1)
+	txn := txnManager.Begin()
+	for i, rid := range allRIDs {
+		data, found, err := reopened.Get(txn, rid)
+		require.Nil(t, err)
+		require.True(t, found)
+		require.Equal(t, allData[i], data)
+	}
+	require.Nil(t, txnManager.Commit(txn))
+
+	// A scan takes a frame more, to find the next page in the free space map
+	// while the header page is pinned.
+	txn = txnManager.Begin()
+	records := scanTableHeap(t, reopen(2), txn)
+	require.Equal(t, len(allRIDs), len(records))
+	for i, rid := range allRIDs {
+		require.Equal(t, allData[i], records[rid])
+	}
+	require.Nil(t, txnManager.Commit(txn))
+}
+
+func TestTableIterator_ConcurrentInsert(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(16, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tableHeapFile := NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+	allData, allRIDs := insertDeleteUtilsFunc(txnManager, tableHeapFile, 100, 1.0)
+
+	reader := txnManager.Begin()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				data := make([]byte, rand.Intn(512)+1)
+				rand.Read(data)
+				txn := txnManager.Begin()
+				tableHeapFile.Insert(txn, data)
+				txnManager.Commit(txn)
+			}
+		}()
+	}
+	// The scan sees the snapshot of the reader, whatever is inserted meanwhile.
+	for i := 0; i < 5; i++ {
+		records := scanTableHeap(t, tableHeapFile, reader)
+		require.Equal(t, len(allRIDs), len(records))
+		for i, rid := range allRIDs {
+			require.Equal(t, allData[i], records[rid])
+		}
+	}
+	wg.Wait()
+	require.Nil(t, txnManager.Commit(reader))
+
+	txn := txnManager.Begin()
+	require.Equal(t, len(allRIDs)+200, len(scanTableHeap(t, tableHeapFile, txn)))
+	require.Nil(t, txnManager.Commit(txn))
+}
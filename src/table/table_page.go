@@ -14,10 +14,23 @@ type TablePage struct {
 	ptr        struct{}
 }
 
+// A slot holds one version of a record. The version is visible to the
+// snapshots between its begin and end timestamps, see `versionTimestamp`.
 type RecordSlot struct {
 	offset int32
+	flags  int32
+	begin  versionTimestamp
+	end    versionTimestamp // Only valid if the record is deleted.
 }
 
+const (
+	// The record is deleted, by a transaction which has not committed yet, or
+	// which committed after some snapshot that may still see the record. It
+	// keeps its space until no snapshot can see it any more, or until it is
+	// restored because the transaction aborts.
+	recordDeletedFlag = int32(1)
+)
+
 const (
 	RecordSlotSize = int(unsafe.Sizeof(RecordSlot{}))
 )
@@ -126,10 +139,31 @@ func (tp *TablePage) Insert(record []byte) (common.RID, bool) {
 	if freeSpace < int32(RecordSlotSize+len(record)) {
 		return common.RID{}, false
 	}
-	recordLen := len(record)
 
 	// Try to find a slot that contains no data.
 	index := tp.getInsertIndex()
+	tp.insertAt(index, record)
+	return common.RID{
+		PageId:  tp.pageId,
+		SlotNum: index,
+	}, true
+}
+
+// Put a record into the given slot, which must be empty or right after the
+// last slot. Used to insert a record at a known RID, e.g. when a logged
+// operation is redone or undone.
+func (tp *TablePage) insertAt(index int, record []byte) bool {
+	recordLen := len(record)
+	if index > int(tp.numRecords) || (index < int(tp.numRecords) && tp.getRecordSize(index) != 0) {
+		return false
+	}
+	requiredSpace := int32(recordLen)
+	if index == int(tp.numRecords) {
+		requiredSpace += int32(RecordSlotSize)
+	}
+	if tp.getFreeSpace() < requiredSpace {
+		return false
+	}
 
 	// Allocate space for the record.
 	newRecordStartOffset := tp.moveBackRecords(index, recordLen)
@@ -144,10 +178,7 @@ func (tp *TablePage) Insert(record []byte) (common.RID, bool) {
 	} else {
 		tp.setRecordSlot(index, RecordSlot{offset: int32(newRecordStartOffset)})
 	}
-	return common.RID{
-		PageId:  tp.pageId,
-		SlotNum: index,
-	}, true
+	return true
 }
 
 func (tp *TablePage) Delete(rid common.RID) bool {
@@ -163,11 +194,80 @@ func (tp *TablePage) Delete(rid common.RID) bool {
 
 	// Update pointers
 	slot := tp.getRecordSlot(rid.SlotNum)
-	slot.offset += size
+	tp.setRecordSlot(rid.SlotNum, RecordSlot{offset: slot.offset + size})
+	return true
+}
+
+// Rewrite a record in place, moving the records stored before it to make or
+// release room. Fails if the page does not have enough free space.
+func (tp *TablePage) Update(rid common.RID, record []byte) bool {
+	if rid.SlotNum >= int(tp.numRecords) {
+		return false
+	}
+	size := tp.getRecordSize(rid.SlotNum)
+	if size == 0 {
+		return false
+	}
+	growth := len(record) - int(size)
+	if growth > 0 && tp.getFreeSpace() < int32(growth) {
+		return false
+	}
+	newRecordStartOffset := tp.moveBackRecords(rid.SlotNum, growth)
+	buf := tp.getRecordRawSlice()
+	copy(buf[newRecordStartOffset:newRecordStartOffset+len(record)], record)
+	slot := tp.getRecordSlot(rid.SlotNum)
+	slot.offset = int32(newRecordStartOffset)
+	tp.setRecordSlot(rid.SlotNum, slot)
+	return true
+}
+
+// Mark a record as deleted without releasing its space. Marked records are
+// invisible, and are removed with `Delete` or restored with `RollbackDelete`.
+func (tp *TablePage) MarkDelete(rid common.RID) bool {
+	if rid.SlotNum >= int(tp.numRecords) || tp.getRecordSize(rid.SlotNum) == 0 {
+		return false
+	}
+	slot := tp.getRecordSlot(rid.SlotNum)
+	if slot.flags&recordDeletedFlag != 0 {
+		return false
+	}
+	slot.flags |= recordDeletedFlag
 	tp.setRecordSlot(rid.SlotNum, slot)
 	return true
 }
 
+func (tp *TablePage) RollbackDelete(rid common.RID) bool {
+	if rid.SlotNum >= int(tp.numRecords) || !tp.isDeleted(rid.SlotNum) {
+		return false
+	}
+	slot := tp.getRecordSlot(rid.SlotNum)
+	slot.flags &^= recordDeletedFlag
+	tp.setRecordSlot(rid.SlotNum, slot)
+	return true
+}
+
+func (tp *TablePage) isDeleted(i int) bool {
+	return tp.getRecordSlot(i).flags&recordDeletedFlag != 0
+}
+
+// The slot holding the version of a record, or false if the slot is empty.
+func (tp *TablePage) getVersion(rid common.RID) (RecordSlot, bool) {
+	if rid.SlotNum >= int(tp.numRecords) || tp.getRecordSize(rid.SlotNum) == 0 {
+		return RecordSlot{}, false
+	}
+	return tp.getRecordSlot(rid.SlotNum), true
+}
+
+func (tp *TablePage) setBegin(i int, begin versionTimestamp) {
+	slots := tp.getSlotSlice()
+	slots[i].begin = begin
+}
+
+func (tp *TablePage) setEnd(i int, end versionTimestamp) {
+	slots := tp.getSlotSlice()
+	slots[i].end = end
+}
+
 func (tp *TablePage) getRecord(i int) []byte {
 	offset := tp.getRecordOffset(i)
 	endOffset := tp.pageSize
@@ -183,7 +283,7 @@ func (tp *TablePage) Get(rid common.RID) ([]byte, bool) {
 		return nil, false
 	}
 	data := tp.getRecord(rid.SlotNum)
-	if len(data) == 0 {
+	if len(data) == 0 || tp.isDeleted(rid.SlotNum) {
 		return nil, false
 	}
 	ret := make([]byte, len(data), len(data))
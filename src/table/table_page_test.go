@@ -300,3 +300,90 @@ func TestTablePage_Delete(t *testing.T) {
 		}
 	}
 }
+
+func TestTablePage_MarkDelete(t *testing.T) {
+	data := directio.AlignedBlock(pageSize)
+	page := createTablePage(data)
+	initPageByData(page, [][]byte{[]byte("hello"), []byte(""), []byte("alice")})
+	freeSpace := page.getFreeSpace()
+
+	rid := common.RID{PageId: common.PageId(1), SlotNum: 0}
+	require.True(t, page.MarkDelete(rid))
+	require.False(t, page.MarkDelete(rid))
+	require.False(t, page.MarkDelete(common.RID{PageId: common.PageId(1), SlotNum: 1}))
+	_, found := page.Get(rid)
+	require.False(t, found)
+	require.Equal(t, freeSpace, page.getFreeSpace()) // The space is kept.
+	require.Equal(t, 1, page.getInsertIndex())
+
+	require.True(t, page.RollbackDelete(rid))
+	require.False(t, page.RollbackDelete(rid))
+	readData, found := page.Get(rid)
+	require.True(t, found)
+	require.Equal(t, []byte("hello"), readData)
+
+	require.True(t, page.MarkDelete(rid))
+	require.True(t, page.Delete(rid))
+	require.False(t, page.isDeleted(0))
+	require.Equal(t, 0, page.getInsertIndex())
+}
+
+func TestTablePage_Update(t *testing.T) {
+	data := directio.AlignedBlock(pageSize)
+	page := createTablePage(data)
+
+	testCases := []struct {
+		originData     [][]byte
+		updatedRID     common.RID
+		updatedData    []byte
+		expectedData   [][]byte
+		expectedResult bool
+	}{
+		{
+			originData:     [][]byte{[]byte("hello"), []byte("world"), []byte("alice")},
+			updatedRID:     common.RID{PageId: common.PageId(1), SlotNum: 1},
+			updatedData:    []byte("wonderful world"),
+			expectedData:   [][]byte{[]byte("hello"), []byte("wonderful world"), []byte("alice")},
+			expectedResult: true,
+		},
+		{
+			originData:     [][]byte{[]byte("hello"), []byte("world"), []byte("alice")},
+			updatedRID:     common.RID{PageId: common.PageId(1), SlotNum: 0},
+			updatedData:    []byte("hi"),
+			expectedData:   [][]byte{[]byte("hi"), []byte("world"), []byte("alice")},
+			expectedResult: true,
+		},
+		{
+			originData:     [][]byte{[]byte("hello"), []byte("world"), []byte("alice")},
+			updatedRID:     common.RID{PageId: common.PageId(1), SlotNum: 2},
+			updatedData:    []byte("bob"),
+			expectedData:   [][]byte{[]byte("hello"), []byte("world"), []byte("bob")},
+			expectedResult: true,
+		},
+		{
+			originData:     [][]byte{[]byte("hello"), []byte(""), []byte("alice")},
+			updatedRID:     common.RID{PageId: common.PageId(1), SlotNum: 1},
+			updatedData:    []byte("bob"),
+			expectedData:   [][]byte{[]byte("hello"), []byte(""), []byte("alice")},
+			expectedResult: false,
+		},
+		{
+			originData:     [][]byte{[]byte("hello"), []byte("world"), []byte("alice")},
+			updatedRID:     common.RID{PageId: common.PageId(1), SlotNum: 1},
+			updatedData:    make([]byte, pageSize),
+			expectedData:   [][]byte{[]byte("hello"), []byte("world"), []byte("alice")},
+			expectedResult: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		initPageByData(page, tc.originData)
+		ok := page.Update(tc.updatedRID, tc.updatedData)
+		require.Equal(t, tc.expectedResult, ok)
+		require.Equal(t, len(tc.expectedData), int(page.numRecords))
+		for i := 0; i < int(page.numRecords); i++ {
+			readData := page.getRecord(i)
+			require.Equal(t, tc.expectedData[i], readData)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package table
+
+import (
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/transaction"
+)
+
+// versionTimestamp is the begin or end timestamp of a record version: the
+// commit timestamp of the transaction which created or deleted the version,
+// or, until that transaction commits, its ID tagged with `uncommittedFlag`.
+type versionTimestamp uint64
+
+const uncommittedFlag = versionTimestamp(1) << 63
+
+func committedAt(ts transaction.Timestamp) versionTimestamp {
+	return versionTimestamp(ts)
+}
+
+func writtenBy(txn *transaction.Transaction) versionTimestamp {
+	return uncommittedFlag | versionTimestamp(uint32(txn.TxnId()))
+}
+
+func (ts versionTimestamp) isCommitted() bool {
+	return ts&uncommittedFlag == 0
+}
+
+func (ts versionTimestamp) txnId() common.TxnId {
+	return common.TxnId(uint32(ts &^ uncommittedFlag))
+}
+
+// Whether the snapshot of `txn` includes the write made at `ts`.
+func (ts versionTimestamp) visibleTo(txn *transaction.Transaction) bool {
+	if !ts.isCommitted() {
+		return ts.txnId() == txn.TxnId()
+	}
+	return transaction.Timestamp(ts) <= txn.ReadTimestamp()
+}
+
+// Whether `txn` sees the version held by the slot.
+func (slot RecordSlot) visibleTo(txn *transaction.Transaction) bool {
+	if !slot.begin.visibleTo(txn) {
+		return false
+	}
+	return slot.flags&recordDeletedFlag == 0 || !slot.end.visibleTo(txn)
+}
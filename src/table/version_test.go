@@ -0,0 +1,38 @@
+package table
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/transaction"
+)
+
+func TestRecordSlot_Visibility(t *testing.T) {
+	txnManager := transaction.NewTransactionManager(nil, nil)
+	writer := txnManager.Begin()
+	reader := txnManager.Begin()
+
+	// Uncommitted versions are only visible to their writer.
+	slot := RecordSlot{begin: writtenBy(writer)}
+	require.True(t, slot.visibleTo(writer))
+	require.False(t, slot.visibleTo(reader))
+	require.Equal(t, writer.TxnId(), slot.begin.txnId())
+
+	require.Nil(t, txnManager.Commit(writer))
+	slot.begin = committedAt(writer.CommitTimestamp())
+	require.False(t, slot.visibleTo(reader))
+	later := txnManager.Begin()
+	require.True(t, slot.visibleTo(later))
+
+	// So are uncommitted deletes.
+	slot.flags |= recordDeletedFlag
+	slot.end = writtenBy(later)
+	require.False(t, slot.visibleTo(later))
+	other := txnManager.Begin()
+	require.True(t, slot.visibleTo(other))
+	require.Nil(t, txnManager.Commit(later))
+	slot.end = committedAt(later.CommitTimestamp())
+	require.True(t, slot.visibleTo(other))
+	require.False(t, slot.visibleTo(txnManager.Begin()))
+}
@@ -0,0 +1,505 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"simple-db-golang/src/common"
+)
+
+type LockMode int
+
+const (
+	IntentionShared LockMode = iota
+	IntentionExclusive
+	Shared
+	SharedIntentionExclusive
+	Exclusive
+)
+
+func (m LockMode) String() string {
+	switch m {
+	case IntentionShared:
+		return "IS"
+	case IntentionExclusive:
+		return "IX"
+	case Shared:
+		return "S"
+	case SharedIntentionExclusive:
+		return "SIX"
+	case Exclusive:
+		return "X"
+	default:
+		return "Invalid"
+	}
+}
+
+// Whether two transactions can hold the modes on the same lock at once.
+var lockCompatibility = [5][5]bool{
+	//                          IS     IX     S      SIX    X
+	IntentionShared:          {true, true, true, true, false},
+	IntentionExclusive:       {true, true, false, false, false},
+	Shared:                   {true, false, true, false, false},
+	SharedIntentionExclusive: {true, false, false, false, false},
+	Exclusive:                {false, false, false, false, false},
+}
+
+func (m LockMode) compatibleWith(other LockMode) bool {
+	return lockCompatibility[m][other]
+}
+
+// Whether holding `m` grants everything `other` does.
+func (m LockMode) covers(other LockMode) bool {
+	switch m {
+	case Exclusive:
+		return true
+	case SharedIntentionExclusive:
+		return other != Exclusive
+	case Shared:
+		return other == Shared || other == IntentionShared
+	case IntentionExclusive:
+		return other == IntentionExclusive || other == IntentionShared
+	default:
+		return other == IntentionShared
+	}
+}
+
+// The weakest mode that covers both `m` and `other`.
+func (m LockMode) union(other LockMode) LockMode {
+	if m.covers(other) {
+		return m
+	}
+	if other.covers(m) {
+		return other
+	}
+	// Only S and IX are incomparable.
+	return SharedIntentionExclusive
+}
+
+// How the lock manager deals with transactions waiting for each other.
+// Transactions are ordered by age, i.e. by their IDs.
+type DeadlockPolicy int
+
+const (
+	// Look for cycles in the waits-for graph in the background, and abort the
+	// youngest transaction of each cycle.
+	DeadlockDetection DeadlockPolicy = iota
+	// An older transaction aborts (wounds) the younger ones it would wait for,
+	// while a younger transaction waits for older ones.
+	WoundWait
+	// An older transaction waits for younger ones, while a younger transaction
+	// aborts (dies) instead of waiting for older ones.
+	WaitDie
+)
+
+const DeadlockDetectionInterval = 50 * time.Millisecond
+
+var (
+	// Another transaction is already waiting to upgrade the lock. Waiting as
+	// well would deadlock, since neither upgrade can be granted.
+	ErrUpgradeConflict = errors.New("Another transaction is upgrading the lock.")
+	// The transaction is chosen to be aborted to prevent or break a deadlock.
+	// Every lock request of it fails from then on, and it must be aborted.
+	ErrDeadlock = errors.New("Transaction is aborted because of a deadlock.")
+)
+
+type lockRequest struct {
+	txn     *Transaction
+	mode    LockMode
+	granted bool
+}
+
+type lockRequestQueue struct {
+	requests []*lockRequest // Granted requests come first, then the waiting ones in FIFO order.
+	// The granted request whose transaction is waiting to upgrade it, if any,
+	// and the mode it is upgraded to.
+	upgrading   *lockRequest
+	upgradeMode LockMode
+	cond        *sync.Cond
+}
+
+// LockManager hands out logical locks on records, identified by their RIDs.
+// Unlike page latches, locks are held until the transaction ends, following
+// strict two-phase locking: the transaction manager releases them when the
+// transaction commits or aborts.
+//
+// Besides shared and exclusive locks, it supports intention locks for
+// locking hierarchies, e.g. a transaction holding IX on a table may take X
+// locks on records of the table. Requests for a lock are granted in FIFO
+// order, so that a stream of readers cannot starve a writer.
+//
+// Deadlocks are handled following the policy chosen at construction time. A
+// transaction chosen to be aborted gets `ErrDeadlock` from its pending and
+// later lock requests, and is expected to be aborted by its caller, which
+// releases its locks.
+type LockManager struct {
+	lockTable map[common.RID]*lockRequestQueue
+	policy    DeadlockPolicy
+	// The queue each waiting transaction waits in.
+	waiting map[*Transaction]*lockRequestQueue
+	stop    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+// Create a lock manager. With `DeadlockDetection`, deadlocks are looked for in
+// the background until the lock manager is closed.
+func NewLockManager(policy DeadlockPolicy) *LockManager {
+	lm := &LockManager{
+		lockTable: make(map[common.RID]*lockRequestQueue),
+		policy:    policy,
+		waiting:   make(map[*Transaction]*lockRequestQueue),
+	}
+	if policy == DeadlockDetection {
+		lm.stop = make(chan struct{})
+		lm.done = make(chan struct{})
+		go lm.runDeadlockDetection()
+	}
+	return lm
+}
+
+// Stop looking for deadlocks in the background.
+func (lm *LockManager) Close() {
+	if lm.stop == nil {
+		return
+	}
+	close(lm.stop)
+	<-lm.done
+	lm.stop = nil
+}
+
+// Acquire a lock on `rid` for `txn`, waiting until it can be granted. If `txn`
+// already holds a weaker lock on `rid`, the lock is upgraded.
+func (lm *LockManager) Lock(txn *Transaction, rid common.RID, mode LockMode) error {
+	_, err := lm.lock(txn, rid, mode, true)
+	return err
+}
+
+// Like `Lock`, but return false instead of waiting if the lock cannot be
+// granted right away.
+func (lm *LockManager) TryLock(txn *Transaction, rid common.RID, mode LockMode) (bool, error) {
+	return lm.lock(txn, rid, mode, false)
+}
+
+func (lm *LockManager) lock(txn *Transaction, rid common.RID, mode LockMode, wait bool) (bool, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if txn.state != Running {
+		return false, fmt.Errorf("Transaction %d is %s.", txn.txnId, txn.state)
+	}
+	if txn.lockErr != nil {
+		return false, txn.lockErr
+	}
+	queue, ok := lm.lockTable[rid]
+	if !ok {
+		queue = &lockRequestQueue{cond: sync.NewCond(&lm.mu)}
+		lm.lockTable[rid] = queue
+	}
+
+	if held, ok := txn.lockSet[rid]; ok {
+		if held.covers(mode) {
+			return true, nil
+		}
+		return lm.upgrade(txn, rid, queue, held.union(mode), wait)
+	}
+
+	request := &lockRequest{txn: txn, mode: mode}
+	queue.requests = append(queue.requests, request)
+	for !queue.grantable(request) {
+		// A request which does not wait wounds nobody.
+		var err error
+		if wait {
+			err = lm.checkWait(txn, queue, request)
+		}
+		if !wait || err != nil {
+			queue.remove(request)
+			queue.cond.Broadcast() // Requests behind it may be grantable now.
+			lm.maybeDropQueue(rid, queue)
+			return false, err
+		}
+		lm.wait(txn, queue)
+	}
+	request.granted = true
+	txn.lockSet[rid] = mode
+	queue.cond.Broadcast() // The next request may be compatible as well.
+	return true, nil
+}
+
+func (lm *LockManager) upgrade(txn *Transaction, rid common.RID, queue *lockRequestQueue, mode LockMode, wait bool) (bool, error) {
+	if queue.upgrading != nil {
+		return false, ErrUpgradeConflict
+	}
+	var request *lockRequest
+	for _, r := range queue.requests {
+		if r.txn == txn {
+			request = r
+			break
+		}
+	}
+	queue.upgrading = request
+	queue.upgradeMode = mode
+	defer func() {
+		queue.upgrading = nil
+		queue.cond.Broadcast() // Requests held back by the upgrade may go on.
+	}()
+	for !queue.upgradable(request, mode) {
+		if !wait {
+			return false, nil
+		}
+		if err := lm.checkWait(txn, queue, request); err != nil {
+			return false, err
+		}
+		lm.wait(txn, queue)
+	}
+	request.mode = mode
+	txn.lockSet[rid] = mode
+	return true, nil
+}
+
+// Decide whether `txn` may wait for `request` to be granted, following the
+// deadlock policy. Wound-wait aborts the younger transactions in the way.
+func (lm *LockManager) checkWait(txn *Transaction, queue *lockRequestQueue, request *lockRequest) error {
+	if txn.lockErr != nil {
+		return txn.lockErr
+	}
+	switch lm.policy {
+	case WoundWait:
+		for _, blocker := range queue.blockers(request) {
+			if blocker.txnId > txn.txnId {
+				lm.chooseVictim(blocker)
+			}
+		}
+	case WaitDie:
+		for _, blocker := range queue.blockers(request) {
+			if blocker.txnId < txn.txnId {
+				return ErrDeadlock
+			}
+		}
+	}
+	return nil
+}
+
+func (lm *LockManager) wait(txn *Transaction, queue *lockRequestQueue) {
+	lm.waiting[txn] = queue
+	queue.cond.Wait()
+	delete(lm.waiting, txn)
+}
+
+// Choose `txn` to be aborted, and wake it up if it is waiting.
+func (lm *LockManager) chooseVictim(txn *Transaction) {
+	if txn.lockErr != nil {
+		return
+	}
+	txn.lockErr = ErrDeadlock
+	if queue, ok := lm.waiting[txn]; ok {
+		queue.cond.Broadcast()
+	}
+}
+
+func (lm *LockManager) runDeadlockDetection() {
+	defer close(lm.done)
+	ticker := time.NewTicker(DeadlockDetectionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lm.stop:
+			return
+		case <-ticker.C:
+			lm.DetectDeadlocks()
+		}
+	}
+}
+
+// Build the waits-for graph and break every cycle in it by aborting its
+// youngest transaction. Returns the aborted transactions.
+func (lm *LockManager) DetectDeadlocks() []*Transaction {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	waitsFor := make(map[*Transaction][]*Transaction)
+	for txn, queue := range lm.waiting {
+		if txn.lockErr != nil {
+			continue
+		}
+		for _, request := range queue.requests {
+			if request.txn == txn {
+				waitsFor[txn] = queue.blockers(request)
+				break
+			}
+		}
+	}
+	victims := make([]*Transaction, 0)
+	for {
+		cycle := findCycle(waitsFor)
+		if cycle == nil {
+			return victims
+		}
+		victim := cycle[0]
+		for _, txn := range cycle {
+			if txn.txnId > victim.txnId {
+				victim = txn
+			}
+		}
+		lm.chooseVictim(victim)
+		delete(waitsFor, victim)
+		victims = append(victims, victim)
+	}
+}
+
+// Find a cycle in a waits-for graph, or return nil if there is none. The
+// transactions are visited from the oldest, so that the result is
+// deterministic.
+func findCycle(waitsFor map[*Transaction][]*Transaction) []*Transaction {
+	txns := make([]*Transaction, 0, len(waitsFor))
+	for txn := range waitsFor {
+		txns = append(txns, txn)
+	}
+	sort.Slice(txns, func(i, j int) bool { return txns[i].txnId < txns[j].txnId })
+
+	const (
+		unvisited = iota
+		onPath
+		visited
+	)
+	state := make(map[*Transaction]int)
+	path := make([]*Transaction, 0)
+	var visit func(txn *Transaction) []*Transaction
+	visit = func(txn *Transaction) []*Transaction {
+		state[txn] = onPath
+		path = append(path, txn)
+		for _, next := range waitsFor[txn] {
+			switch state[next] {
+			case onPath:
+				for i := range path {
+					if path[i] == next {
+						return path[i:]
+					}
+				}
+			case unvisited:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		state[txn] = visited
+		path = path[:len(path)-1]
+		return nil
+	}
+	for _, txn := range txns {
+		if state[txn] == unvisited {
+			if cycle := visit(txn); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// Release the lock of `txn` on `rid`. Under strict two-phase locking, this is
+// only for locks which turned out to protect nothing, e.g. a lock on a
+// record that does not exist. Everything else is released when the
+// transaction ends.
+func (lm *LockManager) Unlock(txn *Transaction, rid common.RID) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.unlock(txn, rid)
+}
+
+// Release all locks of `txn`.
+func (lm *LockManager) releaseAll(txn *Transaction) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	for rid := range txn.lockSet {
+		lm.unlock(txn, rid)
+	}
+}
+
+func (lm *LockManager) unlock(txn *Transaction, rid common.RID) {
+	if _, ok := txn.lockSet[rid]; !ok {
+		return
+	}
+	delete(txn.lockSet, rid)
+	queue := lm.lockTable[rid]
+	for _, request := range queue.requests {
+		if request.txn == txn {
+			queue.remove(request)
+			break
+		}
+	}
+	queue.cond.Broadcast()
+	lm.maybeDropQueue(rid, queue)
+}
+
+func (lm *LockManager) maybeDropQueue(rid common.RID, queue *lockRequestQueue) {
+	if len(queue.requests) == 0 {
+		delete(lm.lockTable, rid)
+	}
+}
+
+// A waiting request is granted once it is compatible with all granted
+// requests, and every request before it has been granted, and no upgrade is
+// pending.
+func (queue *lockRequestQueue) grantable(request *lockRequest) bool {
+	if queue.upgrading != nil {
+		return false
+	}
+	for _, r := range queue.requests {
+		if r == request {
+			return true
+		}
+		if !r.granted || !r.mode.compatibleWith(request.mode) {
+			return false
+		}
+	}
+	return false
+}
+
+// An upgrade is granted once the new mode is compatible with all other
+// granted requests.
+func (queue *lockRequestQueue) upgradable(request *lockRequest, mode LockMode) bool {
+	for _, r := range queue.requests {
+		if r != request && r.granted && !r.mode.compatibleWith(mode) {
+			return false
+		}
+	}
+	return true
+}
+
+// The transactions `request` waits for, following the order in which
+// `grantable` and `upgradable` grant requests.
+func (queue *lockRequestQueue) blockers(request *lockRequest) []*Transaction {
+	blockers := make([]*Transaction, 0)
+	if request == queue.upgrading {
+		for _, r := range queue.requests {
+			if r != request && r.granted && !r.mode.compatibleWith(queue.upgradeMode) {
+				blockers = append(blockers, r.txn)
+			}
+		}
+		return blockers
+	}
+	if queue.upgrading != nil {
+		blockers = append(blockers, queue.upgrading.txn)
+	}
+	for _, r := range queue.requests {
+		if r == request {
+			break
+		}
+		if r != queue.upgrading && (!r.granted || !r.mode.compatibleWith(request.mode)) {
+			blockers = append(blockers, r.txn)
+		}
+	}
+	return blockers
+}
+
+func (queue *lockRequestQueue) remove(request *lockRequest) {
+	for i, r := range queue.requests {
+		if r == request {
+			queue.requests = append(queue.requests[:i], queue.requests[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+)
+
+// Lock `rid` in the background, and report on the channel once the lock is
+// granted.
+func lockAsync(lockManager *LockManager, txn *Transaction, rid common.RID, mode LockMode) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- lockManager.Lock(txn, rid, mode)
+	}()
+	return done
+}
+
+func requireBlocked(t *testing.T, done chan error) {
+	select {
+	case <-done:
+		t.Fatalf("Lock is granted unexpectedly.")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func requireGranted(t *testing.T, done chan error) {
+	select {
+	case err := <-done:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatalf("Lock is not granted.")
+	}
+}
+
+func TestLockMode_Compatibility(t *testing.T) {
+	modes := []LockMode{IntentionShared, IntentionExclusive, Shared, SharedIntentionExclusive, Exclusive}
+	for _, m := range modes {
+		for _, other := range modes {
+			require.Equal(t, m.compatibleWith(other), other.compatibleWith(m))
+			union := m.union(other)
+			require.True(t, union.covers(m) && union.covers(other))
+		}
+	}
+	require.Equal(t, SharedIntentionExclusive, Shared.union(IntentionExclusive))
+	require.Equal(t, Exclusive, Shared.union(Exclusive))
+	require.Equal(t, Shared, IntentionShared.union(Shared))
+}
+
+func TestLockManager_SharedExclusive(t *testing.T) {
+	lockManager := NewLockManager(DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := NewTransactionManager(nil, lockManager)
+	rid := common.RID{PageId: 2, SlotNum: 0}
+
+	reader1, reader2 := txnManager.Begin(), txnManager.Begin()
+	require.Nil(t, lockManager.Lock(reader1, rid, Shared))
+	require.Nil(t, lockManager.Lock(reader2, rid, Shared))
+	mode, ok := reader1.HeldLockMode(rid)
+	require.True(t, ok)
+	require.Equal(t, Shared, mode)
+
+	writer := txnManager.Begin()
+	ok, err := lockManager.TryLock(writer, rid, Exclusive)
+	require.Nil(t, err)
+	require.False(t, ok)
+	done := lockAsync(lockManager, writer, rid, Exclusive)
+	requireBlocked(t, done)
+
+	// Locks are held until the transactions end.
+	require.Nil(t, txnManager.Commit(reader1))
+	requireBlocked(t, done)
+	require.Nil(t, txnManager.Abort(reader2))
+	requireGranted(t, done)
+	_, ok = reader1.HeldLockMode(rid)
+	require.False(t, ok)
+
+	require.Nil(t, txnManager.Commit(writer))
+	require.Equal(t, 0, len(lockManager.lockTable))
+	require.NotNil(t, lockManager.Lock(writer, rid, Shared))
+}
+
+func TestLockManager_FIFO(t *testing.T) {
+	lockManager := NewLockManager(DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := NewTransactionManager(nil, lockManager)
+	rid := common.RID{PageId: 2, SlotNum: 0}
+
+	reader := txnManager.Begin()
+	require.Nil(t, lockManager.Lock(reader, rid, Shared))
+	writer := txnManager.Begin()
+	writerDone := lockAsync(lockManager, writer, rid, Exclusive)
+	requireBlocked(t, writerDone)
+
+	// A later reader is compatible with the granted lock, but queues up behind
+	// the writer.
+	lateReader := txnManager.Begin()
+	readerDone := lockAsync(lockManager, lateReader, rid, Shared)
+	requireBlocked(t, readerDone)
+
+	require.Nil(t, txnManager.Commit(reader))
+	requireGranted(t, writerDone)
+	requireBlocked(t, readerDone)
+	require.Nil(t, txnManager.Commit(writer))
+	requireGranted(t, readerDone)
+	require.Nil(t, txnManager.Commit(lateReader))
+}
+
+func TestLockManager_Upgrade(t *testing.T) {
+	lockManager := NewLockManager(DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := NewTransactionManager(nil, lockManager)
+	rid := common.RID{PageId: 2, SlotNum: 0}
+
+	txn := txnManager.Begin()
+	require.Nil(t, lockManager.Lock(txn, rid, Shared))
+	require.Nil(t, lockManager.Lock(txn, rid, IntentionShared)) // Already covered.
+	require.Nil(t, lockManager.Lock(txn, rid, IntentionExclusive))
+	mode, _ := txn.HeldLockMode(rid)
+	require.Equal(t, SharedIntentionExclusive, mode)
+
+	// The upgrade waits for the other reader, and takes precedence over
+	// requests queued after it.
+	other := txnManager.Begin()
+	require.Nil(t, lockManager.Lock(other, rid, IntentionShared))
+	upgradeDone := lockAsync(lockManager, txn, rid, Exclusive)
+	requireBlocked(t, upgradeDone)
+	third := txnManager.Begin()
+	thirdDone := lockAsync(lockManager, third, rid, IntentionShared)
+	requireBlocked(t, thirdDone)
+
+	// A second upgrade would wait forever.
+	require.Equal(t, ErrUpgradeConflict, lockManager.Lock(other, rid, Shared))
+
+	require.Nil(t, txnManager.Commit(other))
+	requireGranted(t, upgradeDone)
+	mode, _ = txn.HeldLockMode(rid)
+	require.Equal(t, Exclusive, mode)
+	requireBlocked(t, thirdDone)
+	require.Nil(t, txnManager.Abort(txn))
+	requireGranted(t, thirdDone)
+	require.Nil(t, txnManager.Commit(third))
+}
+
+func TestLockManager_Unlock(t *testing.T) {
+	lockManager := NewLockManager(DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := NewTransactionManager(nil, lockManager)
+	rid := common.RID{PageId: 2, SlotNum: 0}
+
+	txn := txnManager.Begin()
+	require.Nil(t, lockManager.Lock(txn, rid, Exclusive))
+	other := txnManager.Begin()
+	done := lockAsync(lockManager, other, rid, Shared)
+	requireBlocked(t, done)
+	lockManager.Unlock(txn, rid)
+	requireGranted(t, done)
+	require.Nil(t, txnManager.Commit(txn))
+	require.Nil(t, txnManager.Commit(other))
+}
+
+func requireDeadlock(t *testing.T, done chan error) {
+	select {
+	case err := <-done:
+		require.Equal(t, ErrDeadlock, err)
+	case <-time.After(time.Second):
+		t.Fatalf("Deadlock is not detected.")
+	}
+}
+
+func TestLockManager_DeadlockDetection(t *testing.T) {
+	lockManager := NewLockManager(DeadlockDetection)
+	defer lockManager.Close()
+	txnManager := NewTransactionManager(nil, lockManager)
+	rid1 := common.RID{PageId: 2, SlotNum: 0}
+	rid2 := common.RID{PageId: 2, SlotNum: 1}
+
+	older, younger := txnManager.Begin(), txnManager.Begin()
+	require.Nil(t, lockManager.Lock(older, rid1, Exclusive))
+	require.Nil(t, lockManager.Lock(younger, rid2, Shared))
+	olderDone := lockAsync(lockManager, older, rid2, Exclusive)
+	requireBlocked(t, olderDone)
+	youngerDone := lockAsync(lockManager, younger, rid1, Shared)
+
+	// The youngest transaction of the cycle is the victim.
+	requireDeadlock(t, youngerDone)
+	require.Equal(t, ErrDeadlock, lockManager.Lock(younger, rid2, Exclusive))
+	requireBlocked(t, olderDone)
+	require.Nil(t, txnManager.Abort(younger))
+	requireGranted(t, olderDone)
+	require.Nil(t, txnManager.Commit(older))
+}
+
+func TestLockManager_FindCycle(t *testing.T) {
+	txns := make([]*Transaction, 4)
+	for i := range txns {
+		txns[i] = newTransaction(common.TxnId(i), 0, nil)
+	}
+	waitsFor := map[*Transaction][]*Transaction{
+		txns[0]: {txns[1]},
+		txns[1]: {txns[2]},
+		txns[2]: {txns[3]},
+	}
+	require.Nil(t, findCycle(waitsFor))
+	waitsFor[txns[3]] = []*Transaction{txns[1]}
+	require.Equal(t, []*Transaction{txns[1], txns[2], txns[3]}, findCycle(waitsFor))
+}
+
+func TestLockManager_WoundWait(t *testing.T) {
+	lockManager := NewLockManager(WoundWait)
+	defer lockManager.Close()
+	txnManager := NewTransactionManager(nil, lockManager)
+	rid1 := common.RID{PageId: 2, SlotNum: 0}
+	rid2 := common.RID{PageId: 2, SlotNum: 1}
+
+	older, younger := txnManager.Begin(), txnManager.Begin()
+	require.Nil(t, lockManager.Lock(older, rid1, Exclusive))
+	require.Nil(t, lockManager.Lock(younger, rid2, Exclusive))
+
+	// Trying a lock does not wound the holder, since it does not wait.
+	ok, err := lockManager.TryLock(older, rid2, Exclusive)
+	require.Nil(t, err)
+	require.False(t, ok)
+	require.Nil(t, younger.lockErr)
+
+	// The younger transaction waits for the older one.
+	youngerDone := lockAsync(lockManager, younger, rid1, Exclusive)
+	requireBlocked(t, youngerDone)
+
+	// The older transaction wounds the younger one instead of waiting for it.
+	olderDone := lockAsync(lockManager, older, rid2, Exclusive)
+	requireDeadlock(t, youngerDone)
+	requireBlocked(t, olderDone)
+	require.Nil(t, txnManager.Abort(younger))
+	requireGranted(t, olderDone)
+	require.Nil(t, txnManager.Commit(older))
+}
+
+func TestLockManager_WaitDie(t *testing.T) {
+	lockManager := NewLockManager(WaitDie)
+	defer lockManager.Close()
+	txnManager := NewTransactionManager(nil, lockManager)
+	rid1 := common.RID{PageId: 2, SlotNum: 0}
+	rid2 := common.RID{PageId: 2, SlotNum: 1}
+
+	older, younger := txnManager.Begin(), txnManager.Begin()
+	require.Nil(t, lockManager.Lock(older, rid1, Exclusive))
+	require.Nil(t, lockManager.Lock(younger, rid2, Exclusive))
+
+	// The older transaction waits for the younger one.
+	olderDone := lockAsync(lockManager, older, rid2, Shared)
+	requireBlocked(t, olderDone)
+
+	// The younger transaction dies instead of waiting for the older one.
+	require.Equal(t, ErrDeadlock, lockManager.Lock(younger, rid1, Shared))
+	require.Nil(t, txnManager.Abort(younger))
+	requireGranted(t, olderDone)
+	require.Nil(t, txnManager.Commit(older))
+	require.Equal(t, 0, len(lockManager.lockTable))
+}
@@ -0,0 +1,122 @@
+package transaction
+
+import (
+	"simple-db-golang/src/common"
+)
+
+type TransactionState int
+
+const (
+	Running TransactionState = iota
+	Committed
+	Aborted
+)
+
+func (s TransactionState) String() string {
+	switch s {
+	case Running:
+		return "Running"
+	case Committed:
+		return "Committed"
+	case Aborted:
+		return "Aborted"
+	default:
+		return "Invalid"
+	}
+}
+
+// Timestamp orders the commits of transactions for multi-version concurrency
+// control. A transaction reads the snapshot of the database made of the
+// transactions committed at or before its read timestamp.
+type Timestamp uint64
+
+type WriteType int
+
+const (
+	InsertWrite WriteType = iota
+	DeleteWrite
+	UpdateWrite
+)
+
+// Table is something a transaction writes to. Its writes are recorded in the
+// write set of the transaction, and handed back to it once the transaction
+// ends.
+type Table interface {
+	// Finish a write of a committed transaction, e.g. actually remove a
+	// record that was marked as deleted.
+	CommitWrite(txn *Transaction, record *WriteRecord)
+	// Revert a write of an aborting transaction.
+	RollbackWrite(txn *Transaction, record *WriteRecord)
+}
+
+type WriteRecord struct {
+	Type  WriteType
+	RID   common.RID
+	Table Table
+	// Previous log record of the transaction when the write was logged, which
+	// is where rolling back continues after reverting it.
+	UndoNextLSN common.LSN
+	// The record as it was before an update, which rolling back writes back.
+	OldData []byte
+}
+
+// Transaction is not safe for concurrent use: all operations of a transaction
+// are expected to come from the same goroutine.
+type Transaction struct {
+	txnId       common.TxnId
+	state       TransactionState
+	readTs      Timestamp
+	commitTs    Timestamp
+	prevLSN     common.LSN
+	writeSet    []*WriteRecord
+	lockManager *LockManager
+	lockSet     map[common.RID]LockMode // Guarded by the lock manager.
+	// Set once the lock manager has chosen the transaction to be aborted.
+	// Guarded by the lock manager.
+	lockErr error
+}
+
+func newTransaction(txnId common.TxnId, readTs Timestamp, lockManager *LockManager) *Transaction {
+	return &Transaction{
+		txnId:       txnId,
+		state:       Running,
+		readTs:      readTs,
+		prevLSN:     common.InvalidLSN,
+		writeSet:    make([]*WriteRecord, 0),
+		lockManager: lockManager,
+		lockSet:     make(map[common.RID]LockMode),
+	}
+}
+
+func (txn *Transaction) TxnId() common.TxnId { return txn.txnId }
+
+func (txn *Transaction) State() TransactionState { return txn.state }
+
+// The transaction sees the writes of the transactions committed at or before
+// its read timestamp, besides its own.
+func (txn *Transaction) ReadTimestamp() Timestamp { return txn.readTs }
+
+// Only valid once the transaction has committed.
+func (txn *Transaction) CommitTimestamp() Timestamp { return txn.commitTs }
+
+// LSN of the last log record of the transaction, which the next one is linked to.
+func (txn *Transaction) PrevLSN() common.LSN { return txn.prevLSN }
+
+func (txn *Transaction) SetPrevLSN(lsn common.LSN) { txn.prevLSN = lsn }
+
+// The lock manager the transaction takes its locks from, or nil if locking is
+// disabled.
+func (txn *Transaction) LockManager() *LockManager { return txn.lockManager }
+
+// The mode of the lock the transaction holds on `rid`, if any.
+func (txn *Transaction) HeldLockMode(rid common.RID) (LockMode, bool) {
+	mode, ok := txn.lockSet[rid]
+	return mode, ok
+}
+
+// Writes of the transaction, in the order they were made.
+func (txn *Transaction) WriteSet() []*WriteRecord { return txn.writeSet }
+
+func (txn *Transaction) AppendWriteRecord(record *WriteRecord) {
+	txn.writeSet = append(txn.writeSet, record)
+}
@@ -0,0 +1,148 @@
+package transaction
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/wal"
+)
+
+// TransactionManager starts and ends transactions. When logging is enabled,
+// every transaction is bracketed by a begin record and a commit or abort
+// record in the log, and commit only returns once the transaction is durable.
+// When locking is enabled, the locks of a transaction are released once it
+// has ended.
+//
+// It also hands out the timestamps for multi-version concurrency control: a
+// transaction reads the snapshot as of its beginning, and its writes become
+// visible to the transactions beginning after it commits.
+type TransactionManager struct {
+	logManager  *wal.LogManager
+	lockManager *LockManager
+	nextTxnId   common.TxnId // Only used when logging is disabled.
+	activeTxns  map[*Transaction]struct{}
+	mu          sync.Mutex
+	// Timestamp of the last commit. Commits hold the lock exclusively until
+	// their writes are stamped with the commit timestamp, so that a snapshot
+	// never sees half of a transaction.
+	lastCommitTs Timestamp
+	commitMu     sync.RWMutex
+}
+
+// Create a transaction manager. `logManager` may be nil, in which case
+// transactions can still be rolled back, but are not durable. `lockManager`
+// may be nil, in which case transactions are not isolated from each other.
+func NewTransactionManager(logManager *wal.LogManager, lockManager *LockManager) *TransactionManager {
+	return &TransactionManager{
+		logManager:  logManager,
+		lockManager: lockManager,
+		activeTxns:  make(map[*Transaction]struct{}),
+	}
+}
+
+func (tm *TransactionManager) Begin() *Transaction {
+	tm.commitMu.RLock()
+	readTs := tm.lastCommitTs
+	tm.mu.Lock()
+	var txn *Transaction
+	if tm.logManager == nil {
+		txn = newTransaction(tm.nextTxnId, readTs, tm.lockManager)
+		tm.nextTxnId++
+	} else {
+		txn = newTransaction(tm.logManager.NextTxnId(), readTs, tm.lockManager)
+	}
+	tm.activeTxns[txn] = struct{}{}
+	tm.mu.Unlock()
+	tm.commitMu.RUnlock()
+
+	if tm.logManager != nil {
+		txn.prevLSN = tm.logManager.AppendLogRecord(&wal.LogRecord{
+			PrevLSN: common.InvalidLSN,
+			TxnId:   txn.txnId,
+			Type:    wal.BeginRecord,
+		})
+	}
+	return txn
+}
+
+// The oldest snapshot any transaction may read: the read timestamp of the
+// oldest active transaction, or the last commit if there is none. Versions
+// deleted at or before it are not visible to anyone any more.
+func (tm *TransactionManager) OldestSnapshot() Timestamp {
+	tm.commitMu.RLock()
+	defer tm.commitMu.RUnlock()
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	oldest := tm.lastCommitTs
+	for txn := range tm.activeTxns {
+		if txn.readTs < oldest {
+			oldest = txn.readTs
+		}
+	}
+	return oldest
+}
+
+// Commit a transaction. Once the commit record is durable, the transaction
+// gets its commit timestamp, and its writes are finished, e.g. stamped with
+// the commit timestamp.
+func (tm *TransactionManager) Commit(txn *Transaction) error {
+	if txn.state != Running {
+		return fmt.Errorf("Transaction %d is %s.", txn.txnId, txn.state)
+	}
+	if tm.logManager != nil {
+		lsn := tm.logManager.AppendLogRecord(&wal.LogRecord{
+			PrevLSN: txn.prevLSN,
+			TxnId:   txn.txnId,
+			Type:    wal.CommitRecord,
+		})
+		if err := tm.logManager.Flush(lsn); err != nil {
+			log.WithError(err).Fatalf("Cannot flush log for commit.")
+		}
+		txn.prevLSN = lsn
+	}
+	tm.commitMu.Lock()
+	tm.lastCommitTs++
+	txn.commitTs = tm.lastCommitTs
+	txn.state = Committed
+	for _, record := range txn.writeSet {
+		record.Table.CommitWrite(txn, record)
+	}
+	tm.commitMu.Unlock()
+	txn.writeSet = txn.writeSet[:0]
+	tm.end(txn)
+	return nil
+}
+
+// Abort a transaction, rolling back its writes in the reverse order.
+func (tm *TransactionManager) Abort(txn *Transaction) error {
+	if txn.state != Running {
+		return fmt.Errorf("Transaction %d is %s.", txn.txnId, txn.state)
+	}
+	for i := len(txn.writeSet) - 1; i >= 0; i-- {
+		record := txn.writeSet[i]
+		record.Table.RollbackWrite(txn, record)
+	}
+	txn.writeSet = txn.writeSet[:0]
+	if tm.logManager != nil {
+		txn.prevLSN = tm.logManager.AppendLogRecord(&wal.LogRecord{
+			PrevLSN: txn.prevLSN,
+			TxnId:   txn.txnId,
+			Type:    wal.AbortRecord,
+		})
+	}
+	txn.state = Aborted
+	tm.end(txn)
+	return nil
+}
+
+func (tm *TransactionManager) end(txn *Transaction) {
+	tm.mu.Lock()
+	delete(tm.activeTxns, txn)
+	tm.mu.Unlock()
+	if tm.lockManager != nil {
+		tm.lockManager.releaseAll(txn)
+	}
+}
@@ -0,0 +1,109 @@
+package transaction
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+	"simple-db-golang/src/wal"
+)
+
+// Records the writes handed back by the transaction manager.
+type fakeTable struct {
+	committed  []common.RID
+	rolledBack []common.RID
+}
+
+func (table *fakeTable) CommitWrite(txn *Transaction, record *WriteRecord) {
+	table.committed = append(table.committed, record.RID)
+}
+
+func (table *fakeTable) RollbackWrite(txn *Transaction, record *WriteRecord) {
+	table.rolledBack = append(table.rolledBack, record.RID)
+}
+
+func TestTransactionManager_CommitAbort(t *testing.T) {
+	txnManager := NewTransactionManager(nil, nil)
+	table := &fakeTable{}
+	rids := []common.RID{{PageId: 2, SlotNum: 0}, {PageId: 2, SlotNum: 1}, {PageId: 3, SlotNum: 0}}
+
+	txn := txnManager.Begin()
+	require.Equal(t, Running, txn.State())
+	for _, rid := range rids {
+		txn.AppendWriteRecord(&WriteRecord{Type: InsertWrite, RID: rid, Table: table})
+	}
+	require.Nil(t, txnManager.Commit(txn))
+	require.Equal(t, Committed, txn.State())
+	require.Equal(t, rids, table.committed)
+	require.Equal(t, 0, len(table.rolledBack))
+	require.NotNil(t, txnManager.Abort(txn))
+
+	// Writes are rolled back in the reverse order.
+	table.committed = nil
+	other := txnManager.Begin()
+	require.NotEqual(t, txn.TxnId(), other.TxnId())
+	for _, rid := range rids {
+		other.AppendWriteRecord(&WriteRecord{Type: DeleteWrite, RID: rid, Table: table})
+	}
+	require.Nil(t, txnManager.Abort(other))
+	require.Equal(t, Aborted, other.State())
+	require.Equal(t, []common.RID{rids[2], rids[1], rids[0]}, table.rolledBack)
+	require.Equal(t, 0, len(table.committed))
+	require.NotNil(t, txnManager.Commit(other))
+}
+
+func TestTransactionManager_Logging(t *testing.T) {
+	defer wal.RemoveLogFiles("tmp-log")
+	logManager := wal.NewLogManager("tmp-log")
+	defer logManager.Close()
+	txnManager := NewTransactionManager(logManager, nil)
+
+	committed := txnManager.Begin()
+	aborted := txnManager.Begin()
+	require.Nil(t, txnManager.Commit(committed))
+	// Commit waits for the log, which is also where the begin records are.
+	require.Equal(t, committed.PrevLSN(), logManager.PersistentLSN())
+	require.Nil(t, txnManager.Abort(aborted))
+	require.Nil(t, logManager.Flush(logManager.LastLSN()))
+
+	it, err := logManager.Iterator(common.InvalidLSN)
+	require.Nil(t, err)
+	defer it.Close()
+	expected := []struct {
+		txn        *Transaction
+		recordType wal.LogRecordType
+	}{
+		{committed, wal.BeginRecord},
+		{aborted, wal.BeginRecord},
+		{committed, wal.CommitRecord},
+		{aborted, wal.AbortRecord},
+	}
+	for _, e := range expected {
+		record, ok := it.Next()
+		require.True(t, ok)
+		require.Equal(t, e.txn.TxnId(), record.TxnId)
+		require.Equal(t, e.recordType, record.Type)
+	}
+	_, ok := it.Next()
+	require.False(t, ok)
+}
+
+func TestTransactionManager_Timestamps(t *testing.T) {
+	txnManager := NewTransactionManager(nil, nil)
+	require.Equal(t, Timestamp(0), txnManager.OldestSnapshot())
+
+	old := txnManager.Begin()
+	txn := txnManager.Begin()
+	require.Nil(t, txnManager.Commit(txn))
+	require.Equal(t, Timestamp(1), txn.CommitTimestamp())
+	txn = txnManager.Begin()
+	require.Equal(t, Timestamp(1), txn.ReadTimestamp())
+	require.Nil(t, txnManager.Abort(txn))
+
+	// The oldest active snapshot holds back the garbage collection.
+	require.Equal(t, Timestamp(0), txnManager.OldestSnapshot())
+	require.Nil(t, txnManager.Commit(old))
+	require.Equal(t, Timestamp(2), old.CommitTimestamp())
+	require.Equal(t, Timestamp(2), txnManager.OldestSnapshot())
+}
@@ -0,0 +1,92 @@
+package types
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Keys of indexes are values encoded so that comparing the bytes of two keys
+// compares their values, one after the other. Each value starts with a byte
+// which is 0 for NULL and 1 otherwise, followed unless NULL by:
+//   - INTEGER, BIGINT, TIMESTAMP: the number, big endian, with the sign bit
+//     flipped.
+//   - DOUBLE: the number, big endian, with the sign bit flipped if it is
+//     positive, and all bits flipped if it is negative. -0 is encoded as 0,
+//     and every NaN as the same value, greater than all numbers.
+//   - BOOLEAN: 0 or 1.
+//   - VARCHAR: the text with each 0x00 byte escaped as 0x00 0xFF, ended by
+//     0x00 0x01, so that no key is a prefix of another.
+//
+// Values of different types are encoded differently, even if they are equal,
+// so the values of a key must have the types of its columns.
+func EncodeKey(values []Value) []byte {
+	key := make([]byte, 0, 16)
+	for _, v := range values {
+		if v.null {
+			key = append(key, 0)
+			continue
+		}
+		key = append(key, 1)
+		switch v.typeId {
+		case Integer:
+			key = appendUint32(key, uint32(v.integer)^1<<31)
+		case BigInt, Timestamp:
+			key = appendUint64(key, uint64(v.integer)^1<<63)
+		case Double:
+			key = appendUint64(key, encodeDouble(v.float))
+		case Boolean:
+			key = append(key, byte(v.integer))
+		case Varchar:
+			for i := 0; i < len(v.str); i++ {
+				key = append(key, v.str[i])
+				if v.str[i] == 0 {
+					key = append(key, 0xFF)
+				}
+			}
+			key = append(key, 0, 1)
+		}
+	}
+	return key
+}
+
+// Maximum size of the key of values of `columns`.
+func MaxKeySize(columns []Column) int {
+	size := 0
+	for _, column := range columns {
+		size++
+		if column.Type == Varchar {
+			// A character takes at most 4 bytes, or 2 once escaped if it is
+			// 0x00.
+			size += 4*column.Length + 2
+		} else {
+			size += column.Type.fixedSize()
+		}
+	}
+	return size
+}
+
+func encodeDouble(f float64) uint64 {
+	switch {
+	case math.IsNaN(f):
+		return math.MaxUint64
+	case f == 0:
+		f = 0
+	}
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		return ^bits
+	}
+	return bits | 1<<63
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-4:], v)
+	return b
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(b[len(b)-8:], v)
+	return b
+}
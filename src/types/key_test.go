@@ -0,0 +1,52 @@
+package types
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeKey(t *testing.T) {
+	ordered := [][]Value{
+		{NewNull(Integer), NewInteger(math.MinInt32), NewInteger(-1), NewInteger(0), NewInteger(1), NewInteger(math.MaxInt32)},
+		{NewNull(BigInt), NewBigInt(math.MinInt64), NewBigInt(-1), NewBigInt(0), NewBigInt(math.MaxInt64)},
+		{NewDouble(math.Inf(-1)), NewDouble(-2.5), NewDouble(-1e-300), NewDouble(0), NewDouble(1e-300), NewDouble(7), NewDouble(math.Inf(1)), NewDouble(math.NaN())},
+		{NewVarchar(""), NewVarchar("\x00"), NewVarchar("\x00\x00"), NewVarchar("\x01"), NewVarchar("a"), NewVarchar("a\x00"), NewVarchar("ab"), NewVarchar("é")},
+		{NewBoolean(false), NewBoolean(true)},
+		{NewTimestamp(time.Unix(-1, 0)), NewTimestamp(time.Unix(0, 0)), NewTimestamp(time.Unix(0, 1000))},
+	}
+	for _, values := range ordered {
+		for i := 1; i < len(values); i++ {
+			a, b := EncodeKey(values[i-1:i]), EncodeKey(values[i:i+1])
+			require.Equal(t, -1, bytes.Compare(a, b), "%v %v", values[i-1], values[i])
+		}
+	}
+	require.Equal(t, EncodeKey([]Value{NewDouble(0)}), EncodeKey([]Value{NewDouble(math.Copysign(0, -1))}))
+
+	// Keys of several values compare value after value.
+	keys := [][]byte{
+		EncodeKey([]Value{NewVarchar("a"), NewInteger(2)}),
+		EncodeKey([]Value{NewVarchar("a\x00"), NewInteger(1)}),
+		EncodeKey([]Value{NewVarchar("ab"), NewNull(Integer)}),
+		EncodeKey([]Value{NewVarchar("ab"), NewInteger(-3)}),
+	}
+	for i := 1; i < len(keys); i++ {
+		require.Equal(t, -1, bytes.Compare(keys[i-1], keys[i]))
+	}
+}
+
+func TestMaxKeySize(t *testing.T) {
+	columns := []Column{
+		{Name: "a", Type: Integer},
+		{Name: "b", Type: Varchar, Length: 3},
+		{Name: "c", Type: Boolean, Nullable: true},
+	}
+	require.Equal(t, 1+4+1+14+1+1, MaxKeySize(columns))
+	for _, text := range []string{"\x00\x00\x00", "€€€", "𝄞𝄞𝄞"} {
+		key := EncodeKey([]Value{NewInteger(1), NewVarchar(text), NewBoolean(true)})
+		require.True(t, len(key) <= MaxKeySize(columns))
+	}
+}
@@ -0,0 +1,78 @@
+package types
+
+import (
+	"fmt"
+)
+
+type Column struct {
+	Name string
+	Type TypeId
+	// Maximum length of a VARCHAR, in characters. Unused by the other types.
+	Length   int
+	Nullable bool
+}
+
+// Schema is the list of columns of a table or of the output of a query, in
+// order.
+type Schema struct {
+	Columns []Column
+}
+
+func NewSchema(columns ...Column) *Schema {
+	return &Schema{Columns: columns}
+}
+
+func (s *Schema) NumColumns() int {
+	return len(s.Columns)
+}
+
+// Position of the column called `name`, or false if there is none.
+func (s *Schema) ColumnIndex(name string) (int, bool) {
+	for i, column := range s.Columns {
+		if column.Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Check that the schema can be used for a table.
+func (s *Schema) Validate() error {
+	if len(s.Columns) == 0 {
+		return fmt.Errorf("Schema has no columns.")
+	}
+	for i, column := range s.Columns {
+		if column.Name == "" {
+			return fmt.Errorf("Column %d has no name.", i)
+		}
+		if index, _ := s.ColumnIndex(column.Name); index != i {
+			return fmt.Errorf("Column %s is defined twice.", column.Name)
+		}
+		if !column.Type.IsValid() {
+			return fmt.Errorf("Column %s has an invalid type.", column.Name)
+		}
+		if column.Type == Varchar && column.Length <= 0 {
+			return fmt.Errorf("Column %s has no length.", column.Name)
+		}
+	}
+	return nil
+}
+
+// Size of the null bitmap of a tuple, one bit per column.
+func (s *Schema) nullBitmapSize() int {
+	return (len(s.Columns) + 7) / 8
+}
+
+// Offset of the value of column `i` in a tuple.
+func (s *Schema) fixedOffset(i int) int {
+	offset := s.nullBitmapSize()
+	for _, column := range s.Columns[:i] {
+		offset += column.Type.fixedSize()
+	}
+	return offset
+}
+
+// Size of the null bitmap and the fixed part of a tuple together.
+func (s *Schema) fixedSize() int {
+	return s.fixedOffset(len(s.Columns))
+}
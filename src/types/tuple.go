@@ -0,0 +1,145 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Tuple is a row of values encoded for a schema, to be stored as a record of a
+// table heap. The schema is not part of the tuple, and is given to every
+// accessor.
+//
+// Format, with all integers little endian: `| null bitmap | fixed part |
+// variable part |`. The null bitmap has a bit per column, set if the column
+// is NULL. The fixed part has a slot per column, of the size of its type, in
+// the order of the schema. The slot of a VARCHAR holds the offset (4) of its
+// text from the start of the tuple and its length (4) in bytes, and the text
+// itself is in the variable part. Slots of NULL columns are zero.
+type Tuple struct {
+	data []byte
+}
+
+// Encode `values` for `schema`, checking that they match its columns.
+func NewTuple(schema *Schema, values []Value) (*Tuple, error) {
+	if len(values) != len(schema.Columns) {
+		return nil, fmt.Errorf("Expected %d values, got %d.", len(schema.Columns), len(values))
+	}
+	size := schema.fixedSize()
+	for i, column := range schema.Columns {
+		value := values[i]
+		if value.null {
+			if !column.Nullable {
+				return nil, fmt.Errorf("Column %s cannot be NULL.", column.Name)
+			}
+			continue
+		}
+		if value.typeId != column.Type {
+			return nil, fmt.Errorf("Column %s is %s, got %s.", column.Name, column.Type, value.typeId)
+		}
+		if column.Type == Varchar {
+			if utf8.RuneCountInString(value.str) > column.Length {
+				return nil, fmt.Errorf("Value of column %s is longer than %d.", column.Name, column.Length)
+			}
+			size += len(value.str)
+		}
+	}
+
+	data := make([]byte, schema.fixedSize(), size)
+	offset := schema.nullBitmapSize()
+	for i, column := range schema.Columns {
+		value := values[i]
+		slot := data[offset : offset+column.Type.fixedSize()]
+		offset += len(slot)
+		if value.null {
+			data[i/8] |= 1 << (i % 8)
+			continue
+		}
+		switch column.Type {
+		case Integer:
+			binary.LittleEndian.PutUint32(slot, uint32(value.integer))
+		case BigInt, Timestamp:
+			binary.LittleEndian.PutUint64(slot, uint64(value.integer))
+		case Double:
+			binary.LittleEndian.PutUint64(slot, math.Float64bits(value.float))
+		case Boolean:
+			slot[0] = byte(value.integer)
+		case Varchar:
+			binary.LittleEndian.PutUint32(slot, uint32(len(data)))
+			binary.LittleEndian.PutUint32(slot[4:], uint32(len(value.str)))
+			data = append(data, value.str...)
+		}
+	}
+	return &Tuple{data: data}, nil
+}
+
+// Decode a tuple read from a table heap, checking that its layout matches
+// `schema`.
+func DecodeTuple(schema *Schema, data []byte) (*Tuple, error) {
+	if len(data) < schema.fixedSize() {
+		return nil, fmt.Errorf("Tuple is too short for its schema.")
+	}
+	tuple := &Tuple{data: data}
+	for i, column := range schema.Columns {
+		if column.Type != Varchar || tuple.IsNull(schema, i) {
+			continue
+		}
+		start, end := tuple.varcharBounds(schema, i)
+		if start < schema.fixedSize() || end < start || end > len(data) {
+			return nil, fmt.Errorf("Tuple has a malformed value for column %s.", column.Name)
+		}
+	}
+	return tuple, nil
+}
+
+// The encoded tuple, to be stored in a table heap.
+func (t *Tuple) Data() []byte {
+	return t.data
+}
+
+func (t *Tuple) IsNull(schema *Schema, i int) bool {
+	return t.data[i/8]&(1<<(i%8)) != 0
+}
+
+// Value of column `i`.
+func (t *Tuple) Value(schema *Schema, i int) Value {
+	column := schema.Columns[i]
+	if t.IsNull(schema, i) {
+		return NewNull(column.Type)
+	}
+	offset := schema.fixedOffset(i)
+	slot := t.data[offset : offset+column.Type.fixedSize()]
+	switch column.Type {
+	case Integer:
+		return NewInteger(int32(binary.LittleEndian.Uint32(slot)))
+	case BigInt:
+		return NewBigInt(int64(binary.LittleEndian.Uint64(slot)))
+	case Timestamp:
+		return Value{typeId: Timestamp, integer: int64(binary.LittleEndian.Uint64(slot))}
+	case Double:
+		return NewDouble(math.Float64frombits(binary.LittleEndian.Uint64(slot)))
+	case Boolean:
+		return NewBoolean(slot[0] != 0)
+	case Varchar:
+		start, end := t.varcharBounds(schema, i)
+		return NewVarchar(string(t.data[start:end]))
+	default:
+		return NewNull(column.Type)
+	}
+}
+
+// Values of all the columns, in the order of the schema.
+func (t *Tuple) Values(schema *Schema) []Value {
+	values := make([]Value, len(schema.Columns))
+	for i := range values {
+		values[i] = t.Value(schema, i)
+	}
+	return values
+}
+
+func (t *Tuple) varcharBounds(schema *Schema, i int) (int, int) {
+	offset := schema.fixedOffset(i)
+	start := int(binary.LittleEndian.Uint32(t.data[offset:]))
+	return start, start + int(binary.LittleEndian.Uint32(t.data[offset+4:]))
+}
@@ -0,0 +1,123 @@
+package types
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/disk"
+	"simple-db-golang/src/table"
+	"simple-db-golang/src/transaction"
+)
+
+func testSchema() *Schema {
+	return NewSchema(
+		Column{Name: "id", Type: Integer},
+		Column{Name: "count", Type: BigInt, Nullable: true},
+		Column{Name: "score", Type: Double},
+		Column{Name: "active", Type: Boolean, Nullable: true},
+		Column{Name: "name", Type: Varchar, Length: 8, Nullable: true},
+		Column{Name: "created", Type: Timestamp},
+		Column{Name: "note", Type: Varchar, Length: 100},
+		Column{Name: "extra", Type: Integer, Nullable: true},
+		Column{Name: "last", Type: Varchar, Length: 4, Nullable: true},
+	)
+}
+
+func TestTuple(t *testing.T) {
+	schema := testSchema()
+	created := time.Date(2021, 3, 4, 5, 6, 7, 890123000, time.UTC)
+	values := []Value{
+		NewInteger(-42),
+		NewBigInt(math.MaxInt64),
+		NewDouble(3.25),
+		NewBoolean(true),
+		NewVarchar("héllo"),
+		NewTimestamp(created),
+		NewVarchar(""),
+		NewNull(Integer),
+		NewVarchar("end"),
+	}
+	tuple, err := NewTuple(schema, values)
+	require.Nil(t, err)
+	require.Equal(t, schema.fixedSize()+len("héllo")+len("end"), len(tuple.Data()))
+
+	decoded, err := DecodeTuple(schema, tuple.Data())
+	require.Nil(t, err)
+	require.Equal(t, values, decoded.Values(schema))
+	require.Equal(t, int32(-42), decoded.Value(schema, 0).AsInteger())
+	require.Equal(t, int64(math.MaxInt64), decoded.Value(schema, 1).AsBigInt())
+	require.Equal(t, 3.25, decoded.Value(schema, 2).AsDouble())
+	require.True(t, decoded.Value(schema, 3).AsBoolean())
+	require.Equal(t, "héllo", decoded.Value(schema, 4).AsVarchar())
+	require.Equal(t, created, decoded.Value(schema, 5).AsTimestamp())
+	require.True(t, decoded.IsNull(schema, 7))
+	require.False(t, decoded.IsNull(schema, 8))
+
+	// All nullable columns NULL.
+	values = []Value{
+		NewInteger(0), NewNull(BigInt), NewDouble(0), NewNull(Boolean), NewNull(Varchar),
+		NewTimestamp(time.Unix(-1, 500).UTC()), NewVarchar("x"), NewNull(Integer), NewNull(Varchar),
+	}
+	tuple, err = NewTuple(schema, values)
+	require.Nil(t, err)
+	decoded, err = DecodeTuple(schema, tuple.Data())
+	require.Nil(t, err)
+	require.Equal(t, values, decoded.Values(schema))
+	require.Equal(t, time.Unix(-1, 0).UTC(), decoded.Value(schema, 5).AsTimestamp())
+	require.Equal(t, "NULL", decoded.Value(schema, 1).String())
+}
+
+func TestTuple_Invalid(t *testing.T) {
+	schema := NewSchema(
+		Column{Name: "id", Type: Integer},
+		Column{Name: "name", Type: Varchar, Length: 3, Nullable: true},
+	)
+	_, err := NewTuple(schema, []Value{NewInteger(1)})
+	require.NotNil(t, err)
+	_, err = NewTuple(schema, []Value{NewBigInt(1), NewNull(Varchar)})
+	require.NotNil(t, err)
+	_, err = NewTuple(schema, []Value{NewNull(Integer), NewNull(Varchar)})
+	require.NotNil(t, err)
+	// The length of a VARCHAR counts characters, not bytes.
+	_, err = NewTuple(schema, []Value{NewInteger(1), NewVarchar("abcd")})
+	require.NotNil(t, err)
+	tuple, err := NewTuple(schema, []Value{NewInteger(1), NewVarchar("äöü")})
+	require.Nil(t, err)
+
+	_, err = DecodeTuple(schema, tuple.Data()[:schema.fixedSize()-1])
+	require.NotNil(t, err)
+	_, err = DecodeTuple(schema, tuple.Data()[:len(tuple.Data())-1])
+	require.NotNil(t, err)
+	_, err = DecodeTuple(schema, tuple.Data())
+	require.Nil(t, err)
+}
+
+func TestTuple_TableHeap(t *testing.T) {
+	bufferPoolManager := disk.NewBufferPoolManager(8, disk.NewMemoryPageStore(), disk.NewLRUReplacer())
+	tableHeap := table.NewTableHeap(bufferPoolManager)
+	txnManager := transaction.NewTransactionManager(nil, nil)
+	schema := testSchema()
+
+	txn := txnManager.Begin()
+	values := []Value{
+		NewInteger(7), NewBigInt(-7), NewDouble(0.5), NewBoolean(false), NewVarchar("name"),
+		NewTimestamp(time.Unix(1600000000, 123000).UTC()), NewVarchar("note"), NewInteger(1), NewNull(Varchar),
+	}
+	tuple, err := NewTuple(schema, values)
+	require.Nil(t, err)
+	rid, err := tableHeap.Insert(txn, tuple.Data())
+	require.Nil(t, err)
+	require.Nil(t, txnManager.Commit(txn))
+
+	txn = txnManager.Begin()
+	data, found, err := tableHeap.Get(txn, rid)
+	require.Nil(t, err)
+	require.True(t, found)
+	decoded, err := DecodeTuple(schema, data)
+	require.Nil(t, err)
+	require.Equal(t, values, decoded.Values(schema))
+	require.Nil(t, txnManager.Commit(txn))
+}
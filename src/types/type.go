@@ -0,0 +1,58 @@
+package types
+
+type TypeId uint8
+
+const (
+	InvalidType TypeId = iota
+	Integer            // 32-bit signed integer.
+	BigInt             // 64-bit signed integer.
+	Double             // 64-bit floating point number.
+	Boolean
+	Varchar   // Text of at most the length of its column, in characters.
+	Timestamp // Microseconds since the Unix epoch, in UTC.
+)
+
+func (t TypeId) String() string {
+	switch t {
+	case Integer:
+		return "INTEGER"
+	case BigInt:
+		return "BIGINT"
+	case Double:
+		return "DOUBLE"
+	case Boolean:
+		return "BOOLEAN"
+	case Varchar:
+		return "VARCHAR"
+	case Timestamp:
+		return "TIMESTAMP"
+	default:
+		return "INVALID"
+	}
+}
+
+func (t TypeId) IsValid() bool {
+	return t > InvalidType && t <= Timestamp
+}
+
+// Whether values of the type are numbers, which compare with each other.
+func (t TypeId) IsNumeric() bool {
+	return t == Integer || t == BigInt || t == Double
+}
+
+// Size of a value of the type in the fixed part of a tuple. A VARCHAR only
+// keeps the offset and the length of its text there.
+func (t TypeId) fixedSize() int {
+	switch t {
+	case Integer:
+		return 4
+	case BigInt, Double, Timestamp:
+		return 8
+	case Boolean:
+		return 1
+	case Varchar:
+		return 8
+	default:
+		return 0
+	}
+}
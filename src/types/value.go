@@ -0,0 +1,232 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const timestampFormat = "2006-01-02 15:04:05.999999"
+
+// Value is a single value of a column, or NULL.
+type Value struct {
+	typeId TypeId
+	null   bool
+	// INTEGER, BIGINT, BOOLEAN and TIMESTAMP values.
+	integer int64
+	float   float64
+	str     string
+}
+
+func NewInteger(v int32) Value {
+	return Value{typeId: Integer, integer: int64(v)}
+}
+
+func NewBigInt(v int64) Value {
+	return Value{typeId: BigInt, integer: v}
+}
+
+func NewDouble(v float64) Value {
+	return Value{typeId: Double, float: v}
+}
+
+func NewBoolean(v bool) Value {
+	value := Value{typeId: Boolean}
+	if v {
+		value.integer = 1
+	}
+	return value
+}
+
+func NewVarchar(v string) Value {
+	return Value{typeId: Varchar, str: v}
+}
+
+// The timestamp is kept with a precision of a microsecond.
+func NewTimestamp(v time.Time) Value {
+	return Value{typeId: Timestamp, integer: v.Unix()*1e6 + int64(v.Nanosecond()/1e3)}
+}
+
+// Parse a timestamp written like `Value.String` does, in UTC. The fraction of
+// the second, or the whole time of the day, may be left out.
+func ParseTimestamp(text string) (Value, error) {
+	for _, layout := range []string{timestampFormat, "2006-01-02"} {
+		if t, err := time.Parse(layout, text); err == nil {
+			return NewTimestamp(t), nil
+		}
+	}
+	return Value{}, fmt.Errorf("Invalid timestamp %q.", text)
+}
+
+func NewNull(typeId TypeId) Value {
+	return Value{typeId: typeId, null: true}
+}
+
+func (v Value) Type() TypeId {
+	return v.typeId
+}
+
+func (v Value) IsNull() bool {
+	return v.null
+}
+
+func (v Value) AsInteger() int32 {
+	return int32(v.integer)
+}
+
+func (v Value) AsBigInt() int64 {
+	return v.integer
+}
+
+func (v Value) AsDouble() float64 {
+	return v.float
+}
+
+func (v Value) AsBoolean() bool {
+	return v.integer != 0
+}
+
+func (v Value) AsVarchar() string {
+	return v.str
+}
+
+func (v Value) AsTimestamp() time.Time {
+	return time.Unix(v.integer/1e6, v.integer%1e6*1e3).UTC()
+}
+
+func (v Value) String() string {
+	if v.null {
+		return "NULL"
+	}
+	switch v.typeId {
+	case Integer, BigInt:
+		return strconv.FormatInt(v.integer, 10)
+	case Double:
+		return strconv.FormatFloat(v.float, 'g', -1, 64)
+	case Boolean:
+		return strconv.FormatBool(v.AsBoolean())
+	case Varchar:
+		return v.str
+	case Timestamp:
+		return v.AsTimestamp().Format(timestampFormat)
+	default:
+		return "INVALID"
+	}
+}
+
+// Convert the value to `typeId`. Numbers convert to each other, as long as
+// they are in range, with a DOUBLE rounded towards zero, and a VARCHAR to a
+// TIMESTAMP, see `ParseTimestamp`. NULL converts to anything.
+func (v Value) CastAs(typeId TypeId) (Value, error) {
+	switch {
+	case v.typeId == typeId:
+		return v, nil
+	case v.null:
+		return NewNull(typeId), nil
+	case v.typeId.IsNumeric() && typeId == Double:
+		return NewDouble(v.asFloat()), nil
+	case v.typeId.IsNumeric() && (typeId == Integer || typeId == BigInt):
+		n := v.integer
+		if v.typeId == Double {
+			if !(v.float >= math.MinInt64 && v.float < math.MaxInt64) {
+				return Value{}, fmt.Errorf("%s is out of the range of %s.", v, typeId)
+			}
+			n = int64(v.float)
+		}
+		if typeId == BigInt {
+			return NewBigInt(n), nil
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return Value{}, fmt.Errorf("%s is out of the range of %s.", v, typeId)
+		}
+		return NewInteger(int32(n)), nil
+	case v.typeId == Varchar && typeId == Timestamp:
+		return ParseTimestamp(v.str)
+	default:
+		return Value{}, fmt.Errorf("Cannot cast %s to %s.", v.typeId, typeId)
+	}
+}
+
+// Compare the value with `other`, returning -1, 0 or 1 if it is smaller,
+// equal or greater. Numbers of different types compare by value, and NULL is
+// smaller than everything else.
+func (v Value) Compare(other Value) (int, error) {
+	if !v.comparableWith(other) {
+		return 0, fmt.Errorf("Cannot compare %s with %s.", v.typeId, other.typeId)
+	}
+	switch {
+	case v.null && other.null:
+		return 0, nil
+	case v.null:
+		return -1, nil
+	case other.null:
+		return 1, nil
+	}
+	switch {
+	case v.typeId == Double || other.typeId == Double:
+		return compareFloats(v.asFloat(), other.asFloat()), nil
+	case v.typeId == Varchar:
+		return compareStrings(v.str, other.str), nil
+	default:
+		return compareIntegers(v.integer, other.integer), nil
+	}
+}
+
+// Whether the value equals `other`. Values that cannot be compared are not
+// equal.
+func (v Value) Equals(other Value) bool {
+	cmp, err := v.Compare(other)
+	return err == nil && cmp == 0
+}
+
+func (v Value) comparableWith(other Value) bool {
+	return v.typeId == other.typeId || (v.typeId.IsNumeric() && other.typeId.IsNumeric())
+}
+
+func (v Value) asFloat() float64 {
+	if v.typeId == Double {
+		return v.float
+	}
+	return float64(v.integer)
+}
+
+func compareIntegers(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// NaN is greater than every other number, and equal to itself.
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	case a == b:
+		return 0
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return 1
+	default:
+		return -1
+	}
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,91 @@
+package types
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValue_Compare(t *testing.T) {
+	ordered := [][]Value{
+		{NewNull(Integer), NewInteger(-1), NewInteger(0), NewBigInt(1), NewDouble(1.5), NewInteger(2), NewDouble(math.NaN())},
+		{NewNull(Varchar), NewVarchar(""), NewVarchar("a"), NewVarchar("ab"), NewVarchar("b")},
+		{NewBoolean(false), NewBoolean(true)},
+		{NewTimestamp(time.Unix(-1, 0)), NewTimestamp(time.Unix(0, 1000)), NewTimestamp(time.Unix(1, 0))},
+	}
+	for _, values := range ordered {
+		for i, a := range values {
+			for j, b := range values {
+				cmp, err := a.Compare(b)
+				require.Nil(t, err)
+				require.Equal(t, compareIntegers(int64(i), int64(j)), cmp, "%v %v", a, b)
+			}
+		}
+	}
+
+	require.True(t, NewInteger(3).Equals(NewDouble(3)))
+	require.True(t, NewNull(Double).Equals(NewNull(BigInt)))
+	require.False(t, NewInteger(3).Equals(NewVarchar("3")))
+	_, err := NewBoolean(true).Compare(NewInteger(1))
+	require.NotNil(t, err)
+	_, err = NewNull(Varchar).Compare(NewNull(Integer))
+	require.NotNil(t, err)
+}
+
+func TestValue_String(t *testing.T) {
+	require.Equal(t, "-5", NewInteger(-5).String())
+	require.Equal(t, "0.1", NewDouble(0.1).String())
+	require.Equal(t, "true", NewBoolean(true).String())
+	require.Equal(t, "text", NewVarchar("text").String())
+	require.Equal(t, "NULL", NewNull(Varchar).String())
+	require.Equal(t, "2020-01-02 03:04:05.000006", NewTimestamp(time.Date(2020, 1, 2, 3, 4, 5, 6789, time.UTC)).String())
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for text, expected := range map[string]time.Time{
+		"2020-01-02 03:04:05.000006": time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC),
+		"2020-01-02 03:04:05":        time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		"2020-01-02":                 time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+	} {
+		value, err := ParseTimestamp(text)
+		require.Nil(t, err)
+		require.Equal(t, expected, value.AsTimestamp())
+	}
+	for _, text := range []string{"", "2020-13-01", "2020-01-02 03:04", "yesterday"} {
+		_, err := ParseTimestamp(text)
+		require.NotNil(t, err)
+	}
+}
+
+func TestValue_CastAs(t *testing.T) {
+	for _, cast := range []struct {
+		value    Value
+		typeId   TypeId
+		expected Value
+	}{
+		{NewInteger(-3), BigInt, NewBigInt(-3)},
+		{NewInteger(-3), Double, NewDouble(-3)},
+		{NewBigInt(math.MaxInt32), Integer, NewInteger(math.MaxInt32)},
+		{NewDouble(-2.75), Integer, NewInteger(-2)},
+		{NewDouble(1e18), BigInt, NewBigInt(1e18)},
+		{NewVarchar("2020-01-02"), Timestamp, NewTimestamp(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))},
+		{NewNull(InvalidType), Varchar, NewNull(Varchar)},
+		{NewBoolean(true), Boolean, NewBoolean(true)},
+	} {
+		value, err := cast.value.CastAs(cast.typeId)
+		require.Nil(t, err, "%v %v", cast.value, cast.typeId)
+		require.Equal(t, cast.expected, value)
+	}
+	for _, value := range []Value{NewBigInt(math.MaxInt32 + 1), NewDouble(math.NaN()), NewDouble(-3e9)} {
+		_, err := value.CastAs(Integer)
+		require.NotNil(t, err, "%v", value)
+	}
+	_, err := NewDouble(math.Inf(1)).CastAs(BigInt)
+	require.NotNil(t, err)
+	_, err = NewBoolean(true).CastAs(Integer)
+	require.NotNil(t, err)
+	_, err = NewVarchar("x").CastAs(Timestamp)
+	require.NotNil(t, err)
+}
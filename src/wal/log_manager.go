@@ -0,0 +1,619 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"simple-db-golang/src/common"
+)
+
+const (
+	logFileMagic    = uint32(0x4c415753) // "SWAL"
+	masterFileMagic = uint32(0x4d415753) // "SWAM"
+	logFileVersion  = uint32(1)
+
+	// | magic (4) | version (4) | base LSN (8) |
+	logFileHeaderSize = 4 + 4 + 8
+
+	// | magic (4) | version (4) | checkpoint LSN (8) |
+	masterFileSize = 4 + 4 + 8
+
+	// Anything larger is garbage rather than a record.
+	maxLogRecordSize = 1 << 26
+
+	DefaultSegmentSize = int64(16 << 20)
+
+	firstLSN = common.LSN(1)
+)
+
+// LogManager owns the write-ahead log. Log records are appended to an
+// in-memory buffer and only become durable once `Flush` covers them.
+//
+// The LSN of a record is its offset in the log stream, so that a record can
+// be located directly from its LSN. The stream is split into segment files
+// named `<fileName>.<base LSN>`, each holding the records from its base LSN
+// up to the base LSN of the next segment, so that old records can be dropped
+// with `Truncate` once a checkpoint makes them unnecessary. The file
+// `<fileName>` itself is the master record, which stores the LSN of the last
+// checkpoint.
+type LogManager struct {
+	fileName    string
+	segmentSize int64
+	segments    []*logSegment // Ordered by base LSN, the last one is appended to.
+
+	buffer        []byte
+	bufferLSN     common.LSN // LSN of the first record in `buffer`.
+	nextLSN       common.LSN
+	lastLSN       common.LSN
+	persistentLSN common.LSN
+	checkpointLSN common.LSN
+	nextTxnId     common.TxnId
+	activeTxns    map[common.TxnId]*activeTxn
+	mu            sync.Mutex
+}
+
+type logSegment struct {
+	fileName string
+	baseLSN  common.LSN
+	fi       *os.File
+}
+
+// First and last LSN of a transaction which has neither committed nor aborted.
+type activeTxn struct {
+	firstLSN common.LSN
+	lastLSN  common.LSN
+}
+
+func NewLogManager(fileName string) *LogManager {
+	lm := &LogManager{
+		fileName:      fileName,
+		segmentSize:   DefaultSegmentSize,
+		lastLSN:       common.InvalidLSN,
+		persistentLSN: common.InvalidLSN,
+		checkpointLSN: common.InvalidLSN,
+		activeTxns:    make(map[common.TxnId]*activeTxn),
+	}
+	if err := lm.readMasterRecord(); err != nil {
+		log.WithError(err).Fatalf("Read log master record failed.")
+	}
+	if err := lm.openSegments(); err != nil {
+		log.WithError(err).Fatalf("Cannot open log file.")
+	}
+	if len(lm.segments) == 0 {
+		if err := lm.writeMasterRecord(); err != nil {
+			log.WithError(err).Fatalf("Write log master record failed.")
+		}
+		if err := lm.createSegment(firstLSN); err != nil {
+			log.WithError(err).Fatalf("Cannot create log file.")
+		}
+		lm.nextLSN = firstLSN
+	} else if err := lm.recoverTail(); err != nil {
+		log.WithError(err).Fatalf("Scan log file failed.")
+	}
+	lm.bufferLSN = lm.nextLSN
+	return lm
+}
+
+// Remove the master record and all segments of the log named `fileName`.
+func RemoveLogFiles(fileName string) error {
+	segmentFileNames, err := filepath.Glob(fileName + ".*")
+	if err != nil {
+		return err
+	}
+	for _, segmentFileName := range segmentFileNames {
+		if err := os.Remove(segmentFileName); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (lm *LogManager) Close() error {
+	if err := lm.Flush(lm.LastLSN()); err != nil {
+		return err
+	}
+	for _, segment := range lm.segments {
+		if err := segment.fi.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set the size after which a new segment is started. Records never span
+// segments, so a segment may be larger if a single record is.
+func (lm *LogManager) SetSegmentSize(size int64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.segmentSize = size
+}
+
+// Append a record to the log, and return the LSN assigned to it. The record
+// is not durable until `Flush` is called with an LSN no less than the returned one.
+func (lm *LogManager) AppendLogRecord(record *LogRecord) common.LSN {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.appendLogRecord(record)
+}
+
+// Append an end checkpoint record with the given dirty page table. The active
+// transactions and the next transaction id are filled in atomically with the
+// append, so that they are consistent with the records around it.
+func (lm *LogManager) AppendCheckpointRecord(dirtyPages map[common.PageId]common.LSN) common.LSN {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	record := &LogRecord{
+		PrevLSN:    common.InvalidLSN,
+		TxnId:      common.InvalidTxnId,
+		Type:       EndCheckpointRecord,
+		DirtyPages: dirtyPages,
+		ActiveTxns: make(map[common.TxnId]common.LSN),
+		NextTxnId:  lm.nextTxnId,
+	}
+	for txnId, txn := range lm.activeTxns {
+		record.ActiveTxns[txnId] = txn.lastLSN
+	}
+	return lm.appendLogRecord(record)
+}
+
+func (lm *LogManager) appendLogRecord(record *LogRecord) common.LSN {
+	if err := lm.maybeStartSegment(record.size()); err != nil {
+		log.WithError(err).Fatalf("Cannot start a new log segment.")
+	}
+	record.LSN = lm.nextLSN
+	lm.buffer = record.encode(lm.buffer)
+	lm.lastLSN = record.LSN
+	lm.nextLSN += common.LSN(record.size())
+
+	switch {
+	case record.TxnId == common.InvalidTxnId:
+	case record.Type == CommitRecord || record.Type == AbortRecord:
+		delete(lm.activeTxns, record.TxnId)
+	default:
+		if txn, ok := lm.activeTxns[record.TxnId]; ok {
+			txn.lastLSN = record.LSN
+		} else {
+			lm.activeTxns[record.TxnId] = &activeTxn{firstLSN: record.LSN, lastLSN: record.LSN}
+		}
+	}
+	return record.LSN
+}
+
+// Make sure all records whose LSN is no greater than `lsn` are on disk.
+func (lm *LogManager) Flush(lsn common.LSN) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lsn <= lm.persistentLSN {
+		return nil
+	}
+	return lm.flushBuffer()
+}
+
+func (lm *LogManager) flushBuffer() error {
+	if len(lm.buffer) == 0 {
+		return nil
+	}
+	segment := lm.activeSegment()
+	if _, err := segment.fi.WriteAt(lm.buffer, segment.fileOffset(lm.bufferLSN)); err != nil {
+		return err
+	}
+	if err := segment.fi.Sync(); err != nil {
+		return err
+	}
+	lm.buffer = lm.buffer[:0]
+	lm.bufferLSN = lm.nextLSN
+	lm.persistentLSN = lm.lastLSN
+	return nil
+}
+
+// Start a new segment if a record of `size` bytes does not fit into the
+// current one. The current segment is flushed first, so that only the last
+// segment can ever have a torn tail.
+func (lm *LogManager) maybeStartSegment(size int) error {
+	used := int64(lm.nextLSN - lm.activeSegment().baseLSN)
+	if used == 0 || used+int64(size) <= lm.segmentSize {
+		return nil
+	}
+	if err := lm.flushBuffer(); err != nil {
+		return err
+	}
+	return lm.createSegment(lm.nextLSN)
+}
+
+// Read the record at `lsn`, which may or may not have been flushed yet.
+func (lm *LogManager) ReadLogRecord(lsn common.LSN) (*LogRecord, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lsn < lm.segments[0].baseLSN || lsn >= lm.nextLSN {
+		return nil, fmt.Errorf("Log record %d is not in the log.", lsn)
+	}
+	var data []byte
+	if lsn >= lm.bufferLSN {
+		data = lm.buffer[lsn-lm.bufferLSN:]
+	} else {
+		segment := lm.findSegment(lsn)
+		sizeBuf := make([]byte, 4)
+		if _, err := segment.fi.ReadAt(sizeBuf, segment.fileOffset(lsn)); err != nil {
+			return nil, err
+		}
+		size := binary.LittleEndian.Uint32(sizeBuf)
+		if size > maxLogRecordSize {
+			return nil, fmt.Errorf("Log record %d is corrupted.", lsn)
+		}
+		data = make([]byte, size)
+		if _, err := segment.fi.ReadAt(data, segment.fileOffset(lsn)); err != nil {
+			return nil, err
+		}
+	}
+	record, _, err := decodeLogRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	if record.LSN != lsn {
+		return nil, fmt.Errorf("Log record %d is corrupted.", lsn)
+	}
+	return record, nil
+}
+
+// Allocate an id for a new transaction. Ids are never reused within a log.
+func (lm *LogManager) NextTxnId() common.TxnId {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	txnId := lm.nextTxnId
+	lm.nextTxnId++
+	return txnId
+}
+
+// LSN of the last appended record, or `common.InvalidLSN` if the log is empty.
+func (lm *LogManager) LastLSN() common.LSN {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.lastLSN
+}
+
+// LSN that the next appended record will get.
+func (lm *LogManager) NextLSN() common.LSN {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.nextLSN
+}
+
+// LSN of the last durable record, or `common.InvalidLSN` if there is none.
+func (lm *LogManager) PersistentLSN() common.LSN {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.persistentLSN
+}
+
+// LSN of the begin checkpoint record of the last complete checkpoint, or
+// `common.InvalidLSN` if there is none.
+func (lm *LogManager) CheckpointLSN() common.LSN {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.checkpointLSN
+}
+
+// Make the checkpoint beginning at `lsn` the one recovery starts from. The
+// checkpoint must have been flushed.
+func (lm *LogManager) SetCheckpointLSN(lsn common.LSN) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lsn > lm.persistentLSN {
+		return fmt.Errorf("Checkpoint %d is not flushed.", lsn)
+	}
+	old := lm.checkpointLSN
+	lm.checkpointLSN = lsn
+	if err := lm.writeMasterRecord(); err != nil {
+		lm.checkpointLSN = old
+		return err
+	}
+	return nil
+}
+
+// The first LSN of the oldest transaction which has neither committed nor
+// aborted, or `common.InvalidLSN` if there is none. Rolling it back may need
+// any record from there on.
+func (lm *LogManager) MinActiveTxnLSN() common.LSN {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	minLSN := common.InvalidLSN
+	for _, txn := range lm.activeTxns {
+		if minLSN == common.InvalidLSN || txn.firstLSN < minLSN {
+			minLSN = txn.firstLSN
+		}
+	}
+	return minLSN
+}
+
+// Delete the segments that only hold records older than `lsn`. The segment
+// being appended to is never deleted.
+func (lm *LogManager) Truncate(lsn common.LSN) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for len(lm.segments) > 1 && lm.segments[1].baseLSN <= lsn {
+		segment := lm.segments[0]
+		if err := segment.fi.Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(segment.fileName); err != nil {
+			return err
+		}
+		lm.segments = lm.segments[1:]
+	}
+	return nil
+}
+
+// Number of segment files of the log.
+func (lm *LogManager) NumSegments() int {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return len(lm.segments)
+}
+
+// Iterate over the durable records, starting from the first one whose LSN is
+// no less than `from`. Records that have not been flushed are not visible.
+func (lm *LogManager) Iterator(from common.LSN) (*LogIterator, error) {
+	lm.mu.Lock()
+	it := &LogIterator{endLSN: lm.bufferLSN}
+	for _, segment := range lm.segments {
+		if len(it.segments) > 0 && segment.baseLSN <= from {
+			it.segments = it.segments[:0]
+		}
+		it.segments = append(it.segments, logSegment{fileName: segment.fileName, baseLSN: segment.baseLSN})
+	}
+	lm.mu.Unlock()
+
+	if err := it.openSegment(); err != nil {
+		return nil, err
+	}
+	for it.nextLSN < from {
+		if _, ok := it.Next(); !ok {
+			break
+		}
+	}
+	return it, nil
+}
+
+func (lm *LogManager) activeSegment() *logSegment {
+	return lm.segments[len(lm.segments)-1]
+}
+
+func (lm *LogManager) findSegment(lsn common.LSN) *logSegment {
+	i := sort.Search(len(lm.segments), func(i int) bool {
+		return lm.segments[i].baseLSN > lsn
+	})
+	return lm.segments[i-1]
+}
+
+func (lm *LogManager) createSegment(baseLSN common.LSN) error {
+	fileName := fmt.Sprintf("%s.%016x", lm.fileName, uint64(baseLSN))
+	fi, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, logFileHeaderSize)
+	binary.LittleEndian.PutUint32(buf[0:], logFileMagic)
+	binary.LittleEndian.PutUint32(buf[4:], logFileVersion)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(baseLSN))
+	if _, err := fi.WriteAt(buf, 0); err != nil {
+		fi.Close()
+		return err
+	}
+	if err := fi.Sync(); err != nil {
+		fi.Close()
+		return err
+	}
+	lm.segments = append(lm.segments, &logSegment{fileName: fileName, baseLSN: baseLSN, fi: fi})
+	return nil
+}
+
+func (lm *LogManager) openSegments() error {
+	segmentFileNames, err := filepath.Glob(lm.fileName + ".*")
+	if err != nil {
+		return err
+	}
+	// The base LSNs are zero padded, so the names sort by LSN.
+	sort.Strings(segmentFileNames)
+	for _, segmentFileName := range segmentFileNames {
+		fi, err := os.OpenFile(segmentFileName, os.O_RDWR, 0644)
+		if err != nil {
+			return err
+		}
+		buf := make([]byte, logFileHeaderSize)
+		if _, err := fi.ReadAt(buf, 0); err != nil {
+			fi.Close()
+			return err
+		}
+		if binary.LittleEndian.Uint32(buf[0:]) != logFileMagic {
+			fi.Close()
+			return fmt.Errorf("%s is not a log file.", segmentFileName)
+		}
+		if version := binary.LittleEndian.Uint32(buf[4:]); version != logFileVersion {
+			fi.Close()
+			return fmt.Errorf("Unknown log file version %d.", version)
+		}
+		lm.segments = append(lm.segments, &logSegment{
+			fileName: segmentFileName,
+			baseLSN:  common.LSN(binary.LittleEndian.Uint64(buf[8:])),
+			fi:       fi,
+		})
+	}
+	return nil
+}
+
+func (lm *LogManager) readMasterRecord() error {
+	fi, err := os.Open(lm.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer fi.Close()
+	buf := make([]byte, masterFileSize)
+	if _, err := io.ReadFull(fi, buf); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(buf[0:]) != masterFileMagic {
+		return fmt.Errorf("%s is not a log master record.", lm.fileName)
+	}
+	if version := binary.LittleEndian.Uint32(buf[4:]); version != logFileVersion {
+		return fmt.Errorf("Unknown log file version %d.", version)
+	}
+	lm.checkpointLSN = common.LSN(binary.LittleEndian.Uint64(buf[8:]))
+	return nil
+}
+
+// Replace the master record atomically, by writing a new one aside and
+// renaming it over the old one.
+func (lm *LogManager) writeMasterRecord() error {
+	buf := make([]byte, masterFileSize)
+	binary.LittleEndian.PutUint32(buf[0:], masterFileMagic)
+	binary.LittleEndian.PutUint32(buf[4:], logFileVersion)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(lm.checkpointLSN))
+
+	tmpFileName := lm.fileName + "-tmp"
+	fi, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fi.Write(buf); err != nil {
+		fi.Close()
+		return err
+	}
+	if err := fi.Sync(); err != nil {
+		fi.Close()
+		return err
+	}
+	if err := fi.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, lm.fileName)
+}
+
+// Find the end of the valid records, and cut off anything after it, e.g. a
+// record which was only partially written before a crash. Only the records
+// from the last checkpoint on are scanned.
+func (lm *LogManager) recoverTail() error {
+	from := lm.segments[0].baseLSN
+	if lm.checkpointLSN != common.InvalidLSN {
+		from = lm.checkpointLSN
+	}
+	lm.bufferLSN = common.LSN(1<<63 - 1)
+	it, err := lm.Iterator(from)
+	if err != nil {
+		return err
+	}
+	for {
+		record, ok := it.Next()
+		if !ok {
+			break
+		}
+		lm.lastLSN = record.LSN
+		if record.TxnId >= lm.nextTxnId {
+			lm.nextTxnId = record.TxnId + 1
+		}
+		if record.Type == EndCheckpointRecord && record.NextTxnId > lm.nextTxnId {
+			lm.nextTxnId = record.NextTxnId
+		}
+	}
+	it.Close()
+	lm.nextLSN = it.nextLSN
+	lm.persistentLSN = lm.lastLSN
+
+	for len(lm.segments) > 1 && lm.activeSegment().baseLSN > lm.nextLSN {
+		segment := lm.activeSegment()
+		segment.fi.Close()
+		if err := os.Remove(segment.fileName); err != nil {
+			return err
+		}
+		lm.segments = lm.segments[:len(lm.segments)-1]
+	}
+	segment := lm.activeSegment()
+	return segment.fi.Truncate(segment.fileOffset(lm.nextLSN))
+}
+
+func (segment *logSegment) fileOffset(lsn common.LSN) int64 {
+	return int64(lsn-segment.baseLSN) + logFileHeaderSize
+}
+
+type LogIterator struct {
+	segments []logSegment // The segment being read, followed by the later ones.
+	fi       *os.File
+	reader   *bufio.Reader
+	nextLSN  common.LSN
+	endLSN   common.LSN
+}
+
+func (it *LogIterator) openSegment() error {
+	segment := it.segments[0]
+	fi, err := os.Open(segment.fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := fi.Seek(segment.fileOffset(segment.baseLSN), io.SeekStart); err != nil {
+		fi.Close()
+		return err
+	}
+	it.fi = fi
+	it.reader = bufio.NewReader(fi)
+	it.nextLSN = segment.baseLSN
+	return nil
+}
+
+// Return the next record, or false if there are no more valid records.
+func (it *LogIterator) Next() (*LogRecord, bool) {
+	if it.fi == nil || it.nextLSN >= it.endLSN {
+		return nil, false
+	}
+	if len(it.segments) > 1 && it.nextLSN == it.segments[1].baseLSN {
+		it.fi.Close()
+		it.fi = nil
+		it.segments = it.segments[1:]
+		if err := it.openSegment(); err != nil {
+			return nil, false
+		}
+	}
+	sizeBuf, err := it.reader.Peek(4)
+	if err != nil {
+		return nil, false
+	}
+	size := int(binary.LittleEndian.Uint32(sizeBuf))
+	if size < logRecordHeaderSize || size > maxLogRecordSize {
+		return nil, false
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(it.reader, data); err != nil {
+		return nil, false
+	}
+	record, _, err := decodeLogRecord(data)
+	if err != nil || record.LSN != it.nextLSN {
+		return nil, false
+	}
+	it.nextLSN += common.LSN(size)
+	return record, true
+}
+
+func (it *LogIterator) Close() error {
+	if it.fi == nil {
+		return nil
+	}
+	return it.fi.Close()
+}
@@ -0,0 +1,280 @@
+package wal
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"simple-db-golang/src/common"
+)
+
+var testLogFileName = "tmp-log"
+
+var randomLogRecordTypes = []LogRecordType{
+	BeginRecord, CommitRecord, AbortRecord, InsertRecord, DeleteRecord, NewPageRecord,
+	UndoInsertRecord, UndoDeleteRecord, MarkDeleteRecord, UndoMarkDeleteRecord,
+	UpdateRecord, UndoUpdateRecord, OverflowPageRecord, FreePageRecord, PageWriteRecord,
+}
+
+func randomLogRecord() *LogRecord {
+	record := &LogRecord{
+		PrevLSN: common.LSN(rand.Intn(1<<20)) - 1,
+		TxnId:   common.TxnId(rand.Intn(100)),
+		Type:    randomLogRecordTypes[rand.Intn(len(randomLogRecordTypes))],
+	}
+	switch record.Type {
+	case InsertRecord, DeleteRecord, UndoInsertRecord, UndoDeleteRecord, MarkDeleteRecord, UndoMarkDeleteRecord:
+		record.RID = common.RID{PageId: common.PageId(rand.Intn(100)), SlotNum: rand.Intn(100)}
+		record.Data = make([]byte, rand.Intn(512)+1)
+		rand.Read(record.Data)
+		if record.Type.IsCompensation() {
+			record.UndoNextLSN = common.LSN(rand.Intn(1<<20)) - 1
+		}
+	case UpdateRecord, UndoUpdateRecord:
+		record.RID = common.RID{PageId: common.PageId(rand.Intn(100)), SlotNum: rand.Intn(100)}
+		record.Data = make([]byte, rand.Intn(512)+1)
+		rand.Read(record.Data)
+		record.OldData = make([]byte, rand.Intn(512)+1)
+		rand.Read(record.OldData)
+		if record.Type.IsCompensation() {
+			record.UndoNextLSN = common.LSN(rand.Intn(1<<20)) - 1
+		}
+	case NewPageRecord:
+		record.PageId = common.PageId(rand.Intn(100))
+		record.HeaderPageId = common.PageId(1)
+	case OverflowPageRecord:
+		record.PageId = common.PageId(rand.Intn(100))
+		record.Data = make([]byte, rand.Intn(4096)+1)
+		rand.Read(record.Data)
+	case FreePageRecord:
+		record.PageId = common.PageId(rand.Intn(100))
+	case PageWriteRecord:
+		record.PageId = common.PageId(rand.Intn(100))
+		record.Offset = rand.Intn(4096)
+		record.Data = make([]byte, rand.Intn(512)+1)
+		rand.Read(record.Data)
+	}
+	return record
+}
+
+func readAllRecords(t *testing.T, lm *LogManager, from common.LSN) []*LogRecord {
+	it, err := lm.Iterator(from)
+	require.Nil(t, err)
+	defer it.Close()
+	records := make([]*LogRecord, 0)
+	for {
+		record, ok := it.Next()
+		if !ok {
+			break
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLogRecord_EncodeDecode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		record := randomLogRecord()
+		record.LSN = common.LSN(rand.Intn(1 << 20))
+		buf := record.encode(nil)
+		require.Equal(t, record.size(), len(buf))
+
+		decoded, n, err := decodeLogRecord(buf)
+		require.Nil(t, err)
+		require.Equal(t, len(buf), n)
+		require.Equal(t, record, decoded)
+
+		buf[len(buf)-1] ^= 0xff
+		_, _, err = decodeLogRecord(buf)
+		require.NotNil(t, err)
+	}
+}
+
+func TestLogManager_AppendFlush(t *testing.T) {
+	defer RemoveLogFiles(testLogFileName)
+	lm := NewLogManager(testLogFileName)
+
+	require.Equal(t, common.InvalidLSN, lm.LastLSN())
+	require.Equal(t, common.InvalidLSN, lm.PersistentLSN())
+
+	records := make([]*LogRecord, 0)
+	prevLSN := common.InvalidLSN
+	for i := 0; i < 100; i++ {
+		record := randomLogRecord()
+		lsn := lm.AppendLogRecord(record)
+		require.True(t, lsn > prevLSN)
+		require.Equal(t, lsn, record.LSN)
+		prevLSN = lsn
+		records = append(records, record)
+	}
+	require.Equal(t, prevLSN, lm.LastLSN())
+	require.Equal(t, common.InvalidLSN, lm.PersistentLSN())
+	require.Equal(t, 0, len(readAllRecords(t, lm, firstLSN))) // Nothing is flushed yet.
+
+	require.Nil(t, lm.Flush(records[49].LSN))
+	require.Equal(t, prevLSN, lm.PersistentLSN())
+	require.Equal(t, records, readAllRecords(t, lm, firstLSN))
+	require.Equal(t, records[50:], readAllRecords(t, lm, records[50].LSN))
+	require.Nil(t, lm.Close())
+
+	// Reopen the log and continue appending.
+	lm = NewLogManager(testLogFileName)
+	require.Equal(t, prevLSN, lm.LastLSN())
+	require.Equal(t, prevLSN, lm.PersistentLSN())
+	record := randomLogRecord()
+	lsn := lm.AppendLogRecord(record)
+	require.True(t, lsn > prevLSN)
+	records = append(records, record)
+	require.Nil(t, lm.Close())
+
+	lm = NewLogManager(testLogFileName)
+	defer lm.Close()
+	require.Equal(t, records, readAllRecords(t, lm, firstLSN))
+}
+
+func TestLogManager_TornTail(t *testing.T) {
+	defer RemoveLogFiles(testLogFileName)
+	lm := NewLogManager(testLogFileName)
+
+	records := make([]*LogRecord, 0)
+	for i := 0; i < 10; i++ {
+		record := randomLogRecord()
+		lm.AppendLogRecord(record)
+		records = append(records, record)
+	}
+	segmentFileName := lm.activeSegment().fileName
+	require.Nil(t, lm.Close())
+
+	// Only a part of the last record reaches the disk.
+	stat, _ := os.Stat(segmentFileName)
+	os.Truncate(segmentFileName, stat.Size()-3)
+
+	lm = NewLogManager(testLogFileName)
+	require.Equal(t, records[:9], readAllRecords(t, lm, firstLSN))
+	require.Equal(t, records[8].LSN, lm.LastLSN())
+
+	// The torn record is overwritten by new records.
+	record := randomLogRecord()
+	lm.AppendLogRecord(record)
+	require.Equal(t, records[9].LSN, record.LSN)
+	require.Nil(t, lm.Close())
+
+	lm = NewLogManager(testLogFileName)
+	defer lm.Close()
+	require.Equal(t, append(records[:9], record), readAllRecords(t, lm, firstLSN))
+}
+
+func TestLogManager_ReadLogRecord(t *testing.T) {
+	defer RemoveLogFiles(testLogFileName)
+	lm := NewLogManager(testLogFileName)
+
+	records := make([]*LogRecord, 0)
+	maxTxnId := common.TxnId(0)
+	for i := 0; i < 20; i++ {
+		record := randomLogRecord()
+		lm.AppendLogRecord(record)
+		records = append(records, record)
+		if record.TxnId > maxTxnId {
+			maxTxnId = record.TxnId
+		}
+		if i == 9 {
+			lm.Flush(record.LSN)
+		}
+	}
+	// Both flushed and buffered records can be read.
+	for _, record := range records {
+		read, err := lm.ReadLogRecord(record.LSN)
+		require.Nil(t, err)
+		require.Equal(t, record, read)
+	}
+	_, err := lm.ReadLogRecord(records[3].LSN + 1)
+	require.NotNil(t, err)
+	_, err = lm.ReadLogRecord(lm.LastLSN() + 1000)
+	require.NotNil(t, err)
+	require.Nil(t, lm.Close())
+
+	// Transaction ids are not reused after reopening the log.
+	lm = NewLogManager(testLogFileName)
+	defer lm.Close()
+	require.Equal(t, maxTxnId+1, lm.NextTxnId())
+	require.Equal(t, maxTxnId+2, lm.NextTxnId())
+}
+
+func TestLogManager_SegmentsAndTruncate(t *testing.T) {
+	defer RemoveLogFiles(testLogFileName)
+	lm := NewLogManager(testLogFileName)
+	lm.SetSegmentSize(4096)
+
+	records := make([]*LogRecord, 0)
+	for i := 0; i < 100; i++ {
+		record := randomLogRecord()
+		lm.AppendLogRecord(record)
+		records = append(records, record)
+	}
+	require.Nil(t, lm.Flush(lm.LastLSN()))
+	numSegments := lm.NumSegments()
+	require.True(t, numSegments > 1)
+	require.Equal(t, records, readAllRecords(t, lm, firstLSN))
+	require.Equal(t, records[60:], readAllRecords(t, lm, records[60].LSN))
+
+	// Only the segments entirely before the LSN are deleted.
+	require.Nil(t, lm.Truncate(records[50].LSN))
+	require.True(t, lm.NumSegments() < numSegments)
+	remaining := readAllRecords(t, lm, common.InvalidLSN)
+	require.True(t, remaining[0].LSN <= records[50].LSN)
+	require.Equal(t, records[len(records)-len(remaining):], remaining)
+	_, err := lm.ReadLogRecord(records[0].LSN)
+	require.NotNil(t, err)
+	read, err := lm.ReadLogRecord(records[50].LSN)
+	require.Nil(t, err)
+	require.Equal(t, records[50], read)
+
+	// The segment being appended to is kept.
+	require.Nil(t, lm.Truncate(lm.NextLSN()))
+	require.Equal(t, 1, lm.NumSegments())
+	require.Nil(t, lm.Close())
+
+	lm = NewLogManager(testLogFileName)
+	defer lm.Close()
+	require.Equal(t, records[99].LSN, lm.LastLSN())
+	record := randomLogRecord()
+	require.True(t, lm.AppendLogRecord(record) > records[99].LSN)
+}
+
+func TestLogManager_Checkpoint(t *testing.T) {
+	defer RemoveLogFiles(testLogFileName)
+	lm := NewLogManager(testLogFileName)
+	require.Equal(t, common.InvalidLSN, lm.CheckpointLSN())
+
+	txnIds := []common.TxnId{lm.NextTxnId(), lm.NextTxnId(), lm.NextTxnId()}
+	lm.AppendLogRecord(&LogRecord{PrevLSN: common.InvalidLSN, TxnId: txnIds[0], Type: BeginRecord})
+	secondLSN := lm.AppendLogRecord(&LogRecord{PrevLSN: common.InvalidLSN, TxnId: txnIds[1], Type: BeginRecord})
+	lastLSN := lm.AppendLogRecord(&LogRecord{PrevLSN: secondLSN, TxnId: txnIds[1], Type: InsertRecord, Data: []byte("x")})
+	lm.AppendLogRecord(&LogRecord{PrevLSN: common.InvalidLSN, TxnId: txnIds[0], Type: CommitRecord})
+	require.Equal(t, secondLSN, lm.MinActiveTxnLSN())
+
+	beginLSN := lm.AppendLogRecord(&LogRecord{PrevLSN: common.InvalidLSN, TxnId: common.InvalidTxnId, Type: BeginCheckpointRecord})
+	dirtyPages := map[common.PageId]common.LSN{3: secondLSN}
+	endLSN := lm.AppendCheckpointRecord(dirtyPages)
+	require.NotNil(t, lm.SetCheckpointLSN(beginLSN)) // Not flushed yet.
+	require.Nil(t, lm.Flush(endLSN))
+	require.Nil(t, lm.SetCheckpointLSN(beginLSN))
+
+	record, err := lm.ReadLogRecord(endLSN)
+	require.Nil(t, err)
+	require.Equal(t, EndCheckpointRecord, record.Type)
+	require.Equal(t, dirtyPages, record.DirtyPages)
+	require.Equal(t, map[common.TxnId]common.LSN{txnIds[1]: lastLSN}, record.ActiveTxns)
+	require.Equal(t, txnIds[2]+1, record.NextTxnId)
+	require.Nil(t, lm.Close())
+
+	// The checkpoint survives reopening, and transaction ids allocated before
+	// it are not reused even though no record of them follows it.
+	lm = NewLogManager(testLogFileName)
+	defer lm.Close()
+	require.Equal(t, beginLSN, lm.CheckpointLSN())
+	require.Equal(t, endLSN, lm.LastLSN())
+	require.Equal(t, txnIds[2]+1, lm.NextTxnId())
+}
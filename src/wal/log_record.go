@@ -0,0 +1,379 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"simple-db-golang/src/common"
+)
+
+type LogRecordType uint8
+
+const (
+	InvalidRecord         LogRecordType = iota
+	BeginRecord                         // A transaction begins.
+	CommitRecord                        // A transaction commits.
+	AbortRecord                         // A transaction has been completely rolled back.
+	InsertRecord                        // A record is inserted into a table page.
+	DeleteRecord                        // A record is deleted from a table page.
+	NewPageRecord                       // A table page is allocated and appended to a table heap.
+	UndoInsertRecord                    // Compensation of an InsertRecord: the record is removed again.
+	UndoDeleteRecord                    // Compensation of a DeleteRecord: the record is restored.
+	BeginCheckpointRecord               // A fuzzy checkpoint begins.
+	EndCheckpointRecord                 // A fuzzy checkpoint ends, with the dirty pages and active transactions.
+	MarkDeleteRecord                    // A record is marked as deleted, it is removed once the transaction commits.
+	UndoMarkDeleteRecord                // Compensation of a MarkDeleteRecord: the mark is cleared.
+	UpdateRecord                        // A record is rewritten in place.
+	UndoUpdateRecord                    // Compensation of an UpdateRecord: the old record is written back.
+	OverflowPageRecord                  // An overflow page is written with a piece of a large record.
+	FreePageRecord                      // A page is given back to the page store.
+	PageWriteRecord                     // Bytes are written at some offset of a page.
+)
+
+func (t LogRecordType) String() string {
+	switch t {
+	case BeginRecord:
+		return "Begin"
+	case CommitRecord:
+		return "Commit"
+	case AbortRecord:
+		return "Abort"
+	case InsertRecord:
+		return "Insert"
+	case DeleteRecord:
+		return "Delete"
+	case NewPageRecord:
+		return "NewPage"
+	case UndoInsertRecord:
+		return "UndoInsert"
+	case UndoDeleteRecord:
+		return "UndoDelete"
+	case BeginCheckpointRecord:
+		return "BeginCheckpoint"
+	case EndCheckpointRecord:
+		return "EndCheckpoint"
+	case MarkDeleteRecord:
+		return "MarkDelete"
+	case UndoMarkDeleteRecord:
+		return "UndoMarkDelete"
+	case UpdateRecord:
+		return "Update"
+	case UndoUpdateRecord:
+		return "UndoUpdate"
+	case OverflowPageRecord:
+		return "OverflowPage"
+	case FreePageRecord:
+		return "FreePage"
+	case PageWriteRecord:
+		return "PageWrite"
+	default:
+		return "Invalid"
+	}
+}
+
+// Whether the record is a compensation log record, which is written while
+// rolling back and is never undone itself.
+func (t LogRecordType) IsCompensation() bool {
+	return t == UndoInsertRecord || t == UndoDeleteRecord || t == UndoMarkDeleteRecord || t == UndoUpdateRecord
+}
+
+// LogRecord is the in-memory form of an entry of the write-ahead log. Every
+// record belongs to a transaction, and `PrevLSN` links it to the previous
+// record of the same transaction. Which of the other fields are meaningful
+// depends on `Type`:
+//   - InsertRecord: `RID` and `Data`, the inserted record.
+//   - DeleteRecord, MarkDeleteRecord: `RID` and `Data`, the deleted record, so
+//     that it can be restored.
+//   - UpdateRecord: `RID`, `Data`, the new record, and `OldData`, the old one.
+//   - UndoUpdateRecord: as the UpdateRecord being compensated, and
+//     `UndoNextLSN`. It writes `OldData` back.
+//   - NewPageRecord: `PageId`, the new page, and `HeaderPageId`, the header of
+//     the table heap the page belongs to.
+//   - OverflowPageRecord: `PageId` and `Data`, the beginning of the page.
+//   - FreePageRecord: `PageId`.
+//   - PageWriteRecord: `PageId`, `Offset` and `Data`, the bytes written there.
+//   - UndoInsertRecord, UndoDeleteRecord, UndoMarkDeleteRecord: `RID` and
+//     `Data` as in the record being compensated, and `UndoNextLSN`, the next
+//     record of the transaction to undo.
+//   - EndCheckpointRecord: `DirtyPages`, mapping each dirty page to the LSN of
+//     the first record that may not be on disk yet, `ActiveTxns`, mapping each
+//     active transaction to its last LSN, and `NextTxnId`.
+//
+// Checkpoint records do not belong to any transaction, and neither do the
+// delete records that remove records marked by committed transactions, nor the
+// records of overflow pages and page writes. Such records are only ever redone.
+type LogRecord struct {
+	LSN          common.LSN
+	PrevLSN      common.LSN
+	TxnId        common.TxnId
+	Type         LogRecordType
+	RID          common.RID
+	Data         []byte
+	OldData      []byte
+	PageId       common.PageId
+	HeaderPageId common.PageId
+	Offset       int
+	UndoNextLSN  common.LSN
+	DirtyPages   map[common.PageId]common.LSN
+	ActiveTxns   map[common.TxnId]common.LSN
+	NextTxnId    common.TxnId
+}
+
+// On-disk layout of a log record, all integers are little endian:
+//
+//	| size (4) | checksum (4) | lsn (8) | prev lsn (8) | txn id (4) | type (1) | body ... |
+//
+// `size` is the length of the whole record, `checksum` is the CRC32C of
+// everything after it.
+const (
+	logRecordHeaderSize = 4 + 4 + 8 + 8 + 4 + 1
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (r *LogRecord) bodySize() int {
+	switch r.Type {
+	case InsertRecord, DeleteRecord, MarkDeleteRecord:
+		return 4 + 4 + 4 + len(r.Data)
+	case UndoInsertRecord, UndoDeleteRecord, UndoMarkDeleteRecord:
+		return 4 + 4 + 4 + len(r.Data) + 8
+	case UpdateRecord:
+		return 4 + 4 + 4 + len(r.Data) + 4 + len(r.OldData)
+	case UndoUpdateRecord:
+		return 4 + 4 + 4 + len(r.Data) + 4 + len(r.OldData) + 8
+	case NewPageRecord:
+		return 4 + 4
+	case OverflowPageRecord:
+		return 4 + 4 + len(r.Data)
+	case FreePageRecord:
+		return 4
+	case PageWriteRecord:
+		return 4 + 4 + 4 + len(r.Data)
+	case EndCheckpointRecord:
+		return 4 + 4 + (4+8)*len(r.DirtyPages) + 4 + (4+8)*len(r.ActiveTxns)
+	default:
+		return 0
+	}
+}
+
+func (r *LogRecord) size() int {
+	return logRecordHeaderSize + r.bodySize()
+}
+
+// Append the encoded record to `buf`.
+func (r *LogRecord) encode(buf []byte) []byte {
+	start := len(buf)
+	size := r.size()
+	buf = append(buf, make([]byte, size)...)
+	data := buf[start:]
+
+	binary.LittleEndian.PutUint32(data[0:], uint32(size))
+	binary.LittleEndian.PutUint64(data[8:], uint64(r.LSN))
+	binary.LittleEndian.PutUint64(data[16:], uint64(r.PrevLSN))
+	binary.LittleEndian.PutUint32(data[24:], uint32(r.TxnId))
+	data[28] = byte(r.Type)
+	body := data[logRecordHeaderSize:]
+	switch r.Type {
+	case InsertRecord, DeleteRecord, UndoInsertRecord, UndoDeleteRecord, MarkDeleteRecord, UndoMarkDeleteRecord:
+		binary.LittleEndian.PutUint32(body[0:], uint32(r.RID.PageId))
+		binary.LittleEndian.PutUint32(body[4:], uint32(r.RID.SlotNum))
+		binary.LittleEndian.PutUint32(body[8:], uint32(len(r.Data)))
+		copy(body[12:], r.Data)
+		if r.Type.IsCompensation() {
+			binary.LittleEndian.PutUint64(body[12+len(r.Data):], uint64(r.UndoNextLSN))
+		}
+	case UpdateRecord, UndoUpdateRecord:
+		binary.LittleEndian.PutUint32(body[0:], uint32(r.RID.PageId))
+		binary.LittleEndian.PutUint32(body[4:], uint32(r.RID.SlotNum))
+		pos := 8
+		for _, data := range [][]byte{r.Data, r.OldData} {
+			binary.LittleEndian.PutUint32(body[pos:], uint32(len(data)))
+			copy(body[pos+4:], data)
+			pos += 4 + len(data)
+		}
+		if r.Type.IsCompensation() {
+			binary.LittleEndian.PutUint64(body[pos:], uint64(r.UndoNextLSN))
+		}
+	case NewPageRecord:
+		binary.LittleEndian.PutUint32(body[0:], uint32(r.PageId))
+		binary.LittleEndian.PutUint32(body[4:], uint32(r.HeaderPageId))
+	case OverflowPageRecord:
+		binary.LittleEndian.PutUint32(body[0:], uint32(r.PageId))
+		binary.LittleEndian.PutUint32(body[4:], uint32(len(r.Data)))
+		copy(body[8:], r.Data)
+	case FreePageRecord:
+		binary.LittleEndian.PutUint32(body[0:], uint32(r.PageId))
+	case PageWriteRecord:
+		binary.LittleEndian.PutUint32(body[0:], uint32(r.PageId))
+		binary.LittleEndian.PutUint32(body[4:], uint32(r.Offset))
+		binary.LittleEndian.PutUint32(body[8:], uint32(len(r.Data)))
+		copy(body[12:], r.Data)
+	case EndCheckpointRecord:
+		binary.LittleEndian.PutUint32(body[0:], uint32(r.NextTxnId))
+		binary.LittleEndian.PutUint32(body[4:], uint32(len(r.DirtyPages)))
+		pos := 8
+		for pageId, recLSN := range r.DirtyPages {
+			binary.LittleEndian.PutUint32(body[pos:], uint32(pageId))
+			binary.LittleEndian.PutUint64(body[pos+4:], uint64(recLSN))
+			pos += 12
+		}
+		binary.LittleEndian.PutUint32(body[pos:], uint32(len(r.ActiveTxns)))
+		pos += 4
+		for txnId, lastLSN := range r.ActiveTxns {
+			binary.LittleEndian.PutUint32(body[pos:], uint32(txnId))
+			binary.LittleEndian.PutUint64(body[pos+4:], uint64(lastLSN))
+			pos += 12
+		}
+	}
+	binary.LittleEndian.PutUint32(data[4:], crc32.Checksum(data[8:], crc32cTable))
+	return buf
+}
+
+// Decode a record from the start of `data`. Returns the record and the
+// number of bytes consumed.
+func decodeLogRecord(data []byte) (*LogRecord, int, error) {
+	if len(data) < logRecordHeaderSize {
+		return nil, 0, fmt.Errorf("Log record header is truncated.")
+	}
+	size := int(binary.LittleEndian.Uint32(data[0:]))
+	if size < logRecordHeaderSize || size > len(data) {
+		return nil, 0, fmt.Errorf("Log record is truncated.")
+	}
+	data = data[:size]
+	if crc32.Checksum(data[8:], crc32cTable) != binary.LittleEndian.Uint32(data[4:]) {
+		return nil, 0, fmt.Errorf("Log record checksum mismatch.")
+	}
+
+	r := &LogRecord{
+		LSN:     common.LSN(binary.LittleEndian.Uint64(data[8:])),
+		PrevLSN: common.LSN(binary.LittleEndian.Uint64(data[16:])),
+		TxnId:   common.TxnId(binary.LittleEndian.Uint32(data[24:])),
+		Type:    LogRecordType(data[28]),
+	}
+	body := data[logRecordHeaderSize:]
+	switch r.Type {
+	case BeginRecord, CommitRecord, AbortRecord, BeginCheckpointRecord:
+		if len(body) != 0 {
+			return nil, 0, fmt.Errorf("Log record body is malformed.")
+		}
+	case InsertRecord, DeleteRecord, UndoInsertRecord, UndoDeleteRecord, MarkDeleteRecord, UndoMarkDeleteRecord:
+		if len(body) < 12 {
+			return nil, 0, fmt.Errorf("Log record body is truncated.")
+		}
+		r.RID.PageId = common.PageId(binary.LittleEndian.Uint32(body[0:]))
+		r.RID.SlotNum = int(int32(binary.LittleEndian.Uint32(body[4:])))
+		length := int(binary.LittleEndian.Uint32(body[8:]))
+		expected := 12 + length
+		if r.Type.IsCompensation() {
+			expected += 8
+		}
+		if len(body) != expected {
+			return nil, 0, fmt.Errorf("Log record body is truncated.")
+		}
+		r.Data = make([]byte, length)
+		copy(r.Data, body[12:])
+		if r.Type.IsCompensation() {
+			r.UndoNextLSN = common.LSN(binary.LittleEndian.Uint64(body[12+length:]))
+		}
+	case UpdateRecord, UndoUpdateRecord:
+		if err := r.decodeUpdate(body); err != nil {
+			return nil, 0, err
+		}
+	case NewPageRecord:
+		if len(body) != 8 {
+			return nil, 0, fmt.Errorf("Log record body is truncated.")
+		}
+		r.PageId = common.PageId(binary.LittleEndian.Uint32(body[0:]))
+		r.HeaderPageId = common.PageId(binary.LittleEndian.Uint32(body[4:]))
+	case OverflowPageRecord:
+		if len(body) < 8 || len(body) != 8+int(binary.LittleEndian.Uint32(body[4:])) {
+			return nil, 0, fmt.Errorf("Log record body is truncated.")
+		}
+		r.PageId = common.PageId(binary.LittleEndian.Uint32(body[0:]))
+		r.Data = make([]byte, len(body)-8)
+		copy(r.Data, body[8:])
+	case FreePageRecord:
+		if len(body) != 4 {
+			return nil, 0, fmt.Errorf("Log record body is truncated.")
+		}
+		r.PageId = common.PageId(binary.LittleEndian.Uint32(body[0:]))
+	case PageWriteRecord:
+		if len(body) < 12 || len(body) != 12+int(binary.LittleEndian.Uint32(body[8:])) {
+			return nil, 0, fmt.Errorf("Log record body is truncated.")
+		}
+		r.PageId = common.PageId(binary.LittleEndian.Uint32(body[0:]))
+		r.Offset = int(binary.LittleEndian.Uint32(body[4:]))
+		r.Data = make([]byte, len(body)-12)
+		copy(r.Data, body[12:])
+	case EndCheckpointRecord:
+		if err := r.decodeCheckpoint(body); err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, fmt.Errorf("Unknown log record type %d.", r.Type)
+	}
+	return r, size, nil
+}
+
+func (r *LogRecord) decodeUpdate(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("Log record body is truncated.")
+	}
+	r.RID.PageId = common.PageId(binary.LittleEndian.Uint32(body[0:]))
+	r.RID.SlotNum = int(int32(binary.LittleEndian.Uint32(body[4:])))
+	pos := 8
+	for _, data := range []*[]byte{&r.Data, &r.OldData} {
+		if len(body) < pos+4 {
+			return fmt.Errorf("Log record body is truncated.")
+		}
+		length := int(binary.LittleEndian.Uint32(body[pos:]))
+		if len(body) < pos+4+length {
+			return fmt.Errorf("Log record body is truncated.")
+		}
+		*data = make([]byte, length)
+		copy(*data, body[pos+4:])
+		pos += 4 + length
+	}
+	expected := pos
+	if r.Type.IsCompensation() {
+		expected += 8
+	}
+	if len(body) != expected {
+		return fmt.Errorf("Log record body is truncated.")
+	}
+	if r.Type.IsCompensation() {
+		r.UndoNextLSN = common.LSN(binary.LittleEndian.Uint64(body[pos:]))
+	}
+	return nil
+}
+
+func (r *LogRecord) decodeCheckpoint(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("Log record body is truncated.")
+	}
+	r.NextTxnId = common.TxnId(binary.LittleEndian.Uint32(body[0:]))
+	numDirtyPages := int(binary.LittleEndian.Uint32(body[4:]))
+	pos := 8
+	if len(body) < pos+12*numDirtyPages+4 {
+		return fmt.Errorf("Log record body is truncated.")
+	}
+	r.DirtyPages = make(map[common.PageId]common.LSN)
+	for i := 0; i < numDirtyPages; i++ {
+		pageId := common.PageId(binary.LittleEndian.Uint32(body[pos:]))
+		r.DirtyPages[pageId] = common.LSN(binary.LittleEndian.Uint64(body[pos+4:]))
+		pos += 12
+	}
+	numActiveTxns := int(binary.LittleEndian.Uint32(body[pos:]))
+	pos += 4
+	if len(body) != pos+12*numActiveTxns {
+		return fmt.Errorf("Log record body is truncated.")
+	}
+	r.ActiveTxns = make(map[common.TxnId]common.LSN)
+	for i := 0; i < numActiveTxns; i++ {
+		txnId := common.TxnId(binary.LittleEndian.Uint32(body[pos:]))
+		r.ActiveTxns[txnId] = common.LSN(binary.LittleEndian.Uint64(body[pos+4:]))
+		pos += 12
+	}
+	return nil
+}